@@ -0,0 +1,76 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PublicPortSpec is a port exposed by a Proxy, as configured in the Controller
+type PublicPortSpec struct {
+	// Name of the Proxy exposing the port
+	Proxy string `json:"proxy"`
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol"`
+	// Router queue the port is bridged to
+	Queue string `json:"queue"`
+	// Microservice exposing the port
+	// +optional
+	MicroserviceUUID string `json:"microserviceUuid,omitempty"`
+}
+
+// PublicPortStatus is the observed state of a PublicPort
+type PublicPortStatus struct {
+	// External address of the Proxy
+	// +optional
+	Address string `json:"address,omitempty"`
+	// Whether the port is served by the Proxy at its registered address
+	Ready bool `json:"ready"`
+	// Last time Ready changed
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason the port is not ready
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// PublicPort mirrors a port exposed by the port manager
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Proxy",type=string,JSONPath=`.spec.proxy`
+// +kubebuilder:printcolumn:name="Port",type=integer,JSONPath=`.spec.port`
+// +kubebuilder:printcolumn:name="Protocol",type=string,JSONPath=`.spec.protocol`
+// +kubebuilder:printcolumn:name="Address",type=string,JSONPath=`.status.address`
+// +kubebuilder:printcolumn:name="Ready",type=boolean,JSONPath=`.status.ready`
+type PublicPort struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PublicPortSpec   `json:"spec,omitempty"`
+	Status PublicPortStatus `json:"status,omitempty"`
+}
+
+// PublicPortList contains a list of PublicPort
+// +kubebuilder:object:root=true
+type PublicPortList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PublicPort `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PublicPort{}, &PublicPortList{})
+}
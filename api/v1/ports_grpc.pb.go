@@ -0,0 +1,173 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.20.1
+// source: ports.proto
+
+package apiv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// PortManagerClient is the client API for PortManager service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PortManagerClient interface {
+	// List the ports exposed by each Proxy
+	ListPorts(ctx context.Context, in *ListPortsRequest, opts ...grpc.CallOption) (*ListPortsResponse, error)
+	// Stream the port map of each Proxy, starting with the current state and then on every change
+	WatchPorts(ctx context.Context, in *WatchPortsRequest, opts ...grpc.CallOption) (PortManager_WatchPortsClient, error)
+}
+
+type portManagerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPortManagerClient(cc grpc.ClientConnInterface) PortManagerClient {
+	return &portManagerClient{cc}
+}
+
+func (c *portManagerClient) ListPorts(ctx context.Context, in *ListPortsRequest, opts ...grpc.CallOption) (*ListPortsResponse, error) {
+	out := new(ListPortsResponse)
+	err := c.cc.Invoke(ctx, "/iofog.portmanager.v1.PortManager/ListPorts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *portManagerClient) WatchPorts(ctx context.Context, in *WatchPortsRequest, opts ...grpc.CallOption) (PortManager_WatchPortsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &PortManager_ServiceDesc.Streams[0], "/iofog.portmanager.v1.PortManager/WatchPorts", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &portManagerWatchPortsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type PortManager_WatchPortsClient interface {
+	Recv() (*PortMap, error)
+	grpc.ClientStream
+}
+
+type portManagerWatchPortsClient struct {
+	grpc.ClientStream
+}
+
+func (x *portManagerWatchPortsClient) Recv() (*PortMap, error) {
+	m := new(PortMap)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PortManagerServer is the server API for PortManager service.
+// All implementations must embed UnimplementedPortManagerServer
+// for forward compatibility
+type PortManagerServer interface {
+	// List the ports exposed by each Proxy
+	ListPorts(context.Context, *ListPortsRequest) (*ListPortsResponse, error)
+	// Stream the port map of each Proxy, starting with the current state and then on every change
+	WatchPorts(*WatchPortsRequest, PortManager_WatchPortsServer) error
+	mustEmbedUnimplementedPortManagerServer()
+}
+
+// UnimplementedPortManagerServer must be embedded to have forward compatible implementations.
+type UnimplementedPortManagerServer struct {
+}
+
+func (UnimplementedPortManagerServer) ListPorts(context.Context, *ListPortsRequest) (*ListPortsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPorts not implemented")
+}
+func (UnimplementedPortManagerServer) WatchPorts(*WatchPortsRequest, PortManager_WatchPortsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchPorts not implemented")
+}
+func (UnimplementedPortManagerServer) mustEmbedUnimplementedPortManagerServer() {}
+
+// UnsafePortManagerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PortManagerServer will
+// result in compilation errors.
+type UnsafePortManagerServer interface {
+	mustEmbedUnimplementedPortManagerServer()
+}
+
+func RegisterPortManagerServer(s grpc.ServiceRegistrar, srv PortManagerServer) {
+	s.RegisterService(&PortManager_ServiceDesc, srv)
+}
+
+func _PortManager_ListPorts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPortsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PortManagerServer).ListPorts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/iofog.portmanager.v1.PortManager/ListPorts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PortManagerServer).ListPorts(ctx, req.(*ListPortsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PortManager_WatchPorts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchPortsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PortManagerServer).WatchPorts(m, &portManagerWatchPortsServer{stream})
+}
+
+type PortManager_WatchPortsServer interface {
+	Send(*PortMap) error
+	grpc.ServerStream
+}
+
+type portManagerWatchPortsServer struct {
+	grpc.ServerStream
+}
+
+func (x *portManagerWatchPortsServer) Send(m *PortMap) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// PortManager_ServiceDesc is the grpc.ServiceDesc for PortManager service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PortManager_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "iofog.portmanager.v1.PortManager",
+	HandlerType: (*PortManagerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListPorts",
+			Handler:    _PortManager_ListPorts_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchPorts",
+			Handler:       _PortManager_WatchPorts_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "ports.proto",
+}
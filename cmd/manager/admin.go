@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/eclipse-iofog/port-manager/v3/pkg/manager"
+)
+
+const (
+	adminAddressEnv   = "ADMIN_ADDRESS"
+	adminTokenEnv     = "ADMIN_TOKEN"
+	adminTokenFileEnv = "ADMIN_TOKEN_FILE"
+	adminCertDirEnv   = "ADMIN_CERT_DIR"
+)
+
+// Read a bearer token, preferring a mounted Secret file over the env var
+func getToken(tokenEnv, tokenFileEnv string) (string, error) {
+	if path := getEnv(tokenFileEnv); path != "" {
+		token, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+	return getEnv(tokenEnv), nil
+}
+
+// serveAdmin exposes the admin API of the Managers when an admin address is configured
+// It is served over HTTPS with the tls.crt and tls.key of the cert dir, plain HTTP is only served on a loopback address
+func serveAdmin(ctrlMgr ctrl.Manager, mgrs []*manager.Manager) error {
+	addr := getEnv(adminAddressEnv)
+	if addr == "" {
+		return nil
+	}
+	token, err := getToken(adminTokenEnv, adminTokenFileEnv)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", adminTokenFileEnv, err.Error())
+	}
+	if token == "" {
+		return errors.New(adminTokenEnv + " or " + adminTokenFileEnv + " is required with " + adminAddressEnv)
+	}
+	server := &http.Server{Addr: addr, Handler: manager.NewAdminHandler(mgrs, token)}
+	if certDir := getEnv(adminCertDirEnv); certDir != "" {
+		config, err := loadServerTLSConfig(certDir)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", adminCertDirEnv, err.Error())
+		}
+		server.TLSConfig = config
+	} else if !isLoopbackAddress(addr) {
+		return fmt.Errorf("%s is required to serve the admin API on the non-loopback address %s", adminCertDirEnv, addr)
+	}
+	return ctrlMgr.Add(newHTTPServerRunnable("admin API", server))
+}
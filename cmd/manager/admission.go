@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlmanager "sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/eclipse-iofog/port-manager/v3/pkg/manager"
+)
+
+const (
+	webhookPortEnv    = "WEBHOOK_PORT"
+	webhookCertDirEnv = "WEBHOOK_CERT_DIR"
+)
+
+// serveAdmission runs the validating admission webhooks when a webhook port is configured
+// The serving certificate and key are read from tls.crt and tls.key in the cert dir
+func serveAdmission(ctrlMgr ctrl.Manager, mgrs []*manager.Manager) error {
+	p := &settingsParser{file: loadedSettingsFile}
+	port := p.getInt(webhookPortEnv, 0)
+	if err := p.err(); err != nil {
+		return err
+	}
+	if port == 0 {
+		return nil
+	}
+	server := &webhook.Server{
+		Port:    port,
+		CertDir: getEnv(webhookCertDirEnv),
+	}
+	manager.RegisterAdmissionWebhooks(server, mgrs)
+	scheme, err := manager.NewAdmissionScheme()
+	if err != nil {
+		return err
+	}
+	return ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+		log.Info("Serving admission webhooks", "port", port)
+		if err := server.StartStandalone(ctx, scheme); err != nil {
+			log.Error(err, "Admission webhook server stopped")
+		}
+		return nil
+	}))
+}
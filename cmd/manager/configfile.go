@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"sigs.k8s.io/yaml"
+)
+
+const configFileEnv = "CONFIG_FILE"
+
+// Keys of the proxies entries of the config file that are not settings
+const (
+	proxyNameKey            = "name"
+	proxyProtocolKey        = "protocol"
+	proxyExternalAddressKey = "external-address"
+	proxyServiceTypeKey     = "service-type"
+)
+
+// A YAML config file holding settings by flag name, e.g.
+//
+//	proxy-image: iofog/proxy:3.0.0
+//	router-address: router:5671
+//	proxies:
+//	- name: http-proxy
+//	  protocol: http
+//	  external-address: 10.0.0.1
+//	- name: tcp-proxy
+//	  protocol: tcp
+//	  proxy-ready-timeout: 5m
+//
+// Each proxies entry runs a Manager and may override any setting for its Proxy except the process-wide ones, e.g. log-level
+// Lists can be YAML sequences or comma-separated strings
+type settingsFile struct {
+	data    []byte
+	values  map[string]string
+	proxies []proxySettings
+}
+
+type proxySettings struct {
+	name            string
+	protocol        string
+	externalAddress string
+	serviceType     string
+	values          map[string]string
+}
+
+// The config file the settings were last loaded from, nil if none is configured
+var loadedSettingsFile *settingsFile
+
+func (file *settingsFile) lookup(key string) (string, bool) {
+	if file == nil {
+		return "", false
+	}
+	value, exists := file.values[key]
+	return value, exists
+}
+
+// Read and validate the config file configured with CONFIG_FILE, nil if none is configured
+func readSettingsFile() (*settingsFile, error) {
+	path := getConfigFilePath()
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := parseSettingsFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, err.Error())
+	}
+	return file, nil
+}
+
+// The config file path cannot be set by the config file itself
+func getConfigFilePath() string {
+	value, _ := lookupFlagOrEnv(configFileEnv)
+	return value
+}
+
+func parseSettingsFile(data []byte) (*settingsFile, error) {
+	raw := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	file := &settingsFile{data: data}
+	var errs []string
+	var err error
+	if proxies, exists := raw["proxies"]; exists {
+		delete(raw, "proxies")
+		entries, ok := proxies.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("proxies must be a list")
+		}
+		names := make(map[string]bool)
+		for idx, entry := range entries {
+			values, ok := entry.(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Sprintf("proxies[%d] must be a map", idx))
+				continue
+			}
+			proxy := proxySettings{}
+			proxy.name, _ = values[proxyNameKey].(string)
+			proxy.protocol, _ = values[proxyProtocolKey].(string)
+			proxy.externalAddress, _ = values[proxyExternalAddressKey].(string)
+			proxy.serviceType, _ = values[proxyServiceTypeKey].(string)
+			for _, key := range []string{proxyNameKey, proxyProtocolKey, proxyExternalAddressKey, proxyServiceTypeKey} {
+				delete(values, key)
+			}
+			if proxy.name == "" {
+				errs = append(errs, fmt.Sprintf("proxies[%d] requires a name", idx))
+			} else if names[proxy.name] {
+				errs = append(errs, fmt.Sprintf("proxies[%d] has duplicate name %s", idx, proxy.name))
+			}
+			names[proxy.name] = true
+			if proxy.values, err = getSettingValues(values, true); err != nil {
+				errs = append(errs, fmt.Sprintf("proxies[%d]: %s", idx, err.Error()))
+			}
+			file.proxies = append(file.proxies, proxy)
+		}
+	}
+	if file.values, err = getSettingValues(raw, false); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return file, nil
+}
+
+// Convert YAML values keyed by flag name to strings keyed by env var
+// The settings of a Proxy cannot include the process-wide settings
+func getSettingValues(raw map[string]interface{}, proxy bool) (map[string]string, error) {
+	keys := make(map[string]setting)
+	for _, setting := range settings {
+		keys[getFlagName(setting.key)] = setting
+	}
+	values := make(map[string]string)
+	var unknown, global []string
+	for name, value := range raw {
+		setting, exists := keys[name]
+		if !exists || setting.key == configFileEnv {
+			unknown = append(unknown, name)
+			continue
+		}
+		if proxy && setting.global {
+			global = append(global, name)
+			continue
+		}
+		key := setting.key
+		str, err := formatSettingValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", name, err.Error())
+		}
+		values[key] = str
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown settings %s", strings.Join(unknown, ", "))
+	}
+	if len(global) > 0 {
+		sort.Strings(global)
+		return nil, fmt.Errorf("process-wide settings %s cannot be set per proxy", strings.Join(global, ", "))
+	}
+	return values, nil
+}
+
+func formatSettingValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			str, err := formatSettingValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// watchSettingsFile signals when the config file may have changed until ctx is done
+// The directory is watched since mounted ConfigMaps are updated by swapping a symlink
+func watchSettingsFile(ctx context.Context) (<-chan struct{}, error) {
+	path := getConfigFilePath()
+	if path == "" {
+		return nil, nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-watcher.Errors:
+				log.Error(err, "Failed to watch config file", "path", path)
+			case <-watcher.Events:
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changed, nil
+}
+
+// Whether the config file content differs from file
+func (file *settingsFile) changed(next *settingsFile) bool {
+	if file == nil || next == nil {
+		return file != next
+	}
+	return !bytes.Equal(file.data, next.data)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSettingsFile(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		data  string
+		err   string
+		check func(t *testing.T, file *settingsFile)
+	}{
+		{
+			name: "settings",
+			data: "proxy-image: iofog/proxy:3.0.0\nproxy-stats-port: 9090\nrouter-tls-verify: true\nreserved-ports: [22, 80-90]\n",
+			check: func(t *testing.T, file *settingsFile) {
+				for key, expected := range map[string]string{
+					proxyImageEnv:      "iofog/proxy:3.0.0",
+					proxyStatsPortEnv:  "9090",
+					routerTLSVerifyEnv: "true",
+					reservedPortsEnv:   "22,80-90",
+				} {
+					if value, _ := file.lookup(key); value != expected {
+						t.Errorf("Expected %s %q, got %q", key, expected, value)
+					}
+				}
+			},
+		},
+		{
+			name: "proxies",
+			data: "log-level: debug\nproxies:\n- name: http-proxy\n  protocol: http\n  service-type: ClusterIP\n  proxy-ready-timeout: 5m\n",
+			check: func(t *testing.T, file *settingsFile) {
+				if len(file.proxies) != 1 {
+					t.Fatalf("Expected 1 proxy, got %d", len(file.proxies))
+				}
+				proxy := file.proxies[0]
+				if proxy.name != "http-proxy" || proxy.protocol != "http" || proxy.serviceType != "ClusterIP" {
+					t.Errorf("Unexpected proxy %+v", proxy)
+				}
+				if value := proxy.values[proxyReadyTimeoutEnv]; value != "5m" {
+					t.Errorf("Expected %s 5m, got %q", proxyReadyTimeoutEnv, value)
+				}
+			},
+		},
+		{name: "unknown setting", data: "proxy-imag: iofog/proxy\n", err: "unknown settings proxy-imag"},
+		{name: "config file", data: "config-file: other.yaml\n", err: "unknown settings config-file"},
+		{name: "unknown proxy setting", data: "proxies:\n- name: http-proxy\n  proxy-imag: iofog/proxy\n", err: "proxies[0]: unknown settings proxy-imag"},
+		{name: "global proxy setting", data: "proxies:\n- name: http-proxy\n  metrics-address: :8081\n  log-level: debug\n", err: "proxies[0]: process-wide settings log-level, metrics-address cannot be set per proxy"},
+		{name: "missing name", data: "proxies:\n- protocol: http\n", err: "proxies[0] requires a name"},
+		{name: "duplicate name", data: "proxies:\n- name: http-proxy\n- name: http-proxy\n", err: "proxies[1] has duplicate name http-proxy"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := parseSettingsFile([]byte(test.data))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, file)
+		})
+	}
+}
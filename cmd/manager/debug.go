@@ -0,0 +1,39 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+const debugAddressEnv = "DEBUG_ADDRESS"
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// serveDebug exposes pprof profiles and runtime stats when a debug address is configured
+// Not enabled by default, the endpoints expose process internals
+func serveDebug() {
+	addr := getEnv(debugAddressEnv)
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// Memory stats, command line and goroutine count
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		log.Info("Serving debug endpoints", "address", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error(err, "Debug server stopped")
+		}
+	}()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlmanager "sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/eclipse-iofog/port-manager/v3/pkg/manager"
+)
+
+const (
+	grpcAddressEnv   = "GRPC_ADDRESS"
+	grpcTokenEnv     = "GRPC_TOKEN"
+	grpcTokenFileEnv = "GRPC_TOKEN_FILE"
+	grpcCertDirEnv   = "GRPC_CERT_DIR"
+)
+
+// serveGRPC exposes the port map of the Managers over gRPC when a gRPC address is configured
+// It is served over TLS with the tls.crt and tls.key of the cert dir, and requires client certificates signed by its ca.crt if present
+// Without a cert dir it is only served on a loopback address
+func serveGRPC(ctrlMgr ctrl.Manager, mgrs []*manager.Manager) error {
+	addr := getEnv(grpcAddressEnv)
+	if addr == "" {
+		return nil
+	}
+	token, err := getToken(grpcTokenEnv, grpcTokenFileEnv)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", grpcTokenFileEnv, err.Error())
+	}
+	if token == "" {
+		return errors.New(grpcTokenEnv + " or " + grpcTokenFileEnv + " is required with " + grpcAddressEnv)
+	}
+	opts := manager.GRPCTokenOptions(token)
+	if certDir := getEnv(grpcCertDirEnv); certDir != "" {
+		config, err := loadGRPCTLSConfig(certDir)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", grpcCertDirEnv, err.Error())
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	} else if !isLoopbackAddress(addr) {
+		return fmt.Errorf("%s is required to serve the gRPC API on the non-loopback address %s", grpcCertDirEnv, addr)
+	}
+	server := grpc.NewServer(opts...)
+	manager.RegisterPortsServer(server, mgrs)
+	return ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Error(err, "Invalid "+grpcAddressEnv)
+			return nil
+		}
+		log.Info("Serving gRPC API", "address", addr)
+		go func() {
+			<-ctx.Done()
+			// Watch streams only end with the client, do not wait for them
+			server.Stop()
+		}()
+		if err := server.Serve(listener); err != nil {
+			log.Error(err, "gRPC server stopped")
+		}
+		return nil
+	}))
+}
+
+// Load the server certificate of a cert dir, with mutual TLS if it holds the ca.crt of the clients
+func loadGRPCTLSConfig(certDir string) (*tls.Config, error) {
+	config, err := loadServerTLSConfig(certDir)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(certDir, "ca.crt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("ca.crt holds no PEM certificates")
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	k8sQPSEnv   = "K8S_QPS"
+	k8sBurstEnv = "K8S_BURST"
+)
+
+// Defaults of controller-runtime's config package
+const (
+	defaultK8sQPS   = 20
+	defaultK8sBurst = 30
+)
+
+// kubeconfigFlag is registered by controller-runtime's config package and read by config.GetConfig
+const kubeconfigFlag = "kubeconfig"
+
+func newKubeconfigFlag() *pflag.Flag {
+	return pflag.PFlagFromGoFlag(flag.CommandLine.Lookup(kubeconfigFlag))
+}
+
+func getKubeconfigPath() string {
+	return flag.CommandLine.Lookup(kubeconfigFlag).Value.String()
+}
+
+// isInCluster reports whether the in-cluster config is used, i.e. no kubeconfig is given and the process runs in a pod
+func isInCluster() bool {
+	return getKubeconfigPath() == "" &&
+		os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" &&
+		os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// getNamespace returns the Namespace the Managers run in
+// Outside the cluster, the kubeconfig context's Namespace is used unless WATCH_NAMESPACE is set
+func getNamespace() (string, error) {
+	if _, ok := lookupEnv(watchNamespaceEnv); ok || isInCluster() {
+		return getWatchNamespace(), nil
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = getKubeconfigPath()
+	ns, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).Namespace()
+	return ns, err
+}
+
+// A remote cluster the Proxies of a kubeconfig are managed in
+type remoteCluster struct {
+	config *rest.Config
+	// Namespace of the kubeconfig context, empty to use the Namespace of the Managers
+	namespace string
+}
+
+// loadRemoteCluster reads the kubeconfig of a remote cluster, its current context selects the cluster
+func loadRemoteCluster(p *settingsParser, path string) (remoteCluster, error) {
+	rules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: path}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+	raw, err := clientConfig.RawConfig()
+	if err != nil {
+		return remoteCluster{}, err
+	}
+	cluster := remoteCluster{}
+	if context, exists := raw.Contexts[raw.CurrentContext]; exists {
+		cluster.namespace = context.Namespace
+	}
+	if cluster.config, err = clientConfig.ClientConfig(); err != nil {
+		return remoteCluster{}, err
+	}
+	setRateLimiter(p, cluster.config)
+	return cluster, nil
+}
+
+// setRateLimiter limits the requests of all clients created from the config to K8S_QPS and K8S_BURST
+// The clients of all Managers of a cluster share the rate limiter instead of each getting the full budget
+func setRateLimiter(p *settingsParser, cfg *rest.Config) {
+	qps := p.getFloat(k8sQPSEnv, defaultK8sQPS)
+	if qps <= 0 {
+		p.check(k8sQPSEnv, errors.New("must be a positive number"))
+	}
+	burst := p.getInt(k8sBurstEnv, defaultK8sBurst)
+	if burst <= 0 {
+		p.check(k8sBurstEnv, errors.New("must be a positive integer"))
+	}
+	cfg.QPS, cfg.Burst = float32(qps), burst
+	cfg.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(cfg.QPS, cfg.Burst)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap/zapcore"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const (
+	logLevelEnv      = "LOG_LEVEL"
+	logFormatEnv     = "LOG_FORMAT"
+	logStacktraceEnv = "LOG_STACKTRACE_LEVEL"
+)
+
+// Parse a zap level name, e.g. debug or error, or a logr verbosity, e.g. 2
+func parseLogLevel(value string, fallback zapcore.Level) (zapcore.Level, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if verbosity, err := strconv.Atoi(value); err == nil {
+		if verbosity < 0 {
+			return fallback, fmt.Errorf("log verbosity must not be negative: %d", verbosity)
+		}
+		return zapcore.Level(-verbosity), nil
+	}
+	return zapcore.ParseLevel(value)
+}
+
+// Build the logger shared by main and all Managers from env vars
+func newLogger() (logr.Logger, error) {
+	level, err := parseLogLevel(getEnv(logLevelEnv), zapcore.InfoLevel)
+	if err != nil {
+		return logr.Logger{}, fmt.Errorf("invalid %s: %s", logLevelEnv, err.Error())
+	}
+	stacktraceLevel, err := parseLogLevel(getEnv(logStacktraceEnv), zapcore.ErrorLevel)
+	if err != nil {
+		return logr.Logger{}, fmt.Errorf("invalid %s: %s", logStacktraceEnv, err.Error())
+	}
+
+	opts := []zap.Opts{
+		zap.Level(level),
+		zap.StacktraceLevel(stacktraceLevel),
+	}
+	switch format := strings.ToLower(getEnv(logFormatEnv)); format {
+	case "", "json":
+		opts = append(opts, zap.JSONEncoder())
+	case "console":
+		opts = append(opts, zap.ConsoleEncoder())
+	default:
+		return logr.Logger{}, fmt.Errorf("invalid %s: unsupported format %s", logFormatEnv, format)
+	}
+	return zap.New(opts...), nil
+}
+
+func setupLogger() {
+	logger, err := newLogger()
+	if err != nil {
+		// Report with the default logger since the configured one cannot be built
+		log.Error(err, "Failed to configure logger")
+		os.Exit(1)
+	}
+	logf.SetLogger(logger)
+	log = logger.WithName("main")
+}
@@ -1,92 +1,397 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
-	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
-	"github.com/eclipse-iofog/port-manager/v3/internal/manager"
+	"github.com/eclipse-iofog/port-manager/v3/pkg/manager"
 )
 
+// Replaced by the configured logger on startup
 var log = zap.New()
 
+const binaryName = "port-manager"
+
 const (
-	userEmailEnv        = "IOFOG_USER_EMAIL"
-	userPassEnv         = "IOFOG_USER_PASS"
-	proxyImageEnv       = "PROXY_IMAGE"
-	httpProxyAddressEnv = "HTTP_PROXY_ADDRESS"
-	tcpProxyAddressEnv  = "TCP_PROXY_ADDRESS"
-	routerAddressEnv    = "ROUTER_ADDRESS"
+	userEmailEnv         = "IOFOG_USER_EMAIL"
+	userPassEnv          = "IOFOG_USER_PASS"
+	proxyImageEnv        = "PROXY_IMAGE"
+	httpProxyAddressEnv  = "HTTP_PROXY_ADDRESS"
+	tcpProxyAddressEnv   = "TCP_PROXY_ADDRESS"
+	routerAddressEnv     = "ROUTER_ADDRESS"
+	routerServiceEnv     = "ROUTER_SERVICE"
+	metricsAddressEnv    = "METRICS_ADDRESS"
+	routerSchemeEnv      = "ROUTER_SCHEME"
+	routerTLSSecretEnv   = "ROUTER_TLS_SECRET"
+	routerTLSVerifyEnv   = "ROUTER_TLS_VERIFY"
+	proxyReadyTimeoutEnv = "PROXY_READY_TIMEOUT"
+	portDrainPeriodEnv   = "PORT_DRAIN_PERIOD"
+	proxyStrategyEnv     = "PROXY_UPDATE_STRATEGY"
+	proxyMaxSurgeEnv     = "PROXY_MAX_SURGE"
+	proxyMaxUnavailEnv   = "PROXY_MAX_UNAVAILABLE"
+	proxyAutoRollbackEnv = "PROXY_AUTO_ROLLBACK"
+	proxyProgressEnv     = "PROXY_PROGRESS_DEADLINE"
+	proxyPinDigestEnv    = "PROXY_IMAGE_PIN_DIGEST"
+	proxyNodeOSEnv       = "PROXY_NODE_OS"
+	proxyNodeArchEnv     = "PROXY_NODE_ARCH"
+	proxyDetectPlatEnv   = "PROXY_DETECT_PLATFORMS"
+	proxySidecarsEnv     = "PROXY_SIDECARS_CONFIGMAP"
+	proxyWaitRouterEnv   = "PROXY_WAIT_FOR_ROUTER"
+	proxyConfigSpillEnv  = "PROXY_CONFIG_SPILL_SIZE"
+	proxyConfigEncEnv    = "PROXY_CONFIG_ENCODING"
+	proxyCommandEnv      = "PROXY_COMMAND"
+	proxyArgsEnv         = "PROXY_ARGS"
+	proxyLabelsEnv       = "PROXY_LABELS"
+	proxyAnnotationsEnv  = "PROXY_ANNOTATIONS"
+	proxyWorkloadEnv     = "PROXY_WORKLOAD"
+	proxyNodeSelectEnv   = "PROXY_NODE_SELECTOR"
+	proxyHostPortsEnv    = "PROXY_HOST_PORTS"
+	proxyStatsPortEnv    = "PROXY_STATS_PORT"
+	proxyStatsPathEnv    = "PROXY_STATS_PATH"
+	proxyStatsPeriodEnv  = "PROXY_STATS_INTERVAL"
+	proxyMinReplicasEnv  = "PROXY_MIN_REPLICAS"
+	proxyMaxReplicasEnv  = "PROXY_MAX_REPLICAS"
+	proxyTargetConnsEnv  = "PROXY_TARGET_CONNECTIONS"
+	proxyScaleDownEnv    = "PROXY_SCALE_DOWN_DELAY"
+	portProbePeriodEnv   = "PORT_PROBE_INTERVAL"
+	portProbeTimeoutEnv  = "PORT_PROBE_TIMEOUT"
+	externalCheckEnv     = "EXTERNAL_CHECK_INTERVAL"
+	externalSampleEnv    = "EXTERNAL_CHECK_SAMPLE"
+	stallTimeoutEnv      = "RECONCILE_STALL_TIMEOUT"
+	auditConfigMapEnv    = "AUDIT_CONFIGMAP"
+	auditMaxEntriesEnv   = "AUDIT_MAX_ENTRIES"
+	auditWebhookEnv      = "AUDIT_WEBHOOK_URL"
+	alertWebhookEnv      = "ALERT_WEBHOOK_URL"
+	alertPeriodEnv       = "ALERT_FAILURE_PERIOD"
+	alertRegisterEnv     = "ALERT_REGISTER_FAILURES"
+	portMapConfigMapEnv  = "PORT_MAP_CONFIGMAP"
+	publicPortsEnv       = "PUBLIC_PORT_RESOURCES"
+	portConflictScopeEnv = "PORT_CONFLICT_SCOPE"
+	reservedPortsEnv     = "RESERVED_PORTS"
+	portSettingsEnv      = "PORT_SETTINGS"
+	proxyCapabilitiesEnv = "PROXY_CAPABILITIES"
+	hostnameTemplateEnv  = "HOSTNAME_TEMPLATE"
+	proxyTLSSecretEnv    = "PROXY_TLS_SECRET"
+	proxyTLSRedirectEnv  = "PROXY_TLS_REDIRECT_PORT"
+	ingressModeEnv       = "INGRESS_MODE"
+	ingressGatewayEnv    = "INGRESS_GATEWAY"
+	ingressAnnotateEnv   = "INGRESS_ANNOTATIONS"
+	gatewayClassEnv      = "INGRESS_GATEWAY_CLASS"
+	serviceExportEnv     = "SERVICE_EXPORT_API"
+	proxyKubeconfigEnv   = "PROXY_KUBECONFIG"
+	lbPresetEnv          = "LB_PRESET"
+	lbIPEnv              = "LB_IP"
+	clientIPCheckEnv     = "CLIENT_IP_CHECK"
+	proxyIPFamiliesEnv   = "PROXY_IP_FAMILIES"
+	notifyQueueEnv       = "NOTIFY_AMQP_ADDRESS"
+	protocolRegisterEnv  = "REGISTER_PER_PROTOCOL"
+	proxyGroupingEnv     = "PROXY_GROUPING"
+	portShardsEnv        = "PORT_SHARDS"
+	proxyZonesEnv        = "PROXY_ZONES"
+	ownerNameEnv         = "OWNER_NAME"
+	ownerModeEnv         = "OWNER_MODE"
+	msvcMetadataEnv      = "MICROSERVICE_METADATA"
+	portOptOutEnv        = "PORT_OPT_OUT"
+	privilegedPortsEnv   = "ALLOW_PRIVILEGED_PORTS"
+	controllerURLEnv     = "CONTROLLER_URL"
+	controllerCondEnv    = "CONTROLLER_CONDITIONAL_REQUESTS"
+	controllerTimeoutEnv = "CONTROLLER_TIMEOUT"
+	controllerCallEnv    = "CONTROLLER_CALL_TIMEOUT"
+	controllerProxyEnv   = "CONTROLLER_PROXY"
+	controllerNoProxyEnv = "CONTROLLER_NO_PROXY"
+	simulationEnv        = "SIMULATION_FIXTURE"
+	watchNamespaceEnv    = "WATCH_NAMESPACE"
 )
 
-type env struct {
-	optional bool
-	key      string
-	value    string
-}
+const defaultMetricsAddress = ":8080"
 
-func generateManagerOptions(namespace string, cfg *rest.Config) (opts []manager.Options) {
-	envs := map[string]env{
-		userEmailEnv:        {key: userEmailEnv},
-		userPassEnv:         {key: userPassEnv},
-		routerAddressEnv:    {key: routerAddressEnv},
-		proxyImageEnv:       {key: proxyImageEnv},
-		httpProxyAddressEnv: {key: httpProxyAddressEnv, optional: true},
-		tcpProxyAddressEnv:  {key: tcpProxyAddressEnv, optional: true},
-	}
-	// Read env vars
-	for _, env := range envs {
-		env.value = os.Getenv(env.key)
-		if env.value == "" && !env.optional {
-			log.Error(nil, env.key+" env var not set")
-			os.Exit(1)
-		}
-		// Store result for later
-		envs[env.key] = env
+// Parse the Options of a Manager, the Proxy name and address are set by the caller
+func parseManagerOptions(p *settingsParser) manager.Options {
+	// No Controller credentials are needed when simulating the Controller
+	simulationFixture := p.get(simulationEnv)
+	userEmail, userPass := p.get(userEmailEnv), p.get(userPassEnv)
+	if simulationFixture == "" {
+		userEmail, userPass = p.getRequired(userEmailEnv), p.getRequired(userPassEnv)
+	}
+	proxyImage := p.getRequired(proxyImageEnv)
+
+	routerScheme, err := manager.GetRouterScheme(p.get(routerSchemeEnv), p.get(routerTLSSecretEnv))
+	p.check(routerSchemeEnv, err)
+	var routerAddresses []manager.RouterAddress
+	// The router address is discovered from the router Service unless set
+	if value := p.get(routerAddressEnv); value != "" {
+		routerAddresses, err = manager.ParseRouterAddresses(value, manager.GetDefaultRouterPort(routerScheme))
+		p.check(routerAddressEnv, err)
+	}
+
+	proxyStrategy, err := manager.ParseDeploymentStrategy(p.get(proxyStrategyEnv), p.get(proxyMaxSurgeEnv), p.get(proxyMaxUnavailEnv))
+	p.check(proxyStrategyEnv, err)
+
+	portConflictScope, err := manager.ParsePortConflictScope(p.get(portConflictScopeEnv))
+	p.check(portConflictScopeEnv, err)
+	reservedPorts, err := manager.ParsePortRanges(p.get(reservedPortsEnv))
+	p.check(reservedPortsEnv, err)
+	portSettings, err := manager.ParsePortSettings(p.get(portSettingsEnv))
+	p.check(portSettingsEnv, err)
+	proxyCapabilities, err := manager.ParseProxyCapabilities(p.getList(proxyCapabilitiesEnv))
+	p.check(proxyCapabilitiesEnv, err)
+	ingressMode, err := manager.ParseIngressMode(p.get(ingressModeEnv))
+	p.check(ingressModeEnv, err)
+	if ingressMode == manager.IngressModeGateway && p.get(ingressGatewayEnv) == "" && p.get(gatewayClassEnv) == "" {
+		p.check(ingressModeEnv, errors.New("the gateway mode requires "+ingressGatewayEnv+" or "+gatewayClassEnv))
+	}
+	ingressAnnotations, err := manager.ParseAnnotations(p.get(ingressAnnotateEnv))
+	p.check(ingressAnnotateEnv, err)
+	serviceExportAPI, err := manager.ParseServiceExportAPI(p.get(serviceExportEnv))
+	p.check(serviceExportEnv, err)
+	lbPreset, err := manager.ParseLoadBalancerPreset(p.get(lbPresetEnv))
+	p.check(lbPresetEnv, err)
+	lbIP, err := manager.ParseLoadBalancerIP(p.get(lbIPEnv))
+	p.check(lbIPEnv, err)
+	proxyGrouping, err := manager.ParseProxyGrouping(p.get(proxyGroupingEnv))
+	p.check(proxyGroupingEnv, err)
+	proxyConfigEncoding, err := manager.ParseProxyConfigEncoding(p.get(proxyConfigEncEnv))
+	p.check(proxyConfigEncEnv, err)
+	proxyArgs := p.getList(proxyArgsEnv)
+	_, err = manager.ParseProxyArgs(proxyArgs)
+	p.check(proxyArgsEnv, err)
+	proxyLabels, err := manager.ParseLabels(p.get(proxyLabelsEnv))
+	p.check(proxyLabelsEnv, err)
+	proxyAnnotations, err := manager.ParseAnnotations(p.get(proxyAnnotationsEnv))
+	p.check(proxyAnnotationsEnv, err)
+	proxyWorkload, err := manager.ParseProxyWorkload(p.get(proxyWorkloadEnv))
+	p.check(proxyWorkloadEnv, err)
+	proxyNodeSelector, err := manager.ParseNodeSelector(p.get(proxyNodeSelectEnv))
+	p.check(proxyNodeSelectEnv, err)
+	ownerMode, err := manager.ParseOwnerMode(p.get(ownerModeEnv))
+	p.check(ownerModeEnv, err)
+	portShards, err := manager.ParsePortShards(p.get(portShardsEnv))
+	p.check(portShardsEnv, err)
+	if len(portShards) > 0 && proxyGrouping != "" {
+		p.check(portShardsEnv, errors.New("cannot be combined with "+proxyGroupingEnv))
+	}
+	proxyZones, err := manager.ParseProxyZones(p.getList(proxyZonesEnv))
+	p.check(proxyZonesEnv, err)
+	if len(proxyZones) > 0 && proxyGrouping != manager.ProxyGroupingZone {
+		p.check(proxyZonesEnv, errors.New("requires "+proxyGroupingEnv+"="+manager.ProxyGroupingZone))
+	}
+	proxyIPFamilies, err := manager.ParseIPFamilies(p.getList(proxyIPFamiliesEnv))
+	p.check(proxyIPFamiliesEnv, err)
+	hostnameTemplate := p.get(hostnameTemplateEnv)
+	if hostnameTemplate != "" {
+		_, err = manager.ParseHostnameTemplate(hostnameTemplate)
+		p.check(hostnameTemplateEnv, err)
 	}
 
+	defaults := manager.DefaultOptions()
+	proxyStatsInterval := p.getDuration(proxyStatsPeriodEnv, defaults.ProxyStatsInterval)
+	if proxyStatsInterval <= 0 {
+		p.check(proxyStatsPeriodEnv, errors.New("interval must be positive"))
+	}
+	proxyMinReplicas := p.getInt(proxyMinReplicasEnv, defaults.ProxyMinReplicas)
+	if proxyMinReplicas < 1 {
+		p.check(proxyMinReplicasEnv, errors.New("at least one replica is required"))
+	}
+	proxyMaxReplicas := p.getInt(proxyMaxReplicasEnv, proxyMinReplicas)
+	if proxyMaxReplicas < proxyMinReplicas {
+		p.check(proxyMaxReplicasEnv, errors.New("must not be lower than "+proxyMinReplicasEnv))
+	}
 	opt := manager.Options{
-		Namespace:            namespace,
-		UserEmail:            envs[userEmailEnv].value,
-		UserPass:             envs[userPassEnv].value,
-		ProxyImage:           envs[proxyImageEnv].value,
-		ProxyServiceType:     "LoadBalancer",
-		ProxyExternalAddress: "",
-		ProtocolFilter:       "",
-		ProxyName:            "http-proxy", // TODO: Fix this default, e.g. iofogctl tests get svc name
-		RouterAddress:        envs[routerAddressEnv].value,
-		Config:               cfg,
-	}
-	opts = append(opts, opt)
-	if envs[httpProxyAddressEnv].value != "" && envs[tcpProxyAddressEnv].value != "" {
-		// Update first opt
-		opts[0].ProxyServiceType = "ClusterIP"
-		opts[0].ProtocolFilter = "http"
-		opts[0].ProxyName = "http-proxy"
-		opts[0].ProxyExternalAddress = envs[httpProxyAddressEnv].value
-		// Create second opt
+		UserEmail:             userEmail,
+		UserPass:              userPass,
+		ProxyImage:            proxyImage,
+		ProxyServiceType:      defaults.ProxyServiceType,
+		ProxyIPFamilies:       proxyIPFamilies,
+		ProxyExternalAddress:  "",
+		ProtocolFilter:        "",
+		ProxyName:             defaults.ProxyName, // TODO: Fix this default, e.g. iofogctl tests get svc name
+		RouterAddresses:       routerAddresses,
+		RouterService:         p.getString(routerServiceEnv, defaults.RouterService),
+		RouterScheme:          routerScheme,
+		RouterTLSSecret:       p.get(routerTLSSecretEnv),
+		RouterTLSInsecure:     !p.getBool(routerTLSVerifyEnv, !defaults.RouterTLSInsecure),
+		ProxyReadyTimeout:     p.getDuration(proxyReadyTimeoutEnv, defaults.ProxyReadyTimeout),
+		PortDrainPeriod:       p.getDuration(portDrainPeriodEnv, defaults.PortDrainPeriod),
+		ProxyStrategy:         proxyStrategy,
+		ProxyAutoRollback:     p.getBool(proxyAutoRollbackEnv, defaults.ProxyAutoRollback),
+		ProxyProgressTimeout:  p.getDuration(proxyProgressEnv, 0),
+		ProxyImagePinDigest:   p.getBool(proxyPinDigestEnv, false),
+		ProxyNodeOS:           p.getList(proxyNodeOSEnv),
+		ProxyNodeArch:         p.getList(proxyNodeArchEnv),
+		ProxyDetectPlatforms:  p.getBool(proxyDetectPlatEnv, false),
+		ProxySidecarConfigMap: p.get(proxySidecarsEnv),
+		ProxyWaitForRouter:    p.getBool(proxyWaitRouterEnv, false),
+		ProxyConfigSpillSize:  p.getInt(proxyConfigSpillEnv, defaults.ProxyConfigSpillSize),
+		ProxyConfigEncoding:   proxyConfigEncoding,
+		ProxyCommand:          p.getList(proxyCommandEnv),
+		ProxyArgs:             proxyArgs,
+		ProxyLabels:           proxyLabels,
+		ProxyAnnotations:      proxyAnnotations,
+		ProxyWorkload:         proxyWorkload,
+		ProxyNodeSelector:     proxyNodeSelector,
+		ProxyHostPorts:        p.getBool(proxyHostPortsEnv, false),
+		ProxyStatsPort:        p.getInt(proxyStatsPortEnv, 0),
+		ProxyStatsPath:        p.getString(proxyStatsPathEnv, defaults.ProxyStatsPath),
+		ProxyStatsInterval:    proxyStatsInterval,
+		ProxyMinReplicas:      proxyMinReplicas,
+		ProxyMaxReplicas:      proxyMaxReplicas,
+		ProxyTargetConns:      p.getInt(proxyTargetConnsEnv, 0),
+		ProxyScaleDownDelay:   p.getDuration(proxyScaleDownEnv, defaults.ProxyScaleDownDelay),
+		PortProbeInterval:     p.getDuration(portProbePeriodEnv, 0),
+		PortProbeTimeout:      p.getDuration(portProbeTimeoutEnv, defaults.PortProbeTimeout),
+		ExternalCheckInterval: p.getDuration(externalCheckEnv, 0),
+		ExternalCheckSample:   p.getInt(externalSampleEnv, defaults.ExternalCheckSample),
+		ReconcileStallTimeout: p.getDuration(stallTimeoutEnv, defaults.ReconcileStallTimeout),
+		AuditConfigMap:        p.get(auditConfigMapEnv),
+		AuditMaxEntries:       p.getInt(auditMaxEntriesEnv, defaults.AuditMaxEntries),
+		AuditWebhookURL:       p.get(auditWebhookEnv),
+		AlertWebhookURL:       p.get(alertWebhookEnv),
+		AlertFailurePeriod:    p.getDuration(alertPeriodEnv, defaults.AlertFailurePeriod),
+		AlertRegisterFailures: p.getInt(alertRegisterEnv, defaults.AlertRegisterFailures),
+		PortMapConfigMap:      p.getString(portMapConfigMapEnv, defaults.PortMapConfigMap),
+		PublicPortResources:   p.getBool(publicPortsEnv, defaults.PublicPortResources),
+		PortConflictScope:     portConflictScope,
+		ReservedPorts:         reservedPorts,
+		AllowPrivilegedPorts:  p.getBool(privilegedPortsEnv, defaults.AllowPrivilegedPorts),
+		PortSettings:          portSettings,
+		ProxyCapabilities:     proxyCapabilities,
+		HostnameTemplate:      hostnameTemplate,
+		MicroserviceMetadata:  p.getBool(msvcMetadataEnv, false),
+		PortOptOut:            p.getBool(portOptOutEnv, false),
+		ProxyTLSSecret:        p.get(proxyTLSSecretEnv),
+		ProxyTLSRedirectPort:  p.getInt(proxyTLSRedirectEnv, 0),
+		IngressMode:           ingressMode,
+		IngressGateway:        p.get(ingressGatewayEnv),
+		IngressAnnotations:    ingressAnnotations,
+		IngressGatewayClass:   p.get(gatewayClassEnv),
+		ServiceExportAPI:      serviceExportAPI,
+		LoadBalancerPreset:    lbPreset,
+		LoadBalancerIP:        lbIP,
+		ClientIPCheck:         p.getBool(clientIPCheckEnv, defaults.ClientIPCheck),
+		ProxyGrouping:         proxyGrouping,
+		PortShards:            portShards,
+		ProxyZones:            proxyZones,
+		OutOfCluster:          !isInCluster(),
+		OwnerName:             p.getString(ownerNameEnv, defaults.OwnerName),
+		OwnerMode:             ownerMode,
+		ControllerURL:         p.get(controllerURLEnv),
+		ControllerConditional: p.getBool(controllerCondEnv, false),
+		ControllerTimeout:     p.getDuration(controllerTimeoutEnv, defaults.ControllerTimeout),
+		ControllerCallTimeout: p.getDuration(controllerCallEnv, defaults.ControllerCallTimeout),
+		ControllerProxy:       p.get(controllerProxyEnv),
+		ControllerNoProxy:     p.get(controllerNoProxyEnv),
+		NotificationAddress:   p.get(notifyQueueEnv),
+		ProtocolRegistration:  p.getBool(protocolRegisterEnv, false),
+		SimulationFixture:     simulationFixture,
+	}
+	// The ingress controller exposes the Proxy, unless a service-type is set for the proxy
+	if ingressMode != "" {
 		opt.ProxyServiceType = "ClusterIP"
-		opt.ProtocolFilter = "tcp"
-		opt.ProxyName = "tcp-proxy"
-		opt.ProxyExternalAddress = envs[tcpProxyAddressEnv].value
-		opts = append(opts, opt)
 	}
-	return opts
+	return opt
 }
 
-func generateManagers(namespace string, cfg *rest.Config) (mgrs []*manager.Manager) {
-	opts := generateManagerOptions(namespace, cfg)
-	// No external address provided, Manager will create Proxy LoadBalancer and single Deployment
-	for idx := range opts {
-		opt := &opts[idx]
-		mgr, err := manager.New(opt)
-		handleErr(err, "")
-		mgrs = append(mgrs, mgr)
+// Generate the Options of each Manager from the settings
+// Without proxies in the config file, a single LoadBalancer Proxy or, given both external addresses, an HTTP and a TCP Proxy are run
+// Proxies with a kubeconfig are managed in that cluster, sharing a PortRegistry with the other Proxies of the cluster
+func generateManagerOptions(file *settingsFile) ([]manager.Options, error) {
+	registry := manager.NewPortRegistry()
+	registries := make(map[string]*manager.PortRegistry)
+	clusters := make(map[string]remoteCluster)
+	setCluster := func(p *settingsParser, opt *manager.Options) {
+		kubeconfig := p.get(proxyKubeconfigEnv)
+		if kubeconfig == "" {
+			opt.PortRegistry = registry
+			return
+		}
+		cluster, exists := clusters[kubeconfig]
+		if !exists {
+			var err error
+			cluster, err = loadRemoteCluster(p, kubeconfig)
+			p.check(proxyKubeconfigEnv, err)
+			clusters[kubeconfig] = cluster
+			registries[kubeconfig] = manager.NewPortRegistry()
+		}
+		opt.Config = cluster.config
+		opt.Namespace = cluster.namespace
+		opt.PortRegistry = registries[kubeconfig]
+		// The port-manager Deployment does not exist in the remote cluster to own the Proxy resources
+		opt.OutOfCluster = true
 	}
-	return
+	var opts []manager.Options
+	var errs []string
+	if file == nil || len(file.proxies) == 0 {
+		p := &settingsParser{file: file}
+		opt := parseManagerOptions(p)
+		setCluster(p, &opt)
+		if err := p.err(); err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+		httpProxyAddress, err := manager.ParseExternalAddress(p.get(httpProxyAddressEnv))
+		p.check(httpProxyAddressEnv, err)
+		tcpProxyAddress, err := manager.ParseExternalAddress(p.get(tcpProxyAddressEnv))
+		p.check(tcpProxyAddressEnv, err)
+		if err := p.err(); err != nil {
+			return nil, err
+		}
+		if httpProxyAddress != "" && tcpProxyAddress != "" {
+			// Update first opt
+			opts[0].ProxyServiceType = "ClusterIP"
+			opts[0].ProtocolFilter = "http"
+			opts[0].ProxyName = "http-proxy"
+			opts[0].ProxyExternalAddress = httpProxyAddress
+			// Create second opt
+			opt.ProxyServiceType = "ClusterIP"
+			opt.ProtocolFilter = "tcp"
+			opt.ProxyName = "tcp-proxy"
+			opt.ProxyExternalAddress = tcpProxyAddress
+			opts = append(opts, opt)
+		}
+		return opts, nil
+	}
+	for _, proxy := range file.proxies {
+		p := &settingsParser{file: file, overrides: proxy.values, prefix: "proxy " + proxy.name + ": "}
+		opt := parseManagerOptions(p)
+		setCluster(p, &opt)
+		opt.ProxyName = proxy.name
+		opt.ProtocolFilter = proxy.protocol
+		externalAddress, err := manager.ParseExternalAddress(proxy.externalAddress)
+		if err != nil {
+			p.errs = append(p.errs, fmt.Sprintf("%sinvalid %s: %s", p.prefix, proxyExternalAddressKey, err.Error()))
+		}
+		opt.ProxyExternalAddress = externalAddress
+		if proxy.externalAddress != "" {
+			opt.ProxyServiceType = "ClusterIP"
+		}
+		if proxy.serviceType != "" {
+			serviceType, err := manager.ParseServiceType(proxy.serviceType)
+			if err != nil {
+				p.errs = append(p.errs, fmt.Sprintf("%sinvalid %s: %s", p.prefix, proxyServiceTypeKey, err.Error()))
+			}
+			opt.ProxyServiceType = serviceType
+		}
+		errs = append(errs, p.errs...)
+		opts = append(opts, opt)
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	return opts, nil
 }
 
 func handleErr(err error, msg string) {
@@ -96,36 +401,100 @@ func handleErr(err error, msg string) {
 	}
 }
 
+// serveMetrics exposes Prometheus metrics of all Managers over HTTP
+func serveMetrics(p *settingsParser) {
+	addr := p.getString(metricsAddressEnv, defaultMetricsAddress)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc(livenessPath, serveLiveness)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error(err, "Metrics server stopped")
+		}
+	}()
+}
+
 // getWatchNamespace returns the Namespace the operator should be watching for changes
 func getWatchNamespace() (ns string) {
 	// WatchNamespaceEnvVar is the constant for env variable WATCH_NAMESPACE
 	// which specifies the Namespace to watch.
 	// An empty value means the operator is running with cluster scope.
-	ns, _ = os.LookupEnv("WATCH_NAMESPACE")
+	ns, _ = lookupEnv(watchNamespaceEnv)
 	return
 }
 
 func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     binaryName,
+		Short:   "Expose ioFog Microservice public ports through the Proxy",
+		Long:    "Expose ioFog Microservice public ports through the Proxy\nEach flag can also be set with the env var named in its description or in the --config-file, flags take precedence over env vars and env vars over the config file",
+		Version: version,
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runManagers()
+		},
+	}
+	cmd.PersistentFlags().AddFlag(newKubeconfigFlag())
+	addSettingFlags(cmd.Flags())
+	cmd.AddCommand(newStatusCommand())
+	cmd.AddCommand(newVersionCommand())
+	return cmd
+}
+
+// Run the Managers until SIGINT or SIGTERM is received
+func runManagers() {
+	setupLogger()
+	log.Info("Starting "+binaryName, "version", version, "commit", commit)
+	file, err := readSettingsFile()
+	handleErr(err, "Invalid "+configFileEnv)
+	// Report invalid settings before connecting to the cluster
+	_, err = generateManagerOptions(file)
+	handleErr(err, "Invalid configuration")
+
+	shutdownTracing, err := setupTracing(context.Background())
+	handleErr(err, "Failed to set up tracing")
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error(err, "Failed to flush traces")
+		}
+	}()
+
 	// Get a config to talk to the apiserver
 	cfg, err := config.GetConfig()
 	handleErr(err, "")
+	p := &settingsParser{file: file}
+	setRateLimiter(p, cfg)
+	handleErr(p.err(), "Invalid Kubernetes client settings")
+	if !isInCluster() {
+		log.Info("Running outside the cluster", "host", cfg.Host)
+	}
 
 	// Instantiate Manager(s)
-	mgrs := generateManagers(getWatchNamespace(), cfg)
+	gen, err := newGeneration(file, cfg)
+	handleErr(err, "Failed to create Managers")
 
-	// Run Managers
-	for _, mgr := range mgrs {
-		go mgr.Run()
-	}
+	// Stop Managers on termination
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveMetrics(p)
+	serveDebug()
+	reloads, err := watchSettingsFile(ctx)
+	handleErr(err, "Failed to watch "+configFileEnv)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1)
+	defer signal.Stop(signals)
 
 	// Set ready
-	readyPath := "/tmp/operator-sdk-ready"
-	if _, err := os.Stat(readyPath); os.IsNotExist(err) {
-		file, err := os.Create(readyPath)
-		handleErr(err, "Failed to create ready file")
-		defer file.Close()
-	}
+	handleErr(setReady(true), "Failed to create ready file")
+	go watchReadiness(ctx)
 
-	// Wait forever
-	select {}
+	// Run Managers until termination
+	handleErr(runGenerations(ctx, gen, cfg, reloads, signals), "Failed to run Managers")
 }
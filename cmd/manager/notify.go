@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/eclipse-iofog/port-manager/v3/pkg/manager"
+)
+
+const (
+	notifyAddressEnv   = "NOTIFY_ADDRESS"
+	notifyTokenEnv     = "NOTIFY_TOKEN"
+	notifyTokenFileEnv = "NOTIFY_TOKEN_FILE"
+	notifyCertDirEnv   = "NOTIFY_CERT_DIR"
+)
+
+// serveNotifications exposes the endpoint the Controller notifies of public port changes when a notification address is configured
+// It is served over HTTPS with the tls.crt and tls.key of the cert dir, plain HTTP is only served on a loopback address
+func serveNotifications(ctrlMgr ctrl.Manager, mgrs []*manager.Manager) error {
+	addr := getEnv(notifyAddressEnv)
+	if addr == "" {
+		return nil
+	}
+	token, err := getToken(notifyTokenEnv, notifyTokenFileEnv)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", notifyTokenFileEnv, err.Error())
+	}
+	if token == "" {
+		return errors.New(notifyTokenEnv + " or " + notifyTokenFileEnv + " is required with " + notifyAddressEnv)
+	}
+	server := &http.Server{Addr: addr, Handler: manager.NewNotificationHandler(mgrs, token)}
+	if certDir := getEnv(notifyCertDirEnv); certDir != "" {
+		config, err := loadServerTLSConfig(certDir)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", notifyCertDirEnv, err.Error())
+		}
+		server.TLSConfig = config
+	} else if !isLoopbackAddress(addr) {
+		return fmt.Errorf("%s is required to serve the notification endpoint on the non-loopback address %s", notifyCertDirEnv, addr)
+	}
+	return ctrlMgr.Add(newHTTPServerRunnable("notification endpoint", server))
+}
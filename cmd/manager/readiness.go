@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse-iofog/port-manager/v3/pkg/manager"
+)
+
+// Checked by the readiness probe of the port-manager Deployment
+const readyPath = "/tmp/operator-sdk-ready"
+
+const readinessInterval = time.Second * 5
+
+// []*manager.Manager of the running generation
+var runningManagers atomic.Value
+
+// Served for the liveness probe of the port-manager Deployment
+const livenessPath = "/healthz"
+
+// Create or remove the ready file
+func setReady(ready bool) error {
+	if !ready {
+		if err := os.Remove(readyPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if _, err := os.Stat(readyPath); !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.Create(readyPath)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// watchReadiness keeps the ready file while the external path of every running Manager passes its synthetic check
+// and the remote clusters of the Managers are reachable
+func watchReadiness(ctx context.Context) {
+	ready := true
+	ticker := time.NewTicker(readinessInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		mgrs, _ := runningManagers.Load().([]*manager.Manager)
+		var reason error
+		for _, mgr := range mgrs {
+			if reason = mgr.ClusterError(); reason != nil {
+				break
+			}
+			if reason = mgr.ExternalCheckError(); reason != nil {
+				break
+			}
+		}
+		healthy := reason == nil
+		if healthy == ready {
+			continue
+		}
+		if err := setReady(healthy); err != nil {
+			log.Error(err, "Failed to update ready file")
+			continue
+		}
+		ready = healthy
+		if ready {
+			log.Info("Managers healthy, ready")
+		} else {
+			log.Info("Manager unhealthy, not ready", "reason", reason.Error())
+		}
+	}
+}
+
+// serveLiveness fails once a running Manager stops finishing reconciles, so a hung reconcile loop restarts the pod
+func serveLiveness(w http.ResponseWriter, r *http.Request) {
+	mgrs, _ := runningManagers.Load().([]*manager.Manager)
+	for _, mgr := range mgrs {
+		if err := mgr.ReconcileStallError(); err != nil {
+			log.Error(err, "Reconcile loop stalled, not live")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
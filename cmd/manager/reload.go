@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/eclipse-iofog/port-manager/v3/pkg/manager"
+)
+
+// Bound on the deletion requests of the labelled resources when the program stops
+const cleanupTimeout = time.Second * 30
+
+// A generation of Managers running with the same settings, replaced when the config file changes
+type generation struct {
+	file    *settingsFile
+	mgrs    []*manager.Manager
+	ctrlMgr ctrl.Manager
+}
+
+// newGeneration creates the Managers, their controller manager and servers from the settings of file
+// The settings of file are only kept if the generation is created
+func newGeneration(file *settingsFile, cfg *rest.Config) (gen *generation, err error) {
+	previous := loadedSettingsFile
+	loadedSettingsFile = file
+	defer func() {
+		if err != nil {
+			loadedSettingsFile = previous
+		}
+	}()
+
+	opts, err := generateManagerOptions(file)
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := getNamespace()
+	if err != nil {
+		return nil, err
+	}
+	gen = &generation{file: file}
+	var proxies []string
+	for _, opt := range opts {
+		mgrOpts := []manager.Option{manager.WithOptions(opt)}
+		// Unless the Proxy is managed in a remote cluster
+		if opt.Config == nil {
+			mgrOpts = append(mgrOpts, manager.WithConfig(cfg))
+		}
+		if opt.Namespace == "" {
+			mgrOpts = append(mgrOpts, manager.WithNamespace(namespace))
+		}
+		mgr, err := manager.New(mgrOpts...)
+		if err != nil {
+			return nil, err
+		}
+		gen.mgrs = append(gen.mgrs, mgr)
+		proxies = append(proxies, mgr.Name())
+	}
+	if gen.ctrlMgr, err = manager.NewControllerManager(cfg, gen.mgrs); err != nil {
+		return nil, err
+	}
+	for _, serve := range []func(ctrl.Manager, []*manager.Manager) error{serveAdmin, serveNotifications, serveGRPC, serveAdmission} {
+		if err = serve(gen.ctrlMgr, gen.mgrs); err != nil {
+			return nil, err
+		}
+	}
+	log.Info("Created Managers", "namespace", namespace, "proxies", proxies)
+	return gen, nil
+}
+
+// runGenerations runs the Managers until ctx is done
+// When the config file changes, the Managers are replaced once the next generation is created
+// On SIGHUP or SIGUSR1 the config file is also re-read and, if unchanged, the Managers rebuild their caches
+func runGenerations(ctx context.Context, gen *generation, cfg *rest.Config, reloads <-chan struct{}, signals <-chan os.Signal) error {
+	for {
+		runningManagers.Store(gen.mgrs)
+		genCtx, stop := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- gen.ctrlMgr.Start(genCtx)
+		}()
+		var next *generation
+		for next == nil {
+			select {
+			case err := <-done:
+				stop()
+				if err == nil && ctx.Err() != nil {
+					cleanupManagers(gen.mgrs)
+				}
+				return err
+			case <-reloads:
+				next = reloadGeneration(gen, cfg)
+			case sig := <-signals:
+				log.Info("Received " + sig.String() + ", reloading config file")
+				if next = reloadGeneration(gen, cfg); next == nil {
+					for _, mgr := range gen.mgrs {
+						mgr.Rebuild()
+					}
+				}
+			}
+		}
+		log.Info("Restarting Managers with reloaded config file")
+		stop()
+		if err := <-done; err != nil {
+			return err
+		}
+		gen = next
+	}
+}
+
+// Create the next generation if the config file changed, nil if it did not change or is invalid
+func reloadGeneration(gen *generation, cfg *rest.Config) *generation {
+	file, err := readSettingsFile()
+	if err != nil {
+		log.Error(err, "Failed to reload config file, keeping the current settings")
+		return nil
+	}
+	if !gen.file.changed(file) {
+		return nil
+	}
+	next, err := newGeneration(file, cfg)
+	if err != nil {
+		log.Error(err, "Failed to apply config file, keeping the current settings")
+		return nil
+	}
+	return next
+}
+
+// Delete the labelled resources of the Managers stopped for good, the Proxies are kept while the config is reloaded
+func cleanupManagers(mgrs []*manager.Manager) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	for _, mgr := range mgrs {
+		if err := mgr.Cleanup(ctx); err != nil {
+			log.Error(err, "Failed to delete labelled resources", "proxy", mgr.Name())
+		}
+	}
+}
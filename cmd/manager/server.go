@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	ctrlmanager "sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const serverShutdownTimeout = time.Second * 5
+
+// Load the tls.crt and tls.key of a cert dir to serve over TLS with
+func loadServerTLSConfig(certDir string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certDir, "tls.crt"), filepath.Join(certDir, "tls.key"))
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// Whether a listen address only accepts connections from within the pod, an empty host listens on all interfaces
+func isLoopbackAddress(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// newHTTPServerRunnable serves HTTP, or HTTPS if the server has a TLS config with its certificate, until the controller manager stops
+// Failures are logged rather than stopping the Managers
+func newHTTPServerRunnable(name string, server *http.Server) ctrlmanager.RunnableFunc {
+	return func(ctx context.Context) error {
+		done := make(chan error, 1)
+		go func() {
+			if server.TLSConfig != nil {
+				done <- server.ListenAndServeTLS("", "")
+				return
+			}
+			done <- server.ListenAndServe()
+		}()
+		log.Info("Serving "+name, "address", server.Addr)
+		select {
+		case err := <-done:
+			log.Error(err, name+" server stopped")
+			return nil
+		case <-ctx.Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(err, "Failed to shut down "+name+" server")
+		}
+		return nil
+	}
+}
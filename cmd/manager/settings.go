@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// A setting is read from its flag or, if the flag is not set, from its env var or the config file
+// Flags and config file keys are named after the env var, e.g. --proxy-image for PROXY_IMAGE
+type setting struct {
+	key  string
+	kind settingKind
+	// Process-wide settings cannot be overridden by the proxies entries of the config file
+	global bool
+	usage  string
+}
+
+// Type of the flag of a setting, so invalid values are rejected when the flags are parsed
+type settingKind int
+
+const (
+	stringSetting settingKind = iota
+	boolSetting
+	intSetting
+	floatSetting
+	durationSetting
+)
+
+// The standard OTEL_EXPORTER_OTLP_* env vars are read by the exporter and have no flags
+var settings = []setting{
+	{key: configFileEnv, global: true, usage: "YAML config file of the settings, reloaded when it changes"},
+	{key: watchNamespaceEnv, global: true, usage: "Namespace of the Controller and Proxies"},
+	{key: userEmailEnv, usage: "Controller user email"},
+	{key: userPassEnv, usage: "Controller user password, may be base64 encoded, prefer the env var"},
+	{key: controllerURLEnv, usage: "Controller API URL, defaults to the in-cluster Controller Service"},
+	{key: controllerCondEnv, kind: boolSetting, usage: "Query the public ports with conditional requests to skip unchanged lists"},
+	{key: controllerTimeoutEnv, kind: durationSetting, usage: "Timeout of each Controller API request"},
+	{key: controllerCallEnv, kind: durationSetting, usage: "Deadline of each Controller API call including its retries, 0 disables the deadline"},
+	{key: controllerProxyEnv, usage: "HTTP(S) proxy the Controller API is reached through, e.g. http://proxy.example.com:3128, defaults to HTTPS_PROXY and HTTP_PROXY"},
+	{key: controllerNoProxyEnv, usage: "Comma-separated hosts, domains and CIDRs of Controllers reached without " + controllerProxyEnv + ", defaults to NO_PROXY"},
+	{key: simulationEnv, usage: "File of public ports served instead of the Controller API"},
+	{key: routerAddressEnv, usage: "Comma-separated router host[:port] addresses, discovered from " + routerServiceEnv + " if not set"},
+	{key: routerServiceEnv, usage: "Name or label selector of the router Service the router address is discovered from"},
+	{key: routerSchemeEnv, usage: "Router scheme, amqp or amqps"},
+	{key: routerTLSSecretEnv, usage: "Secret with the router TLS CA and client certificate"},
+	{key: routerTLSVerifyEnv, kind: boolSetting, usage: "Verify the router TLS certificate"},
+	{key: proxyImageEnv, usage: "Proxy image"},
+	{key: httpProxyAddressEnv, usage: "External address of the HTTP Proxy, requires " + tcpProxyAddressEnv},
+	{key: tcpProxyAddressEnv, usage: "External address of the TCP Proxy, requires " + httpProxyAddressEnv},
+	{key: proxyReadyTimeoutEnv, kind: durationSetting, usage: "Time to wait for the Proxy Deployment to become ready"},
+	{key: portDrainPeriodEnv, kind: durationSetting, usage: "Time removed ports are drained before the Proxy stops serving them"},
+	{key: proxyStrategyEnv, usage: "Proxy Deployment strategy, RollingUpdate or Recreate"},
+	{key: proxyMaxSurgeEnv, usage: "Proxy rolling update max surge"},
+	{key: proxyMaxUnavailEnv, usage: "Proxy rolling update max unavailable"},
+	{key: proxyAutoRollbackEnv, kind: boolSetting, usage: "Roll back Proxy rollouts exceeding the progress deadline, the failed config is kept off until the ports change"},
+	{key: proxyProgressEnv, kind: durationSetting, usage: "Proxy Deployment progress deadline"},
+	{key: proxyPinDigestEnv, kind: boolSetting, usage: "Pin the Proxy image to its digest"},
+	{key: proxyNodeOSEnv, usage: "Comma-separated node operating systems the Proxy can run on"},
+	{key: proxyNodeArchEnv, usage: "Comma-separated node architectures the Proxy can run on"},
+	{key: proxyDetectPlatEnv, kind: boolSetting, usage: "Detect the platforms of the Proxy image"},
+	{key: proxySidecarsEnv, usage: "ConfigMap of additional Proxy containers"},
+	{key: proxyWaitRouterEnv, kind: boolSetting, usage: "Wait for the router before starting the Proxy"},
+	{key: proxyConfigSpillEnv, kind: intSetting, usage: "Size in bytes above which the Proxy config is mounted from a ConfigMap, 0 never spills"},
+	{key: proxyConfigEncEnv, usage: "Encoding of the queues in the Proxy config, plain rejects queues containing separators, escaped percent-encodes them and json passes a versioned JSON document"},
+	{key: proxyCommandEnv, usage: "Comma-separated command of the Proxy container, defaults to the entrypoint of the Proxy image"},
+	{key: proxyArgsEnv, usage: "Comma-separated argument template of the Proxy container with {{.Config}} or {{.ConfigFile}}, defaults to node /opt/app-root/bin/simple.js {{.Config}}"},
+	{key: proxyLabelsEnv, usage: "Comma-separated key=value labels of the Proxy Deployment, pods and Services"},
+	{key: proxyAnnotationsEnv, usage: "Semicolon-separated key=value annotations of the Proxy Deployment, pods and Services"},
+	{key: proxyWorkloadEnv, usage: "Kind of the Proxy workload, Deployment, DaemonSet running a Proxy pod on every selected node or StatefulSet giving each replica a stable hostname"},
+	{key: proxyNodeSelectEnv, usage: "Comma-separated key=value node labels the Proxy pods are restricted to"},
+	{key: proxyHostPortsEnv, kind: boolSetting, usage: "Bind the public ports on the nodes running the Proxy pods"},
+	{key: proxyStatsPortEnv, kind: intSetting, usage: "Port of the per-port stats served by the Proxy pods, 0 disables scraping"},
+	{key: proxyStatsPathEnv, usage: "Path of the per-port stats served by the Proxy pods"},
+	{key: proxyStatsPeriodEnv, kind: durationSetting, usage: "Interval the Proxy pods stats are scraped at"},
+	{key: proxyMinReplicasEnv, kind: intSetting, usage: "Replicas the Proxy is created with and scaled down to"},
+	{key: proxyMaxReplicasEnv, kind: intSetting, usage: "Replicas the Proxy is scaled up to, defaults to " + proxyMinReplicasEnv},
+	{key: proxyTargetConnsEnv, kind: intSetting, usage: "Connections per Proxy replica to scale for, requires " + proxyStatsPortEnv},
+	{key: proxyScaleDownEnv, kind: durationSetting, usage: "Time fewer Proxy replicas must be needed before scaling down"},
+	{key: portProbePeriodEnv, kind: durationSetting, usage: "Interval the exposed ports are probed through the Proxy Service at, disabled if empty"},
+	{key: portProbeTimeoutEnv, kind: durationSetting, usage: "Timeout of each port probe"},
+	{key: externalCheckEnv, kind: durationSetting, usage: "Interval a sample of ports is checked through the registered external address at, disabled if empty"},
+	{key: stallTimeoutEnv, kind: durationSetting, usage: "Time without a finished reconcile after which the liveness endpoint fails, disabled if empty"},
+	{key: externalSampleEnv, kind: intSetting, usage: "Ports checked through the external address each interval"},
+	{key: auditConfigMapEnv, usage: "ConfigMap port exposure changes are audited to"},
+	{key: auditMaxEntriesEnv, kind: intSetting, usage: "Audit records kept in the audit ConfigMap"},
+	{key: auditWebhookEnv, usage: "Webhook port exposure changes are audited to"},
+	{key: alertWebhookEnv, usage: "Webhook persistent failures are reported to"},
+	{key: alertPeriodEnv, kind: durationSetting, usage: "Time reconcile failures persist before an alert"},
+	{key: alertRegisterEnv, kind: intSetting, usage: "Consecutive registration failures before an alert"},
+	{key: portMapConfigMapEnv, usage: "ConfigMap the port map is exported to and restored from when the Proxy Deployment is missing e.g. iofog-public-ports, disabled if empty"},
+	{key: publicPortsEnv, kind: boolSetting, usage: "Mirror exposed ports to PublicPort resources"},
+	{key: portConflictScopeEnv, usage: "Where other Services are checked for port collisions, namespace, cluster or none"},
+	{key: reservedPortsEnv, usage: "Comma-separated ports and port ranges that are never exposed"},
+	{key: privilegedPortsEnv, kind: boolSetting, usage: "Allow exposing ports below 1024"},
+	{key: portSettingsEnv, usage: "Comma-separated ports or port ranges and their limits and timeouts, e.g. 5000-5010:max-connections=100;idle-timeout=1h, limits and timeouts require the port-settings capability of " + proxyCapabilitiesEnv},
+	{key: proxyCapabilitiesEnv, usage: "Comma-separated features of the Proxy image beyond the stock Proxy, which ignores the settings it does not know, e.g. port-settings,basic-auth"},
+	{key: hostnameTemplateEnv, usage: "Template of the hostnames of HTTP ports published for external-dns, e.g. {{.Microservice}}.{{.Application}}.edge.example.com"},
+	{key: msvcMetadataEnv, kind: boolSetting, usage: "Label and annotate the Proxy Service, port map and PublicPort resources with the microservice and application of their ports"},
+	{key: portOptOutEnv, kind: boolSetting, usage: "Skip the public ports a microservice lists in its IOFOG_PORT_MANAGER_EXCLUDE environment variable, e.g. ports only exposed on the LAN of its Agent"},
+	{key: ingressModeEnv, usage: "Ingress controller the ports are routed to a ClusterIP Proxy Service through, istio, traefik, contour, kong or gateway"},
+	{key: ingressGatewayEnv, usage: "Gateway of the ingress controller, e.g. the istio label of the Istio ingress gateway pods, the Traefik entry point of hostnames the Kong ingress class or the Gateway API Gateway"},
+	{key: gatewayClassEnv, usage: "GatewayClass of a Gateway API Gateway owned by the port-manager with a listener per port"},
+	{key: proxyKubeconfigEnv, usage: "Kubeconfig of a remote cluster the Proxy is managed in, e.g. a regional ingress cluster, usually set per proxy in the config file"},
+	{key: proxyIPFamiliesEnv, usage: "Comma-separated IP families of the Proxy Service, IPv4 and/or IPv6 with the primary first, defaults to the cluster's"},
+	{key: lbPresetEnv, usage: "Cloud provider load balancer of a LoadBalancer Proxy Service, aws-nlb, aws-nlb-internal, gcp-internal, azure-internal, oci or do"},
+	{key: lbIPEnv, usage: "Pre-reserved IP of a LoadBalancer Proxy Service, or comma-separated Elastic IP allocations of an AWS NLB"},
+	{key: clientIPCheckEnv, kind: boolSetting, usage: "Warn when the external traffic policy or PROXY protocol settings of the Proxy Service lose the client IPs"},
+	{key: serviceExportEnv, usage: "Group/version of the MCS API ServiceExport exporting the Proxy Service to peer clusters, e.g. multicluster.x-k8s.io/v1alpha1"},
+	{key: ingressAnnotateEnv, usage: "Semicolon-separated annotations of the ingress controller resources, e.g. konghq.com/plugins=rate-limiting,key-auth"},
+	{key: proxyTLSSecretEnv, usage: "kubernetes.io/tls Secret of a wildcard certificate the Proxy terminates TLS with on all HTTP ports"},
+	{key: proxyTLSRedirectEnv, kind: intSetting, usage: "HTTPS port HTTP requests to port 80 of the Proxy are redirected to with a 301, requires " + proxyTLSSecretEnv + " and the tls-redirect capability of " + proxyCapabilitiesEnv + ", disabled if 0"},
+	{key: metricsAddressEnv, global: true, usage: "Address of the Prometheus metrics endpoint"},
+	{key: debugAddressEnv, global: true, usage: "Address of the pprof and expvar endpoints, disabled if empty"},
+	{key: k8sQPSEnv, kind: floatSetting, global: true, usage: "Requests per second to each Kubernetes API server, shared by the Managers of the cluster"},
+	{key: k8sBurstEnv, kind: intSetting, global: true, usage: "Requests to each Kubernetes API server allowed above " + k8sQPSEnv + " in a burst"},
+	{key: adminAddressEnv, global: true, usage: "Address of the admin API e.g. 127.0.0.1:8082, disabled if empty"},
+	{key: adminTokenEnv, global: true, usage: "Bearer token of the admin API, prefer the env var"},
+	{key: adminTokenFileEnv, global: true, usage: "File holding the bearer token of the admin API"},
+	{key: adminCertDirEnv, global: true, usage: "Directory with the tls.crt and tls.key the admin API is served with over HTTPS, required unless it listens on a loopback address"},
+	{key: proxyGroupingEnv, usage: "Run a Proxy per group of public ports instead of a single Proxy, application runs a Proxy per ioFog Application, microservice a Proxy per microservice and zone a Proxy per availability zone"},
+	{key: proxyZonesEnv, usage: "Comma-separated availability zones a Proxy is run in with zone grouping, empty runs one in each zone of the topology.kubernetes.io/zone node labels"},
+	{key: portShardsEnv, usage: "Run a Proxy per shard of public ports instead of a single Proxy, e.g. a=1-10000;b=10001-65535"},
+	{key: ownerNameEnv, usage: "Name of the Manager Deployment owning the Proxy resources"},
+	{key: ownerModeEnv, usage: "How the Proxy resources are tied to the Manager, reference sets owner references to the owner Deployment and labels labels the resources with the owner name instead"},
+	{key: protocolRegisterEnv, kind: boolSetting, usage: "Register the address of each Proxy filtering a protocol as the <protocol>-public-port-host of the Controller instead of its default-proxy-host"},
+	{key: notifyQueueEnv, usage: "Router address the Controller publishes public port changes to, e.g. multicast/iofog.public-ports, disabled if empty"},
+	{key: notifyAddressEnv, global: true, usage: "Address of the endpoint the Controller posts public port changes to, disabled if empty"},
+	{key: notifyTokenEnv, global: true, usage: "Bearer token of the notification endpoint, prefer the env var"},
+	{key: notifyTokenFileEnv, global: true, usage: "File holding the bearer token of the notification endpoint"},
+	{key: notifyCertDirEnv, global: true, usage: "Directory with the tls.crt and tls.key the notification endpoint is served with over HTTPS, required unless it listens on a loopback address"},
+	{key: grpcAddressEnv, global: true, usage: "Address of the gRPC API e.g. 127.0.0.1:9090, disabled if empty"},
+	{key: grpcTokenEnv, global: true, usage: "Bearer token of the gRPC API, prefer the env var"},
+	{key: grpcTokenFileEnv, global: true, usage: "File holding the bearer token of the gRPC API"},
+	{key: grpcCertDirEnv, global: true, usage: "Directory with the tls.crt and tls.key the gRPC API is served with over TLS and an optional ca.crt client certificates must be signed by, required unless it listens on a loopback address"},
+	{key: webhookPortEnv, kind: intSetting, global: true, usage: "Port of the admission webhooks, disabled if empty, see config/webhook for their configuration"},
+	{key: webhookCertDirEnv, global: true, usage: "Directory with the tls.crt and tls.key of the admission webhooks"},
+	{key: logLevelEnv, global: true, usage: "Log level, e.g. info, debug or a verbosity"},
+	{key: logFormatEnv, global: true, usage: "Log format, json or console"},
+	{key: logStacktraceEnv, global: true, usage: "Level from which stack traces are logged"},
+}
+
+// Flags of the settings by env var
+var settingFlags = make(map[string]*pflag.Flag)
+
+func addSettingFlags(flags *pflag.FlagSet) {
+	for _, setting := range settings {
+		name := getFlagName(setting.key)
+		usage := fmt.Sprintf("%s (%s)", setting.usage, setting.key)
+		switch setting.kind {
+		case boolSetting:
+			flags.Bool(name, false, usage)
+		case intSetting:
+			flags.Int(name, 0, usage)
+		case floatSetting:
+			flags.Float64(name, 0, usage)
+		case durationSetting:
+			flags.Duration(name, 0, usage)
+		default:
+			flags.String(name, "", usage)
+		}
+		settingFlags[setting.key] = flags.Lookup(name)
+	}
+}
+
+func getFlagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// lookupEnv returns the value of the setting's flag if set, otherwise of its env var or the config file
+func lookupEnv(key string) (string, bool) {
+	if value, exists := lookupFlagOrEnv(key); exists {
+		return value, true
+	}
+	return loadedSettingsFile.lookup(key)
+}
+
+func lookupFlagOrEnv(key string) (string, bool) {
+	if flag, exists := settingFlags[key]; exists && flag.Changed {
+		return flag.Value.String(), true
+	}
+	return os.LookupEnv(key)
+}
+
+func getEnv(key string) string {
+	value, _ := lookupEnv(key)
+	return value
+}
+
+// settingsParser parses settings, collecting all invalid settings so they are reported together
+type settingsParser struct {
+	file *settingsFile
+	// Settings of a single Proxy, taking precedence over the config file
+	overrides map[string]string
+	// Reported with the errors, e.g. the Proxy name
+	prefix string
+	errs   []string
+}
+
+// Get a setting from its flag, env var, the overrides or the config file, in that order
+func (p *settingsParser) get(key string) string {
+	if value, _ := lookupFlagOrEnv(key); value != "" {
+		return value
+	}
+	if value, exists := p.overrides[key]; exists {
+		return value
+	}
+	value, _ := p.file.lookup(key)
+	return value
+}
+
+func (p *settingsParser) check(key string, err error) {
+	if err != nil {
+		p.errs = append(p.errs, fmt.Sprintf("%sinvalid %s (--%s): %s", p.prefix, key, getFlagName(key), err.Error()))
+	}
+}
+
+func (p *settingsParser) err() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(p.errs, "; "))
+}
+
+// Get a required setting
+func (p *settingsParser) getRequired(key string) string {
+	value := p.get(key)
+	if value == "" {
+		p.errs = append(p.errs, fmt.Sprintf("%s%s (--%s) is required", p.prefix, key, getFlagName(key)))
+	}
+	return value
+}
+
+// Get a string setting, falling back to a default when not set
+func (p *settingsParser) getString(key, fallback string) string {
+	if value := p.get(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Parse a duration setting, e.g. 30s or 2m, falling back to a default when not set
+func (p *settingsParser) getDuration(key string, fallback time.Duration) time.Duration {
+	value := p.get(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	p.check(key, err)
+	return duration
+}
+
+// Parse a boolean setting, falling back to a default when not set
+func (p *settingsParser) getBool(key string, fallback bool) bool {
+	value := p.get(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	p.check(key, err)
+	return parsed
+}
+
+// Parse an integer setting, falling back to a default when not set
+func (p *settingsParser) getInt(key string, fallback int) int {
+	value := p.get(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	p.check(key, err)
+	return parsed
+}
+
+// Parse a floating point setting, falling back to a default when not set
+func (p *settingsParser) getFloat(key string, fallback float64) float64 {
+	value := p.get(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	p.check(key, err)
+	return parsed
+}
+
+// Parse a comma-separated list setting
+func (p *settingsParser) getList(key string) (values []string) {
+	for _, value := range strings.Split(p.get(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Register the setting flags on a new flag set and parse args, restoring the flags of the command afterwards
+func parseSettingFlags(t *testing.T, args ...string) error {
+	previous := settingFlags
+	settingFlags = make(map[string]*pflag.Flag)
+	t.Cleanup(func() { settingFlags = previous })
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addSettingFlags(flags)
+	return flags.Parse(args)
+}
+
+func TestSettingsParserGet(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		flag     string
+		env      string
+		override string
+		file     string
+		expected string
+	}{
+		{name: "unset"},
+		{name: "file", file: "file", expected: "file"},
+		{name: "override", override: "override", file: "file", expected: "override"},
+		{name: "env", env: "env", override: "override", file: "file", expected: "env"},
+		{name: "flag", flag: "flag", env: "env", override: "override", file: "file", expected: "flag"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var args []string
+			if test.flag != "" {
+				args = append(args, "--proxy-image="+test.flag)
+			}
+			if err := parseSettingFlags(t, args...); err != nil {
+				t.Fatal(err)
+			}
+			if test.env != "" {
+				t.Setenv(proxyImageEnv, test.env)
+			}
+			p := settingsParser{
+				file:      &settingsFile{values: map[string]string{}},
+				overrides: map[string]string{},
+			}
+			if test.override != "" {
+				p.overrides[proxyImageEnv] = test.override
+			}
+			if test.file != "" {
+				p.file.values[proxyImageEnv] = test.file
+			}
+			if value := p.get(proxyImageEnv); value != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, value)
+			}
+		})
+	}
+}
+
+func TestSettingsParserTypes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		value    string
+		parse    func(p *settingsParser) interface{}
+		expected interface{}
+		invalid  bool
+	}{
+		{name: "string", value: "value", parse: func(p *settingsParser) interface{} { return p.getString(proxyImageEnv, "default") }, expected: "value"},
+		{name: "string default", parse: func(p *settingsParser) interface{} { return p.getString(proxyImageEnv, "default") }, expected: "default"},
+		{name: "int", value: "5", parse: func(p *settingsParser) interface{} { return p.getInt(proxyImageEnv, 1) }, expected: 5},
+		{name: "int default", parse: func(p *settingsParser) interface{} { return p.getInt(proxyImageEnv, 1) }, expected: 1},
+		{name: "invalid int", value: "five", parse: func(p *settingsParser) interface{} { return p.getInt(proxyImageEnv, 1) }, invalid: true},
+		{name: "bool", value: "false", parse: func(p *settingsParser) interface{} { return p.getBool(proxyImageEnv, true) }, expected: false},
+		{name: "bool default", parse: func(p *settingsParser) interface{} { return p.getBool(proxyImageEnv, true) }, expected: true},
+		{name: "invalid bool", value: "yes", parse: func(p *settingsParser) interface{} { return p.getBool(proxyImageEnv, true) }, invalid: true},
+		{name: "duration", value: "90s", parse: func(p *settingsParser) interface{} { return p.getDuration(proxyImageEnv, time.Second) }, expected: 90 * time.Second},
+		{name: "duration default", parse: func(p *settingsParser) interface{} { return p.getDuration(proxyImageEnv, time.Second) }, expected: time.Second},
+		{name: "invalid duration", value: "90", parse: func(p *settingsParser) interface{} { return p.getDuration(proxyImageEnv, time.Second) }, invalid: true},
+		{name: "list", value: "a, b,,c", parse: func(p *settingsParser) interface{} { return len(p.getList(proxyImageEnv)) }, expected: 3},
+		{name: "required", value: "value", parse: func(p *settingsParser) interface{} { return p.getRequired(proxyImageEnv) }, expected: "value"},
+		{name: "missing required", parse: func(p *settingsParser) interface{} { return p.getRequired(proxyImageEnv) }, invalid: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := settingsParser{overrides: map[string]string{proxyImageEnv: test.value}}
+			value := test.parse(&p)
+			if test.invalid {
+				if p.err() == nil {
+					t.Errorf("Expected %q to be rejected", test.value)
+				}
+				return
+			}
+			if err := p.err(); err != nil {
+				t.Fatal(err)
+			}
+			if value != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, value)
+			}
+		})
+	}
+}
+
+func TestSettingFlags(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		arg      string
+		key      string
+		expected string
+		invalid  bool
+	}{
+		{name: "bool", arg: "--router-tls-verify", key: routerTLSVerifyEnv, expected: "true"},
+		{name: "explicit bool", arg: "--router-tls-verify=false", key: routerTLSVerifyEnv, expected: "false"},
+		{name: "invalid bool", arg: "--router-tls-verify=yes", invalid: true},
+		{name: "int", arg: "--proxy-stats-port=9090", key: proxyStatsPortEnv, expected: "9090"},
+		{name: "invalid int", arg: "--proxy-stats-port=http", invalid: true},
+		{name: "float", arg: "--k8s-qps=12.5", key: k8sQPSEnv, expected: "12.5"},
+		{name: "invalid float", arg: "--k8s-qps=fast", invalid: true},
+		{name: "duration", arg: "--port-drain-period=1m", key: portDrainPeriodEnv, expected: "1m0s"},
+		{name: "invalid duration", arg: "--port-drain-period=60", invalid: true},
+		{name: "string", arg: "--proxy-image=iofog/proxy", key: proxyImageEnv, expected: "iofog/proxy"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := parseSettingFlags(t, test.arg)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("Expected %s to be rejected", test.arg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := settingsParser{}
+			if value := p.get(test.key); value != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, value)
+			}
+		})
+	}
+}
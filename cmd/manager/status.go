@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/eclipse-iofog/port-manager/v3/pkg/manager"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func newStatusCommand() *cobra.Command {
+	namespace := getWatchNamespace()
+	output := outputTable
+	check := false
+	cmd := &cobra.Command{
+		Use:          "status",
+		Short:        "Print the port map, Proxy addresses and errors of the running Managers",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != outputTable && output != outputJSON {
+				return fmt.Errorf("invalid output format %s, must be %s or %s", output, outputTable, outputJSON)
+			}
+			cfg, err := config.GetConfig()
+			if err != nil {
+				return err
+			}
+			client, err := k8sclient.New(cfg, k8sclient.Options{})
+			if err != nil {
+				return err
+			}
+			statuses, err := manager.GetStatus(context.Background(), client, namespace)
+			if err != nil {
+				return err
+			}
+			if output == outputJSON {
+				err = printStatusJSON(cmd.OutOrStdout(), statuses)
+			} else {
+				err = printStatusTable(cmd.OutOrStdout(), statuses)
+			}
+			if err != nil {
+				return err
+			}
+			if check && hasErrors(statuses) {
+				return errors.New("one or more Managers report errors")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", namespace, "Namespace the Managers run in")
+	cmd.Flags().StringVarP(&output, "output", "o", output, "Output format, table or json")
+	cmd.Flags().BoolVar(&check, "check", check, "Exit with a non-zero code if any Manager reports errors")
+	return cmd
+}
+
+func hasErrors(statuses []manager.Status) bool {
+	for _, status := range statuses {
+		if len(status.Errors) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func printStatusJSON(out io.Writer, statuses []manager.Status) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(statuses)
+}
+
+func printStatusTable(out io.Writer, statuses []manager.Status) error {
+	writer := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "PROXY\tADDRESS\tPORT\tPROTOCOL\tQUEUE")
+	for _, status := range statuses {
+		address := status.Address
+		if address == "" {
+			address = "<pending>"
+		}
+		if len(status.Ports) == 0 {
+			fmt.Fprintf(writer, "%s\t%s\t-\t-\t-\n", status.Proxy, address)
+		}
+		for _, port := range status.Ports {
+			fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%s\n", status.Proxy, address, port.Port, port.Protocol, port.Queue)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	// Errors and warnings are printed after the port map so the table stays readable
+	var errs, warnings []string
+	for _, status := range statuses {
+		for operation, err := range status.Errors {
+			errs = append(errs, fmt.Sprintf("%s: %s failed at %s: %s", status.Proxy, operation, err.Time.Format(time.RFC3339), err.Message))
+		}
+		for condition, warning := range status.Warnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s since %s: %s", status.Proxy, condition, warning.Time.Format(time.RFC3339), warning.Message))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		if _, err := fmt.Fprintf(out, "\nErrors:\n  %s\n", strings.Join(errs, "\n  ")); err != nil {
+			return err
+		}
+	}
+	if len(warnings) > 0 {
+		sort.Strings(warnings)
+		if _, err := fmt.Fprintf(out, "\nWarnings:\n  %s\n", strings.Join(warnings, "\n  ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
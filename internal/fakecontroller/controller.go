@@ -0,0 +1,100 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+// Package fakecontroller provides an in-memory ioFog Controller backed by a fixture file of public ports
+// so the Managers can run against a cluster without a Controller
+package fakecontroller
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Controller serves the public ports of a fixture file
+// The fixture uses the format of the Controller's GET /microservices/public-ports response, in YAML or JSON
+// and is read again whenever it is modified
+type Controller struct {
+	path string
+
+	mu              sync.Mutex
+	modTime         time.Time
+	ports           []ioclient.MicroservicePublicPort
+	defaultProxy    string
+	publicPortHosts map[string]string
+}
+
+func New(path string) (*Controller, error) {
+	ctrl := &Controller{path: path, publicPortHosts: make(map[string]string)}
+	if _, err := ctrl.GetAllMicroservicePublicPorts(); err != nil {
+		return nil, err
+	}
+	return ctrl, nil
+}
+
+func (ctrl *Controller) GetAllMicroservicePublicPorts() ([]ioclient.MicroservicePublicPort, error) {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	info, err := os.Stat(ctrl.path)
+	if err != nil {
+		return nil, err
+	}
+	if ctrl.ports == nil || !info.ModTime().Equal(ctrl.modTime) {
+		data, err := os.ReadFile(ctrl.path)
+		if err != nil {
+			return nil, err
+		}
+		ports := []ioclient.MicroservicePublicPort{}
+		if err := yaml.Unmarshal(data, &ports); err != nil {
+			return nil, fmt.Errorf("could not parse fixture %s: %s", ctrl.path, err.Error())
+		}
+		ctrl.ports = ports
+		ctrl.modTime = info.ModTime()
+	}
+
+	// Copy so callers cannot modify the fixture
+	return append([]ioclient.MicroservicePublicPort{}, ctrl.ports...), nil
+}
+
+func (ctrl *Controller) PutDefaultProxy(address string) error {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+	ctrl.defaultProxy = address
+	return nil
+}
+
+// GetDefaultProxy returns the address last registered through PutDefaultProxy
+func (ctrl *Controller) GetDefaultProxy() string {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+	return ctrl.defaultProxy
+}
+
+func (ctrl *Controller) PutPublicPortHost(protocol, host string) error {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+	ctrl.publicPortHosts[protocol] = host
+	return nil
+}
+
+// GetPublicPortHost returns the address last registered for a protocol through PutPublicPortHost
+func (ctrl *Controller) GetPublicPortHost(protocol string) string {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+	return ctrl.publicPortHosts[protocol]
+}
@@ -0,0 +1,87 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package fakecontroller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestController(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.yaml")
+	fixture := `
+- microserviceUuid: abc
+  publicPort:
+    protocol: http
+    queueName: abc-5000
+    publicPort: 5000
+`
+	if err := os.WriteFile(path, []byte(fixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctrl, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports, err := ctrl.GetAllMicroservicePublicPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 1 || ports[0].MicroserviceUUID != "abc" || ports[0].PublicPort.Port != 5000 || ports[0].PublicPort.Queue != "abc-5000" {
+		t.Errorf("Unexpected ports %v", ports)
+	}
+
+	// Edits to the fixture are served on the next call
+	if err := os.WriteFile(path, []byte(`[{"microserviceUuid":"def","publicPort":{"protocol":"tcp","queueName":"def-6000","publicPort":6000}}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if ports, err = ctrl.GetAllMicroservicePublicPorts(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 1 || ports[0].MicroserviceUUID != "def" || ports[0].PublicPort.Protocol != "tcp" {
+		t.Errorf("Expected reloaded fixture, got %v", ports)
+	}
+
+	if err := ctrl.PutDefaultProxy("1.2.3.4"); err != nil {
+		t.Fatal(err)
+	}
+	if addr := ctrl.GetDefaultProxy(); addr != "1.2.3.4" {
+		t.Errorf("Expected default proxy 1.2.3.4, got %s", addr)
+	}
+	if err := ctrl.PutPublicPortHost("tcp", "1.2.3.5"); err != nil {
+		t.Fatal(err)
+	}
+	if addr := ctrl.GetPublicPortHost("tcp"); addr != "1.2.3.5" || ctrl.GetDefaultProxy() != "1.2.3.4" {
+		t.Errorf("Expected tcp public port host 1.2.3.5, got %s", addr)
+	}
+}
+
+func TestControllerInvalidFixture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.yaml")
+	if err := os.WriteFile(path, []byte("publicPort: 5000"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path); err == nil {
+		t.Error("Expected error for invalid fixture")
+	}
+	if _, err := New(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected error for missing fixture")
+	}
+}
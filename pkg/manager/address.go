@@ -0,0 +1,267 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ParseExternalAddress checks the external address of a Proxy, a host, host:port or URL, e.g. proxy.example.com,
+// 203.0.113.10:8443 or https://proxy.example.com, and normalizes it to the host or host:port registered with the Controller
+func ParseExternalAddress(value string) (string, error) {
+	address := strings.TrimSpace(value)
+	if address == "" {
+		return "", nil
+	}
+	if strings.Contains(address, "://") {
+		parsed, err := url.Parse(address)
+		if err != nil {
+			return "", fmt.Errorf("expected a host, host:port or URL, got %s", value)
+		}
+		if parsed.User != nil || strings.TrimSuffix(parsed.Path, "/") != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+			return "", fmt.Errorf("URL %s must not have credentials, a path, query or fragment", value)
+		}
+		address = parsed.Host
+	}
+
+	host, port := address, ""
+	if net.ParseIP(address) == nil {
+		if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+			host = address[1 : len(address)-1]
+		} else if strings.Contains(address, ":") {
+			var err error
+			if host, port, err = net.SplitHostPort(address); err != nil {
+				return "", fmt.Errorf("expected a host, host:port or URL, got %s", value)
+			}
+			if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+				return "", fmt.Errorf("port of %s must be between 1 and 65535", value)
+			}
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else {
+		host = strings.ToLower(host)
+		if errs := validation.IsDNS1123Subdomain(host); len(errs) > 0 {
+			return "", fmt.Errorf("host of %s must be an IP or DNS name: %s", value, strings.Join(errs, ", "))
+		}
+	}
+	if port == "" {
+		return host, nil
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Host of a registered address, without the port of an LB fronting the Proxy
+func getAddressHost(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// addressQueue holds at most one pending Proxy address registration
+// Adding an address while one is pending replaces it, so bursts of signals collapse into a single registration
+type addressQueue struct {
+	mu         sync.Mutex
+	addr       string
+	pending    bool
+	registered string
+	signal     chan struct{}
+}
+
+func newAddressQueue() *addressQueue {
+	return &addressQueue{
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// Add an address to register, replacing any pending address
+func (q *addressQueue) add(addr string) {
+	q.mu.Lock()
+	q.addr = addr
+	q.pending = true
+	q.mu.Unlock()
+	q.notify()
+}
+
+// Requeue a failed address unless a newer address has been added in the meantime
+func (q *addressQueue) requeue(addr string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending {
+		return
+	}
+	q.addr = addr
+	q.pending = true
+}
+
+// Block until an address is pending or the context is cancelled
+func (q *addressQueue) get(ctx context.Context) (string, bool) {
+	for {
+		q.mu.Lock()
+		if q.pending {
+			addr := q.addr
+			q.pending = false
+			q.mu.Unlock()
+			// Discard the signal for the address being returned
+			select {
+			case <-q.signal:
+			default:
+			}
+			return addr, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-q.signal:
+		}
+	}
+}
+
+// Record the address last registered with the Controller
+func (q *addressQueue) setRegistered(addr string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.registered = addr
+}
+
+// Get the address last registered with the Controller
+func (q *addressQueue) getRegistered() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.registered
+}
+
+// Check whether an address is already registered or waiting to be registered
+func (q *addressQueue) isKnown(addr string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending {
+		return q.addr == addr
+	}
+	return q.registered == addr
+}
+
+func (q *addressQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func newRegisterBackoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: pkg.registerBackoffBase,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    pkg.registerBackoffSteps,
+		Cap:      pkg.registerBackoffCap,
+	}
+}
+
+// Register Proxy addresses with the Controller as they are queued
+// Failed registrations, including panics, are retried with exponential backoff until they succeed or a newer address is queued
+func (mgr *Manager) registerProxyAddress(ctx context.Context) {
+	backoff := newRegisterBackoff()
+
+	for {
+		// Wait for signal
+		addr, ok := mgr.addressQueue.get(ctx)
+		if !ok {
+			return
+		}
+
+		err := mgr.callRecovering("register-address", func() error {
+			return mgr.registerAddress(ctx, addr)
+		})
+		mgr.observeAlert(ctx, mgr.registerAlert, err)
+		mgr.status.setError(statusErrorRegistration, err)
+		if err != nil {
+			mgr.getLog(ctx).Error(err, "Failed to register Proxy address", "address", addr)
+			mgr.addressQueue.requeue(addr)
+			// Wait before retrying, unless a new address is queued
+			delay := backoff.Step()
+			select {
+			case <-ctx.Done():
+				return
+			case <-mgr.addressQueue.signal:
+				backoff = newRegisterBackoff()
+				mgr.addressQueue.notify()
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		backoff = newRegisterBackoff()
+	}
+}
+
+func (mgr *Manager) registerAddress(ctx context.Context, addr string) (err error) {
+	ctx, span := mgr.startSpan(ctx, "registerProxyAddress")
+	defer func() {
+		observeRegistration(mgr.opt.ProxyName, err)
+		endSpan(span, err)
+	}()
+
+	if addr == "" {
+		// Wait for LB Service
+		_, lbSpan := mgr.startSpan(ctx, "k8s.WaitForLoadBalancer")
+		addr, err = mgr.waitClient.WaitForLoadBalancer(mgr.opt.Namespace, mgr.opt.ProxyName, pkg.loadBalancerTimeout)
+		endSpan(lbSpan, err)
+		mgr.observeAlert(ctx, mgr.loadBalancerAlert, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Attempt to register
+	ioClient := bindControllerClient(ctx, mgr.ioClient)
+	if mgr.opt.ProtocolRegistration && mgr.opt.ProtocolFilter != "" {
+		// Checked by New, the default address of all protocols is never registered in its place
+		registrar, ok := ioClient.(publicPortHostRegistrar)
+		if !ok {
+			return errProtocolRegistration
+		}
+		protocol := strings.ToLower(mgr.opt.ProtocolFilter)
+		_, controllerSpan := mgr.startSpan(ctx, "controller.PutPublicPortHost", attribute.String("address", addr), attribute.String("protocol", protocol))
+		err = registrar.PutPublicPortHost(protocol, addr)
+		endSpan(controllerSpan, err)
+	} else {
+		_, controllerSpan := mgr.startSpan(ctx, "controller.PutDefaultProxy", attribute.String("address", addr))
+		err = ioClient.PutDefaultProxy(addr)
+		endSpan(controllerSpan, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	mgr.addressQueue.setRegistered(addr)
+	mgr.getLog(ctx).Info("Successfully registered Proxy address " + addr)
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Copy of the cache taken by the reconciler, read by the admin and gRPC APIs without locking the cache
+// Each snapshot is immutable, changed is closed once it has been replaced by a newer snapshot
+type cacheSnapshot struct {
+	ports    portMap
+	address  string
+	metadata map[int]portMetadata
+	// Addresses of single ports overriding address
+	addresses map[int]string
+	changed   chan struct{}
+}
+
+// Replace the snapshot if the cache or the registered address changed
+func (mgr *Manager) saveSnapshot() {
+	previous := mgr.loadSnapshot()
+	address := mgr.addressQueue.getRegistered()
+	if previous != nil && previous.address == address && reflect.DeepEqual(previous.ports, mgr.cache) &&
+		reflect.DeepEqual(previous.metadata, mgr.portMetadata) && reflect.DeepEqual(previous.addresses, mgr.portAddresses) {
+		return
+	}
+	ports := make(portMap, len(mgr.cache))
+	for port, publicPort := range mgr.cache {
+		ports[port] = publicPort
+	}
+	metadata := make(map[int]portMetadata, len(mgr.portMetadata))
+	for port, meta := range mgr.portMetadata {
+		metadata[port] = meta
+	}
+	addresses := make(map[int]string, len(mgr.portAddresses))
+	for port, portAddress := range mgr.portAddresses {
+		addresses[port] = portAddress
+	}
+	mgr.snapshot.Store(&cacheSnapshot{
+		ports:     ports,
+		address:   address,
+		metadata:  metadata,
+		addresses: addresses,
+		changed:   make(chan struct{}),
+	})
+	if previous != nil {
+		close(previous.changed)
+	}
+}
+
+// Get the latest snapshot, nil until the cache has been generated
+func (mgr *Manager) loadSnapshot() *cacheSnapshot {
+	snapshot, _ := mgr.snapshot.Load().(*cacheSnapshot)
+	return snapshot
+}
+
+func (mgr *Manager) getSnapshot() portMap {
+	if snapshot := mgr.loadSnapshot(); snapshot != nil {
+		return snapshot.ports
+	}
+	return nil
+}
+
+// Get the name of the Proxy managed by the Manager
+func (mgr *Manager) Name() string {
+	return mgr.opt.ProxyName
+}
+
+type proxyConfig struct {
+	Proxy  string `json:"proxy"`
+	Router string `json:"router"`
+}
+
+// NewAdminHandler serves the admin API of the Managers, authenticated by a bearer token
+// GET /ports and GET /config return the cached port map and rendered Proxy config of each Proxy
+// POST /reconcile polls the Controller immediately
+// All endpoints accept a proxy query parameter to select a single Proxy
+func NewAdminHandler(mgrs []*Manager, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ports := make(map[string][]PortStatus)
+		for _, mgr := range selectManagers(mgrs, r) {
+			ports[mgr.Name()] = getPortStatuses(mgr.getSnapshot())
+		}
+		writeJSON(w, ports)
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		configs := make(map[string]proxyConfig)
+		for _, mgr := range selectManagers(mgrs, r) {
+			routerConfig, err := getRouterConfig(mgr.opt, mgr.getRouterAddresses())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			configs[mgr.Name()] = proxyConfig{
+				Proxy:  createProxyConfig(mgr.getSnapshot(), mgr.opt),
+				Router: routerConfig,
+			}
+		}
+		writeJSON(w, configs)
+	})
+	mux.HandleFunc("/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		for _, mgr := range selectManagers(mgrs, r) {
+			mgr.triggerReconcile()
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return withBearerToken(mux, token)
+}
+
+func selectManagers(mgrs []*Manager, r *http.Request) []*Manager {
+	proxy := r.URL.Query().Get("proxy")
+	if proxy == "" {
+		return mgrs
+	}
+	for _, mgr := range mgrs {
+		if mgr.Name() == proxy {
+			return []*Manager{mgr}
+		}
+	}
+	return nil
+}
+
+// Require an Authorization: Bearer <token> header, a bare token is rejected
+func withBearerToken(handler http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, isBearer := getBearerToken(r.Header.Get("Authorization"))
+		if !isBearer || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func getBearerToken(header string) (string, bool) {
+	const scheme = "Bearer "
+	if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+	return header[len(scheme):], true
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
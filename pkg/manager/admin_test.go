@@ -0,0 +1,65 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestAdminHandler(t *testing.T) {
+	mgr := &Manager{opt: &Options{ProxyName: "http-proxy"}, addressQueue: newAddressQueue(), events: make(chan event.GenericEvent, 1)}
+	mgr.cache = portMap{80: ioclient.PublicPort{Port: 80, Protocol: "http", Queue: "queue"}}
+	mgr.saveSnapshot()
+	handler := NewAdminHandler([]*Manager{mgr}, "secret")
+
+	req := httptest.NewRequest(http.MethodGet, "/ports", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthenticated request to be rejected, got %d", resp.Code)
+	}
+
+	// The token must be passed with the Bearer scheme
+	req.Header.Set("Authorization", "secret")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a bare token to be rejected, got %d", resp.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	ports := make(map[string][]PortStatus)
+	if err := json.NewDecoder(resp.Body).Decode(&ports); err != nil {
+		t.Fatalf("Failed to decode ports: %s", err.Error())
+	}
+	if len(ports["http-proxy"]) != 1 || ports["http-proxy"][0].Port != 80 {
+		t.Errorf("Expected cached port 80, got %v", ports)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/reconcile", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusAccepted || len(mgr.events) != 1 {
+		t.Errorf("Expected reconcile to be triggered, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	portsv1alpha1 "github.com/eclipse-iofog/port-manager/v3/api/portmanager/v1alpha1"
+)
+
+const (
+	validateServicePath    = "/validate-service"
+	validatePublicPortPath = "/validate-publicport"
+)
+
+// Rejects Proxy Services and PublicPorts claiming a reserved port or a port already exposed by another Proxy
+type portValidator struct {
+	mgrs []*Manager
+}
+
+// RegisterAdmissionWebhooks registers the validating webhooks of the Managers with a webhook server
+func RegisterAdmissionWebhooks(server *webhook.Server, mgrs []*Manager) {
+	validator := &portValidator{mgrs: mgrs}
+	server.Register(validateServicePath, &webhook.Admission{Handler: admission.HandlerFunc(validator.validateService)})
+	server.Register(validatePublicPortPath, &webhook.Admission{Handler: admission.HandlerFunc(validator.validatePublicPort)})
+}
+
+// NewAdmissionScheme returns the scheme of the objects handled by the admission webhooks
+func NewAdmissionScheme() (*runtime.Scheme, error) {
+	return newScheme()
+}
+
+func (validator *portValidator) getManager(namespace, proxy string) *Manager {
+	for _, mgr := range validator.mgrs {
+		if mgr.opt.Namespace == namespace && mgr.Name() == proxy {
+			return mgr
+		}
+	}
+	return nil
+}
+
+// Check that a port can be exposed by a Proxy
+func (validator *portValidator) validatePort(namespace, proxy string, port int) error {
+	if mgr := validator.getManager(namespace, proxy); mgr != nil && mgr.opt.ReservedPorts.Contains(port) {
+		return fmt.Errorf("port %d is reserved", port)
+	} else if mgr != nil && isTLSRedirectPort(mgr.opt, port) {
+		return fmt.Errorf("port %d serves the HTTPS redirect", port)
+	}
+	for _, mgr := range validator.mgrs {
+		if mgr.opt.Namespace != namespace || mgr.Name() == proxy {
+			continue
+		}
+		if exposed, exists := mgr.getSnapshot()[port]; exists {
+			return fmt.Errorf("port %d is already exposed by Proxy %s for queue %s", port, mgr.Name(), exposed.Queue)
+		}
+	}
+	return nil
+}
+
+func (validator *portValidator) validateService(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	svc := corev1.Service{}
+	if err := json.Unmarshal(req.Object.Raw, &svc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	// Only Proxy Services are validated
+	mgr := validator.getManager(req.Namespace, svc.Name)
+	if mgr == nil {
+		return admission.Allowed("")
+	}
+	for _, port := range svc.Spec.Ports {
+		if isTLSRedirectPort(mgr.opt, int(port.Port)) {
+			continue
+		}
+		if err := validator.validatePort(req.Namespace, svc.Name, int(port.Port)); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+	return admission.Allowed("")
+}
+
+func (validator *portValidator) validatePublicPort(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	publicPort := portsv1alpha1.PublicPort{}
+	if err := json.Unmarshal(req.Object.Raw, &publicPort); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if err := validator.validatePort(req.Namespace, publicPort.Spec.Proxy, int(publicPort.Spec.Port)); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
@@ -0,0 +1,65 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newTestManager(proxy string, ports portMap) *Manager {
+	mgr := &Manager{opt: &Options{Namespace: "iofog", ProxyName: proxy}, addressQueue: newAddressQueue(), cache: ports}
+	mgr.saveSnapshot()
+	return mgr
+}
+
+func TestValidateService(t *testing.T) {
+	validator := &portValidator{mgrs: []*Manager{
+		newTestManager("http-proxy", portMap{80: ioclient.PublicPort{Port: 80, Protocol: "http", Queue: "web"}}),
+		newTestManager("tcp-proxy", portMap{}),
+	}}
+	review := func(name string, port int32) admission.Response {
+		svc := corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "iofog"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: port}}},
+		}
+		raw, _ := json.Marshal(&svc)
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			Namespace: "iofog",
+		}}
+		req.Object.Raw = raw
+		return validator.validateService(context.Background(), req)
+	}
+
+	if resp := review("tcp-proxy", 80); resp.Allowed {
+		t.Errorf("Expected port exposed by another Proxy to be denied")
+	}
+	if resp := review("http-proxy", 80); !resp.Allowed {
+		t.Errorf("Expected Proxy to keep its own port, got %s", resp.Result.Message)
+	}
+	if resp := review("tcp-proxy", 5000); !resp.Allowed {
+		t.Errorf("Expected free port to be allowed, got %s", resp.Result.Message)
+	}
+	if resp := review("other", 80); !resp.Allowed {
+		t.Errorf("Expected Services of other components to be ignored")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	alertReasonReconcile    = "ReconcileFailing"
+	alertReasonLoadBalancer = "LoadBalancerTimeout"
+	alertReasonRegistration = "AddressRegistrationFailing"
+
+	alertStatusFiring   = "firing"
+	alertStatusResolved = "resolved"
+)
+
+// Webhook payload, the text field makes it compatible with Slack incoming webhooks
+type alert struct {
+	Text     string    `json:"text"`
+	Proxy    string    `json:"proxy"`
+	Reason   string    `json:"reason"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Failures int       `json:"failures"`
+	Since    time.Time `json:"since"`
+}
+
+// Tracks consecutive failures of an operation
+// Fires once the failures exceed both thresholds and resolves on the next success
+type alertState struct {
+	reason      string
+	minFailures int
+	minPeriod   time.Duration
+	failures    int
+	since       time.Time
+	firing      bool
+}
+
+func newAlertState(reason string, minFailures int, minPeriod time.Duration) *alertState {
+	if minFailures < 1 {
+		minFailures = 1
+	}
+	return &alertState{
+		reason:      reason,
+		minFailures: minFailures,
+		minPeriod:   minPeriod,
+	}
+}
+
+// Record the result of an attempt, returning an alert if the state changed
+func (state *alertState) observe(err error, now time.Time) (alert, bool) {
+	if err == nil {
+		wasFiring := state.firing
+		since := state.since
+		failures := state.failures
+		state.failures = 0
+		state.firing = false
+		if !wasFiring {
+			return alert{}, false
+		}
+		return alert{Reason: state.reason, Status: alertStatusResolved, Failures: failures, Since: since}, true
+	}
+	if state.failures == 0 {
+		state.since = now
+	}
+	state.failures++
+	if state.firing || state.failures < state.minFailures || now.Sub(state.since) < state.minPeriod {
+		return alert{}, false
+	}
+	state.firing = true
+	return alert{Reason: state.reason, Status: alertStatusFiring, Error: err.Error(), Failures: state.failures, Since: state.since}, true
+}
+
+// Record the result of an attempt and send an alert if the state changed
+func (mgr *Manager) observeAlert(ctx context.Context, state *alertState, err error) {
+	alert, changed := state.observe(err, time.Now())
+	if !changed {
+		return
+	}
+	alert.Proxy = mgr.opt.ProxyName
+	if alert.Status == alertStatusFiring {
+		alert.Text = fmt.Sprintf("[%s] %s: %d consecutive failures since %s: %s", alert.Proxy, alert.Reason, alert.Failures, alert.Since.UTC().Format(time.RFC3339), alert.Error)
+		mgr.getLog(ctx).Error(nil, "Alert firing", "reason", alert.Reason, "failures", alert.Failures, "since", alert.Since)
+	} else {
+		alert.Text = fmt.Sprintf("[%s] %s: resolved after %d failures", alert.Proxy, alert.Reason, alert.Failures)
+		mgr.getLog(ctx).Info("Alert resolved", "reason", alert.Reason)
+	}
+	if mgr.opt.AlertWebhookURL == "" {
+		return
+	}
+	// Do not hold up the caller's retries on a slow webhook
+	go func() {
+		if err := postJSON(ctx, mgr.opt.AlertWebhookURL, &alert); err != nil {
+			mgr.getLog(ctx).Error(err, "Failed to send alert to webhook", "reason", alert.Reason)
+		}
+	}()
+}
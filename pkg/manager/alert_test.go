@@ -0,0 +1,52 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAlertState(t *testing.T) {
+	state := newAlertState(alertReasonReconcile, 1, time.Minute)
+	start := time.Now()
+	failure := errors.New("failed")
+
+	if _, changed := state.observe(failure, start); changed {
+		t.Errorf("Expected no alert before the failure period")
+	}
+	alert, changed := state.observe(failure, start.Add(time.Minute))
+	if !changed || alert.Status != alertStatusFiring || alert.Failures != 2 || !alert.Since.Equal(start) {
+		t.Errorf("Expected firing alert after the failure period, got %v", alert)
+	}
+	if _, changed := state.observe(failure, start.Add(2*time.Minute)); changed {
+		t.Errorf("Expected a firing alert to be sent once")
+	}
+	alert, changed = state.observe(nil, start.Add(3*time.Minute))
+	if !changed || alert.Status != alertStatusResolved {
+		t.Errorf("Expected resolved alert on success, got %v", alert)
+	}
+	if _, changed := state.observe(nil, start.Add(4*time.Minute)); changed {
+		t.Errorf("Expected no alert while healthy")
+	}
+
+	state = newAlertState(alertReasonRegistration, 3, 0)
+	for attempt := 1; attempt <= 3; attempt++ {
+		_, changed := state.observe(failure, start)
+		if changed != (attempt == 3) {
+			t.Errorf("Attempt %d: expected alert %t, got %t", attempt, attempt == 3, changed)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Azure/go-amqp"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Subscribe to the router address the Controller publishes public port changes to, reconciling on each notification
+// Reconnects with backoff through the router addresses in order, polling once connected so changes missed meanwhile are picked up
+func (mgr *Manager) subscribeNotifications(ctx context.Context) {
+	delay := pkg.retryBaseDelay
+	for {
+		connected, err := mgr.receiveNotifications(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = pkg.retryBaseDelay
+		}
+		mgr.getLog(ctx).Error(err, "Lost notification subscription, reconnecting", "address", mgr.opt.NotificationAddress, "delay", delay.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > pkg.retryMaxDelay {
+			delay = pkg.retryMaxDelay
+		}
+	}
+}
+
+// Receive notifications until the link fails, returning whether a router was connected
+func (mgr *Manager) receiveNotifications(ctx context.Context) (bool, error) {
+	client, err := mgr.dialRouter(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return false, err
+	}
+	receiver, err := session.NewReceiver(amqp.LinkSourceAddress(mgr.opt.NotificationAddress))
+	if err != nil {
+		return false, err
+	}
+	mgr.getLog(ctx).Info("Subscribed to public port notifications", "address", mgr.opt.NotificationAddress)
+	mgr.triggerReconcile()
+	for {
+		msg, err := receiver.Receive(ctx)
+		if err != nil {
+			return true, err
+		}
+		if err := receiver.AcceptMessage(ctx, msg); err != nil {
+			return true, err
+		}
+		mgr.onNotificationMessage(msg.GetData())
+	}
+}
+
+// Invalid notifications still trigger a reconcile, a change happened even if it cannot be narrowed down
+func (mgr *Manager) onNotificationMessage(data []byte) {
+	notification := portsNotification{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &notification); err != nil {
+			mgr.log.Error(err, "Invalid public ports notification")
+		}
+	}
+	mgr.onPortsNotification(notification)
+}
+
+// Connect to the first reachable router with the scheme and TLS credentials of the Proxy
+func (mgr *Manager) dialRouter(ctx context.Context) (*amqp.Client, error) {
+	opts := []amqp.ConnOption{amqp.ConnSASLAnonymous(), amqp.ConnConnectTimeout(pkg.webhookTimeout)}
+	if mgr.opt.RouterScheme == routerSchemeAMQPS {
+		tlsConfig, err := mgr.getRouterTLSConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, amqp.ConnTLSConfig(tlsConfig))
+	}
+	addrs := mgr.getRouterAddresses()
+	errs := make([]error, 0, len(addrs))
+	for _, addr := range addrs {
+		routerURL := url.URL{Scheme: mgr.opt.RouterScheme, Host: addr.String()}
+		client, err := amqp.Dial(routerURL.String(), opts...)
+		if err == nil {
+			return client, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %s", addr.String(), err.Error()))
+	}
+	return nil, fmt.Errorf("could not connect to a router: %v", errs)
+}
+
+// Load the CA and client certificate of the router TLS Secret, verifying the router unless disabled for the Proxy
+func (mgr *Manager) getRouterTLSConfig(ctx context.Context) (*tls.Config, error) {
+	// nolint:gosec
+	config := &tls.Config{InsecureSkipVerify: mgr.opt.RouterTLSInsecure, MinVersion: tls.VersionTLS12}
+	if mgr.opt.RouterTLSSecret == "" {
+		return config, nil
+	}
+	secret := corev1.Secret{}
+	key := k8sclient.ObjectKey{Name: mgr.opt.RouterTLSSecret, Namespace: mgr.opt.Namespace}
+	if err := mgr.k8sClient.Get(ctx, key, &secret); err != nil {
+		return nil, err
+	}
+	if ca := secret.Data[routerCAKey]; len(ca) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid " + routerCAKey + " in router TLS Secret " + mgr.opt.RouterTLSSecret)
+		}
+	}
+	if len(secret.Data[routerCertKey]) > 0 {
+		cert, err := tls.X509KeyPair(secret.Data[routerCertKey], secret.Data[routerKeyKey])
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
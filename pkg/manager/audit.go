@@ -0,0 +1,161 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	auditConfigKey = "audit.jsonl"
+	// Records waiting for the webhook, beyond which new records are dropped
+	auditQueueSize = 100
+
+	auditActionAdd    = "add"
+	auditActionRemove = "remove"
+	auditActionUpdate = "update"
+)
+
+// A single port exposure change
+type auditChange struct {
+	Action           string `json:"action"`
+	Port             int    `json:"port"`
+	Protocol         string `json:"protocol"`
+	Queue            string `json:"queue"`
+	PreviousProtocol string `json:"previousProtocol,omitempty"`
+	PreviousQueue    string `json:"previousQueue,omitempty"`
+	MicroserviceUUID string `json:"microserviceUuid,omitempty"`
+}
+
+// Port exposure changes applied in a single reconcile, with the resulting Proxy config
+type auditRecord struct {
+	Time    time.Time     `json:"time"`
+	Proxy   string        `json:"proxy"`
+	Changes []auditChange `json:"changes"`
+	Config  string        `json:"config"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Append records to a JSON lines ring buffer, keeping at most max lines
+func appendAuditRecords(existing string, records []auditRecord, max int) (string, error) {
+	var lines []string
+	for _, line := range strings.Split(existing, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	for idx := range records {
+		line, err := json.Marshal(&records[idx])
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, string(line))
+	}
+	if max > 0 && len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// Write the record to the configured audit sinks
+// Failures are logged so that auditing never blocks port changes
+func (mgr *Manager) audit(ctx context.Context, record auditRecord) {
+	if len(record.Changes) == 0 {
+		return
+	}
+	mgr.getLog(ctx).Info("Audit", "changes", record.Changes, "error", record.Error)
+	if mgr.opt.AuditConfigMap != "" {
+		if err := mgr.appendAuditConfigMap(ctx, record); err != nil {
+			mgr.getLog(ctx).Error(err, "Failed to write audit record to ConfigMap", "configmap", mgr.opt.AuditConfigMap)
+		}
+	}
+	if mgr.auditRecords != nil {
+		select {
+		case mgr.auditRecords <- record:
+		default:
+			mgr.getLog(ctx).Error(errors.New("audit queue is full"), "Dropped audit record for webhook", "changes", record.Changes)
+		}
+	}
+}
+
+// Send the queued audit records to the webhook in order, so a slow webhook does not hold up the reconciles
+func (mgr *Manager) sendAuditRecords(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-mgr.auditRecords:
+			if err := postJSON(ctx, mgr.opt.AuditWebhookURL, &record); err != nil {
+				mgr.log.Error(err, "Failed to send audit record to webhook", "recordProxy", record.Proxy, "time", record.Time)
+			}
+		}
+	}
+}
+
+// Append the record to the audit ConfigMap, retrying if another manager updated it concurrently
+func (mgr *Manager) appendAuditConfigMap(ctx context.Context, record auditRecord) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return mgr.appendAuditConfigMapOnce(ctx, record)
+	})
+}
+
+func (mgr *Manager) appendAuditConfigMapOnce(ctx context.Context, record auditRecord) error {
+	cm := corev1.ConfigMap{}
+	key := k8sclient.ObjectKey{
+		Name:      mgr.opt.AuditConfigMap,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(ctx, key, &cm); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+		data, err := appendAuditRecords("", []auditRecord{record}, mgr.opt.AuditMaxEntries)
+		if err != nil {
+			return err
+		}
+		cm = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+			},
+			Data: map[string]string{auditConfigKey: data},
+		}
+		mgr.setOwnerReference(&cm)
+		err = mgr.k8sClient.Create(ctx, &cm)
+		if k8serrors.IsAlreadyExists(err) {
+			// Created concurrently, retry as an update
+			return k8serrors.NewConflict(corev1.Resource("configmaps"), key.Name, err)
+		}
+		return err
+	}
+	data, err := appendAuditRecords(cm.Data[auditConfigKey], []auditRecord{record}, mgr.opt.AuditMaxEntries)
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[auditConfigKey] = data
+	return mgr.k8sClient.Update(ctx, &cm)
+}
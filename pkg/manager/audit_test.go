@@ -0,0 +1,82 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendAuditRecords(t *testing.T) {
+	data := ""
+	for port := 1; port <= 5; port++ {
+		var err error
+		record := auditRecord{Changes: []auditChange{{Action: auditActionAdd, Port: port}}}
+		if data, err = appendAuditRecords(data, []auditRecord{record}, 3); err != nil {
+			t.Fatalf("Failed to append audit record: %s", err.Error())
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 audit records, got %d", len(lines))
+	}
+	if !strings.Contains(lines[0], `"port":3`) || !strings.Contains(lines[2], `"port":5`) {
+		t.Errorf("Expected oldest records to be dropped, got %v", lines)
+	}
+}
+
+func TestAuditWebhook(t *testing.T) {
+	received := make(chan auditRecord, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record := auditRecord{}
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			t.Error(err)
+		}
+		<-release
+		received <- record
+	}))
+	defer server.Close()
+	defer close(release)
+
+	mgr, _ := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.AuditWebhookURL = server.URL
+	mgr.auditRecords = make(chan auditRecord, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mgr.sendAuditRecords(ctx)
+
+	// The reconcile does not wait for the webhook, records beyond the queue are dropped
+	start := time.Now()
+	for port := 1; port <= 3; port++ {
+		mgr.audit(ctx, auditRecord{Proxy: "http-proxy", Changes: []auditChange{{Action: auditActionAdd, Port: port}}})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected audit not to wait for the webhook, took %s", elapsed)
+	}
+	release <- struct{}{}
+	select {
+	case record := <-received:
+		if len(record.Changes) != 1 || record.Changes[0].Port != 1 {
+			t.Errorf("Expected the first record to be sent, got %v", record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the audit record to be sent to the webhook")
+	}
+}
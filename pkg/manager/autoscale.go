@@ -0,0 +1,95 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/retry"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const reasonProxyScaled = "ProxyScaled"
+
+// Whether the Proxy replicas follow its connections, requires the Proxy stats to be scraped
+func isAutoscalingEnabled(opt *Options) bool {
+	return opt.ProxyStatsPort > 0 && opt.ProxyTargetConns > 0 && opt.ProxyMaxReplicas > getMinReplicas(opt)
+}
+
+func getMinReplicas(opt *Options) int {
+	if opt.ProxyMinReplicas < 1 {
+		return 1
+	}
+	return opt.ProxyMinReplicas
+}
+
+// Replicas needed to serve the connections at the target connections per replica
+func getDesiredReplicas(opt *Options, connections int64) int {
+	target := int64(opt.ProxyTargetConns)
+	desired := int((connections + target - 1) / target)
+	if min := getMinReplicas(opt); desired < min {
+		return min
+	}
+	if desired > opt.ProxyMaxReplicas {
+		return opt.ProxyMaxReplicas
+	}
+	return desired
+}
+
+// Scales the Proxy Deployment with the connections scraped from its pods
+// Scaling out is immediate, scaling in waits for the scale down delay to ride out short dips
+type proxyScaler struct {
+	mgr *Manager
+	// Since when fewer replicas than the current ones are desired
+	scaleDownSince time.Time
+}
+
+func (scaler *proxyScaler) observe(ctx context.Context, connections int64, now time.Time) error {
+	mgr := scaler.mgr
+	desired := int32(getDesiredReplicas(mgr.opt, connections))
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		dep := appsv1.Deployment{}
+		key := k8sclient.ObjectKey{Name: mgr.opt.ProxyName, Namespace: mgr.opt.Namespace}
+		if err := mgr.k8sClient.Get(ctx, key, &dep); err != nil {
+			return k8sclient.IgnoreNotFound(err)
+		}
+		current := int32(1)
+		if dep.Spec.Replicas != nil {
+			current = *dep.Spec.Replicas
+		}
+		switch {
+		case desired == current:
+			scaler.scaleDownSince = time.Time{}
+			return nil
+		case desired < current:
+			if scaler.scaleDownSince.IsZero() {
+				scaler.scaleDownSince = now
+			}
+			if now.Sub(scaler.scaleDownSince) < mgr.opt.ProxyScaleDownDelay {
+				return nil
+			}
+		}
+		dep.Spec.Replicas = &desired
+		if err := mgr.k8sClient.Update(ctx, &dep); err != nil {
+			return err
+		}
+		scaler.scaleDownSince = time.Time{}
+		mgr.getLog(ctx).Info("Scaled Proxy", "replicas", desired, "previous", current, "connections", connections)
+		mgr.recorder.Eventf(&dep, corev1.EventTypeNormal, reasonProxyScaled, "Scaled Proxy from %d to %d replicas for %d connections", current, desired, connections)
+		return nil
+	})
+}
@@ -0,0 +1,68 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestProxyScaler(t *testing.T) {
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.ProxyMaxReplicas = 3
+	mgr.opt.ProxyTargetConns = 100
+	mgr.opt.ProxyScaleDownDelay = time.Minute
+	dep, err := newProxyDeployment(mgr.opt, 1, "tcp:5000=>amqp:abc-5000", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Create(ctx, dep); err != nil {
+		t.Fatal(err)
+	}
+	getReplicas := func() int32 {
+		found := appsv1.Deployment{}
+		if err := k8sClient.Get(ctx, k8sclient.ObjectKeyFromObject(dep), &found); err != nil {
+			t.Fatal(err)
+		}
+		return *found.Spec.Replicas
+	}
+
+	scaler := proxyScaler{mgr: mgr}
+	now := time.Now()
+	steps := []struct {
+		connections int64
+		elapsed     time.Duration
+		replicas    int32
+	}{
+		// Scaling out is immediate and capped by the max replicas
+		{connections: 150, replicas: 2},
+		{connections: 1000, replicas: 3},
+		// Scaling in waits for the delay
+		{connections: 10, elapsed: time.Second, replicas: 3},
+		{connections: 10, elapsed: time.Minute + time.Second, replicas: 1},
+	}
+	for _, step := range steps {
+		if err := scaler.observe(ctx, step.connections, now.Add(step.elapsed)); err != nil {
+			t.Fatal(err)
+		}
+		if replicas := getReplicas(); replicas != step.replicas {
+			t.Errorf("Expected %d replicas for %d connections, got %d", step.replicas, step.connections, replicas)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Each basic auth Secret is mounted to its own directory holding a file per username with the password as content
+const (
+	basicAuthMountPath    = "/etc/basic-auth"
+	basicAuthVolumePrefix = "basic-auth-"
+)
+
+func getBasicAuthPath(secret string) string {
+	return path.Join(basicAuthMountPath, secret)
+}
+
+// Get the basic auth Secrets referenced by any port settings, in order
+func (rules PortSettingsRules) getBasicAuthSecrets() []string {
+	unique := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Settings.BasicAuthSecret != "" {
+			unique[rule.Settings.BasicAuthSecret] = true
+		}
+	}
+	secrets := make([]string, 0, len(unique))
+	for secret := range unique {
+		secrets = append(secrets, secret)
+	}
+	sort.Strings(secrets)
+	return secrets
+}
+
+// Mount the basic auth Secrets into the Proxy container
+// The Secrets are optional so a missing Secret leaves its ports without users instead of blocking the Proxy pods
+func setBasicAuthSecrets(dep *appsv1.Deployment, opt *Options) {
+	podSpec := &dep.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	optional := true
+	for idx, secret := range opt.PortSettings.getBasicAuthSecrets() {
+		volume := fmt.Sprintf("%s%d", basicAuthVolumePrefix, idx)
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: volume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secret,
+					Optional:   &optional,
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      volume,
+			MountPath: getBasicAuthPath(secret),
+			ReadOnly:  true,
+		})
+	}
+}
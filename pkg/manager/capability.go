@@ -0,0 +1,103 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Features of Proxy images beyond the stock Proxy, which ignores the config items it does not know
+// The image cannot be inspected for them, so they are declared with Options.ProxyCapabilities
+const (
+	// Limits, timeouts and forwarded headers of PortSettings
+	ProxyCapabilityPortSettings = "port-settings"
+	// The redirect item of Options.ProxyTLSRedirectPort
+	ProxyCapabilityTLSRedirect = "tls-redirect"
+	// Basic auth of PortSettings, an image ignoring it would serve the protected ports to anyone
+	ProxyCapabilityBasicAuth = "basic-auth"
+	// Bearer token validation of PortSettings, an image ignoring it would serve the protected ports to anyone
+	ProxyCapabilityJWT = "jwt"
+	// The ws protocol of config items, otherwise ws ports are passed as http ports flagged with ws=true
+	ProxyCapabilityWebSocket = "ws"
+	// Decoding the percent-encoded separators of queue names in the escaped config encoding, an image not decoding them
+	// would bridge the ports to queues that do not exist
+	ProxyCapabilityEscapedQueues = "escaped-queues"
+	// Parsing the versioned JSON document of the json config encoding, an image not parsing it would serve no ports
+	ProxyCapabilityJSONConfig = "json-config"
+)
+
+var proxyCapabilities = []string{
+	ProxyCapabilityPortSettings,
+	ProxyCapabilityTLSRedirect,
+	ProxyCapabilityBasicAuth,
+	ProxyCapabilityJWT,
+	ProxyCapabilityWebSocket,
+	ProxyCapabilityEscapedQueues,
+	ProxyCapabilityJSONConfig,
+}
+
+// ParseProxyCapabilities validates the capabilities of a Proxy image, see the ProxyCapability constants
+func ParseProxyCapabilities(values []string) ([]string, error) {
+	capabilities := make([]string, 0, len(values))
+	for _, value := range values {
+		capability := strings.ToLower(strings.TrimSpace(value))
+		if capability == "" {
+			continue
+		}
+		if !isProxyCapability(proxyCapabilities, capability) {
+			return nil, fmt.Errorf("unknown proxy capability %s, expected one of %s", value, strings.Join(proxyCapabilities, ", "))
+		}
+		capabilities = append(capabilities, capability)
+	}
+	sort.Strings(capabilities)
+	return capabilities, nil
+}
+
+func hasProxyCapability(opt *Options, capability string) bool {
+	return isProxyCapability(opt.ProxyCapabilities, capability)
+}
+
+func isProxyCapability(capabilities []string, capability string) bool {
+	for _, item := range capabilities {
+		if item == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Reject the Options the Proxy image lacks the capabilities for, rather than having the Proxy ignore them
+func checkProxyCapabilities(opt *Options) error {
+	for _, rule := range opt.PortSettings {
+		if err := rule.Settings.checkCapabilities(opt); err != nil {
+			return fmt.Errorf("invalid port settings of ports %d-%d: %s", rule.Ports.From, rule.Ports.To, err.Error())
+		}
+	}
+	switch opt.ProxyConfigEncoding {
+	case ProxyConfigEncodingEscaped:
+		return requireProxyCapability(opt, ProxyCapabilityEscapedQueues, "the escaped proxy config encoding")
+	case ProxyConfigEncodingJSON:
+		return requireProxyCapability(opt, ProxyCapabilityJSONConfig, "the json proxy config encoding")
+	}
+	return nil
+}
+
+func requireProxyCapability(opt *Options, capability, feature string) error {
+	if !hasProxyCapability(opt, capability) {
+		return fmt.Errorf("%s requires a Proxy image with the %s capability", feature, capability)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	reasonClientIPLost = "ClientIPLost"
+
+	statusWarningClientIP = "client-ip"
+)
+
+// Annotations enabling the PROXY protocol on cloud provider load balancers
+var proxyProtocolAnnotations = []string{
+	"service.beta.kubernetes.io/aws-load-balancer-proxy-protocol",
+	"service.beta.kubernetes.io/do-loadbalancer-enable-proxy-protocol",
+	"service.beta.kubernetes.io/linode-loadbalancer-proxy-protocol",
+	"service.beta.kubernetes.io/scw-loadbalancer-proxy-protocol-v2",
+	"load-balancer.hetzner.cloud/uses-proxyprotocol",
+}
+
+// Settings of a Proxy Service the client IPs of its connections are lost with, empty if they are preserved
+func getClientIPLoss(svc *corev1.Service) []string {
+	var causes []string
+	switch svc.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer, corev1.ServiceTypeNodePort:
+		if policy := svc.Spec.ExternalTrafficPolicy; policy != corev1.ServiceExternalTrafficPolicyTypeLocal {
+			if policy == "" {
+				policy = corev1.ServiceExternalTrafficPolicyTypeCluster
+			}
+			causes = append(causes, fmt.Sprintf("the %s external traffic policy of Service %s SNATs connections to node IPs", policy, svc.Name))
+		}
+	}
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return causes
+	}
+	var enabled []string
+	for _, key := range proxyProtocolAnnotations {
+		switch value := strings.ToLower(svc.Annotations[key]); value {
+		case "", "false", "none", "disabled":
+		default:
+			enabled = append(enabled, key)
+		}
+	}
+	if len(enabled) > 0 {
+		sort.Strings(enabled)
+		causes = append(causes, fmt.Sprintf("the load balancer of Service %s sends the PROXY protocol header, which the Proxy does not decode, enabled by %s", svc.Name, strings.Join(enabled, ", ")))
+	}
+	return causes
+}
+
+// Warn when the Proxy Service settings lose the client IPs, e.g. when backend logs show node IPs
+// The Service is checked on each reconcile as its policy and annotations may be changed by other tools
+func (mgr *Manager) checkClientIP(ctx context.Context) error {
+	if !mgr.opt.ClientIPCheck {
+		return nil
+	}
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	svc := corev1.Service{}
+	if err := mgr.k8sClient.Get(ctx, proxyKey, &svc); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+		proxyClientIPPreserved.DeleteLabelValues(mgr.opt.ProxyName)
+		mgr.reportClientIPLoss(nil, nil)
+		return nil
+	}
+	causes := getClientIPLoss(&svc)
+	if len(causes) > 0 {
+		proxyClientIPPreserved.WithLabelValues(mgr.opt.ProxyName).Set(0)
+	} else {
+		proxyClientIPPreserved.WithLabelValues(mgr.opt.ProxyName).Set(1)
+	}
+	mgr.reportClientIPLoss(&svc, causes)
+	return nil
+}
+
+// Events are recorded once per change of the causes, the status lists the current causes
+func (mgr *Manager) reportClientIPLoss(svc *corev1.Service, causes []string) {
+	warning := strings.Join(causes, ", ")
+	if warning == mgr.clientIPWarning {
+		return
+	}
+	mgr.clientIPWarning = warning
+	if warning == "" {
+		mgr.warnings.setError(statusWarningClientIP, nil)
+		return
+	}
+	mgr.log.Info("Client IPs are lost, "+warning, "service", svc.Name)
+	mgr.recorder.Event(svc, corev1.EventTypeWarning, reasonClientIPLost, "Backends see node or load balancer IPs instead of client IPs: "+warning)
+	mgr.warnings.setError(statusWarningClientIP, errors.New(warning))
+}
@@ -0,0 +1,77 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestClientIPCheck(t *testing.T) {
+	for name, spec := range map[string]struct {
+		svc    corev1.Service
+		causes int
+	}{
+		"local":    {svc: corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal}}},
+		"internal": {svc: corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}}},
+		"nodeport": {svc: corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort}}, causes: 1},
+		"proxy protocol": {svc: corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"service.beta.kubernetes.io/aws-load-balancer-proxy-protocol": "*"}},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeCluster},
+		}, causes: 2},
+		"proxy protocol disabled": {svc: corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"service.beta.kubernetes.io/do-loadbalancer-enable-proxy-protocol": "false"}},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal},
+		}},
+	} {
+		if causes := getClientIPLoss(&spec.svc); len(causes) != spec.causes {
+			t.Errorf("%s: expected %d causes, got %v", name, spec.causes, causes)
+		}
+	}
+
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	svc := newProxyService("iofog", "http-proxy", nil, string(corev1.ServiceTypeLoadBalancer))
+	svc.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeCluster
+	if err := k8sClient.Create(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+
+	// The warning is recorded once and cleared when the Service preserves client IPs again
+	for i := 0; i < 2; i++ {
+		if err := mgr.checkClientIP(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, warned := mgr.getStatus().Warnings[statusWarningClientIP]; !warned {
+		t.Error("Expected lost client IPs to be reported in the status")
+	}
+	if events := mgr.recorder.(*record.FakeRecorder).Events; len(events) != 1 {
+		t.Errorf("Expected a single Event, got %d", len(events))
+	}
+	svc.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+	if err := k8sClient.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.checkClientIP(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if warnings := mgr.getStatus().Warnings; len(warnings) != 0 {
+		t.Errorf("Expected warning to be cleared, got %v", warnings)
+	}
+}
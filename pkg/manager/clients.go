@@ -0,0 +1,52 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+)
+
+// ControllerClient is the subset of the ioFog Controller API used by the Manager
+type ControllerClient interface {
+	GetAllMicroservicePublicPorts() ([]ioclient.MicroservicePublicPort, error)
+	PutDefaultProxy(address string) error
+}
+
+// Controller clients able to make their calls in the context of the caller, so they end with it e.g. on shutdown
+type contextControllerClient interface {
+	withContext(ctx context.Context) ControllerClient
+}
+
+// Controller client making its calls in ctx, clients without context support are returned as they are
+func bindControllerClient(ctx context.Context, client ControllerClient) ControllerClient {
+	if contextClient, ok := client.(contextControllerClient); ok {
+		return contextClient.withContext(ctx)
+	}
+	return client
+}
+
+// Controller clients able to register the address of the ports of a protocol, e.g. the ioFog SDK client
+type publicPortHostRegistrar interface {
+	PutPublicPortHost(protocol, host string) error
+}
+
+var errProtocolRegistration = errors.New("the Controller client cannot register the address of a protocol, required by the protocol registration")
+
+// LoadBalancerWaiter waits for a LoadBalancer Service to be assigned an external address
+type LoadBalancerWaiter interface {
+	WaitForLoadBalancer(namespace, name string, timeoutSeconds int64) (string, error)
+}
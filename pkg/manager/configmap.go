@@ -0,0 +1,86 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Set a single key of a ConfigMap shared by the Managers, creating the ConfigMap if required
+// Other keys are left untouched so each Manager can own a key of the same ConfigMap
+func (mgr *Manager) applyConfigMapKey(ctx context.Context, name, key, value string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return mgr.applyConfigMapKeyOnce(ctx, name, key, value)
+	})
+}
+
+func (mgr *Manager) applyConfigMapKeyOnce(ctx context.Context, name, key, value string) error {
+	objKey := k8sclient.ObjectKey{
+		Name:      name,
+		Namespace: mgr.opt.Namespace,
+	}
+	cm := corev1.ConfigMap{}
+	if err := mgr.k8sClient.Get(ctx, objKey, &cm); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+		cm = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      objKey.Name,
+				Namespace: objKey.Namespace,
+			},
+			Data: map[string]string{key: value},
+		}
+		mgr.setOwnerReference(&cm)
+		err = mgr.k8sClient.Create(ctx, &cm)
+		if k8serrors.IsAlreadyExists(err) {
+			// Created concurrently by another Manager, retry as an update
+			return k8serrors.NewConflict(corev1.Resource("configmaps"), objKey.Name, err)
+		}
+		return err
+	}
+	if cm.Data[key] == value {
+		return nil
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[key] = value
+	return mgr.k8sClient.Update(ctx, &cm)
+}
+
+// Remove a key of a shared ConfigMap, e.g. of a Proxy that no longer exists
+func (mgr *Manager) deleteConfigMapKey(ctx context.Context, name, key string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		objKey := k8sclient.ObjectKey{
+			Name:      name,
+			Namespace: mgr.opt.Namespace,
+		}
+		cm := corev1.ConfigMap{}
+		if err := mgr.k8sClient.Get(ctx, objKey, &cm); err != nil {
+			return k8sclient.IgnoreNotFound(err)
+		}
+		if _, exists := cm.Data[key]; !exists {
+			return nil
+		}
+		delete(cm.Data, key)
+		return mgr.k8sClient.Update(ctx, &cm)
+	})
+}
@@ -0,0 +1,149 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	PortConflictScopeNone      = "none"
+	PortConflictScopeNamespace = "namespace"
+	PortConflictScopeCluster   = "cluster"
+
+	reasonPortConflict = "PortConflict"
+
+	statusErrorRejected = "rejected"
+)
+
+// ParsePortConflictScope validates where other Services are checked for port collisions, defaulting to the namespace
+func ParsePortConflictScope(scope string) (string, error) {
+	switch strings.ToLower(scope) {
+	case "", PortConflictScopeNamespace:
+		return PortConflictScopeNamespace, nil
+	case PortConflictScopeCluster:
+		return PortConflictScopeCluster, nil
+	case PortConflictScopeNone:
+		return PortConflictScopeNone, nil
+	}
+	return "", fmt.Errorf("unsupported port conflict scope %s", scope)
+}
+
+// Get the externally reachable ports claimed by Services other than the Proxy Service
+// LoadBalancer Services claim their ports, NodePort Services claim their node ports
+func getClaimedPorts(services []corev1.Service, namespace, proxyName string) map[int]string {
+	claimed := make(map[int]string)
+	for idx := range services {
+		svc := &services[idx]
+		if svc.Namespace == namespace && svc.Name == proxyName {
+			continue
+		}
+		name := svc.Namespace + "/" + svc.Name
+		for _, port := range svc.Spec.Ports {
+			switch svc.Spec.Type {
+			case corev1.ServiceTypeLoadBalancer:
+				claimed[int(port.Port)] = name
+			case corev1.ServiceTypeNodePort:
+				if port.NodePort != 0 {
+					claimed[int(port.NodePort)] = name
+				}
+			}
+		}
+	}
+	return claimed
+}
+
+// Lists the Services in the configured scope on first use, so polls without new ports do not list Services
+type portClaims struct {
+	mgr     *Manager
+	claimed map[int]string
+}
+
+// Get the Service already claiming a port, if any
+func (claims *portClaims) getClaim(ctx context.Context, port int) (string, error) {
+	mgr := claims.mgr
+	if mgr.opt.PortConflictScope == "" || mgr.opt.PortConflictScope == PortConflictScopeNone {
+		return "", nil
+	}
+	if claims.claimed == nil {
+		var opts []k8sclient.ListOption
+		if mgr.opt.PortConflictScope == PortConflictScopeNamespace {
+			opts = append(opts, k8sclient.InNamespace(mgr.opt.Namespace))
+		}
+		list := corev1.ServiceList{}
+		if err := mgr.k8sClient.List(ctx, &list, opts...); err != nil {
+			return "", err
+		}
+		// The Proxies of all zones expose the same ports
+		services := make([]corev1.Service, 0, len(list.Items))
+		for idx := range list.Items {
+			if !isZoneSibling(&list.Items[idx], mgr.opt) {
+				services = append(services, list.Items[idx])
+			}
+		}
+		claims.claimed = getClaimedPorts(services, mgr.opt.Namespace, mgr.opt.ProxyName)
+	}
+	return claims.claimed[port], nil
+}
+
+// Reason a port requested by the Controller is not exposed
+type portRejection struct {
+	reason  string
+	message string
+}
+
+func newPortConflict(port int, svc string) portRejection {
+	return portRejection{
+		reason:  reasonPortConflict,
+		message: fmt.Sprintf("port %d is already claimed by Service %s", port, svc),
+	}
+}
+
+// Report ports that are not exposed, e.g. because another Service claims them
+// Events are recorded once per rejection, the status lists all current rejections
+func (mgr *Manager) reportRejectedPorts(rejected map[int]portRejection) {
+	var msgs []string
+	for port, rejection := range rejected {
+		msgs = append(msgs, rejection.message)
+		if mgr.rejectedPorts[port] == rejection {
+			continue
+		}
+		mgr.log.Info("Not exposing port, "+rejection.message, "port", port)
+		mgr.recorder.Event(mgr.getOwnerObjectReference(), corev1.EventTypeWarning, rejection.reason, "Not exposing "+rejection.message)
+	}
+	mgr.rejectedPorts = rejected
+	if len(msgs) == 0 {
+		mgr.status.setError(statusErrorRejected, nil)
+		return
+	}
+	sort.Strings(msgs)
+	mgr.status.setError(statusErrorRejected, fmt.Errorf("%s", strings.Join(msgs, ", ")))
+}
+
+// Reference to the Deployment of this manager, used as the subject of Events not tied to a Proxy resource
+func (mgr *Manager) getOwnerObjectReference() *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: mgr.owner.APIVersion,
+		Kind:       mgr.owner.Kind,
+		Name:       mgr.owner.Name,
+		Namespace:  mgr.opt.Namespace,
+		UID:        mgr.owner.UID,
+	}
+}
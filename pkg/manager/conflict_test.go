@@ -0,0 +1,52 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetClaimedPorts(t *testing.T) {
+	services := []corev1.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "http-proxy", Namespace: "iofog"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: []corev1.ServicePort{{Port: 80}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "iofog"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: []corev1.ServicePort{{Port: 443, NodePort: 30443}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "iofog"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, Ports: []corev1.ServicePort{{Port: 8080, NodePort: 30080}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: "iofog"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, Ports: []corev1.ServicePort{{Port: 5000}}},
+		},
+	}
+	claimed := getClaimedPorts(services, "iofog", "http-proxy")
+	expected := map[int]string{443: "iofog/ingress", 30080: "iofog/debug"}
+	if len(claimed) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, claimed)
+	}
+	for port, svc := range expected {
+		if claimed[port] != svc {
+			t.Errorf("Expected port %d to be claimed by %s, got %s", port, svc, claimed[port])
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var contourHTTPProxyKind = schema.GroupVersionKind{Group: "projectcontour.io", Version: "v1", Kind: "HTTPProxy"}
+
+// Routes each HTTP port with a hostname to the Proxy Service with an HTTPProxy named after the Proxy and the port
+// Envoy only serves virtual hosts, so the Proxy is reached by TCP proxying with TLS passthrough when it terminates TLS
+// Ports without a hostname, including all TCP ports, cannot be routed
+type contourRenderer struct{}
+
+func (contourRenderer) kinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{contourHTTPProxyKind}
+}
+
+func (contourRenderer) render(mgr *Manager, ports portMap) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	var unrouted []int
+	for _, port := range getSortedPorts(ports) {
+		protocol := ports[port].Protocol
+		hostname, hasHostname := mgr.hostnames[port]
+		if !isHTTPProtocol(protocol) || !hasHostname {
+			unrouted = append(unrouted, port)
+			continue
+		}
+		service := map[string]interface{}{
+			"name": mgr.opt.ProxyName,
+			"port": int64(port),
+		}
+		virtualHost := map[string]interface{}{"fqdn": hostname}
+		spec := map[string]interface{}{"virtualhost": virtualHost}
+		if isTLSTerminated(mgr.opt, protocol) {
+			virtualHost["tls"] = map[string]interface{}{"passthrough": true}
+			spec["tcpproxy"] = map[string]interface{}{"services": []interface{}{service}}
+		} else {
+			if protocol == "http2" || protocol == "grpc" {
+				service["protocol"] = "h2c"
+			}
+			route := map[string]interface{}{"services": []interface{}{service}}
+			if protocol == "ws" {
+				route["enableWebsockets"] = true
+			}
+			spec["routes"] = []interface{}{route}
+		}
+		objs = append(objs, newRouteObject(contourHTTPProxyKind, fmt.Sprintf("%s-%d", mgr.opt.ProxyName, port), mgr.opt.Namespace, spec))
+	}
+	return objs, newUnroutedPortsError(unrouted, "Contour requires an HTTP port with a hostname, see HOSTNAME_TEMPLATE")
+}
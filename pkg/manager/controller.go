@@ -0,0 +1,530 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrlmanager "sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const statusErrorCluster = "cluster"
+
+type clusterResult struct {
+	err error
+}
+
+// NewControllerManager creates the controller-runtime manager running the reconcilers of the Managers
+// Its informers only cache the Proxy Deployments and Services, the Secret and ConfigMap the Proxies depend on
+// and the EndpointSlices of the router Services
+// Managers configured with another cluster's Config run in a controller manager of their cluster, started with the returned one
+// and recreated with backoff when it fails, so an unreachable cluster does not stop the Managers of the others
+func NewControllerManager(cfg *rest.Config, mgrs []*Manager) (ctrl.Manager, error) {
+	var local []*Manager
+	var remoteConfigs []*rest.Config
+	remote := make(map[*rest.Config][]*Manager)
+	for _, mgr := range mgrs {
+		if mgr.opt.Config == nil || mgr.opt.Config == cfg {
+			local = append(local, mgr)
+			continue
+		}
+		if _, exists := remote[mgr.opt.Config]; !exists {
+			remoteConfigs = append(remoteConfigs, mgr.opt.Config)
+		}
+		remote[mgr.opt.Config] = append(remote[mgr.opt.Config], mgr)
+	}
+	ctrlMgr, err := newControllerManager(cfg, local)
+	if err != nil {
+		return nil, err
+	}
+	for _, remoteCfg := range remoteConfigs {
+		remoteCfg, remoteMgrs := remoteCfg, remote[remoteCfg]
+		if err := ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+			remoteMgrs[0].runRoutine(ctx, "remote-cluster", func(ctx context.Context) {
+				runRemoteControllerManager(ctx, remoteCfg, remoteMgrs)
+			})
+			return nil
+		})); err != nil {
+			return nil, err
+		}
+	}
+	return ctrlMgr, nil
+}
+
+// Run the controller manager of a remote cluster until the context is done, recreating it with backoff when it fails
+// The failure is reported by the Managers of the cluster until its caches are synced again, see ClusterError
+func runRemoteControllerManager(ctx context.Context, cfg *rest.Config, mgrs []*Manager) {
+	delay := pkg.retryBaseDelay
+	for {
+		synced, err := startRemoteControllerManager(ctx, cfg, mgrs)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("stopped")
+		}
+		err = fmt.Errorf("remote cluster %s: %s", cfg.Host, err.Error())
+		for _, mgr := range mgrs {
+			mgr.setClusterError(err)
+		}
+		if synced {
+			delay = pkg.retryBaseDelay
+		}
+		mgrs[0].log.Error(err, "Remote cluster failed, retrying", "after", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > pkg.retryMaxDelay {
+			delay = pkg.retryMaxDelay
+		}
+	}
+}
+
+// Start a controller manager of a remote cluster, returning whether its caches were synced before it stopped
+func startRemoteControllerManager(ctx context.Context, cfg *rest.Config, mgrs []*Manager) (bool, error) {
+	remoteMgr, err := newControllerManager(cfg, mgrs)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	synced := make(chan bool, 1)
+	go func() {
+		ok := remoteMgr.GetCache().WaitForCacheSync(ctx)
+		if ok {
+			for _, mgr := range mgrs {
+				mgr.setClusterError(nil)
+			}
+		}
+		synced <- ok
+	}()
+	err = remoteMgr.Start(ctx)
+	cancel()
+	return <-synced, err
+}
+
+func (mgr *Manager) setClusterError(err error) {
+	mgr.clusterError.Store(clusterResult{err: err})
+	mgr.status.setError(statusErrorCluster, err)
+}
+
+// ClusterError returns the error of the controller manager of the remote cluster of the Manager
+// It is nil while it runs, before it first fails and for Managers of the local cluster
+func (mgr *Manager) ClusterError() error {
+	result, _ := mgr.clusterError.Load().(clusterResult)
+	return result.err
+}
+
+func newControllerManager(cfg *rest.Config, mgrs []*Manager) (ctrl.Manager, error) {
+	scheme, err := newScheme()
+	if err != nil {
+		return nil, err
+	}
+	namespace := ""
+	var proxies, secrets, configMaps []string
+	for _, mgr := range mgrs {
+		namespace = mgr.opt.Namespace
+		proxies = append(proxies, mgr.opt.ProxyName)
+		if mgr.opt.RouterTLSSecret != "" {
+			secrets = append(secrets, mgr.opt.RouterTLSSecret)
+		}
+		if mgr.opt.ProxySidecarConfigMap != "" {
+			configMaps = append(configMaps, mgr.opt.ProxySidecarConfigMap)
+		}
+	}
+	proxySelector, err := newProxySelector(proxies)
+	if err != nil {
+		return nil, err
+	}
+	routerSelector, err := newRouterEndpointsSelector(mgrs)
+	if err != nil {
+		return nil, err
+	}
+	ctrlMgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:    scheme,
+		Namespace: namespace,
+		// Metrics are served by the caller from the same registry
+		MetricsBindAddress:     "0",
+		HealthProbeBindAddress: "0",
+		NewCache: cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&appsv1.Deployment{}:  {Label: proxySelector},
+				&appsv1.DaemonSet{}:   {Label: proxySelector},
+				&appsv1.StatefulSet{}: {Label: proxySelector},
+				&corev1.Service{}:     {Label: proxySelector},
+				&corev1.Secret{}:      {Field: newNameSelector(secrets)},
+				&corev1.ConfigMap{}:   {Field: newNameSelector(configMaps)},
+				// Router endpoint changes re-discover the router
+				&discoveryv1.EndpointSlice{}: {Label: routerSelector},
+			},
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, mgr := range mgrs {
+		if err := mgr.SetupWithManager(ctrlMgr); err != nil {
+			return nil, err
+		}
+	}
+	return ctrlMgr, nil
+}
+
+// Select the Proxy resources by the name label set on them
+func newProxySelector(proxies []string) (labels.Selector, error) {
+	// All Managers run in remote clusters
+	if len(proxies) == 0 {
+		return labels.Nothing(), nil
+	}
+	requirement, err := labels.NewRequirement("name", selection.In, proxies)
+	if err != nil {
+		return nil, err
+	}
+	return labels.NewSelector().Add(*requirement), nil
+}
+
+// Select a single object by name when all Managers depend on the same one
+func newNameSelector(names []string) fields.Selector {
+	for _, name := range names {
+		if name != names[0] {
+			return fields.Everything()
+		}
+	}
+	if len(names) == 0 {
+		return fields.Everything()
+	}
+	return fields.OneTermEqualSelector("metadata.name", names[0])
+}
+
+// Run reconciles the Proxy until ctx is cancelled
+// Use NewControllerManager to run several Managers, or SetupWithManager to add the Manager to an existing controller manager
+func (mgr *Manager) Run(ctx context.Context) error {
+	ctrlMgr, err := NewControllerManager(mgr.opt.Config, []*Manager{mgr})
+	if err != nil {
+		return err
+	}
+	return ctrlMgr.Start(ctx)
+}
+
+// SetupWithManager registers the reconciler of the Proxy and the routine registering its address
+func (mgr *Manager) SetupWithManager(ctrlMgr ctrl.Manager) error {
+	c, err := controller.New(mgr.opt.ProxyName, ctrlMgr, controller.Options{
+		Reconciler: mgr,
+		// The cache is not safe for concurrent reconciles
+		MaxConcurrentReconciles: 1,
+		RateLimiter:             workqueue.NewItemExponentialFailureRateLimiter(pkg.retryBaseDelay, pkg.retryMaxDelay),
+	})
+	if err != nil {
+		return err
+	}
+	// Nothing else may enqueue the first reconcile, e.g. on a fresh install with ROUTER_ADDRESS set and no Proxy resources yet
+	if err := ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+		mgr.triggerReconcile()
+		return nil
+	})); err != nil {
+		return err
+	}
+	// Send the audit records of the Manager and its grouped Proxies
+	if mgr.auditRecords != nil {
+		if err := ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+			mgr.runRoutine(ctx, "audit", mgr.sendAuditRecords)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+
+	// Re-discover the router when its Service or pods change
+	if mgr.routerDiscovery {
+		if err := c.Watch(&source.Kind{Type: &discoveryv1.EndpointSlice{}}, mgr.newEventHandler(nil), mgr.newRouterEndpointsPredicate()); err != nil {
+			return err
+		}
+	}
+
+	// The Proxies of the groups are checked on each poll instead, see reconcileGroups
+	if mgr.opt.ProxyGrouping != "" {
+		return mgr.setupGroupsWithManager(c, ctrlMgr)
+	}
+
+	// Only the Proxy resources of the Manager are cached, the grouped Proxies are not watched
+	mgr.reader = ctrlMgr.GetClient()
+
+	// Enqueue the Proxy when its resources change, resyncing them if they are deleted
+	// Service changes also re-register the address if the LoadBalancer ingress changes
+	proxyPredicate := mgr.newNamePredicate(mgr.opt.ProxyName)
+	if err := c.Watch(&source.Kind{Type: newProxyWorkload(mgr.opt)}, mgr.newEventHandler(mgr.onProxyEvent), proxyPredicate); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &corev1.Service{}}, mgr.newEventHandler(mgr.onProxyServiceEvent), proxyPredicate); err != nil {
+		return err
+	}
+	lbServiceName := getServiceTypeName(mgr.opt.ProxyName, string(corev1.ServiceTypeLoadBalancer))
+	if err := c.Watch(&source.Kind{Type: &corev1.Service{}}, mgr.newEventHandler(mgr.onServiceTypeEvent), mgr.newNamePredicate(lbServiceName)); err != nil {
+		return err
+	}
+	// Roll the Proxy when router credentials rotate
+	if mgr.opt.RouterTLSSecret != "" {
+		if err := c.Watch(&source.Kind{Type: &corev1.Secret{}}, mgr.newEventHandler(mgr.onDependencyEvent), mgr.newNamePredicate(mgr.opt.RouterTLSSecret)); err != nil {
+			return err
+		}
+	}
+	// Re-inject sidecars when their template changes
+	if mgr.opt.ProxySidecarConfigMap != "" {
+		if err := c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, mgr.newEventHandler(mgr.onDependencyEvent), mgr.newNamePredicate(mgr.opt.ProxySidecarConfigMap)); err != nil {
+			return err
+		}
+	}
+	// Explicit triggers, e.g. from the admin API
+	if err := c.Watch(&source.Channel{Source: mgr.events}, mgr.newEventHandler(nil)); err != nil {
+		return err
+	}
+
+	// Scrape the per-port stats of the Proxy pods
+	if mgr.opt.ProxyStatsPort > 0 {
+		scraper := mgr.newStatsScraper()
+		if err := ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+			mgr.runRoutine(ctx, "stats", scraper.run)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+
+	// Probe the exposed ports through the Proxy Service
+	if mgr.opt.PortProbeInterval > 0 {
+		prober := mgr.newPortProber()
+		if err := ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+			mgr.runRoutine(ctx, "probe", prober.run)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+
+	// Check the whole path through the external address
+	if mgr.opt.ExternalCheckInterval > 0 {
+		checker := mgr.newExternalChecker()
+		if err := ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+			mgr.runRoutine(ctx, "external-check", checker.run)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+
+	// Reconcile on the public port notifications the Controller publishes to the router
+	if mgr.opt.NotificationAddress != "" {
+		if err := ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+			mgr.runRoutine(ctx, "notifications", mgr.subscribeNotifications)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+
+	// Start address register routine
+	return ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+		mgr.registerProxyAddress(ctx)
+		return nil
+	}))
+}
+
+func (mgr *Manager) newNamePredicate(name string) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj k8sclient.Object) bool {
+		return obj.GetNamespace() == mgr.opt.Namespace && obj.GetName() == name
+	})
+}
+
+// Enqueue the Proxy for every event but status updates, first passing the object to onEvent if set
+func (mgr *Manager) newEventHandler(onEvent func(obj k8sclient.Object, deleted bool)) handler.EventHandler {
+	notify := func(obj k8sclient.Object, deleted bool) {
+		if onEvent != nil {
+			onEvent(obj, deleted)
+		}
+	}
+	enqueue := func(obj k8sclient.Object, deleted bool, queue workqueue.RateLimitingInterface) {
+		notify(obj, deleted)
+		queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: mgr.opt.Namespace,
+			Name:      mgr.opt.ProxyName,
+		}})
+	}
+	return handler.Funcs{
+		CreateFunc: func(e event.CreateEvent, queue workqueue.RateLimitingInterface) {
+			enqueue(e.Object, false, queue)
+		},
+		UpdateFunc: func(e event.UpdateEvent, queue workqueue.RateLimitingInterface) {
+			if isStatusUpdate(e) {
+				notify(e.ObjectNew, false)
+				return
+			}
+			enqueue(e.ObjectNew, false, queue)
+		},
+		DeleteFunc: func(e event.DeleteEvent, queue workqueue.RateLimitingInterface) {
+			enqueue(e.Object, true, queue)
+		},
+		GenericFunc: func(e event.GenericEvent, queue workqueue.RateLimitingInterface) {
+			enqueue(e.Object, false, queue)
+		},
+	}
+}
+
+// Check whether an update only changed the status, e.g. replicas becoming ready or the LoadBalancer ingress,
+// which does not need a poll of the Controller
+// Services do not track their generation, so their spec is compared instead
+func isStatusUpdate(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+	if !equality.Semantic.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
+		!equality.Semantic.DeepEqual(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()) {
+		return false
+	}
+	if oldSvc, ok := e.ObjectOld.(*corev1.Service); ok {
+		newSvc, ok := e.ObjectNew.(*corev1.Service)
+		return ok && equality.Semantic.DeepEqual(oldSvc.Spec, newSvc.Spec)
+	}
+	if e.ObjectNew.GetGeneration() == 0 {
+		return false
+	}
+	return !(predicate.GenerationChangedPredicate{}).Update(e)
+}
+
+// Request the Proxy resources to be re-applied from the cache on the next reconcile
+func (mgr *Manager) triggerResync() {
+	atomic.StoreInt32(&mgr.resyncRequested, 1)
+	mgr.triggerReconcile()
+}
+
+// Rebuild regenerates the cache from the Proxy Deployment and re-applies the Proxy resources on an immediate reconcile
+// e.g. after fixing a misconfiguration
+func (mgr *Manager) Rebuild() {
+	atomic.StoreInt32(&mgr.rebuildRequested, 1)
+	mgr.triggerReconcile()
+}
+
+// Request an immediate poll of the Controller
+func (mgr *Manager) triggerReconcile() {
+	obj := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}}
+	select {
+	case mgr.events <- event.GenericEvent{Object: obj}:
+	default:
+	}
+}
+
+// Reconcile queries the ioFog Controller REST API and compares it against the cache
+// Makes updates to K8s resources as required and polls again after the poll interval
+// Failures are retried with the rate limiter's backoff, a panic is retried as a failure with a rebuilt cache
+func (mgr *Manager) Reconcile(ctx context.Context, req reconcile.Request) (result reconcile.Result, err error) {
+	ctx = mgr.withReconcileLog(ctx)
+	err = mgr.callRecovering("reconcile", func() (err error) {
+		result, err = mgr.reconcile(ctx, req)
+		return err
+	})
+	var panicErr *panicError
+	if errors.As(err, &panicErr) {
+		// The cache may be partially updated, the watchdog still counts the reconcile as unfinished
+		atomic.StoreInt32(&mgr.rebuildRequested, 1)
+		mgr.status.setError(statusErrorReconcile, err)
+		return reconcile.Result{}, err
+	}
+	return result, err
+}
+
+func (mgr *Manager) reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	if mgr.opt.ProxyGrouping != "" {
+		return mgr.reconcileGroups(ctx)
+	}
+	if mgr.routerDiscovery {
+		if changed, err := mgr.discoverRouter(ctx); err != nil {
+			mgr.getLog(ctx).Error(err, "Failed to discover router address", "service", mgr.opt.RouterService)
+		} else if changed {
+			// Re-render the router env and config of the Proxy
+			atomic.StoreInt32(&mgr.resyncRequested, 1)
+		}
+	}
+	if atomic.CompareAndSwapInt32(&mgr.rebuildRequested, 1, 0) {
+		mgr.getLog(ctx).Info("Rebuilding cache")
+		mgr.cacheGenerated = false
+	}
+	if !mgr.cacheGenerated {
+		// Initialize cache based on K8s API
+		if err := mgr.generateCache(ctx); err != nil {
+			mgr.getLog(ctx).Error(err, "Failed to generate cache")
+		} else {
+			// Apply configuration that may have changed since the Proxy was deployed, e.g. router addresses
+			atomic.StoreInt32(&mgr.resyncRequested, 1)
+		}
+		mgr.cacheGenerated = true
+		mgr.saveSnapshot()
+	}
+
+	if atomic.CompareAndSwapInt32(&mgr.resyncRequested, 1, 0) {
+		err := mgr.resync(ctx)
+		if ignorePending(err) != nil {
+			mgr.getLog(ctx).Error(err, "Failed to resync Proxy")
+		}
+		mgr.observeReconcile(ctx, ignorePending(err))
+		if err != nil {
+			return getErrorResult(err)
+		}
+	}
+
+	if err := mgr.checkProxyRollout(ctx); err != nil {
+		mgr.getLog(ctx).Error(err, "Failed to check Proxy rollout")
+	}
+	if err := mgr.checkProxyImage(ctx); err != nil {
+		mgr.getLog(ctx).Error(err, "Failed to check Proxy image")
+	}
+	if err := mgr.checkClientIP(ctx); err != nil {
+		mgr.getLog(ctx).Error(err, "Failed to check client IP preservation")
+	}
+	err := mgr.run(ctx)
+	if ignorePending(err) != nil {
+		mgr.getLog(ctx).Error(err, "Failed to reconcile Proxy")
+	}
+	mgr.observeReconcile(ctx, ignorePending(err))
+	if err != nil {
+		return getErrorResult(err)
+	}
+	return reconcile.Result{RequeueAfter: pkg.pollInterval}, nil
+}
@@ -0,0 +1,322 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+)
+
+const (
+	controllerRequestTimeout = time.Second * 30
+	controllerCallTimeout    = time.Minute * 2
+)
+
+// Operations of the Controller API reported in the request metrics
+const (
+	controllerOpLogin             = "login"
+	controllerOpGetPublicPorts    = "get_public_ports"
+	controllerOpGetMicroservice   = "get_microservice"
+	controllerOpPutDefaultProxy   = "put_default_proxy"
+	controllerOpPutPublicPortHost = "put_public_port_host"
+)
+
+// Keys of the Controller config set by the SDK, the protocol of a Proxy prefixes the public port host key
+const (
+	controllerConfigDefaultProxy   = "default-proxy-host"
+	controllerConfigPublicPortHost = "-public-port-host"
+)
+
+// Non-2xx response of the Controller to a request of the HTTP client
+type controllerStatusError struct {
+	status string
+	code   int
+	path   string
+}
+
+func newControllerStatusError(resp *http.Response, path string) error {
+	return &controllerStatusError{status: resp.Status, code: resp.StatusCode, path: path}
+}
+
+func (err *controllerStatusError) Error() string {
+	return fmt.Sprintf("controller returned %s for %s", err.status, err.path)
+}
+
+// Result of a Controller call, a panic is raised again on the calling goroutine
+type controllerCallResult struct {
+	err      error
+	panicked bool
+	value    interface{}
+}
+
+// Slot of the single call of a Controller client in flight, so an abandoned call does not overlap the next one,
+// e.g. while the SDK logs in again to refresh its token
+type controllerCalls chan struct{}
+
+func newControllerCalls() controllerCalls {
+	return make(controllerCalls, 1)
+}
+
+// Call the Controller within timeout, including the retries of the SDK, and record the latency and errors of the call
+// The SDK does not take a context: once ctx is done or the deadline passed the call is abandoned and ends with its
+// request timeout, requests of the HTTP client are cancelled with ctx instead
+// With calls, the call first waits for the previous one to finish, abandoned or not
+func callController(ctx context.Context, calls controllerCalls, operation string, timeout time.Duration, call func(ctx context.Context) error) (err error) {
+	start := time.Now()
+	defer func() {
+		observeControllerRequest(operation, start, err)
+	}()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if calls != nil {
+		select {
+		case calls <- struct{}{}:
+		case <-ctx.Done():
+			return fmt.Errorf("controller %s did not start, the previous call is still running: %w", operation, ctx.Err())
+		}
+	}
+	done := make(chan controllerCallResult, 1)
+	go func() {
+		defer func() {
+			if calls != nil {
+				<-calls
+			}
+		}()
+		defer func() {
+			if value := recover(); value != nil {
+				done <- controllerCallResult{panicked: true, value: value}
+			}
+		}()
+		done <- controllerCallResult{err: call(ctx)}
+	}()
+	select {
+	case result := <-done:
+		if result.panicked {
+			panic(result.value)
+		}
+		return result.err
+	case <-ctx.Done():
+		if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("controller %s did not finish within %s: %w", operation, timeout, ctx.Err())
+		}
+		return fmt.Errorf("controller %s was abandoned: %w", operation, ctx.Err())
+	}
+}
+
+// Timeout of each request of the SDK, which only supports whole seconds
+func getSDKTimeout(timeout time.Duration) int {
+	return int(math.Ceil(timeout.Seconds()))
+}
+
+// Controller client recording the latency and errors of each request, other requests go through the SDK client
+// When conditional, ports are queried directly with conditional requests so an unchanged list is not downloaded again
+// The Controller returns all public ports at once, they are filtered by protocol by the Manager
+// The SDK sends its requests through http.DefaultTransport, so with a Controller proxy all requests are sent directly
+// through the transport of the client instead, see newControllerTransport
+type controllerHTTPClient struct {
+	*ioclient.Client
+	conditional bool
+	direct      bool
+	httpClient  *http.Client
+	callTimeout time.Duration
+	// Context the calls are made in, see withContext
+	ctx context.Context
+	// Shared with the copies bound to other contexts
+	calls      controllerCalls
+	validators *listValidators
+}
+
+// Validators of the last list returned by the Controller
+type listValidators struct {
+	ports        []ioclient.MicroservicePublicPort
+	etag         string
+	lastModified string
+}
+
+func newControllerHTTPClient(client *ioclient.Client, opt *Options) *controllerHTTPClient {
+	transport := newControllerTransport(opt)
+	return &controllerHTTPClient{
+		Client:      client,
+		conditional: opt.ControllerConditional,
+		direct:      transport != nil,
+		httpClient:  &http.Client{Timeout: opt.ControllerTimeout, Transport: transport},
+		callTimeout: opt.ControllerCallTimeout,
+		ctx:         context.Background(),
+		calls:       newControllerCalls(),
+		validators:  &listValidators{},
+	}
+}
+
+// Copy of the client making its calls in ctx, they still run one at a time with the calls of the other copies
+func (clt *controllerHTTPClient) withContext(ctx context.Context) ControllerClient {
+	bound := *clt
+	bound.ctx = ctx
+	return &bound
+}
+
+func (clt *controllerHTTPClient) call(operation string, call func(ctx context.Context) error) error {
+	return callController(clt.ctx, clt.calls, operation, clt.callTimeout, call)
+}
+
+// Log in with POST /user/login like the SDK, through the transport of the client
+func (clt *controllerHTTPClient) login(email, password string) error {
+	return clt.call(controllerOpLogin, func(ctx context.Context) error {
+		response := ioclient.LoginResponse{}
+		if err := clt.do(ctx, http.MethodPost, "/user/login", ioclient.LoginRequest{Email: email, Password: password}, &response); err != nil {
+			return err
+		}
+		clt.SetAccessToken(response.AccessToken)
+		return nil
+	})
+}
+
+// Results of a call are only read once it returned without error, an abandoned call may still set them
+func (clt *controllerHTTPClient) GetAllMicroservicePublicPorts() ([]ioclient.MicroservicePublicPort, error) {
+	var ports []ioclient.MicroservicePublicPort
+	err := clt.call(controllerOpGetPublicPorts, func(ctx context.Context) (err error) {
+		if clt.conditional || clt.direct {
+			ports, err = clt.queryPublicPorts(ctx)
+		} else {
+			ports, err = clt.Client.GetAllMicroservicePublicPorts()
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ports, nil
+}
+
+func (clt *controllerHTTPClient) GetMicroserviceByID(uuid string) (*ioclient.MicroserviceInfo, error) {
+	var msvc *ioclient.MicroserviceInfo
+	err := clt.call(controllerOpGetMicroservice, func(ctx context.Context) (err error) {
+		if clt.direct {
+			msvc = new(ioclient.MicroserviceInfo)
+			return clt.do(ctx, http.MethodGet, "/microservices/"+url.PathEscape(uuid), nil, msvc)
+		}
+		msvc, err = clt.Client.GetMicroserviceByID(uuid)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msvc, nil
+}
+
+func (clt *controllerHTTPClient) PutDefaultProxy(address string) error {
+	return clt.call(controllerOpPutDefaultProxy, func(ctx context.Context) error {
+		if clt.direct {
+			return clt.putConfig(ctx, controllerConfigDefaultProxy, address)
+		}
+		return clt.Client.PutDefaultProxy(address)
+	})
+}
+
+func (clt *controllerHTTPClient) PutPublicPortHost(protocol, host string) error {
+	return clt.call(controllerOpPutPublicPortHost, func(ctx context.Context) error {
+		if clt.direct {
+			return clt.putConfig(ctx, protocol+controllerConfigPublicPortHost, host)
+		}
+		return clt.Client.PutPublicPortHost(protocol, host)
+	})
+}
+
+// Set a key of the Controller config with PUT /config, the request of the SDK for the default and protocol addresses
+func (clt *controllerHTTPClient) putConfig(ctx context.Context, key, value string) error {
+	return clt.do(ctx, http.MethodPut, "/config", ioclient.UpdateConfigRequest{Key: key, Value: value}, nil)
+}
+
+// Send a JSON request to the Controller and decode its JSON response into result unless it is nil
+func (clt *controllerHTTPClient) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(clt.GetBaseURL(), "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", clt.GetAccessToken())
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := clt.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newControllerStatusError(resp, path)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Query the public ports, when conditional with the validators of the previous list so a 304 Not Modified returns it
+func (clt *controllerHTTPClient) queryPublicPorts(ctx context.Context) ([]ioclient.MicroservicePublicPort, error) {
+	path := "/microservices/public-ports"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(clt.GetBaseURL(), "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", clt.GetAccessToken())
+	conditional := clt.conditional && clt.validators.ports != nil
+	if conditional {
+		if clt.validators.etag != "" {
+			req.Header.Set("If-None-Match", clt.validators.etag)
+		}
+		if clt.validators.lastModified != "" {
+			req.Header.Set("If-Modified-Since", clt.validators.lastModified)
+		}
+	}
+	resp, err := clt.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && conditional {
+		return append([]ioclient.MicroservicePublicPort{}, clt.validators.ports...), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newControllerStatusError(resp, path)
+	}
+	ports := make([]ioclient.MicroservicePublicPort, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&ports); err != nil {
+		return nil, err
+	}
+
+	if clt.conditional {
+		clt.validators.ports = append([]ioclient.MicroservicePublicPort{}, ports...)
+		clt.validators.etag, clt.validators.lastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	}
+	return ports, nil
+}
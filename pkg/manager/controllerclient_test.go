@@ -0,0 +1,266 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newTestPublicPorts(count int) []ioclient.MicroservicePublicPort {
+	ports := make([]ioclient.MicroservicePublicPort, 0, count)
+	for idx := 0; idx < count; idx++ {
+		port := 5000 + idx
+		ports = append(ports, ioclient.MicroservicePublicPort{
+			MicroserviceUUID: "abc",
+			PublicPort:       ioclient.PublicPort{Port: port, Protocol: "http", Queue: "abc-" + strconv.Itoa(port)},
+		})
+	}
+	return ports
+}
+
+func TestControllerHTTPClient(t *testing.T) {
+	ports := newTestPublicPorts(5)
+	version := 1
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/microservices/public-ports" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// The Proxy filters the protocols itself, a Controller filter would drop the ws and grpc ports of an http Proxy
+		if _, exists := r.URL.Query()["protocol"]; exists {
+			t.Errorf("Expected no protocol filter, got %s", r.URL.Query().Get("protocol"))
+		}
+		etag := `"` + strconv.Itoa(version) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		_ = json.NewEncoder(w).Encode(ports[:version+2])
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL + "/api/v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ioclient.NewWithToken(ioclient.Options{BaseURL: baseURL}, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clt := newControllerHTTPClient(client, &Options{ProtocolFilter: "HTTP", ControllerConditional: true})
+
+	// The second query of each version is answered with 304 Not Modified
+	for _, version = range []int{1, 1, 3, 3} {
+		result, err := clt.GetAllMicroservicePublicPorts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != version+2 {
+			t.Fatalf("Expected %d ports of version %d, got %v", version+2, version, result)
+		}
+		for idx := range result {
+			if result[idx] != ports[idx] {
+				t.Errorf("Expected port %v, got %v", ports[idx], result[idx])
+			}
+		}
+	}
+	if downloads != 2 {
+		t.Errorf("Expected unchanged lists not to be downloaded again, got %d downloads", downloads)
+	}
+}
+
+func TestControllerRequestMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/microservices/abc":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/api/v3/config":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			_ = json.NewEncoder(w).Encode(newTestPublicPorts(1))
+		}
+	}))
+	defer server.Close()
+	baseURL, err := url.Parse(server.URL + "/api/v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ioclient.NewWithToken(ioclient.Options{BaseURL: baseURL}, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clt := newControllerHTTPClient(client, &Options{})
+	if _, err := clt.GetAllMicroservicePublicPorts(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clt.GetMicroserviceByID("abc"); err == nil {
+		t.Error("Expected microservice lookup to fail")
+	}
+	if err := clt.PutDefaultProxy("1.2.3.4"); err == nil {
+		t.Error("Expected default proxy registration to fail")
+	}
+
+	for operation, class := range map[string]string{controllerOpGetMicroservice: "server_error", controllerOpPutDefaultProxy: "unauthorized"} {
+		metric := dto.Metric{}
+		if err := controllerRequestErrors.WithLabelValues(operation, class).Write(&metric); err != nil || metric.GetCounter().GetValue() < 1 {
+			t.Errorf("Expected %s to fail with %s", operation, class)
+		}
+	}
+	metric := dto.Metric{}
+	observer := controllerRequestDuration.WithLabelValues(controllerOpGetPublicPorts, "success").(prometheus.Histogram)
+	if err := observer.Write(&metric); err != nil || metric.GetHistogram().GetSampleCount() < 1 {
+		t.Error("Expected public port query latency to be recorded")
+	}
+	for err, class := range map[error]string{
+		errors.New("decode"):                                    "other",
+		&url.Error{Op: "Get", Err: errors.New("refused")}:       "connection",
+		ioclient.NewNotFoundError("gone"):                       "not_found",
+		&controllerStatusError{code: http.StatusGatewayTimeout}: "timeout",
+	} {
+		if got := getControllerErrorClass(err); got != class {
+			t.Errorf("Expected class %s of %v, got %s", class, err, got)
+		}
+	}
+}
+
+func TestControllerTimeouts(t *testing.T) {
+	// The Controller accepts connections but never responds
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hung:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(hung)
+	baseURL, err := url.Parse(server.URL + "/api/v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ioclient.NewWithToken(ioclient.Options{BaseURL: baseURL, Timeout: 1}, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The call deadline cancels the requests of the HTTP client and abandons the SDK calls
+	clt := newControllerHTTPClient(client, &Options{ControllerConditional: true, ControllerTimeout: time.Minute, ControllerCallTimeout: time.Millisecond * 50})
+	start := time.Now()
+	if _, err := clt.GetAllMicroservicePublicPorts(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected conditional query to exceed its deadline, got %v", err)
+	}
+	if err := clt.PutDefaultProxy("1.2.3.4"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected SDK call to exceed its deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected calls to fail at their deadline, took %s", elapsed)
+	}
+
+	// Without a deadline, each request still times out
+	clt = newControllerHTTPClient(client, &Options{ControllerConditional: true, ControllerTimeout: time.Millisecond * 50})
+	_, err = clt.GetAllMicroservicePublicPorts()
+	if err == nil {
+		t.Fatal("Expected public port query to time out")
+	}
+	if class := getControllerErrorClass(err); class != "timeout" {
+		t.Errorf("Expected timed out request to be classed as timeout, got %s", class)
+	}
+}
+
+func TestControllerCallsInFlight(t *testing.T) {
+	// The Controller holds the config requests until released
+	release := make(chan struct{})
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/config" {
+			return
+		}
+		count := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for max := atomic.LoadInt32(&maxInFlight); count > max && !atomic.CompareAndSwapInt32(&maxInFlight, max, count); max = atomic.LoadInt32(&maxInFlight) {
+		}
+		<-release
+	}))
+	defer server.Close()
+	baseURL, err := url.Parse(server.URL + "/api/v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ioclient.NewWithToken(ioclient.Options{BaseURL: baseURL, Timeout: 10}, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clt := newControllerHTTPClient(client, &Options{ControllerCallTimeout: time.Millisecond * 50})
+
+	// The abandoned call keeps the next one from starting
+	if err := clt.PutDefaultProxy("1.2.3.4"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected SDK call to exceed its deadline, got %v", err)
+	}
+	if err := clt.PutDefaultProxy("1.2.3.4"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected call to wait for the abandoned call, got %v", err)
+	}
+
+	// Calls in a cancelled context are abandoned without waiting for the deadline
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bound := bindControllerClient(ctx, clt)
+	if err := bound.PutDefaultProxy("1.2.3.4"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected call in cancelled context to be abandoned, got %v", err)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		if err := clt.PutDefaultProxy("1.2.3.4"); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected call to succeed once the abandoned call finished, got %v", err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if max := atomic.LoadInt32(&maxInFlight); max != 1 {
+		t.Errorf("Expected a single request in flight, got %d", max)
+	}
+}
+
+func TestControllerCallPanic(t *testing.T) {
+	defer func() {
+		if value := recover(); value != "boom" {
+			t.Errorf("Expected panic of the call to be raised again, got %v", value)
+		}
+	}()
+	_ = callController(context.Background(), newControllerCalls(), controllerOpGetMicroservice, time.Minute, func(context.Context) error {
+		panic("boom")
+	})
+}
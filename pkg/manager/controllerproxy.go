@@ -0,0 +1,81 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+func validateControllerProxy(proxy string) error {
+	if proxy == "" {
+		return nil
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("scheme must be http, https or socks5")
+	}
+	if proxyURL.Host == "" {
+		return fmt.Errorf("host is missing")
+	}
+	return nil
+}
+
+// Proxy URL with its user info replaced, so the proxy credentials are not logged
+func redactProxyURL(proxy string) string {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return "<invalid>"
+	}
+	if proxyURL.User != nil {
+		proxyURL.User = url.User("redacted")
+	}
+	return proxyURL.String()
+}
+
+// Get the proxy function of the Controller requests, nil when only HTTPS_PROXY, HTTP_PROXY and NO_PROXY apply
+func getControllerProxy(opt *Options) func(*url.URL) (*url.URL, error) {
+	if opt.ControllerProxy == "" && opt.ControllerNoProxy == "" {
+		return nil
+	}
+	cfg := httpproxy.FromEnvironment()
+	if opt.ControllerProxy != "" {
+		cfg.HTTPProxy, cfg.HTTPSProxy = opt.ControllerProxy, opt.ControllerProxy
+	}
+	if opt.ControllerNoProxy != "" {
+		cfg.NoProxy = opt.ControllerNoProxy
+	}
+	return cfg.ProxyFunc()
+}
+
+// Transport of the requests of a Manager to its Controller through its Controller proxy, nil without one
+func newControllerTransport(opt *Options) http.RoundTripper {
+	proxy := getControllerProxy(opt)
+	if proxy == nil {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+	return transport
+}
@@ -0,0 +1,159 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// Keys of the custom labels and annotations last set on a resource, so keys dropped from the Options are removed
+	customMetadataAnnotation = "iofog.org/proxy-custom-metadata"
+
+	// Prefix of the labels and annotations managed by the Manager itself
+	managedMetadataPrefix = "iofog.org/"
+)
+
+// Keys recorded in the customMetadataAnnotation
+type customMetadataKeys struct {
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// ParseLabels parses comma-separated labels added to the Proxy resources, e.g. team=edge,cost-center=1234
+// The name label selecting the Proxy pods and the iofog.org labels of the Manager cannot be set
+func ParseLabels(value string) (map[string]string, error) {
+	labels, err := parseLabelPairs(value)
+	if err != nil {
+		return nil, err
+	}
+	for key := range labels {
+		if err := checkCustomMetadataKey(key); err != nil {
+			return nil, fmt.Errorf("invalid label %s: %s", key, err.Error())
+		}
+	}
+	return labels, nil
+}
+
+// ParseNodeSelector parses the comma-separated node labels the Proxy pods are restricted to, e.g. node-role.kubernetes.io/ingress=
+func ParseNodeSelector(value string) (map[string]string, error) {
+	return parseLabelPairs(value)
+}
+
+func parseLabelPairs(value string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		keyValue := strings.SplitN(item, "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid label %s: expected key=value", item)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key %s: %s", key, strings.Join(errs, ", "))
+		}
+		value := strings.TrimSpace(keyValue[1])
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label value %s: %s", value, strings.Join(errs, ", "))
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// Check a custom label or annotation does not replace one the Manager relies on
+func checkCustomMetadataKey(key string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	if key == "name" || strings.HasPrefix(key, managedMetadataPrefix) {
+		return fmt.Errorf("%s is managed by the Manager", key)
+	}
+	return nil
+}
+
+// Merge the custom labels and annotations into a resource, removing those set before that are no longer configured
+// Other labels and annotations are kept
+func setCustomMetadata(obj metav1.Object, opt *Options) {
+	previous := customMetadataKeys{}
+	if recorded, exists := obj.GetAnnotations()[customMetadataAnnotation]; exists {
+		_ = json.Unmarshal([]byte(recorded), &previous)
+	}
+	labels := mergeCustomMetadata(obj.GetLabels(), previous.Labels, opt.ProxyLabels)
+	annotations := mergeCustomMetadata(obj.GetAnnotations(), previous.Annotations, opt.ProxyAnnotations)
+
+	keys := customMetadataKeys{Labels: sortedKeys(toSet(opt.ProxyLabels)), Annotations: sortedKeys(toSet(opt.ProxyAnnotations))}
+	if len(keys.Labels) == 0 && len(keys.Annotations) == 0 {
+		delete(annotations, customMetadataAnnotation)
+	} else if data, err := json.Marshal(keys); err == nil {
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[customMetadataAnnotation] = string(data)
+	}
+	obj.SetLabels(labels)
+	obj.SetAnnotations(annotations)
+}
+
+func mergeCustomMetadata(current map[string]string, previous []string, custom map[string]string) map[string]string {
+	for _, key := range previous {
+		if _, exists := custom[key]; !exists {
+			delete(current, key)
+		}
+	}
+	if len(custom) > 0 && current == nil {
+		current = make(map[string]string)
+	}
+	for key, value := range custom {
+		current[key] = value
+	}
+	return current
+}
+
+func toSet(values map[string]string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for key := range values {
+		set[key] = true
+	}
+	return set
+}
+
+// Set the custom labels and annotations on the Proxy Deployment and its pods
+func setDeploymentCustomMetadata(dep *appsv1.Deployment, opt *Options) {
+	setCustomMetadata(dep, opt)
+	setCustomMetadata(&dep.Spec.Template.ObjectMeta, opt)
+}
+
+// Check the custom labels and annotations of the Options
+func checkCustomMetadata(opt *Options) error {
+	for key := range opt.ProxyLabels {
+		if err := checkCustomMetadataKey(key); err != nil {
+			return fmt.Errorf("invalid Proxy label %s: %s", key, err.Error())
+		}
+	}
+	for key := range opt.ProxyAnnotations {
+		if err := checkCustomMetadataKey(key); err != nil {
+			return fmt.Errorf("invalid Proxy annotation %s: %s", key, err.Error())
+		}
+	}
+	return nil
+}
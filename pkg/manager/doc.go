@@ -0,0 +1,31 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+// Package manager exposes the public ports of ioFog Microservices through Proxy Deployments and Services
+//
+// Each Manager reconciles a single Proxy against the public ports reported by the Controller:
+//
+//	mgr, err := manager.New(
+//		manager.WithNamespace("iofog"),
+//		manager.WithCredentials(email, password),
+//		manager.WithProxyImage(image),
+//		manager.WithRouterAddresses(manager.RouterAddress{Host: "router", Port: 5671}),
+//		manager.WithConfig(cfg),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	return mgr.Run(ctx)
+//
+// Operators embedding the Manager in their own controller manager call SetupWithManager instead of Run
+package manager
@@ -0,0 +1,135 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	alertReasonExternalPath = "ExternalPathFailing"
+
+	statusErrorExternalPath = "external"
+
+	defaultExternalCheckSample = 3
+)
+
+// Result of the last synthetic check, boxed for atomic.Value
+type externalCheckResult struct {
+	err error
+}
+
+// Periodically connects to a sample of the exposed ports through the registered external address
+// to validate the whole path of the LoadBalancer, Service, Proxy and router
+type externalChecker struct {
+	mgr        *Manager
+	httpClient *probeHTTPClient
+	// Position of the next sample in the sorted ports, so every port is eventually checked
+	offset int
+}
+
+func (mgr *Manager) newExternalChecker() *externalChecker {
+	return &externalChecker{
+		mgr:        mgr,
+		httpClient: &probeHTTPClient{mgr: mgr},
+	}
+}
+
+func (checker *externalChecker) run(ctx context.Context) {
+	mgr := checker.mgr
+	ticker := time.NewTicker(mgr.opt.ExternalCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			proxyExternalPathUp.DeleteLabelValues(mgr.opt.ProxyName)
+			return
+		case <-ticker.C:
+		}
+		err := checker.check(ctx)
+		if ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			mgr.getLog(ctx).Error(err, "External path check failed")
+			proxyExternalPathUp.WithLabelValues(mgr.opt.ProxyName).Set(0)
+		} else {
+			proxyExternalPathUp.WithLabelValues(mgr.opt.ProxyName).Set(1)
+		}
+		mgr.externalCheck.Store(externalCheckResult{err: err})
+		mgr.status.setError(statusErrorExternalPath, err)
+		mgr.observeAlert(ctx, mgr.externalPathAlert, err)
+	}
+}
+
+// Check a sample of the cached ports through the registered address
+// The path is only considered broken if none of the sampled ports is reachable, so a single failing Microservice does not fail it
+func (checker *externalChecker) check(ctx context.Context) error {
+	mgr := checker.mgr
+	snapshot := mgr.loadSnapshot()
+	if snapshot == nil || snapshot.address == "" || len(snapshot.ports) == 0 {
+		return nil
+	}
+	// Ports of the Services of other types are not reachable through the registered address
+	ports := make([]int, 0, len(snapshot.ports))
+	for port := range snapshot.ports {
+		if isProxyServicePort(mgr.opt, port) {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 0 {
+		return nil
+	}
+	httpClient, err := checker.httpClient.get(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Ints(ports)
+	sample := mgr.opt.ExternalCheckSample
+	if sample <= 0 {
+		sample = defaultExternalCheckSample
+	}
+	if sample > len(ports) {
+		sample = len(ports)
+	}
+
+	var msgs []string
+	for idx := 0; idx < sample; idx++ {
+		port := snapshot.ports[ports[(checker.offset+idx)%len(ports)]]
+		// Public ports are exposed on the host, a port of the address only applies to the LB fronting the Proxy
+		addr := net.JoinHostPort(getAddressHost(snapshot.address), strconv.Itoa(port.Port))
+		err := probePort(ctx, httpClient, mgr.opt.PortProbeTimeout, addr, port.Protocol, isTLSTerminated(mgr.opt, port.Protocol))
+		if err == nil {
+			checker.offset = (checker.offset + sample) % len(ports)
+			return nil
+		}
+		msgs = append(msgs, fmt.Sprintf("port %d: %s", port.Port, err.Error()))
+	}
+	checker.offset = (checker.offset + sample) % len(ports)
+	return errors.New("no sampled port is reachable through " + snapshot.address + ": " + strings.Join(msgs, ", "))
+}
+
+// ExternalCheckError returns the error of the last synthetic check through the external address
+// It is nil while the check passes, before the first check and when checks are disabled
+func (mgr *Manager) ExternalCheckError() error {
+	result, _ := mgr.externalCheck.Load().(externalCheckResult)
+	return result.err
+}
@@ -0,0 +1,57 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestExternalCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	openPort, closedPort := getServerPort(t, listener.Addr()), getServerPort(t, closed.Addr())
+	closed.Close()
+
+	mgr, _ := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.PortProbeTimeout = time.Second
+	mgr.addressQueue.setRegistered("127.0.0.1")
+	mgr.cache[closedPort] = ioclient.PublicPort{Port: closedPort, Protocol: "tcp", Queue: "abc-closed"}
+	mgr.saveSnapshot()
+	checker := mgr.newExternalChecker()
+	if err := checker.check(context.Background()); err == nil {
+		t.Error("Expected external path to be broken without a reachable port")
+	}
+
+	// A single reachable sampled port is enough
+	mgr.cache[openPort] = ioclient.PublicPort{Port: openPort, Protocol: "tcp", Queue: "abc-open"}
+	mgr.saveSnapshot()
+	if err := checker.check(context.Background()); err != nil {
+		t.Errorf("Expected external path to pass, got %v", err)
+	}
+	if err := mgr.ExternalCheckError(); err != nil {
+		t.Errorf("Expected no error before the checker stores a result, got %v", err)
+	}
+}
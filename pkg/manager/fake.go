@@ -0,0 +1,129 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"sync"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+)
+
+// FakeControllerClient is an in-memory ControllerClient for tests
+// Use the controller-runtime fake client and record.FakeRecorder for the Kubernetes clients
+type FakeControllerClient struct {
+	mu            sync.Mutex
+	ports         []ioclient.MicroservicePublicPort
+	microservices map[string]ioclient.MicroserviceInfo
+	defaultProxy  string
+	hosts         map[string]string
+	err           error
+}
+
+func NewFakeControllerClient(ports ...ioclient.MicroservicePublicPort) *FakeControllerClient {
+	return &FakeControllerClient{ports: ports}
+}
+
+// SetPorts replaces the public ports returned by the Controller
+func (clt *FakeControllerClient) SetPorts(ports ...ioclient.MicroservicePublicPort) {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	clt.ports = ports
+}
+
+// SetMicroservices replaces the microservices looked up by UUID
+func (clt *FakeControllerClient) SetMicroservices(msvcs ...ioclient.MicroserviceInfo) {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	clt.microservices = make(map[string]ioclient.MicroserviceInfo)
+	for _, msvc := range msvcs {
+		clt.microservices[msvc.UUID] = msvc
+	}
+}
+
+// SetError makes all subsequent calls fail with err, or succeed if nil
+func (clt *FakeControllerClient) SetError(err error) {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	clt.err = err
+}
+
+// GetDefaultProxy returns the address last registered through PutDefaultProxy
+func (clt *FakeControllerClient) GetDefaultProxy() string {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	return clt.defaultProxy
+}
+
+func (clt *FakeControllerClient) GetAllMicroservicePublicPorts() ([]ioclient.MicroservicePublicPort, error) {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	if clt.err != nil {
+		return nil, clt.err
+	}
+	return append([]ioclient.MicroservicePublicPort{}, clt.ports...), nil
+}
+
+func (clt *FakeControllerClient) GetMicroserviceByID(uuid string) (*ioclient.MicroserviceInfo, error) {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	if clt.err != nil {
+		return nil, clt.err
+	}
+	msvc, exists := clt.microservices[uuid]
+	if !exists {
+		return nil, fmt.Errorf("microservice %s not found", uuid)
+	}
+	return &msvc, nil
+}
+
+func (clt *FakeControllerClient) PutDefaultProxy(address string) error {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	if clt.err != nil {
+		return clt.err
+	}
+	clt.defaultProxy = address
+	return nil
+}
+
+func (clt *FakeControllerClient) PutPublicPortHost(protocol, host string) error {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	if clt.err != nil {
+		return clt.err
+	}
+	if clt.hosts == nil {
+		clt.hosts = make(map[string]string)
+	}
+	clt.hosts[protocol] = host
+	return nil
+}
+
+// GetPublicPortHost returns the address last registered for a protocol through PutPublicPortHost
+func (clt *FakeControllerClient) GetPublicPortHost(protocol string) string {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	return clt.hosts[protocol]
+}
+
+// FakeLoadBalancerWaiter returns a fixed LoadBalancer address for tests
+type FakeLoadBalancerWaiter struct {
+	Address string
+	Err     error
+}
+
+func (waiter *FakeLoadBalancerWaiter) WaitForLoadBalancer(namespace, name string, timeoutSeconds int64) (string, error) {
+	return waiter.Address, waiter.Err
+}
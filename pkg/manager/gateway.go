@@ -0,0 +1,105 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	gatewayKind   = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1beta1", Kind: "Gateway"}
+	httpRouteKind = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1beta1", Kind: "HTTPRoute"}
+	tcpRouteKind  = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1alpha2", Kind: "TCPRoute"}
+	tlsRouteKind  = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1alpha2", Kind: "TLSRoute"}
+)
+
+// Routes each port to the Proxy Service with a Gateway API route named after the Proxy and the port
+// HTTP ports get an HTTPRoute, the TLS the Proxy terminates is passed through with a TLSRoute and other ports get a TCPRoute
+// The routes attach to the port of the IngressGateway Gateway, or to the listener of the port on a Gateway of IngressGatewayClass owned by the Manager
+type gatewayRenderer struct{}
+
+func (gatewayRenderer) kinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{gatewayKind, httpRouteKind, tcpRouteKind, tlsRouteKind}
+}
+
+func getGatewayListenerName(port int) string {
+	return fmt.Sprintf("port-%d", port)
+}
+
+func (gatewayRenderer) render(mgr *Manager, ports portMap) ([]*unstructured.Unstructured, error) {
+	owned := mgr.opt.IngressGatewayClass != ""
+	gateway := mgr.opt.IngressGateway
+	if owned {
+		gateway = mgr.opt.ProxyName
+	} else if gateway == "" {
+		return nil, errors.New("the gateway mode requires a Gateway to attach to or a GatewayClass")
+	}
+	if len(ports) == 0 {
+		return nil, nil
+	}
+	var objs []*unstructured.Unstructured
+	var listeners []interface{}
+	for _, port := range getSortedPorts(ports) {
+		protocol := ports[port].Protocol
+		hostname, hasHostname := mgr.hostnames[port]
+		listener := map[string]interface{}{
+			"name": getGatewayListenerName(port),
+			"port": int64(port),
+		}
+		parentRef := map[string]interface{}{"name": gateway}
+		if owned {
+			parentRef["sectionName"] = getGatewayListenerName(port)
+		} else {
+			parentRef["port"] = int64(port)
+		}
+		spec := map[string]interface{}{
+			"parentRefs": []interface{}{parentRef},
+			"rules": []interface{}{
+				map[string]interface{}{
+					"backendRefs": []interface{}{
+						map[string]interface{}{"name": mgr.opt.ProxyName, "port": int64(port)},
+					},
+				},
+			},
+		}
+		kind := tcpRouteKind
+		listener["protocol"] = "TCP"
+		switch {
+		case isTLSTerminated(mgr.opt, protocol):
+			kind = tlsRouteKind
+			listener["protocol"] = "TLS"
+			listener["tls"] = map[string]interface{}{"mode": "Passthrough"}
+		case isHTTPProtocol(protocol):
+			kind = httpRouteKind
+			listener["protocol"] = "HTTP"
+		}
+		if hasHostname && kind != tcpRouteKind {
+			listener["hostname"] = hostname
+			spec["hostnames"] = []interface{}{hostname}
+		}
+		listeners = append(listeners, listener)
+		objs = append(objs, newRouteObject(kind, fmt.Sprintf("%s-%d", mgr.opt.ProxyName, port), mgr.opt.Namespace, spec))
+	}
+	if owned {
+		objs = append(objs, newRouteObject(gatewayKind, gateway, mgr.opt.Namespace, map[string]interface{}{
+			"gatewayClassName": mgr.opt.IngressGatewayClass,
+			"listeners":        listeners,
+		}))
+	}
+	return objs, nil
+}
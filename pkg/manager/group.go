@@ -0,0 +1,477 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	ctrlmanager "sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// ProxyGroupingApplication runs a Proxy per ioFog Application
+	ProxyGroupingApplication = "application"
+	// ProxyGroupingMicroservice runs a Proxy per microservice exposing public ports
+	ProxyGroupingMicroservice = "microservice"
+
+	// Set on the resources of a grouped Proxy to the Proxy name of the grouping Manager
+	proxyGroupLabel = "iofog.org/proxy-group"
+	// Identity of the group of a grouped Proxy, set on its resources
+	applicationLabel      = "iofog.org/application"
+	microserviceLabel     = "iofog.org/microservice-uuid"
+	microserviceNameLabel = "iofog.org/microservice"
+	portShardLabel        = "iofog.org/port-shard"
+
+	statusErrorGroups = "groups"
+)
+
+// ParseProxyGrouping validates how the public ports are grouped into Proxies, empty runs a single Proxy
+func ParseProxyGrouping(value string) (string, error) {
+	switch grouping := strings.ToLower(value); grouping {
+	case "", ProxyGroupingApplication, ProxyGroupingMicroservice, ProxyGroupingZone:
+		return grouping, nil
+	default:
+		return "", fmt.Errorf("unknown proxy grouping %s, expected %s, %s or %s", value, ProxyGroupingApplication, ProxyGroupingMicroservice, ProxyGroupingZone)
+	}
+}
+
+// Manager of the Proxy of a group and the routine recording its address
+type proxyGroup struct {
+	mgr    *Manager
+	client *groupControllerClient
+	cancel context.CancelFunc
+}
+
+// Controller client of a grouped Proxy, serving the ports of its group from the last poll of the grouping Manager
+type groupControllerClient struct {
+	parent ControllerClient
+	mu     sync.Mutex
+	ports  []ioclient.MicroservicePublicPort
+}
+
+func (clt *groupControllerClient) setPorts(ports []ioclient.MicroservicePublicPort) {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	clt.ports = ports
+}
+
+func (clt *groupControllerClient) GetAllMicroservicePublicPorts() ([]ioclient.MicroservicePublicPort, error) {
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+	return append([]ioclient.MicroservicePublicPort{}, clt.ports...), nil
+}
+
+// Copy of the client looking microservices up with the parent client bound to ctx, the ports stay shared
+// Microservices are only looked up through such a copy, see bindControllerClient
+func (clt *groupControllerClient) withContext(ctx context.Context) ControllerClient {
+	return &boundGroupControllerClient{groupControllerClient: clt, parent: bindControllerClient(ctx, clt.parent)}
+}
+
+type boundGroupControllerClient struct {
+	*groupControllerClient
+	parent ControllerClient
+}
+
+func (clt *boundGroupControllerClient) GetMicroserviceByID(uuid string) (*ioclient.MicroserviceInfo, error) {
+	getter, ok := clt.parent.(microserviceGetter)
+	if !ok {
+		return nil, errMicroserviceGetter
+	}
+	return getter.GetMicroserviceByID(uuid)
+}
+
+// The Controller only knows a single default address, so the address of a grouped Proxy is not registered and is
+// published in the status and port map ConfigMaps of the Proxy instead
+func (clt *groupControllerClient) PutDefaultProxy(address string) error {
+	return nil
+}
+
+// Nor is it registered for its protocol
+func (clt *groupControllerClient) PutPublicPortHost(protocol, host string) error {
+	return nil
+}
+
+// Name of the Proxy of a group, prefixed with the Proxy name of the grouping Manager
+func getGroupProxyName(proxy, group string) string {
+	name := proxy + "-" + group
+	if len(name) > validation.DNS1035LabelMaxLength {
+		name = name[:validation.DNS1035LabelMaxLength]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// Ports of a group and the labels identifying it
+type groupedPorts struct {
+	labels map[string]string
+	ports  []ioclient.MicroservicePublicPort
+}
+
+// Group of the port of a microservice and its labels, empty if it cannot be named
+func getPortGroup(grouping string, msvc *ioclient.MicroserviceInfo) (string, map[string]string) {
+	labels := make(map[string]string)
+	if application := toDNSLabel(msvc.Application); application != "" {
+		labels[applicationLabel] = application
+	}
+	switch grouping {
+	case ProxyGroupingApplication:
+		return labels[applicationLabel], labels
+	case ProxyGroupingMicroservice:
+		uuid := msvc.UUID
+		if len(validation.IsValidLabelValue(uuid)) != 0 {
+			uuid = toDNSLabel(uuid)
+		}
+		if uuid == "" {
+			return "", nil
+		}
+		labels[microserviceLabel] = uuid
+		if name := toDNSLabel(msvc.Name); name != "" {
+			labels[microserviceNameLabel] = name
+		}
+		return toDNSLabel(msvc.UUID), labels
+	}
+	return "", nil
+}
+
+// Labels identifying the group of a grouped Proxy on its existing resources
+func getGroupLabels(labels map[string]string) map[string]string {
+	groupLabels := make(map[string]string)
+	for _, key := range []string{applicationLabel, microserviceLabel, microserviceNameLabel, portShardLabel, proxyZoneLabel} {
+		if value, exists := labels[key]; exists {
+			groupLabels[key] = value
+		}
+	}
+	return groupLabels
+}
+
+// Label the resources of a grouped Proxy so they are found again after a restart, e.g. to delete the Proxy of a removed group
+func setProxyGroup(obj metav1.Object, opt *Options) {
+	if opt.ProxyGroup == "" {
+		return
+	}
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	for key, value := range opt.ProxyGroupLabels {
+		labels[key] = value
+	}
+	labels[proxyGroupLabel] = opt.ProxyGroup
+	obj.SetLabels(labels)
+}
+
+// Register the reconciler of a grouping Manager, triggered explicitly and by public port notifications besides the poll
+func (mgr *Manager) setupGroupsWithManager(c controller.Controller, ctrlMgr ctrl.Manager) error {
+	if err := c.Watch(&source.Channel{Source: mgr.events}, mgr.newEventHandler(nil)); err != nil {
+		return err
+	}
+	if mgr.opt.NotificationAddress == "" {
+		return nil
+	}
+	return ctrlMgr.Add(ctrlmanager.RunnableFunc(func(ctx context.Context) error {
+		mgr.runRoutine(ctx, "notifications", mgr.subscribeNotifications)
+		return nil
+	}))
+}
+
+// Reconcile the Proxy of each group with the ports the Controller exposes for it
+// The Proxies are not watched, their resources are checked on each poll instead
+func (mgr *Manager) reconcileGroups(ctx context.Context) (reconcile.Result, error) {
+	rebuild := atomic.CompareAndSwapInt32(&mgr.rebuildRequested, 1, 0)
+	resync := atomic.CompareAndSwapInt32(&mgr.resyncRequested, 1, 0)
+	if !mgr.cacheGenerated {
+		if err := mgr.findGroups(ctx); err != nil {
+			mgr.getLog(ctx).Error(err, "Failed to find grouped Proxies")
+			mgr.status.setError(statusErrorGroups, err)
+			mgr.observeReconcile(ctx, err)
+			return reconcile.Result{}, err
+		}
+		mgr.cacheGenerated = true
+	}
+
+	err := mgr.runGroups(ctx, rebuild, resync)
+	if ignorePending(err) != nil {
+		mgr.getLog(ctx).Error(err, "Failed to reconcile grouped Proxies")
+	}
+	mgr.observeReconcile(ctx, ignorePending(err))
+	if err != nil {
+		return getErrorResult(err)
+	}
+	return reconcile.Result{RequeueAfter: pkg.pollInterval}, nil
+}
+
+func (mgr *Manager) runGroups(ctx context.Context, rebuild, resync bool) error {
+	allPorts, err := bindControllerClient(ctx, mgr.ioClient).GetAllMicroservicePublicPorts()
+	if err != nil {
+		return err
+	}
+	groupPorts, err := mgr.groupPorts(ctx, allPorts)
+	mgr.status.setError(statusErrorGroups, err)
+	if groupPorts == nil {
+		return err
+	}
+
+	for name, group := range groupPorts {
+		if _, exists := mgr.groups[name]; !exists {
+			if err := mgr.addGroup(ctx, name, group.labels); err != nil {
+				return err
+			}
+		}
+	}
+	names := make([]string, 0, len(mgr.groups))
+	for name := range mgr.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []string
+	// Earliest requeue of the groups with a pending update
+	var pending time.Duration
+	for _, name := range names {
+		group := mgr.groups[name]
+		var ports []ioclient.MicroservicePublicPort
+		if grouped, exists := groupPorts[name]; exists {
+			ports = grouped.ports
+		}
+		group.client.setPorts(ports)
+		if rebuild {
+			atomic.StoreInt32(&group.mgr.rebuildRequested, 1)
+		}
+		if resync || group.mgr.isProxyMissing(ctx) {
+			atomic.StoreInt32(&group.mgr.resyncRequested, 1)
+		}
+		result, err := group.mgr.Reconcile(ctx, reconcile.Request{})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+			continue
+		}
+		if after := result.RequeueAfter; after > 0 && after < pkg.pollInterval && (pending == 0 || after < pending) {
+			pending = after
+		}
+		// The Proxy resources of a removed group are deleted once its last port is removed
+		if len(ports) == 0 && len(group.mgr.cache) == 0 {
+			if err := mgr.removeGroup(ctx, name); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	if err := mgr.deleteUngroupedProxy(ctx); err != nil {
+		return err
+	}
+	if pending > 0 {
+		return &pendingError{reason: "waiting for grouped Proxies", after: pending}
+	}
+	return nil
+}
+
+// Split the ports passing the protocol filter by the Proxy of their group
+// A port whose group cannot be looked up stays with the Proxy already exposing it
+func (mgr *Manager) groupPorts(ctx context.Context, allPorts []ioclient.MicroservicePublicPort) (map[string]*groupedPorts, error) {
+	switch mgr.opt.ProxyGrouping {
+	case ProxyGroupingShard:
+		return mgr.shardPorts(allPorts)
+	case ProxyGroupingZone:
+		return mgr.zonePorts(ctx, allPorts)
+	}
+	mgr.forgetMicroservices(allPorts)
+	groupPorts := make(map[string]*groupedPorts)
+	add := func(name string, labels map[string]string, port ioclient.MicroservicePublicPort) {
+		if _, exists := groupPorts[name]; !exists {
+			groupPorts[name] = &groupedPorts{labels: labels}
+		}
+		groupPorts[name].ports = append(groupPorts[name].ports, port)
+	}
+	var errs []string
+	for _, port := range allPorts {
+		if !matchesProtocolFilter(port.PublicPort.Protocol, mgr.opt.ProtocolFilter) {
+			continue
+		}
+		msvc, err := mgr.getMicroservice(ctx, port.MicroserviceUUID)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("port %d: %s", port.PublicPort.Port, err.Error()))
+			if name := mgr.findPortGroup(port.PublicPort.Port); name != "" {
+				add(name, mgr.groups[name].mgr.opt.ProxyGroupLabels, port)
+			}
+			continue
+		}
+		group, labels := getPortGroup(mgr.opt.ProxyGrouping, msvc)
+		if group == "" {
+			errs = append(errs, fmt.Sprintf("port %d: microservice %s has no %s to name its Proxy after", port.PublicPort.Port, port.MicroserviceUUID, mgr.opt.ProxyGrouping))
+			continue
+		}
+		add(getGroupProxyName(mgr.opt.ProxyName, group), labels, port)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return groupPorts, errors.New(strings.Join(errs, ", "))
+	}
+	return groupPorts, nil
+}
+
+func (mgr *Manager) findPortGroup(port int) string {
+	for name, group := range mgr.groups {
+		if _, exists := group.mgr.cache[port]; exists {
+			return name
+		}
+	}
+	return ""
+}
+
+// Start managing the Proxy of a group with the clients of the grouping Manager
+// The recorded address is set by the address routine of the Proxy, running until the group is removed
+func (mgr *Manager) addGroup(ctx context.Context, name string, labels map[string]string) error {
+	opt := *mgr.opt
+	opt.ProxyName = name
+	opt.ProxyGroup = mgr.opt.ProxyName
+	opt.ProxyGroupLabels = labels
+	opt.ProxyGrouping = ""
+	opt.PortShards = nil
+	opt.ProxyZones = nil
+	setZoneOptions(&opt)
+	// The image was already pinned and its platforms detected by the grouping Manager
+	opt.ProxyImagePinDigest = false
+	opt.ProxyDetectPlatforms = false
+	// Grouped Proxies discover the router themselves so they follow its changes
+	if mgr.routerDiscovery {
+		opt.RouterAddresses = nil
+	}
+	client := &groupControllerClient{parent: mgr.ioClient}
+	opt.ControllerClient = client
+	opt.K8sClient = mgr.k8sClient
+	opt.LoadBalancerWaiter = mgr.waitClient
+	opt.EventRecorder = mgr.recorder
+	groupMgr, err := New(WithOptions(opt))
+	if err != nil {
+		return fmt.Errorf("could not create Manager of grouped Proxy %s: %s", name, err.Error())
+	}
+	groupMgr.imageDigest = mgr.imageDigest
+	groupMgr.auditRecords = mgr.auditRecords
+	// The reconcile context lasts until the controller manager stops
+	groupCtx, cancel := context.WithCancel(ctx)
+	go groupMgr.registerProxyAddress(groupCtx)
+	mgr.groups[name] = &proxyGroup{mgr: groupMgr, client: client, cancel: cancel}
+	mgr.getLog(ctx).Info("Added grouped Proxy", "groupProxy", name)
+	return nil
+}
+
+// Stop managing the Proxy of a removed group once its resources are deleted, dropping its keys of the shared ConfigMaps
+func (mgr *Manager) removeGroup(ctx context.Context, name string) error {
+	if err := mgr.deleteConfigMapKey(ctx, pkg.statusConfigMapName, name+statusKeySuffix); err != nil {
+		return err
+	}
+	if mgr.opt.PortMapConfigMap != "" {
+		if err := mgr.deleteConfigMapKey(ctx, mgr.opt.PortMapConfigMap, name+portMapKeySuffix); err != nil {
+			return err
+		}
+	}
+	mgr.getLog(ctx).Info("Removed grouped Proxy", "groupProxy", name)
+	mgr.groups[name].cancel()
+	delete(mgr.groups, name)
+	return nil
+}
+
+// Manage the grouped Proxies left by a previous run, so the Proxies of groups removed in the meantime are deleted
+func (mgr *Manager) findGroups(ctx context.Context) error {
+	names := make(map[string]map[string]string)
+	labels := k8sclient.MatchingLabels{proxyGroupLabel: mgr.opt.ProxyName}
+	deps := appsv1.DeploymentList{}
+	if err := mgr.k8sClient.List(ctx, &deps, k8sclient.InNamespace(mgr.opt.Namespace), labels); err != nil {
+		return err
+	}
+	for _, dep := range deps.Items {
+		names[dep.Name] = getGroupLabels(dep.Labels)
+	}
+	svcs := corev1.ServiceList{}
+	if err := mgr.k8sClient.List(ctx, &svcs, k8sclient.InNamespace(mgr.opt.Namespace), labels); err != nil {
+		return err
+	}
+	for _, svc := range svcs.Items {
+		if _, exists := names[svc.Name]; !exists {
+			names[svc.Name] = getGroupLabels(svc.Labels)
+		}
+	}
+	for name, groupLabels := range names {
+		if _, exists := mgr.groups[name]; exists {
+			continue
+		}
+		if err := mgr.addGroup(ctx, name, groupLabels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resync a Proxy with ports whose Deployment or Service was deleted, grouped Proxies are not watched
+func (mgr *Manager) isProxyMissing(ctx context.Context) bool {
+	if len(mgr.cache) == 0 {
+		return false
+	}
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(ctx, proxyKey, &appsv1.Deployment{}); k8serrors.IsNotFound(err) {
+		return true
+	}
+	err := mgr.k8sClient.Get(ctx, proxyKey, &corev1.Service{})
+	return k8serrors.IsNotFound(err)
+}
+
+// Delete the Proxy run before grouping was enabled once the grouped Proxies are up to date
+func (mgr *Manager) deleteUngroupedProxy(ctx context.Context) error {
+	if mgr.ungroupedDeleted {
+		return nil
+	}
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(ctx, proxyKey, &appsv1.Deployment{}); err == nil {
+		mgr.getLog(ctx).Info("Deleting ungrouped Proxy Deployment")
+		if err := mgr.deleteProxyDeployment(ctx); err != nil {
+			return err
+		}
+	} else if !k8serrors.IsNotFound(err) {
+		return err
+	}
+	if err := mgr.k8sClient.Get(ctx, proxyKey, &corev1.Service{}); err == nil {
+		mgr.getLog(ctx).Info("Deleting ungrouped Proxy Service")
+		if err := mgr.deleteProxyService(ctx); err != nil {
+			return err
+		}
+	} else if !k8serrors.IsNotFound(err) {
+		return err
+	}
+	mgr.ungroupedDeleted = true
+	return nil
+}
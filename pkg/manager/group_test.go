@@ -0,0 +1,161 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestProxyGrouping(t *testing.T) {
+	if _, err := ParseProxyGrouping("flow"); err == nil {
+		t.Error("Expected unknown grouping to be rejected")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "def", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "def-6000"}},
+	)
+	ioClient.SetMicroservices(
+		ioclient.MicroserviceInfo{UUID: "abc", Name: "web", Application: "Shop"},
+		ioclient.MicroserviceInfo{UUID: "def", Name: "db", Application: "billing"},
+	)
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyGrouping = ProxyGroupingApplication
+
+	// The Services are not created until the Proxy Deployments are ready
+	if err := mgr.runGroups(ctx, false, false); err == nil {
+		t.Fatal("Expected error waiting for grouped Proxy Deployments")
+	}
+	// The addresses of the grouped Proxies are recorded below instead of waiting for their LoadBalancers
+	for _, group := range mgr.groups {
+		group.cancel()
+	}
+	for name, port := range map[string]int{"http-proxy-shop": 5000, "http-proxy-billing": 6000} {
+		key := k8sclient.ObjectKey{Name: name, Namespace: "iofog"}
+		dep := appsv1.Deployment{}
+		if err := k8sClient.Get(ctx, key, &dep); err != nil {
+			t.Fatal(err)
+		}
+		if dep.Labels[proxyGroupLabel] != "http-proxy" {
+			t.Errorf("Expected %s to be labelled with its group, got %v", name, dep.Labels)
+		}
+		if _, exists := mgr.groups[name].mgr.cache[port]; !exists || len(mgr.groups[name].mgr.cache) != 1 {
+			t.Errorf("Expected %s to only expose port %d, got %v", name, port, mgr.groups[name].mgr.cache)
+		}
+		dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+		if err := k8sClient.Update(ctx, &dep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mgr.runGroups(ctx, false, false); err != nil {
+		t.Fatal(err)
+	}
+	svc := corev1.Service{}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: "http-proxy-billing", Namespace: "iofog"}, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 6000 || svc.Labels[proxyGroupLabel] != "http-proxy" {
+		t.Errorf("Unexpected grouped Proxy Service %v", svc)
+	}
+
+	// The address of each grouped Proxy is recorded in its status rather than registered as the default address
+	for name, address := range map[string]string{"http-proxy-shop": "10.0.0.1", "http-proxy-billing": "10.0.0.2"} {
+		if err := mgr.groups[name].mgr.registerAddress(ctx, address); err != nil {
+			t.Fatal(err)
+		}
+		if recorded := mgr.groups[name].mgr.getStatus().Address; recorded != address {
+			t.Errorf("Expected %s to record address %s, got %q", name, address, recorded)
+		}
+	}
+	if address := ioClient.GetDefaultProxy(); address != "" {
+		t.Errorf("Expected grouped Proxy address not to be registered as the default address, got %q", address)
+	}
+
+	// A restarted Manager deletes the Proxy of an application removed in the meantime
+	ioClient.SetPorts(ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}})
+	restarted, err := New(WithOptions(*mgr.opt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.reconcileGroups(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := restarted.groups["http-proxy-billing"]; exists {
+		t.Error("Expected grouped Proxy of removed application to be removed")
+	}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: "http-proxy-billing", Namespace: "iofog"}, &appsv1.Deployment{}); err == nil {
+		t.Error("Expected grouped Proxy Deployment of removed application to be deleted")
+	}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: "http-proxy-shop", Namespace: "iofog"}, &corev1.Service{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMicroserviceGrouping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "jNwlcqBT", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "jNwlcqBT", PublicPort: ioclient.PublicPort{Port: 5001, Protocol: "http", Queue: "abc-5001"}},
+	)
+	ioClient.SetMicroservices(ioclient.MicroserviceInfo{UUID: "jNwlcqBT", Name: "Web UI", Application: "shop"})
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyGrouping = ProxyGroupingMicroservice
+
+	if err := mgr.runGroups(ctx, false, false); err == nil {
+		t.Fatal("Expected error waiting for grouped Proxy Deployment")
+	}
+	mgr.groups["http-proxy-jnwlcqbt"].cancel()
+	key := k8sclient.ObjectKey{Name: "http-proxy-jnwlcqbt", Namespace: "iofog"}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if dep.Labels[microserviceLabel] != "jNwlcqBT" || dep.Labels[microserviceNameLabel] != "web-ui" || dep.Labels[applicationLabel] != "shop" {
+		t.Errorf("Expected Proxy Deployment to be labelled with the microservice, got %v", dep.Labels)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.runGroups(ctx, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the last port of the microservice deletes its Proxy and status
+	ioClient.SetPorts()
+	if err := mgr.runGroups(ctx, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(mgr.groups) != 0 {
+		t.Errorf("Expected grouped Proxy to be removed, got %v", mgr.groups)
+	}
+	if err := k8sClient.Get(ctx, key, &corev1.Service{}); err == nil {
+		t.Error("Expected grouped Proxy Service to be deleted")
+	}
+	cm := corev1.ConfigMap{}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: pkg.statusConfigMapName, Namespace: "iofog"}, &cm); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := cm.Data[key.Name+statusKeySuffix]; exists {
+		t.Error("Expected status of removed Proxy to be deleted")
+	}
+}
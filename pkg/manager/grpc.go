@@ -0,0 +1,147 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"crypto/subtle"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apiv1 "github.com/eclipse-iofog/port-manager/v3/api/v1"
+)
+
+// Serves the port map of the Managers over gRPC
+type portsServer struct {
+	apiv1.UnimplementedPortManagerServer
+	mgrs []*Manager
+}
+
+// RegisterPortsServer registers the read-only PortManager gRPC service of the Managers
+func RegisterPortsServer(server *grpc.Server, mgrs []*Manager) {
+	apiv1.RegisterPortManagerServer(server, &portsServer{mgrs: mgrs})
+}
+
+// GRPCTokenOptions authenticate the calls of a gRPC server by an authorization: Bearer <token> metadata entry
+func GRPCTokenOptions(token string) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkGRPCToken(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkGRPCToken(stream.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+}
+
+func checkGRPCToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if provided, isBearer := getBearerToken(value); isBearer && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing bearer token")
+}
+
+func newPortMap(proxy string, snapshot *cacheSnapshot) *apiv1.PortMap {
+	portMap := &apiv1.PortMap{
+		Proxy:   proxy,
+		Address: snapshot.address,
+	}
+	for _, port := range snapshot.ports {
+		portMap.Ports = append(portMap.Ports, &apiv1.Port{
+			Port:     int32(port.Port),
+			Protocol: port.Protocol,
+			Queue:    port.Queue,
+		})
+	}
+	sort.Slice(portMap.Ports, func(i, j int) bool { return portMap.Ports[i].Port < portMap.Ports[j].Port })
+	return portMap
+}
+
+func (server *portsServer) selectManagers(proxy string) ([]*Manager, error) {
+	if proxy == "" {
+		return server.mgrs, nil
+	}
+	for _, mgr := range server.mgrs {
+		if mgr.Name() == proxy {
+			return []*Manager{mgr}, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "proxy %s not found", proxy)
+}
+
+func (server *portsServer) ListPorts(ctx context.Context, req *apiv1.ListPortsRequest) (*apiv1.ListPortsResponse, error) {
+	mgrs, err := server.selectManagers(req.GetProxy())
+	if err != nil {
+		return nil, err
+	}
+	resp := &apiv1.ListPortsResponse{}
+	for _, mgr := range mgrs {
+		resp.Proxies = append(resp.Proxies, newPortMap(mgr.Name(), mgr.loadSnapshot()))
+	}
+	return resp, nil
+}
+
+func (server *portsServer) WatchPorts(req *apiv1.WatchPortsRequest, stream apiv1.PortManager_WatchPortsServer) error {
+	mgrs, err := server.selectManagers(req.GetProxy())
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	// Serialize the updates of all Managers onto the stream
+	updates := make(chan *apiv1.PortMap)
+	for _, mgr := range mgrs {
+		go mgr.watchSnapshot(ctx, updates)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case update := <-updates:
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Send the current port map and then every change until the context is cancelled
+func (mgr *Manager) watchSnapshot(ctx context.Context, updates chan<- *apiv1.PortMap) {
+	snapshot := mgr.loadSnapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case updates <- newPortMap(mgr.Name(), snapshot):
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-snapshot.changed:
+			snapshot = mgr.loadSnapshot()
+		}
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apiv1 "github.com/eclipse-iofog/port-manager/v3/api/v1"
+)
+
+func TestWatchSnapshot(t *testing.T) {
+	mgr := &Manager{opt: &Options{ProxyName: "http-proxy"}, addressQueue: newAddressQueue(), cache: make(portMap)}
+	mgr.saveSnapshot()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan *apiv1.PortMap)
+	go mgr.watchSnapshot(ctx, updates)
+
+	receive := func() *apiv1.PortMap {
+		select {
+		case update := <-updates:
+			return update
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for port map")
+			return nil
+		}
+	}
+	if update := receive(); len(update.Ports) != 0 {
+		t.Errorf("Expected empty port map, got %v", update.Ports)
+	}
+
+	// Unchanged cache must not produce an update
+	mgr.saveSnapshot()
+	mgr.cache[80] = ioclient.PublicPort{Port: 80, Protocol: "http", Queue: "queue"}
+	mgr.saveSnapshot()
+	if update := receive(); len(update.Ports) != 1 || update.Ports[0].Port != 80 {
+		t.Errorf("Expected port 80, got %v", update.Ports)
+	}
+}
+
+func TestGRPCToken(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		valid  bool
+	}{
+		{header: "Bearer secret", valid: true},
+		{header: "bearer secret", valid: true},
+		{header: "secret"},
+		{header: "Bearer other"},
+		{},
+	} {
+		ctx := context.Background()
+		if test.header != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", test.header))
+		}
+		err := checkGRPCToken(ctx, "secret")
+		if test.valid && err != nil {
+			t.Errorf("Expected %q to be accepted, got %s", test.header, err.Error())
+		}
+		if !test.valid && status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Expected %q to be rejected as unauthenticated, got %v", test.header, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	statusErrorHostname = "hostname"
+
+	externalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+)
+
+// Values available to the hostname template, names are reduced to DNS labels
+type hostnameTemplateValues struct {
+	Microservice string
+	Application  string
+	Port         int
+	Queue        string
+	Proxy        string
+	Namespace    string
+}
+
+// ParseHostnameTemplate parses the template of the hostnames of HTTP ports, e.g. {{.Microservice}}.{{.Application}}.edge.example.com
+func ParseHostnameTemplate(tmpl string) (*template.Template, error) {
+	parsed, err := template.New("hostname").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	// Catch unknown fields before any port is exposed
+	sample := hostnameTemplateValues{Microservice: "msvc", Application: "app", Port: 80, Queue: "queue", Proxy: "proxy", Namespace: "default"}
+	if _, err := renderHostname(parsed, sample); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+func renderHostname(tmpl *template.Template, values hostnameTemplateValues) (string, error) {
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return "", err
+	}
+	hostname := strings.ToLower(strings.TrimSpace(buf.String()))
+	if errs := validation.IsDNS1123Subdomain(hostname); len(errs) > 0 {
+		return "", fmt.Errorf("invalid hostname %q: %s", hostname, strings.Join(errs, ", "))
+	}
+	return hostname, nil
+}
+
+// Render the hostname of each cached HTTP port, returning whether any hostname changed
+// Ports whose microservice cannot be looked up keep their previous hostname
+func (mgr *Manager) updateHostnames(ctx context.Context) bool {
+	if mgr.hostnameTemplate == nil {
+		return false
+	}
+	hostnames := make(map[int]string)
+	var errs []string
+	for port, publicPort := range mgr.cache {
+		uuid := mgr.portOwners[port]
+		if !isHTTPProtocol(publicPort.Protocol) || uuid == "" {
+			continue
+		}
+		msvc, err := mgr.getMicroservice(ctx, uuid)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("port %d: %s", port, err.Error()))
+			if hostname, exists := mgr.hostnames[port]; exists {
+				hostnames[port] = hostname
+			}
+			continue
+		}
+		hostname, err := renderHostname(mgr.hostnameTemplate, hostnameTemplateValues{
+			Microservice: toDNSLabel(msvc.Name),
+			Application:  toDNSLabel(msvc.Application),
+			Port:         port,
+			Queue:        toDNSLabel(publicPort.Queue),
+			Proxy:        mgr.opt.ProxyName,
+			Namespace:    mgr.opt.Namespace,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("port %d: %s", port, err.Error()))
+			continue
+		}
+		hostnames[port] = hostname
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		mgr.status.setError(statusErrorHostname, fmt.Errorf("%s", strings.Join(errs, ", ")))
+	} else {
+		mgr.status.setError(statusErrorHostname, nil)
+	}
+
+	changed := len(hostnames) != len(mgr.hostnames)
+	for port, hostname := range hostnames {
+		if mgr.hostnames[port] != hostname {
+			changed = true
+		}
+	}
+	mgr.hostnames = hostnames
+	return changed
+}
+
+// Publish the hostnames on the Proxy Service for external-dns
+func (mgr *Manager) setServiceHostnames(svc *corev1.Service) {
+	if mgr.hostnameTemplate == nil {
+		return
+	}
+	hostnames := make([]string, 0, len(mgr.hostnames))
+	for _, hostname := range mgr.hostnames {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	if len(hostnames) == 0 {
+		delete(svc.Annotations, externalDNSHostnameAnnotation)
+		return
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	svc.Annotations[externalDNSHostnameAnnotation] = strings.Join(hostnames, ",")
+}
@@ -0,0 +1,73 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestHostnames(t *testing.T) {
+	ctx := context.Background()
+	if _, err := ParseHostnameTemplate("{{.Microservice}}.{{.Unknown}}.example.com"); err == nil {
+		t.Error("Expected unknown template field to be rejected")
+	}
+	ioClient := NewFakeControllerClient()
+	ioClient.SetMicroservices(ioclient.MicroserviceInfo{UUID: "abc", Name: "My_Dashboard", Application: "edge-app"})
+	mgr, _ := newFakeManager(t, ioClient)
+	tmpl, err := ParseHostnameTemplate("{{.Microservice}}.{{.Application}}.edge.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr.hostnameTemplate = tmpl
+	mgr.cache[5000] = ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}
+	mgr.cache[6000] = ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}
+	mgr.portOwners[5000], mgr.portOwners[6000] = "abc", "abc"
+
+	if !mgr.updateHostnames(ctx) {
+		t.Fatal("Expected hostnames to change")
+	}
+	svc := &corev1.Service{}
+	mgr.setServiceHostnames(svc)
+	if hostname := svc.Annotations[externalDNSHostnameAnnotation]; hostname != "my-dashboard.edge-app.edge.example.com" {
+		t.Errorf("Unexpected hostname annotation %q", hostname)
+	}
+
+	// A failed lookup keeps the previous hostname, without looking up cached microservices
+	ioClient.SetError(errors.New("unavailable"))
+	if mgr.updateHostnames(ctx) {
+		t.Error("Expected hostnames of cached microservices to be unchanged")
+	}
+	mgr.microservices = make(map[string]*ioclient.MicroserviceInfo)
+	if mgr.updateHostnames(ctx) || mgr.hostnames[5000] == "" {
+		t.Error("Expected previous hostname to be kept when the lookup fails")
+	}
+	if _, failing := mgr.status.get()[statusErrorHostname]; !failing {
+		t.Error("Expected hostname status error")
+	}
+
+	delete(mgr.cache, 5000)
+	if !mgr.updateHostnames(ctx) {
+		t.Error("Expected hostname of removed port to be dropped")
+	}
+	mgr.setServiceHostnames(svc)
+	if _, exists := svc.Annotations[externalDNSHostnameAnnotation]; exists {
+		t.Error("Expected hostname annotation to be removed")
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	dockerHubRegistry     = "docker.io"
+	dockerHubEndpoint     = "registry-1.docker.io"
+	runningDigestAnnotate = "iofog.org/proxy-running-digest"
+	reasonImageMismatch   = "ProxyImageMismatch"
+)
+
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+type imageReference struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// Split an image reference into registry, repository, tag and digest, applying Docker Hub defaults
+func parseImageReference(image string) (ref imageReference, err error) {
+	remainder := image
+	if idx := strings.Index(remainder, "@"); idx != -1 {
+		ref.digest = remainder[idx+1:]
+		remainder = remainder[:idx]
+		if !strings.HasPrefix(ref.digest, "sha256:") {
+			return ref, fmt.Errorf("unsupported digest in image %s", image)
+		}
+	}
+	// A registry is only present if the first component looks like a host
+	ref.registry = dockerHubRegistry
+	if idx := strings.Index(remainder, "/"); idx != -1 {
+		host := remainder[:idx]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.registry = host
+			remainder = remainder[idx+1:]
+		}
+	}
+	// Tag follows the last colon after the last slash
+	if idx := strings.LastIndex(remainder, ":"); idx > strings.LastIndex(remainder, "/") {
+		ref.tag = remainder[idx+1:]
+		remainder = remainder[:idx]
+	}
+	if ref.tag == "" && ref.digest == "" {
+		ref.tag = "latest"
+	}
+	if remainder == "" {
+		return ref, fmt.Errorf("invalid image %s", image)
+	}
+	if ref.registry == dockerHubRegistry && !strings.Contains(remainder, "/") {
+		remainder = "library/" + remainder
+	}
+	ref.repository = remainder
+	return ref, nil
+}
+
+// Name of the image pinned to its digest
+func (ref imageReference) pinned() string {
+	return fmt.Sprintf("%s/%s@%s", ref.registry, ref.repository, ref.digest)
+}
+
+func (ref imageReference) endpoint() string {
+	if ref.registry == dockerHubRegistry {
+		return dockerHubEndpoint
+	}
+	return ref.registry
+}
+
+// Resolve the digest of an image tag with the registry manifest API
+// Only anonymous pulls are supported
+func resolveImageDigest(ctx context.Context, image string) (imageReference, error) {
+	ref, err := parseImageReference(image)
+	if err != nil || ref.digest != "" {
+		return ref, err
+	}
+
+	resp, err := registryRequest(ctx, http.MethodHead, ref.manifestURL(ref.tag))
+	if err != nil {
+		return ref, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ref, fmt.Errorf("failed to resolve digest of image %s: registry returned %s", image, resp.Status)
+	}
+	ref.digest = resp.Header.Get("Docker-Content-Digest")
+	if !strings.HasPrefix(ref.digest, "sha256:") {
+		return ref, fmt.Errorf("registry did not return a digest for image %s", image)
+	}
+	return ref, nil
+}
+
+func (ref imageReference) manifestURL(reference string) string {
+	return fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.endpoint(), ref.repository, reference)
+}
+
+func (ref imageReference) blobURL(digest string) string {
+	return fmt.Sprintf("https://%s/v2/%s/blobs/%s", ref.endpoint(), ref.repository, digest)
+}
+
+// Reference used to fetch the manifest, the digest takes precedence over the tag
+func (ref imageReference) reference() string {
+	if ref.digest != "" {
+		return ref.digest
+	}
+	return ref.tag
+}
+
+// Perform a registry API request, authenticating anonymously if the registry requires it
+// The caller must close the response body
+func registryRequest(ctx context.Context, method, requestURL string) (*http.Response, error) {
+	resp, err := doRegistryRequest(ctx, method, requestURL, "")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	token, err := getRegistryToken(ctx, resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return nil, err
+	}
+	return doRegistryRequest(ctx, method, requestURL, token)
+}
+
+func doRegistryRequest(ctx context.Context, method, requestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ","))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// Request an anonymous pull token from the realm advertised by the registry
+func getRegistryToken(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry authentication challenge %q", challenge)
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid registry token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get registry token: %s", resp.Status)
+	}
+	body := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// Pin the configured Proxy image to the digest its tag currently resolves to
+func (mgr *Manager) pinProxyImage() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pkg.registryTimeout)
+	defer cancel()
+	ref, err := resolveImageDigest(ctx, mgr.opt.ProxyImage)
+	if err != nil {
+		return err
+	}
+	mgr.imageDigest = ref.digest
+	if !strings.Contains(mgr.opt.ProxyImage, "@") {
+		pinned := ref.pinned()
+		mgr.getLog(ctx).Info("Pinned Proxy image to digest", "image", mgr.opt.ProxyImage, "pinned", pinned)
+		mgr.opt.ProxyImage = pinned
+	}
+	return nil
+}
+
+// Verify the Proxy pods run the pinned digest and record the running digest on the Deployment
+func (mgr *Manager) checkProxyImage(ctx context.Context) error {
+	if mgr.imageDigest == "" {
+		return nil
+	}
+	pods := corev1.PodList{}
+	opts := []k8sclient.ListOption{
+		k8sclient.InNamespace(mgr.opt.Namespace),
+		k8sclient.MatchingLabels{"name": mgr.opt.ProxyName},
+	}
+	if err := mgr.k8sClient.List(ctx, &pods, opts...); err != nil {
+		return err
+	}
+
+	running := ""
+	for idx := range pods.Items {
+		pod := &pods.Items[idx]
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != proxyContainerName || !strings.Contains(status.ImageID, "@") {
+				continue
+			}
+			digest := status.ImageID[strings.LastIndex(status.ImageID, "@")+1:]
+			if digest == mgr.imageDigest {
+				running = digest
+				continue
+			}
+			if !mgr.mismatchReported[pod.UID] {
+				mgr.recorder.Eventf(pod, corev1.EventTypeWarning, reasonImageMismatch, "Proxy runs image digest %s instead of pinned digest %s", digest, mgr.imageDigest)
+				mgr.mismatchReported[pod.UID] = true
+			}
+		}
+	}
+	if running == "" {
+		return nil
+	}
+	if mgr.runningDigest != "" && mgr.runningDigest != running {
+		proxyImageInfo.DeleteLabelValues(mgr.opt.ProxyName, mgr.runningDigest)
+	}
+	mgr.runningDigest = running
+	proxyImageInfo.WithLabelValues(mgr.opt.ProxyName, running).Set(1)
+	return mgr.annotateProxyDeployment(ctx, runningDigestAnnotate, running)
+}
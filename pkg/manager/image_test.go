@@ -0,0 +1,46 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	cases := map[string]imageReference{
+		"proxy":                               {registry: "docker.io", repository: "library/proxy", tag: "latest"},
+		"iofog/proxy:3.0.0":                   {registry: "docker.io", repository: "iofog/proxy", tag: "3.0.0"},
+		"gcr.io/project/proxy:1":              {registry: "gcr.io", repository: "project/proxy", tag: "1"},
+		"localhost:5000/proxy":                {registry: "localhost:5000", repository: "proxy", tag: "latest"},
+		"quay.io/skupper/proxy:1.0@" + digest: {registry: "quay.io", repository: "skupper/proxy", tag: "1.0", digest: digest},
+		"quay.io/skupper/proxy@" + digest:     {registry: "quay.io", repository: "skupper/proxy", digest: digest},
+	}
+	for image, expected := range cases {
+		ref, err := parseImageReference(image)
+		if err != nil {
+			t.Errorf("Failed to parse image %s: %s", image, err.Error())
+			continue
+		}
+		if ref != expected {
+			t.Errorf("Image %s: expected %+v, got %+v", image, expected, ref)
+		}
+	}
+
+	ref, _ := parseImageReference("iofog/proxy:3.0.0")
+	ref.digest = digest
+	if ref.pinned() != "docker.io/iofog/proxy@"+digest {
+		t.Errorf("Unexpected pinned image %s", ref.pinned())
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const defaultIstioGateway = "ingressgateway"
+
+var (
+	istioGatewayKind        = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "Gateway"}
+	istioVirtualServiceKind = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "VirtualService"}
+)
+
+// Binds the ports to the Istio ingress gateway with a Gateway and routes them to the Proxy Service with a VirtualService
+// The Proxy is still required to bridge the ports to the router, the mesh only carries the traffic up to it
+type istioRenderer struct{}
+
+func (istioRenderer) kinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{istioGatewayKind, istioVirtualServiceKind}
+}
+
+// Gateway server protocol of a port, TLS terminated by the Proxy is passed through as TCP
+func getIstioProtocol(opt *Options, protocol string) string {
+	if isTLSTerminated(opt, protocol) {
+		return "TCP"
+	}
+	switch protocol {
+	case "http", "ws":
+		return "HTTP"
+	case "http2":
+		return "HTTP2"
+	case "grpc":
+		return "GRPC"
+	}
+	return "TCP"
+}
+
+func (istioRenderer) render(mgr *Manager, ports portMap) ([]*unstructured.Unstructured, error) {
+	if len(ports) == 0 {
+		return nil, nil
+	}
+	gateway := mgr.opt.IngressGateway
+	if gateway == "" {
+		gateway = defaultIstioGateway
+	}
+	var servers, httpRoutes, tcpRoutes []interface{}
+	for _, port := range getSortedPorts(ports) {
+		protocol := getIstioProtocol(mgr.opt, ports[port].Protocol)
+		host := "*"
+		if hostname, exists := mgr.hostnames[port]; exists {
+			host = hostname
+		}
+		servers = append(servers, map[string]interface{}{
+			"port": map[string]interface{}{
+				"number":   int64(port),
+				"name":     fmt.Sprintf("%s-%d", strings.ToLower(protocol), port),
+				"protocol": protocol,
+			},
+			"hosts": []interface{}{host},
+		})
+		route := map[string]interface{}{
+			"match": []interface{}{
+				map[string]interface{}{"port": int64(port)},
+			},
+			"route": []interface{}{
+				map[string]interface{}{
+					"destination": map[string]interface{}{
+						"host": mgr.opt.ProxyName,
+						"port": map[string]interface{}{"number": int64(port)},
+					},
+				},
+			},
+		}
+		if protocol == "TCP" {
+			tcpRoutes = append(tcpRoutes, route)
+		} else {
+			httpRoutes = append(httpRoutes, route)
+		}
+	}
+
+	virtualService := map[string]interface{}{
+		"hosts":    []interface{}{"*"},
+		"gateways": []interface{}{mgr.opt.ProxyName},
+	}
+	if len(httpRoutes) > 0 {
+		virtualService["http"] = httpRoutes
+	}
+	if len(tcpRoutes) > 0 {
+		virtualService["tcp"] = tcpRoutes
+	}
+	return []*unstructured.Unstructured{
+		newRouteObject(istioGatewayKind, mgr.opt.ProxyName, mgr.opt.Namespace, map[string]interface{}{
+			"selector": map[string]interface{}{"istio": gateway},
+			"servers":  servers,
+		}),
+		newRouteObject(istioVirtualServiceKind, mgr.opt.ProxyName, mgr.opt.Namespace, virtualService),
+	}, nil
+}
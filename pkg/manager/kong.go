@@ -0,0 +1,90 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const defaultKongIngressClass = "kong"
+
+var (
+	kongIngressKind    = schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	kongTCPIngressKind = schema.GroupVersionKind{Group: "configuration.konghq.com", Version: "v1beta1", Kind: "TCPIngress"}
+)
+
+// Routes each port to the Proxy Service through the Kong Ingress Controller with resources named after the Proxy and the port
+// http and ws ports with a hostname get an Ingress so Kong plugins apply to their requests
+// Other ports get a TCPIngress on their port, which must be a stream listener of Kong
+type kongRenderer struct{}
+
+func (kongRenderer) kinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{kongIngressKind, kongTCPIngressKind}
+}
+
+func (kongRenderer) render(mgr *Manager, ports portMap) ([]*unstructured.Unstructured, error) {
+	ingressClass := mgr.opt.IngressGateway
+	if ingressClass == "" {
+		ingressClass = defaultKongIngressClass
+	}
+	objs := make([]*unstructured.Unstructured, 0, len(ports))
+	for _, port := range getSortedPorts(ports) {
+		protocol := ports[port].Protocol
+		name := fmt.Sprintf("%s-%d", mgr.opt.ProxyName, port)
+		hostname, hasHostname := mgr.hostnames[port]
+		if hasHostname && (protocol == "http" || protocol == "ws") && !isTLSTerminated(mgr.opt, protocol) {
+			objs = append(objs, newRouteObject(kongIngressKind, name, mgr.opt.Namespace, map[string]interface{}{
+				"ingressClassName": ingressClass,
+				"rules": []interface{}{
+					map[string]interface{}{
+						"host": hostname,
+						"http": map[string]interface{}{
+							"paths": []interface{}{
+								map[string]interface{}{
+									"path":     "/",
+									"pathType": "Prefix",
+									"backend": map[string]interface{}{
+										"service": map[string]interface{}{
+											"name": mgr.opt.ProxyName,
+											"port": map[string]interface{}{"number": int64(port)},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}))
+			continue
+		}
+		tcpIngress := newRouteObject(kongTCPIngressKind, name, mgr.opt.Namespace, map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{
+					"port": int64(port),
+					"backend": map[string]interface{}{
+						"serviceName": mgr.opt.ProxyName,
+						"servicePort": int64(port),
+					},
+				},
+			},
+		})
+		// TCPIngress predates ingressClassName
+		tcpIngress.SetAnnotations(map[string]string{"kubernetes.io/ingress.class": ingressClass})
+		objs = append(objs, tcpIngress)
+	}
+	return objs, nil
+}
@@ -0,0 +1,152 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Cloud provider load balancers the Proxy Service can be configured for, see ParseLoadBalancerPreset
+const (
+	LoadBalancerPresetAWSNLB         = "aws-nlb"
+	LoadBalancerPresetAWSNLBInternal = "aws-nlb-internal"
+	LoadBalancerPresetGCPInternal    = "gcp-internal"
+	LoadBalancerPresetAzureInternal  = "azure-internal"
+	LoadBalancerPresetOCI            = "oci"
+	LoadBalancerPresetDigitalOcean   = "do"
+)
+
+const (
+	awsEIPAllocationsAnnotation = "service.beta.kubernetes.io/aws-load-balancer-eip-allocations"
+	awsEIPAllocationPrefix      = "eipalloc-"
+)
+
+// Service annotations and traffic policy of a cloud provider load balancer
+type loadBalancerPreset struct {
+	annotations   map[string]string
+	trafficPolicy corev1.ServiceExternalTrafficPolicyType
+	// Annotation requesting the static IP, which is otherwise requested with spec.loadBalancerIP
+	staticIPAnnotation string
+}
+
+var loadBalancerPresets = map[string]loadBalancerPreset{
+	LoadBalancerPresetAWSNLB: {
+		annotations: map[string]string{
+			"service.beta.kubernetes.io/aws-load-balancer-type":                              "nlb",
+			"service.beta.kubernetes.io/aws-load-balancer-cross-zone-load-balancing-enabled": "true",
+		},
+		trafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+	},
+	LoadBalancerPresetAWSNLBInternal: {
+		annotations: map[string]string{
+			"service.beta.kubernetes.io/aws-load-balancer-type":                              "nlb",
+			"service.beta.kubernetes.io/aws-load-balancer-internal":                          "true",
+			"service.beta.kubernetes.io/aws-load-balancer-cross-zone-load-balancing-enabled": "true",
+		},
+		trafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+	},
+	LoadBalancerPresetGCPInternal: {
+		annotations: map[string]string{
+			"networking.gke.io/load-balancer-type": "Internal",
+		},
+		trafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+	},
+	LoadBalancerPresetAzureInternal: {
+		annotations: map[string]string{
+			"service.beta.kubernetes.io/azure-load-balancer-internal": "true",
+		},
+		trafficPolicy:      corev1.ServiceExternalTrafficPolicyTypeLocal,
+		staticIPAnnotation: "service.beta.kubernetes.io/azure-load-balancer-ipv4",
+	},
+	// Network load balancer, which preserves client IPs unlike the default flexible load balancer
+	LoadBalancerPresetOCI: {
+		annotations: map[string]string{
+			"oci.oraclecloud.com/load-balancer-type": "nlb",
+		},
+		trafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+	},
+	// Nodes without a Proxy pod fail the health check with the Local policy, so traffic is spread by the cluster instead
+	LoadBalancerPresetDigitalOcean: {
+		annotations: map[string]string{
+			"service.beta.kubernetes.io/do-loadbalancer-healthcheck-protocol": "tcp",
+		},
+		trafficPolicy: corev1.ServiceExternalTrafficPolicyTypeCluster,
+	},
+}
+
+// ParseLoadBalancerPreset checks the cloud provider load balancer preset of the Proxy Service, empty keeps the Service as is
+func ParseLoadBalancerPreset(preset string) (string, error) {
+	if _, exists := loadBalancerPresets[preset]; preset != "" && !exists {
+		presets := make([]string, 0, len(loadBalancerPresets))
+		for preset := range loadBalancerPresets {
+			presets = append(presets, preset)
+		}
+		sort.Strings(presets)
+		return "", fmt.Errorf("unsupported load balancer preset %s, expected one of %v", preset, presets)
+	}
+	return preset, nil
+}
+
+// Apply the preset to a LoadBalancer Service, keeping annotations set by other tools
+func setLoadBalancerPreset(svc *corev1.Service, opt *Options) {
+	preset, exists := loadBalancerPresets[opt.LoadBalancerPreset]
+	if !exists || svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	for key, value := range preset.annotations {
+		svc.Annotations[key] = value
+	}
+	svc.Spec.ExternalTrafficPolicy = preset.trafficPolicy
+}
+
+// ParseLoadBalancerIP checks the pre-reserved address of a LoadBalancer Proxy Service,
+// an IP or comma-separated AWS Elastic IP allocations, one per subnet of the NLB, e.g. eipalloc-0a1b,eipalloc-2c3d
+func ParseLoadBalancerIP(value string) (string, error) {
+	if value == "" || net.ParseIP(value) != nil {
+		return value, nil
+	}
+	for _, allocation := range strings.Split(value, ",") {
+		if !strings.HasPrefix(allocation, awsEIPAllocationPrefix) || len(allocation) == len(awsEIPAllocationPrefix) {
+			return "", fmt.Errorf("expected an IP or Elastic IP allocations, got %s", value)
+		}
+	}
+	return value, nil
+}
+
+// Request the static IP for a LoadBalancer Service, with the annotation of the preset if it has one
+func setLoadBalancerIP(svc *corev1.Service, opt *Options) {
+	if opt.LoadBalancerIP == "" || svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return
+	}
+	annotation := loadBalancerPresets[opt.LoadBalancerPreset].staticIPAnnotation
+	if strings.HasPrefix(opt.LoadBalancerIP, awsEIPAllocationPrefix) {
+		annotation = awsEIPAllocationsAnnotation
+	}
+	if annotation == "" {
+		svc.Spec.LoadBalancerIP = opt.LoadBalancerIP
+		return
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	svc.Annotations[annotation] = opt.LoadBalancerIP
+}
@@ -0,0 +1,1250 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	waitclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/k8s"
+
+	portsv1alpha1 "github.com/eclipse-iofog/port-manager/v3/api/portmanager/v1alpha1"
+	"github.com/eclipse-iofog/port-manager/v3/internal/fakecontroller"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+type Manager struct {
+	opt          *Options
+	cache        portMap
+	k8sClient    k8sclient.Client
+	waitClient   LoadBalancerWaiter
+	ioClient     ControllerClient
+	log          logr.Logger
+	owner        metav1.OwnerReference
+	addressQueue *addressQueue
+	recorder     record.EventRecorder
+	// Reads the Proxy resources from the informer cache once the Manager is set up with a controller manager
+	reader k8sclient.Reader
+	// Set when the cache has been reconciled but the K8s resources failed to update or the update is pending
+	outOfSync bool
+	// When the Proxy was first found not ready and whether that was reported, see checkProxyReady
+	notReadySince    time.Time
+	notReadyReported bool
+	// End of the drain period of removed ports, see drainRemovedPorts
+	drainUntil time.Time
+	// Digest the Proxy image is pinned to, if pinning is enabled
+	imageDigest      string
+	mismatchReported map[types.UID]bool
+	// Digest last exported by the image info metric
+	runningDigest string
+	// Microservice exposing each cached port, as last reported by the Controller
+	portOwners map[int]string
+	// Hostname of each cached HTTP port and the microservices they were rendered from, see HostnameTemplate
+	hostnameTemplate *template.Template
+	hostnames        map[int]string
+	microservices    map[string]*ioclient.MicroserviceInfo
+	// Time each cached microservice was looked up, see getMicroservice
+	microserviceLookups map[string]time.Time
+	// Microservice of each cached port, see MicroserviceMetadata
+	portMetadata map[int]portMetadata
+	// Ports requested by the Controller but not exposed, by port
+	rejectedPorts map[int]portRejection
+	// Ports their microservice opted out of, see PortOptOut
+	optedOutPorts map[int]bool
+	// External address of each port overriding the Proxy address, see getPortAddresses
+	portAddresses map[int]string
+	// Consecutive failures reported to the alert webhook
+	reconcileAlert    *alertState
+	registerAlert     *alertState
+	loadBalancerAlert *alertState
+	externalPathAlert *alertState
+	proxyConfigAlert  *alertState
+	rollbackAlert     *alertState
+	// Hash of the rollout that was rolled back, see checkProxyRollout
+	failedRollout string
+	// Current errors and warnings and the last status written to the status ConfigMap
+	status          *statusErrors
+	warnings        *statusErrors
+	publishedStatus string
+	// Causes of lost client IPs last reported, see checkClientIP
+	clientIPWarning string
+	// Triggers a reconcile when signalled
+	events chan event.GenericEvent
+	// Audit records waiting for the webhook, shared with the grouped Proxies, see sendAuditRecords
+	auditRecords chan auditRecord
+	// Set to re-apply the Proxy resources from the cache on the next reconcile
+	resyncRequested int32
+	// Set once the cache has been generated from the Proxy Deployment
+	cacheGenerated bool
+	// Set to regenerate the cache on the next reconcile
+	rebuildRequested int32
+	// Latest *cacheSnapshot for the admin and gRPC APIs
+	snapshot atomic.Value
+	// Latest externalCheckResult of the synthetic check through the external address
+	externalCheck atomic.Value
+	// Latest clusterResult of the controller manager of a remote cluster
+	clusterError atomic.Value
+	// time.Time the last reconcile finished at, or the Manager was created at
+	lastReconciled atomic.Value
+	// Snapshot last written to the port map ConfigMap
+	exportedSnapshot *cacheSnapshot
+	// Snapshot and status last mirrored to the PublicPort resources
+	syncedSnapshot *cacheSnapshot
+	syncedStatus   portsv1alpha1.PublicPortStatus
+	// Hash of the ingress controller resources last applied
+	syncedRoutes string
+	// Set when the router address is discovered from RouterService
+	routerDiscovery bool
+	// Name of the discovered router Service and the endpoints of a headless one
+	routerServiceName atomic.Value
+	routerEndpoints   string
+	// Router addresses for the routines and APIs running outside of Reconcile, which alone updates opt.RouterAddresses
+	routerAddresses atomic.Value
+	// Proxies of each group by Proxy name, see ProxyGrouping
+	groups           map[string]*proxyGroup
+	ungroupedDeleted bool
+}
+
+type Options struct {
+	Namespace            string
+	UserEmail            string
+	UserPass             string
+	ProxyImage           string
+	ProxyName            string
+	ProxyServiceType     string
+	ProxyIPFamilies      []string
+	ProtocolFilter       string
+	ProxyExternalAddress string
+	RouterAddresses      []RouterAddress
+	// Name or label selector of the Service the router address is discovered from when RouterAddresses is empty
+	RouterService        string
+	RouterScheme         string
+	RouterTLSSecret      string
+	RouterTLSInsecure    bool
+	ProxyReadyTimeout    time.Duration
+	PortDrainPeriod      time.Duration
+	ProxyStrategy        *appsv1.DeploymentStrategy
+	ProxyAutoRollback    bool
+	ProxyProgressTimeout time.Duration
+	ProxyImagePinDigest  bool
+	ProxyNodeOS          []string
+	ProxyNodeArch        []string
+	ProxyDetectPlatforms bool
+	// ConfigMap holding a template of additional containers for the Proxy pods
+	ProxySidecarConfigMap string
+	ProxyWaitForRouter    bool
+	// Encoding of the Proxy config and its queue names, see ParseProxyConfigEncoding
+	ProxyConfigEncoding string
+	// Size in bytes above which the Proxy config is mounted from the router ConfigMap instead of passed as an argument, 0 never spills
+	ProxyConfigSpillSize int
+	// Command and argument template of Proxy images with another entrypoint, see ParseProxyArgs
+	// Empty args run node /opt/app-root/bin/simple.js with the config
+	ProxyCommand []string
+	ProxyArgs    []string
+	// Labels and annotations merged into the Proxy Deployment, its pods and Services, see ParseLabels and ParseAnnotations
+	ProxyLabels      map[string]string
+	ProxyAnnotations map[string]string
+	// Kind of the Proxy workload, see ParseProxyWorkload, the nodes its pods run on and whether they bind the ports on the nodes
+	ProxyWorkload     string
+	ProxyNodeSelector map[string]string
+	ProxyHostPorts    bool
+	// Port and path of the per-port stats served by each Proxy pod, e.g. by a sidecar exporter, 0 disables scraping
+	ProxyStatsPort     int
+	ProxyStatsPath     string
+	ProxyStatsInterval time.Duration
+	// Replicas the Proxy is created with and scaled between to serve ProxyTargetConns connections per replica
+	// Scaling requires the Proxy stats, the replicas are only reduced after ProxyScaleDownDelay
+	ProxyMinReplicas    int
+	ProxyMaxReplicas    int
+	ProxyTargetConns    int
+	ProxyScaleDownDelay time.Duration
+	// Interval the exposed ports are probed through the Proxy Service at, 0 disables probing
+	PortProbeInterval time.Duration
+	PortProbeTimeout  time.Duration
+	// Interval a sample of ExternalCheckSample ports is checked through the registered external address at, 0 disables the check
+	ExternalCheckInterval time.Duration
+	ExternalCheckSample   int
+	// Time without a finished reconcile after which the Manager is considered stalled, see ReconcileStallError, 0 disables the watchdog
+	ReconcileStallTimeout time.Duration
+	// Audit sinks for port exposure changes
+	AuditConfigMap  string
+	AuditMaxEntries int
+	AuditWebhookURL string
+	// Alert webhook for persistent failures
+	AlertWebhookURL       string
+	AlertFailurePeriod    time.Duration
+	AlertRegisterFailures int
+	// ConfigMap the reconciled port map is exported to and restored from when the Proxy Deployment is missing, disabled if empty
+	PortMapConfigMap string
+	// Mirror each exposed port to a PublicPort resource, requires the CRD to be installed
+	PublicPortResources bool
+	// Where other Services are checked for port collisions, see ParsePortConflictScope
+	PortConflictScope string
+	// Ports that are never exposed
+	ReservedPorts PortRanges
+	// Allow exposing ports below 1024
+	AllowPrivilegedPorts bool
+	// Limits passed to the Proxy per port, see ParsePortSettings
+	PortSettings PortSettingsRules
+	// Features of the Proxy image beyond the stock Proxy, see ParseProxyCapabilities
+	ProxyCapabilities []string
+	// Template of the hostnames of HTTP ports published for external-dns, see ParseHostnameTemplate
+	HostnameTemplate string
+	// Label and annotate the Proxy Service, port map and PublicPort resources with the microservice and application of their ports
+	MicroserviceMetadata bool
+	// Skip the public ports a microservice lists in its IOFOG_PORT_MANAGER_EXCLUDE environment variable
+	PortOptOut bool
+	// kubernetes.io/tls Secret of a wildcard certificate covering the hostnames, the Proxy terminates TLS on all HTTP ports with it
+	ProxyTLSSecret string
+	// HTTPS port of the Proxy that HTTP requests to port 80 are redirected to with a 301, requires ProxyTLSSecret, 0 disables the redirect
+	ProxyTLSRedirectPort int
+	// Ingress controller the ports are routed to the Proxy Service through, see ParseIngressMode
+	// IngressGateway selects the gateway of the ingress controller, e.g. the istio label of the Istio ingress gateway pods or the Traefik entry point of hostnames, the Kong ingress class or the Gateway API Gateway
+	IngressMode    string
+	IngressGateway string
+	// Added to the ingress controller resources, e.g. to apply Kong plugins, see ParseAnnotations
+	IngressAnnotations map[string]string
+	// GatewayClass of a Gateway owned by the Manager with a listener per port, instead of attaching the routes to IngressGateway
+	IngressGatewayClass string
+	// Group/version of the ServiceExport of the Proxy Service reaching it from the peer clusters of a ClusterSet, see ParseServiceExportAPI
+	ServiceExportAPI string
+	// Annotations and traffic policy of a cloud provider load balancer applied to a LoadBalancer Proxy Service, see ParseLoadBalancerPreset
+	LoadBalancerPreset string
+	// Pre-reserved address of a LoadBalancer Proxy Service, see ParseLoadBalancerIP
+	LoadBalancerIP string
+	// Warn when the traffic policy or PROXY protocol settings of the Proxy Service lose the client IPs
+	ClientIPCheck bool
+	// Run a Proxy named <ProxyName>-<group> per group of ports instead of a single Proxy, see ParseProxyGrouping
+	ProxyGrouping string
+	// Proxy name of the grouping Manager of a grouped Proxy and the identity of its group, set on its resources
+	ProxyGroup       string
+	ProxyGroupLabels map[string]string
+	// Run a Proxy per shard of ports, see ParsePortShards
+	PortShards []PortShard
+	// Availability zones a Proxy is run in with zone proxy grouping, empty runs one in each zone of the topology labels of the nodes
+	ProxyZones []string
+	// Shared by the Managers of this process to detect duplicate ports
+	PortRegistry *PortRegistry
+	// Running outside the cluster, e.g. against a dev cluster through a kubeconfig
+	// The manager Deployment may not exist, in which case resources are created without an owner
+	OutOfCluster bool
+	// Deployment of the Manager owning the resources, and whether they reference it or are labelled with its name, see ParseOwnerMode
+	OwnerName string
+	OwnerMode string
+	// Overrides the in-cluster Controller API URL
+	ControllerURL string
+	// Timeout of each Controller request and deadline of each call including its retries, 0 disables the deadline
+	ControllerTimeout     time.Duration
+	ControllerCallTimeout time.Duration
+	// HTTP(S) proxy the Controller API is reached through, e.g. http://proxy.example.com:3128, and the comma-separated hosts,
+	// domains and CIDRs reached directly; HTTPS_PROXY, HTTP_PROXY and NO_PROXY apply to what is not set
+	ControllerProxy   string
+	ControllerNoProxy string
+	// Register the address of a Proxy filtering a protocol as the <protocol>-public-port-host of the Controller instead of its default-proxy-host
+	// so the Controller knows the address of each Proxy in dual-proxy mode
+	ProtocolRegistration bool
+	// Router address the Controller publishes public port changes to, e.g. a multicast address, empty only polls
+	NotificationAddress string
+	// Query the public ports with If-None-Match and If-Modified-Since so an unchanged list is not downloaded each poll
+	ControllerConditional bool
+	// Fixture of public ports served instead of the Controller API, see the fakecontroller package
+	SimulationFixture string
+	// Clients created from Config when nil, see fake.go for test implementations
+	K8sClient          k8sclient.Client
+	ControllerClient   ControllerClient
+	LoadBalancerWaiter LoadBalancerWaiter
+	EventRecorder      record.EventRecorder
+	Config             *rest.Config
+}
+
+// New creates the Manager of a single Proxy from the DefaultOptions and opts
+// It logs into the Controller and checks the existing Proxy resources, reconciling starts with Run
+func New(opts ...Option) (*Manager, error) {
+	opt := DefaultOptions()
+	for _, apply := range opts {
+		apply(&opt)
+	}
+	password, err := decodeBase64(opt.UserPass)
+	if err == nil {
+		opt.UserPass = password
+	}
+	mgr := &Manager{
+		cache:               make(portMap),
+		log:                 logf.Log.WithName(opt.ProxyName).WithValues("namespace", opt.Namespace, "proxy", opt.ProxyName),
+		opt:                 &opt,
+		addressQueue:        newAddressQueue(),
+		events:              make(chan event.GenericEvent, 1),
+		mismatchReported:    make(map[types.UID]bool),
+		portOwners:          make(map[int]string),
+		hostnames:           make(map[int]string),
+		microservices:       make(map[string]*ioclient.MicroserviceInfo),
+		microserviceLookups: make(map[string]time.Time),
+		portMetadata:        make(map[int]portMetadata),
+		portAddresses:       make(map[int]string),
+		groups:              make(map[string]*proxyGroup),
+		status:              newStatusErrors(),
+		warnings:            newStatusErrors(),
+	}
+	// Empty until the cache is generated, so API watchers always have a snapshot to wait on
+	mgr.snapshot.Store(&cacheSnapshot{changed: make(chan struct{})})
+	if opt.AuditWebhookURL != "" {
+		mgr.auditRecords = make(chan auditRecord, auditQueueSize)
+	}
+	mgr.reconcileAlert = newAlertState(alertReasonReconcile, 1, opt.AlertFailurePeriod)
+	mgr.registerAlert = newAlertState(alertReasonRegistration, opt.AlertRegisterFailures, 0)
+	mgr.loadBalancerAlert = newAlertState(alertReasonLoadBalancer, 1, 0)
+	mgr.externalPathAlert = newAlertState(alertReasonExternalPath, 1, opt.AlertFailurePeriod)
+	mgr.proxyConfigAlert = newAlertState(alertReasonProxyConfig, 1, 0)
+	mgr.rollbackAlert = newAlertState(alertReasonRollback, 1, 0)
+	mgr.lastReconciled.Store(time.Now())
+	mgr.opt.ProtocolFilter = strings.ToUpper(mgr.opt.ProtocolFilter)
+	// The type is compared with the type of the existing Service, which is recreated when they differ
+	if mgr.opt.ProxyServiceType, err = ParseServiceType(mgr.opt.ProxyServiceType); err != nil {
+		return nil, fmt.Errorf("invalid Proxy Service type: %s", err.Error())
+	}
+	if len(mgr.opt.PortShards) > 0 {
+		if mgr.opt.ProxyGrouping != "" && mgr.opt.ProxyGrouping != ProxyGroupingShard {
+			return nil, fmt.Errorf("port shards cannot be combined with %s proxy grouping", mgr.opt.ProxyGrouping)
+		}
+		mgr.opt.ProxyGrouping = ProxyGroupingShard
+	}
+	if len(mgr.opt.ProxyZones) > 0 && mgr.opt.ProxyGrouping != ProxyGroupingZone {
+		return nil, fmt.Errorf("proxy zones require %s proxy grouping", ProxyGroupingZone)
+	}
+	if mgr.opt.ProxyZones, err = ParseProxyZones(mgr.opt.ProxyZones); err != nil {
+		return nil, err
+	}
+	// The node ports of the Proxy Services of all zones would collide
+	if mgr.opt.ProxyGrouping == ProxyGroupingZone && mgr.opt.ProxyServiceType == string(corev1.ServiceTypeNodePort) {
+		return nil, fmt.Errorf("%s proxy grouping requires a %s or %s Proxy Service", ProxyGroupingZone, corev1.ServiceTypeLoadBalancer, corev1.ServiceTypeClusterIP)
+	}
+	if len(mgr.opt.RouterAddresses) == 0 {
+		if mgr.opt.RouterService == "" {
+			return nil, errors.New("at least one router address or the router Service is required")
+		}
+		if err := validateRouterService(mgr.opt.RouterService); err != nil {
+			return nil, err
+		}
+		mgr.routerDiscovery = true
+	}
+	mgr.routerAddresses.Store(append([]RouterAddress{}, mgr.opt.RouterAddresses...))
+	if mgr.opt.ProxyExternalAddress, err = ParseExternalAddress(mgr.opt.ProxyExternalAddress); err != nil {
+		return nil, fmt.Errorf("invalid external address: %s", err.Error())
+	}
+	if mgr.opt.HostnameTemplate != "" {
+		if mgr.hostnameTemplate, err = ParseHostnameTemplate(mgr.opt.HostnameTemplate); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := ParseProxyArgs(mgr.opt.ProxyArgs); err != nil {
+		return nil, fmt.Errorf("invalid Proxy args template: %s", err.Error())
+	}
+	if err := checkCustomMetadata(mgr.opt); err != nil {
+		return nil, err
+	}
+	if mgr.opt.ProxyCapabilities, err = ParseProxyCapabilities(mgr.opt.ProxyCapabilities); err != nil {
+		return nil, err
+	}
+	if err := checkProxyCapabilities(mgr.opt); err != nil {
+		return nil, err
+	}
+	if err := checkTLSRedirect(mgr.opt); err != nil {
+		return nil, err
+	}
+	if mgr.opt.OwnerMode, err = ParseOwnerMode(mgr.opt.OwnerMode); err != nil {
+		return nil, err
+	}
+	if mgr.opt.ProxyWorkload, err = ParseProxyWorkload(mgr.opt.ProxyWorkload); err != nil {
+		return nil, err
+	}
+	// Grouped Proxies are found by their Deployments
+	if mgr.opt.ProxyWorkload != ProxyWorkloadDeployment && mgr.opt.ProxyGrouping != "" {
+		return nil, fmt.Errorf("a %s Proxy cannot be combined with %s proxy grouping", mgr.opt.ProxyWorkload, mgr.opt.ProxyGrouping)
+	}
+	// A DaemonSet runs a pod per node instead of replicas and the replicas of a StatefulSet are fixed
+	if isDaemonSet(mgr.opt) && (mgr.opt.ProxyMinReplicas > 1 || mgr.opt.ProxyMaxReplicas > 1) {
+		return nil, fmt.Errorf("the replicas of a %s Proxy cannot be set", ProxyWorkloadDaemonSet)
+	}
+	if isStatefulSet(mgr.opt) && mgr.opt.ProxyMaxReplicas > getMinReplicas(mgr.opt) {
+		return nil, fmt.Errorf("a %s Proxy cannot be autoscaled", ProxyWorkloadStatefulSet)
+	}
+	if err := validateOwnerName(mgr.opt.OwnerName); err != nil {
+		return nil, fmt.Errorf("invalid owner name %s: %s", mgr.opt.OwnerName, err.Error())
+	}
+	if mgr.opt.ProxyReadyTimeout == 0 {
+		mgr.opt.ProxyReadyTimeout = pkg.proxyReadyTimeout
+	}
+	if err := validateControllerProxy(mgr.opt.ControllerProxy); err != nil {
+		return nil, fmt.Errorf("invalid Controller proxy %s: %s", redactProxyURL(mgr.opt.ControllerProxy), err.Error())
+	}
+	// Only the requests of the clients created by the Manager are sent through its Controller proxy
+	if getControllerProxy(mgr.opt) != nil && mgr.opt.ControllerClient != nil {
+		return nil, errors.New("a Controller proxy cannot be applied to the requests of the given Controller client")
+	}
+	if mgr.opt.ControllerTimeout == 0 {
+		mgr.opt.ControllerTimeout = controllerRequestTimeout
+	}
+	if mgr.opt.ControllerCallTimeout > 0 && mgr.opt.ControllerCallTimeout < mgr.opt.ControllerTimeout {
+		return nil, fmt.Errorf("the Controller call timeout %s must not be below the Controller request timeout %s", mgr.opt.ControllerCallTimeout, mgr.opt.ControllerTimeout)
+	}
+	// A reconcile waiting for the Proxy to become ready is not stalled
+	if mgr.opt.ReconcileStallTimeout > 0 && mgr.opt.ReconcileStallTimeout <= mgr.opt.ProxyReadyTimeout {
+		return nil, fmt.Errorf("the reconcile stall timeout %s must exceed the Proxy ready timeout %s", mgr.opt.ReconcileStallTimeout, mgr.opt.ProxyReadyTimeout)
+	}
+	err = mgr.init()
+
+	return mgr, err
+}
+
+// Query the K8s API Server for details of this pod's deployment
+// Store details for later use when assigning owners to other K8s resources we make
+// Owner reference is required for automatic cleanup of K8s resources made by this runtime
+func (mgr *Manager) getOwnerReference() error {
+	objKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.OwnerName,
+		Namespace: mgr.opt.Namespace,
+	}
+	dep := appsv1.Deployment{}
+	if err := mgr.k8sClient.Get(context.TODO(), objKey, &dep); err != nil {
+		return err
+	}
+	mgr.owner = metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       dep.Name,
+		UID:        dep.UID,
+	}
+	return nil
+}
+
+func (mgr *Manager) init() (err error) {
+	// Instantiate Kubernetes clients unless provided
+	mgr.k8sClient = mgr.opt.K8sClient
+	mgr.waitClient = mgr.opt.LoadBalancerWaiter
+	mgr.recorder = mgr.opt.EventRecorder
+	if mgr.k8sClient == nil {
+		scheme, err := newScheme()
+		if err != nil {
+			return err
+		}
+		if mgr.k8sClient, err = k8sclient.New(withTracing(mgr.opt.Config), k8sclient.Options{Scheme: scheme}); err != nil {
+			return err
+		}
+	}
+	if mgr.waitClient == nil || mgr.recorder == nil {
+		clientset, err := kubernetes.NewForConfig(mgr.opt.Config)
+		if err != nil {
+			return err
+		}
+		if mgr.waitClient == nil {
+			mgr.waitClient = &waitclient.Client{Clientset: clientset}
+		}
+		if mgr.recorder == nil {
+			mgr.recorder = mgr.newEventRecorder(clientset)
+		}
+	}
+	mgr.log.Info("Created Kubernetes clients")
+
+	// The router may not be up yet, discovery is retried on each reconcile
+	if mgr.routerDiscovery {
+		if _, err := mgr.discoverRouter(context.TODO()); err != nil {
+			mgr.log.Error(err, "Failed to discover router address", "service", mgr.opt.RouterService)
+		}
+	}
+
+	// Pin the Proxy image so tag changes in the registry do not change the running Proxy
+	if mgr.opt.ProxyImagePinDigest {
+		if err = mgr.pinProxyImage(); err != nil {
+			return
+		}
+	}
+
+	if mgr.opt.ProxyDetectPlatforms {
+		mgr.detectProxyPlatforms()
+	}
+
+	// Get owner reference, the resources are labelled with the owner name instead in the labels owner mode
+	if mgr.opt.OwnerMode == OwnerModeLabels {
+		mgr.log.Info("Labelling resources instead of setting owner references", "owner", mgr.opt.OwnerName)
+	} else if err = mgr.getOwnerReference(); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return
+		}
+		if !mgr.opt.OutOfCluster {
+			err = fmt.Errorf("owner Deployment %s not found, set the owner name or the %s owner mode: %s", mgr.opt.OwnerName, OwnerModeLabels, err.Error())
+			return
+		}
+		// Nothing owns the resources when running from outside the cluster
+		mgr.log.Info("Manager Deployment not found, creating resources without owner reference", "deployment", mgr.opt.OwnerName)
+		err = nil
+	} else {
+		mgr.log.Info("Got owner reference from Kubernetes API Server")
+	}
+
+	// Set up ioFog client unless provided
+	if mgr.ioClient = mgr.opt.ControllerClient; mgr.ioClient == nil {
+		if mgr.ioClient, err = mgr.newControllerClient(); err != nil {
+			return
+		}
+	}
+	// Falling back to the default address would overwrite the address of the other protocols
+	if _, ok := mgr.ioClient.(publicPortHostRegistrar); mgr.opt.ProtocolRegistration && !ok {
+		err = errProtocolRegistration
+		return
+	}
+
+	// Check if Proxy Service exists
+	svc := corev1.Service{}
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	// Check if service exists
+	if err := mgr.k8sClient.Get(context.TODO(), proxyKey, &svc); err != nil {
+		// Not found, no problem
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	// Service exists, register Service IP
+	mgr.addressQueue.add(mgr.opt.ProxyExternalAddress)
+	return nil
+}
+
+// URL of the Controller API, the in-cluster Controller Service unless set
+func getControllerURL(opt *Options) string {
+	if opt.ControllerURL != "" {
+		return opt.ControllerURL
+	}
+	return fmt.Sprintf("http://%s.%s:%d/api/v3", pkg.controllerServiceName, opt.Namespace, pkg.controllerPort)
+}
+
+// Log into the Controller API, or serve the public ports of the simulation fixture instead
+func (mgr *Manager) newControllerClient() (ControllerClient, error) {
+	if mgr.opt.SimulationFixture != "" {
+		ctrl, err := fakecontroller.New(mgr.opt.SimulationFixture)
+		if err != nil {
+			return nil, err
+		}
+		mgr.log.Info("Simulating Controller API", "fixture", mgr.opt.SimulationFixture)
+		return ctrl, nil
+	}
+
+	ioclient.SetGlobalRetries(ioclient.Retries{
+		CustomMessage: map[string]int{
+			"timeout":    10,
+			"refuse":     10,
+			"credential": 10,
+		},
+	})
+	baseURLStr := getControllerURL(mgr.opt)
+	baseURL, err := url.Parse(baseURLStr)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Controller URL %s: %s", baseURLStr, err.Error())
+	}
+	opt := ioclient.Options{BaseURL: baseURL, Timeout: getSDKTimeout(mgr.opt.ControllerTimeout)}
+	if getControllerProxy(mgr.opt) != nil {
+		// The SDK cannot be routed through the proxy, all requests are sent by the HTTP client
+		// Managers are created outside of any reconcile, the login is only bounded by its deadline
+		client := newControllerHTTPClient(ioclient.New(opt), mgr.opt)
+		if err := client.login(mgr.opt.UserEmail, mgr.opt.UserPass); err != nil {
+			return nil, err
+		}
+		mgr.log.Info("Logged into Controller API through proxy", "httpProxy", redactProxyURL(mgr.opt.ControllerProxy), "noProxy", mgr.opt.ControllerNoProxy)
+		return client, nil
+	}
+	var client *ioclient.Client
+	// Managers are created outside of any reconcile, the login is only bounded by its deadline
+	err = callController(context.Background(), nil, controllerOpLogin, mgr.opt.ControllerCallTimeout, func(context.Context) (err error) {
+		client, err = ioclient.NewAndLogin(opt, mgr.opt.UserEmail, mgr.opt.UserPass)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	mgr.log.Info("Logged into Controller API")
+	return newControllerHTTPClient(client, mgr.opt), nil
+}
+
+// Report the result of a reconcile to the APIs, the alert webhook and the status and port map ConfigMaps
+func (mgr *Manager) observeReconcile(ctx context.Context, reconcileErr error) {
+	mgr.markReconciled()
+	mgr.saveSnapshot()
+	mgr.observeAlert(ctx, mgr.reconcileAlert, reconcileErr)
+	mgr.status.setError(statusErrorReconcile, reconcileErr)
+	routesErr := mgr.syncRoutes(ctx)
+	if routesErr != nil {
+		mgr.getLog(ctx).Error(routesErr, "Failed to sync ingress routes", "mode", mgr.opt.IngressMode)
+	}
+	mgr.status.setError(statusErrorRoutes, routesErr)
+	if err := mgr.publishStatus(ctx); err != nil {
+		mgr.getLog(ctx).Error(err, "Failed to publish status")
+	}
+	if err := mgr.exportPortMap(ctx); err != nil {
+		mgr.getLog(ctx).Error(err, "Failed to export port map", "configmap", mgr.opt.PortMapConfigMap)
+	}
+	if err := mgr.syncPublicPorts(ctx, reconcileErr); err != nil {
+		mgr.getLog(ctx).Error(err, "Failed to sync PublicPort resources")
+	}
+}
+
+// Re-apply the Proxy resources from the cache
+func (mgr *Manager) resync(ctx context.Context) error {
+	if len(mgr.cache) == 0 && !mgr.outOfSync {
+		return nil
+	}
+	err := mgr.updateProxy(ctx)
+	mgr.outOfSync = err != nil
+	return err
+}
+
+func (mgr *Manager) generateCache(ctx context.Context) error {
+	mgr.getLog(ctx).Info("Generating cache based on Kubernetes API")
+	// Clear the cache
+	mgr.cache = make(portMap)
+
+	// Get deployment, or the DaemonSet viewed as one
+	foundDep, _, err := mgr.getProxyDeployment(ctx)
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+		// Deployment not found, restore the ports last exported so they are re-opened rather than dropped
+		ports, err := mgr.restorePortMap(ctx)
+		if err != nil {
+			return err
+		}
+		mgr.cache = ports
+		if len(mgr.cache) == 0 {
+			mgr.getLog(ctx).Info("Initialized with empty cache")
+		} else {
+			mgr.getLog(ctx).Info("Restored cache from port map", "configmap", mgr.opt.PortMapConfigMap, "cache", mgr.cache)
+		}
+		return mgr.checkProxyService(ctx)
+	}
+
+	// Deployment exists, get the config and whether it was rolled back from a failed rollout
+	mgr.failedRollout = foundDep.Annotations[failedRolloutAnnotation]
+	config, spilled, err := getProxyConfig(foundDep)
+	if err != nil {
+		return err
+	}
+	if spilled {
+		if config, err = mgr.getSpilledProxyConfig(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Get microservices from config, in the encoding the Deployment was created with
+	// Configs of earlier releases are migrated and the Deployment converted in place on the next run
+	version, err := getProxyConfigVersion(foundDep)
+	if err == nil {
+		var migrated bool
+		if config, migrated, err = migrateProxyConfig(config, version); migrated {
+			mgr.getLog(ctx).Info("Migrated Proxy config", "version", version, "config", config)
+			mgr.outOfSync = true
+		}
+	}
+	if err != nil {
+		// The ports are re-added from the Controller
+		mgr.getLog(ctx).Error(err, "Skipping Proxy config")
+		mgr.outOfSync = true
+		return mgr.checkProxyService(ctx)
+	}
+
+	// Invalid items are skipped, their ports are re-added if the Controller still exposes them
+	ports, errs := decodeProxyConfig(config, getProxyConfigEncoding(foundDep))
+	for _, err := range errs {
+		mgr.getLog(ctx).Error(err, "Skipping invalid Proxy config item")
+	}
+	for _, port := range ports {
+		err := validatePublicPort(port, mgr.opt.AllowPrivilegedPorts)
+		if err == nil {
+			err = validateQueue(port, getProxyConfigEncoding(foundDep))
+		}
+		if err != nil {
+			mgr.getLog(ctx).Error(err, "Skipping invalid Proxy config item", "port", port.Port)
+			continue
+		}
+		// Update cache
+		mgr.cache[port.Port] = port
+	}
+
+	mgr.getLog(ctx).Info("Generated cache", "cache", mgr.cache)
+	return mgr.checkProxyService(ctx)
+}
+
+// Mark the Proxy resources out of sync if the Service exposes ports other than the cached ones
+// e.g. after a partial failure left the Service behind without its Deployment
+func (mgr *Manager) checkProxyService(ctx context.Context) error {
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	foundSvc := corev1.Service{}
+	if err := mgr.k8sClient.Get(ctx, proxyKey, &foundSvc); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	servicePorts := mgr.getProxyServicePorts()
+	if hasStaleServicePorts(&foundSvc, servicePorts, getTLSRedirectServicePorts(servicePorts, mgr.opt)...) {
+		mgr.getLog(ctx).Info("Proxy Service ports differ from cache", "ports", foundSvc.Spec.Ports)
+		mgr.outOfSync = true
+	}
+	return nil
+}
+
+func (mgr *Manager) run(ctx context.Context) (err error) {
+	ctx, span := mgr.startSpan(ctx, "reconcile")
+	defer func() { endSpan(span, err) }()
+
+	cacheReconciled := false
+
+	// Get public ports from Controller
+	_, controllerSpan := mgr.startSpan(ctx, "controller.GetAllMicroservicePublicPorts")
+	allBackendPorts, err := bindControllerClient(ctx, mgr.ioClient).GetAllMicroservicePublicPorts()
+	endSpan(controllerSpan, err)
+	if err != nil {
+		return err
+	}
+	mgr.forgetMicroservices(allBackendPorts)
+	allBackendPorts = mgr.filterOptedOutPorts(ctx, allBackendPorts)
+
+	var backendPorts []ioclient.MicroservicePublicPort
+	// Filter ports based on protocol and drop invalid and reserved ports
+	rejected := make(map[int]portRejection)
+	for _, port := range allBackendPorts {
+		// Protocols are rendered and decoded in lower case, the Controller may report them capitalized
+		port.PublicPort.Protocol = strings.ToLower(port.PublicPort.Protocol)
+		if !matchesProtocolFilter(port.PublicPort.Protocol, mgr.opt.ProtocolFilter) {
+			continue
+		}
+		if err := validatePublicPort(port.PublicPort, mgr.opt.AllowPrivilegedPorts); err != nil {
+			rejected[port.PublicPort.Port] = newInvalidPortRejection(err)
+			continue
+		}
+		if err := validateQueue(port.PublicPort, mgr.opt.ProxyConfigEncoding); err != nil {
+			rejected[port.PublicPort.Port] = newInvalidPortRejection(err)
+			continue
+		}
+		if mgr.opt.ReservedPorts.Contains(port.PublicPort.Port) {
+			rejected[port.PublicPort.Port] = newReservedPortRejection(port.PublicPort.Port)
+			continue
+		}
+		if isTLSRedirectPort(mgr.opt, port.PublicPort.Port) {
+			rejected[port.PublicPort.Port] = newTLSRedirectPortRejection(port.PublicPort.Port)
+			continue
+		}
+		backendPorts = append(backendPorts, port)
+	}
+
+	// Drop ports claimed by another Manager of this process
+	if mgr.opt.PortRegistry != nil {
+		requested := make(map[int]bool)
+		for _, port := range backendPorts {
+			requested[port.PublicPort.Port] = true
+		}
+		lost := mgr.opt.PortRegistry.request(mgr.opt.ProxyName, requested)
+		if len(lost) > 0 {
+			var kept []ioclient.MicroservicePublicPort
+			for _, port := range backendPorts {
+				if winner, exists := lost[port.PublicPort.Port]; exists {
+					rejected[port.PublicPort.Port] = newDuplicatePortRejection(port.PublicPort.Port, winner)
+					continue
+				}
+				kept = append(kept, port)
+			}
+			backendPorts = kept
+		}
+	}
+
+	// Update Proxy config if new ports are created or queues changed
+	var changes []auditChange
+	claims := portClaims{mgr: mgr}
+	for _, backendPort := range backendPorts {
+		newPort := backendPort.PublicPort
+		existingPort, exists := mgr.cache[newPort.Port]
+		// Microservice already stored in cache
+		if exists {
+			// Check for queue change
+			if existingPort.Queue != newPort.Queue || existingPort.Protocol != newPort.Protocol {
+				cacheReconciled = true
+				// Update cache, the series of the previous queue are not updated anymore
+				mgr.cache[newPort.Port] = newPort
+				if existingPort.Queue != newPort.Queue {
+					deletePortSeries(mgr.opt.ProxyName, existingPort.Port, existingPort.Queue)
+				}
+				changes = append(changes, auditChange{
+					Action:           auditActionUpdate,
+					Port:             newPort.Port,
+					Protocol:         newPort.Protocol,
+					Queue:            newPort.Queue,
+					PreviousProtocol: existingPort.Protocol,
+					PreviousQueue:    existingPort.Queue,
+					MicroserviceUUID: backendPort.MicroserviceUUID,
+				})
+			}
+		} else {
+			// New port, make sure no other Service exposes it
+			claim, err := claims.getClaim(ctx, newPort.Port)
+			if err != nil {
+				return err
+			}
+			if claim != "" {
+				rejected[newPort.Port] = newPortConflict(newPort.Port, claim)
+				continue
+			}
+			// Update cache
+			cacheReconciled = true
+			mgr.cache[newPort.Port] = newPort
+			changes = append(changes, auditChange{
+				Action:           auditActionAdd,
+				Port:             newPort.Port,
+				Protocol:         newPort.Protocol,
+				Queue:            newPort.Queue,
+				MicroserviceUUID: backendPort.MicroserviceUUID,
+			})
+		}
+		mgr.portOwners[newPort.Port] = backendPort.MicroserviceUUID
+	}
+
+	// Update Proxy config if ports are deleted
+	// Create map of backend ports
+	backendPortMap := make(map[int]string)
+	for _, backendPort := range backendPorts {
+		backendPortMap[backendPort.PublicPort.Port] = backendPort.PublicPort.Queue
+	}
+	for port, cachedPort := range mgr.cache {
+		// Cached port does not exist in backend, delete it
+		if _, exists := backendPortMap[port]; !exists {
+			// Cached microservice not found in backend
+			cacheReconciled = true
+			// Remove microservice from cache
+			delete(mgr.cache, port)
+			deletePortSeries(mgr.opt.ProxyName, port, cachedPort.Queue)
+			changes = append(changes, auditChange{
+				Action:           auditActionRemove,
+				Port:             port,
+				Protocol:         cachedPort.Protocol,
+				Queue:            cachedPort.Queue,
+				MicroserviceUUID: mgr.portOwners[port],
+			})
+			delete(mgr.portOwners, port)
+		}
+	}
+
+	mgr.reportRejectedPorts(rejected)
+	hostnamesChanged := mgr.updateHostnames(ctx)
+	metadataChanged := mgr.updatePortMetadata(ctx)
+	mgr.updatePortAddresses(ctx)
+
+	// Update K8s resources, retrying previous failures
+	if cacheReconciled || hostnamesChanged || metadataChanged || mgr.outOfSync {
+		mgr.getLog(ctx).Info("Reconciled cache", "cache", mgr.cache)
+		err := mgr.updateProxy(ctx)
+		mgr.outOfSync = err != nil
+		record := auditRecord{
+			Time:    time.Now().UTC(),
+			Proxy:   mgr.opt.ProxyName,
+			Changes: changes,
+			Config:  createProxyConfig(mgr.cache, mgr.opt),
+		}
+		if err := ignorePending(err); err != nil {
+			record.Error = err.Error()
+		}
+		mgr.audit(ctx, record)
+		return err
+	}
+
+	return nil
+}
+
+// Delete K8s resources for an HTTP Proxy created for a Microservice
+func (mgr *Manager) deleteProxyDeployment(ctx context.Context) error {
+	dep := newProxyWorkload(mgr.opt)
+	dep.SetName(mgr.opt.ProxyName)
+	dep.SetNamespace(mgr.opt.Namespace)
+	if err := mgr.delete(ctx, dep); err != nil {
+		return err
+	}
+	return mgr.deleteRouterConfigMap(ctx)
+}
+
+// Delete K8s resources for an HTTP Proxy created for a Microservice
+// The deletion is not waited for, e.g. while a cloud provider releases the LoadBalancer
+func (mgr *Manager) deleteProxyService(ctx context.Context) error {
+	meta := metav1.ObjectMeta{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.unexportProxyService(ctx); err != nil {
+		return err
+	}
+	return mgr.delete(ctx, &corev1.Service{ObjectMeta: meta})
+}
+
+// Create or update an HTTP Proxy instance for a Microservice
+func (mgr *Manager) updateProxy(ctx context.Context) (err error) {
+	ctx, span := mgr.startSpan(ctx, "updateProxy", attribute.Int("ports", len(mgr.cache)))
+	defer func() { endSpan(span, err) }()
+
+	// Key to check resources don't already exist
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+
+	// Stop routing traffic to removed ports before the Proxy stops serving them
+	if err := mgr.drainRemovedPorts(ctx); err != nil {
+		return err
+	}
+
+	// Router config, must exist before Proxy pods can mount it
+	routerConfig, err := getRouterConfig(mgr.opt, mgr.opt.RouterAddresses)
+	if err != nil {
+		return err
+	}
+	// Large Proxy configs are passed to the Proxy through the router ConfigMap
+	proxyConfig := createProxyConfig(mgr.cache, mgr.opt)
+	if len(mgr.cache) > 0 {
+		// Refuse to apply a config the cache would not be regenerated from after a restart
+		configErr := verifyProxyConfig(proxyConfig, mgr.cache, mgr.opt.ProxyConfigEncoding)
+		mgr.observeAlert(ctx, mgr.proxyConfigAlert, configErr)
+		if configErr != nil {
+			return configErr
+		}
+	}
+	spilledConfig := ""
+	if isProxyConfigSpilled(mgr.opt, proxyConfig) {
+		spilledConfig = proxyConfig
+	}
+	if len(mgr.cache) > 0 {
+		if err := mgr.applyRouterConfigMap(ctx, routerConfig, spilledConfig); err != nil {
+			return err
+		}
+	}
+	configHash, err := mgr.getConfigHash(ctx, routerConfig, spilledConfig)
+	if err != nil {
+		return err
+	}
+	sidecars, err := mgr.getSidecars(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Deployment, new ports are only exposed once it is ready
+	var readyErr error
+	foundDep := newProxyWorkload(mgr.opt)
+	if err := mgr.getReader().Get(ctx, proxyKey, foundDep); err == nil {
+		// Existing deployment found, update the proxy configuration
+		if err := mgr.updateProxyWorkload(ctx, foundDep, configHash, sidecars); err != nil {
+			return err
+		}
+		if len(mgr.cache) > 0 {
+			readyErr = mgr.checkProxyReady(foundDep)
+		}
+	} else {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+		// Create new deployment, unless there is nothing to serve e.g. only a stale Service was left behind
+		if len(mgr.cache) > 0 {
+			dep, err := newProxyDeployment(mgr.opt, int32(getMinReplicas(mgr.opt)), proxyConfig, configHash, sidecars)
+			if err != nil {
+				return err
+			}
+			setHostPorts(dep, mgr.cache, mgr.opt)
+			workload := newProxyWorkloadFrom(dep, mgr.opt)
+			mgr.setOwnerReference(workload)
+			if err := mgr.k8sClient.Create(ctx, workload); err != nil {
+				return err
+			}
+			readyErr = mgr.checkProxyReady(workload)
+		}
+	}
+	if err := mgr.syncHeadlessService(ctx); err != nil {
+		return err
+	}
+	// The rolled back Proxy does not serve the ports added by the failed rollout
+	exposable := readyErr == nil && mgr.failedRollout == ""
+
+	// Service
+	foundSvc := corev1.Service{}
+	if err := mgr.k8sClient.Get(ctx, proxyKey, &foundSvc); err == nil && getImmutableServiceChange(&foundSvc, mgr.opt) == "" {
+		// Existing service found, update it without touching immutable values
+		if err := mgr.updateProxyService(ctx, &foundSvc, exposable); err != nil {
+			return err
+		}
+	} else {
+		if err == nil {
+			// Immutable values changed, recreate the service
+			if err := mgr.deleteChangedProxyService(ctx, &foundSvc); err != nil {
+				return err
+			}
+		} else if !k8serrors.IsNotFound(err) {
+			return err
+		}
+		// Nothing to expose, other than by the Services of other types
+		servicePorts := mgr.getProxyServicePorts()
+		if len(servicePorts) == 0 {
+			if err := mgr.syncServiceTypes(ctx, exposable); err != nil {
+				return err
+			}
+			return readyErr
+		}
+		// Do not expose the Proxy until it can serve traffic
+		if !exposable {
+			return readyErr
+		}
+		// Create new service if ports exist
+		svc := newProxyService(mgr.opt.Namespace, mgr.opt.ProxyName, servicePorts, mgr.opt.ProxyServiceType, getTLSRedirectServicePorts(servicePorts, mgr.opt)...)
+		setIPFamilies(svc, mgr.opt)
+		mgr.setServicePortNames(svc)
+		mgr.setServiceHostnames(svc)
+		mgr.setServiceMetadata(svc)
+		setLoadBalancerPreset(svc, mgr.opt)
+		setLoadBalancerIP(svc, mgr.opt)
+		setWorkloadTrafficPolicy(svc, mgr.opt)
+		setProxyGroup(svc, mgr.opt)
+		setCustomMetadata(svc, mgr.opt)
+		mgr.setOwnerReference(svc)
+		if err := mgr.k8sClient.Create(ctx, svc); err != nil {
+			return err
+		}
+		if err := mgr.exportProxyService(ctx); err != nil {
+			return err
+		}
+		// Trigger address registration for Controller
+		mgr.addressQueue.add(mgr.opt.ProxyExternalAddress)
+	}
+
+	if err := mgr.syncServiceTypes(ctx, exposable); err != nil {
+		return err
+	}
+	return readyErr
+}
+
+// Remove ports that are no longer in the cache from the Proxy Service
+// and give their connections a grace period before the Proxy config is updated, requeueing the reconcile until it ends
+func (mgr *Manager) drainRemovedPorts(ctx context.Context) error {
+	if mgr.opt.PortDrainPeriod <= 0 {
+		return nil
+	}
+	if !mgr.drainUntil.IsZero() {
+		if remaining := time.Until(mgr.drainUntil); remaining > 0 {
+			return &pendingError{reason: "draining removed ports", after: remaining}
+		}
+		mgr.drainUntil = time.Time{}
+	}
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	foundSvc := corev1.Service{}
+	if err := mgr.k8sClient.Get(ctx, proxyKey, &foundSvc); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	removed := make([]int32, 0)
+	servicePorts := mgr.getProxyServicePorts()
+	for _, svcPort := range foundSvc.Spec.Ports {
+		// The redirect is removed with its HTTPS port
+		if _, exists := servicePorts[int(svcPort.Port)]; !exists && !isTLSRedirectPort(mgr.opt, int(svcPort.Port)) {
+			removed = append(removed, svcPort.Port)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	_, span := mgr.startSpan(ctx, "drainRemovedPorts")
+	defer span.End()
+	mgr.getLog(ctx).Info("Draining removed ports", "ports", removed, "period", mgr.opt.PortDrainPeriod.String())
+	// Ports added meanwhile are only exposed once the Proxy serves them
+	if err := mgr.updateProxyService(ctx, &foundSvc, false); err != nil {
+		return err
+	}
+	mgr.drainUntil = time.Now().Add(mgr.opt.PortDrainPeriod)
+	return &pendingError{reason: "draining removed ports", after: mgr.opt.PortDrainPeriod}
+}
+
+// Delete the Proxy Service so it is recreated with values that cannot be updated, its new address is registered once created
+// The update is pending until the Service is gone
+func (mgr *Manager) deleteChangedProxyService(ctx context.Context, foundSvc *corev1.Service) error {
+	pending := &pendingError{reason: "waiting for the Proxy Service to be deleted", after: pkg.readyPollInterval}
+	if foundSvc.DeletionTimestamp != nil {
+		return pending
+	}
+	change := getImmutableServiceChange(foundSvc, mgr.opt)
+	mgr.getLog(ctx).Info("Recreating Proxy Service", "change", change)
+	mgr.recorder.Event(foundSvc, corev1.EventTypeNormal, reasonProxyServiceRecreated, "Recreating Proxy Service to change its "+change)
+	if err := mgr.deleteProxyService(ctx); err != nil {
+		return err
+	}
+	proxyKey := k8sclient.ObjectKey{Name: mgr.opt.ProxyName, Namespace: mgr.opt.Namespace}
+	if err := mgr.k8sClient.Get(ctx, proxyKey, &corev1.Service{}); !k8serrors.IsNotFound(err) {
+		if err != nil {
+			return err
+		}
+		return pending
+	}
+	return nil
+}
+
+// Ports not exposed yet are only added once the Proxy is ready to serve them
+func (mgr *Manager) updateProxyService(ctx context.Context, foundSvc *corev1.Service, ready bool) error {
+	ports := mgr.getProxyServicePorts()
+	if !ready {
+		// Keep the Service and its address rather than deleting it for having only new ports
+		if ports = getExposedPorts(ports, foundSvc); len(ports) == 0 && len(mgr.getProxyServicePorts()) > 0 {
+			return nil
+		}
+	}
+	modifyServiceSpec(foundSvc, ports, getTLSRedirectServicePorts(ports, mgr.opt)...)
+	mgr.setServicePortNames(foundSvc)
+	mgr.setServiceHostnames(foundSvc)
+	mgr.setServiceMetadata(foundSvc)
+	setLoadBalancerPreset(foundSvc, mgr.opt)
+	setLoadBalancerIP(foundSvc, mgr.opt)
+	setWorkloadTrafficPolicy(foundSvc, mgr.opt)
+	setCustomMetadata(foundSvc, mgr.opt)
+
+	// Cannot update service to have 0 ports, delete it
+	if len(foundSvc.Spec.Ports) == 0 {
+		// Delete empty service
+		return mgr.deleteProxyService(ctx)
+	}
+
+	// Update the service with new ports
+	if err := mgr.k8sClient.Update(ctx, foundSvc); err != nil {
+		return err
+	}
+
+	return mgr.exportProxyService(ctx)
+}
+
+// TODO: Replace this function with logic to update config in Proxy without editing the deployment
+func (mgr *Manager) updateProxyDeployment(ctx context.Context, foundDep *appsv1.Deployment, configHash string, sidecars []corev1.Container) error {
+	// Generate config
+	config := createProxyConfig(mgr.cache, mgr.opt)
+
+	if config == "" {
+		// Delete unneeded resource
+		return mgr.deleteProxyDeployment(ctx)
+	}
+
+	// Save the config to deployment
+	original := foundDep.DeepCopy()
+	if err := mgr.setProxySpec(foundDep, config, configHash, sidecars); err != nil {
+		return err
+	}
+	if mgr.isFailedRollout(ctx, foundDep) {
+		return nil
+	}
+
+	// Patch only the fields set above, so replicas and the entries others added to the pod template are not written back
+	if err := mgr.k8sClient.Patch(ctx, foundDep, k8sclient.StrategicMergeFrom(original)); err != nil {
+		return err
+	}
+	if isDeploymentReady(original) {
+		return mgr.deleteReplacedProxyWorkloads(ctx)
+	}
+	return nil
+}
+
+// Apply the config and Options to an existing Proxy Deployment
+func (mgr *Manager) setProxySpec(dep *appsv1.Deployment, config, configHash string, sidecars []corev1.Container) error {
+	if err := updateProxyConfig(dep, mgr.opt, config); err != nil {
+		return err
+	}
+	setRouterConfig(dep, mgr.opt, configHash)
+	setBasicAuthSecrets(dep, mgr.opt)
+	setProxyTLSSecret(dep, mgr.opt)
+	setUpdateStrategy(dep, mgr.opt)
+	setProxyImage(dep, mgr.opt)
+	setNodePlacement(dep, mgr.opt)
+	setHostPorts(dep, mgr.cache, mgr.opt)
+	setSidecars(dep, sidecars)
+	setDeploymentCustomMetadata(dep, mgr.opt)
+	return setInitContainers(dep, mgr.opt)
+}
+
+func (mgr *Manager) delete(ctx context.Context, obj k8sclient.Object) error {
+	if err := mgr.k8sClient.Delete(ctx, obj); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+		return err
+	}
+	return nil
+}
+
+// Set an annotation on the Proxy Deployment metadata without rolling its pods
+func (mgr *Manager) annotateProxyDeployment(ctx context.Context, key, value string) error {
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	dep := newProxyWorkload(mgr.opt)
+	if err := mgr.k8sClient.Get(ctx, proxyKey, dep); err != nil {
+		return k8sclient.IgnoreNotFound(err)
+	}
+	annotations := dep.GetAnnotations()
+	if annotations[key] == value {
+		return nil
+	}
+	patch := k8sclient.MergeFrom(dep.DeepCopyObject().(k8sclient.Object))
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[key] = value
+	dep.SetAnnotations(annotations)
+	return mgr.k8sClient.Patch(ctx, dep, patch)
+}
+
+func (mgr *Manager) setOwnerReference(obj metav1.Object) {
+	if mgr.opt.OwnerMode == OwnerModeLabels {
+		setManagedByLabel(obj, mgr.opt)
+		return
+	}
+	if mgr.owner.UID == "" {
+		return
+	}
+	obj.SetOwnerReferences([]metav1.OwnerReference{mgr.owner})
+}
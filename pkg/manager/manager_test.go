@@ -0,0 +1,868 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newFakeManager(t *testing.T, ioClient ControllerClient) (*Manager, k8sclient.Client) {
+	scheme, err := newScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: pkg.managerName, Namespace: "iofog", UID: "owner"}}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(owner).Build()
+	mgr, err := New(
+		WithNamespace("iofog"),
+		WithProxyImage("iofog/proxy"),
+		WithRouterAddresses(RouterAddress{Host: "router", Port: 5671}),
+		WithK8sClient(k8sClient),
+		WithControllerClient(ioClient),
+		WithLoadBalancerWaiter(&FakeLoadBalancerWaiter{Address: "1.2.3.4"}),
+		WithEventRecorder(record.NewFakeRecorder(100)),
+		func(opt *Options) {
+			opt.ProxyReadyTimeout = time.Millisecond
+			opt.PortDrainPeriod = 0
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mgr, k8sClient
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyReadyTimeout = time.Minute
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Service is not created until the Proxy Deployment is ready, the reconcile is requeued instead of waiting
+	err := mgr.run(ctx)
+	if !isPending(err) {
+		t.Fatalf("Expected pending update waiting for Proxy Deployment, got %v", err)
+	}
+	if result, err := getErrorResult(err); err != nil || result.RequeueAfter != pkg.readyPollInterval {
+		t.Errorf("Expected requeue after %s, got %v %v", pkg.readyPollInterval, result, err)
+	}
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	if err := k8sClient.Get(ctx, key, &corev1.Service{}); err == nil {
+		t.Error("Expected no Proxy Service before the Proxy Deployment is ready")
+	}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if len(dep.OwnerReferences) != 1 || dep.OwnerReferences[0].UID != "owner" {
+		t.Errorf("Expected Proxy Deployment to be owned by the manager, got %v", dep.OwnerReferences)
+	}
+	config, spilled, err := getProxyConfig(&dep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spilled || config != createProxyConfig(mgr.cache, mgr.opt) {
+		t.Errorf("Unexpected Proxy config %s", config)
+	}
+
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	svc := corev1.Service{}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 5000 {
+		t.Errorf("Expected Service to expose port 5000, got %v", svc.Spec.Ports)
+	}
+
+	// Removing the last port deletes the Proxy resources
+	ioClient.SetPorts()
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.Deployment{}); err == nil {
+		t.Error("Expected Proxy Deployment to be deleted")
+	}
+	if err := k8sClient.Get(ctx, key, &corev1.Service{}); err == nil {
+		t.Error("Expected Proxy Service to be deleted")
+	}
+}
+
+func TestRegisterAddress(t *testing.T) {
+	ioClient := NewFakeControllerClient()
+	mgr, _ := newFakeManager(t, ioClient)
+	if err := mgr.registerAddress(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if addr := ioClient.GetDefaultProxy(); addr != "1.2.3.4" {
+		t.Errorf("Expected LoadBalancer address to be registered, got %s", addr)
+	}
+}
+
+func TestRegisterProtocolAddress(t *testing.T) {
+	ioClient := NewFakeControllerClient()
+	mgr, _ := newFakeManager(t, ioClient)
+	mgr.opt.ProtocolFilter = "TCP"
+	mgr.opt.ProtocolRegistration = true
+	if err := mgr.registerAddress(context.Background(), "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	if addr := ioClient.GetPublicPortHost("tcp"); addr != "10.0.0.2" || ioClient.GetDefaultProxy() != "" {
+		t.Errorf("Expected the address to be registered for tcp only, got %s", addr)
+	}
+
+	// A client only registering the default address is rejected rather than overwriting it for all protocols
+	scheme, err := newScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(
+		WithNamespace("iofog"),
+		WithProxyImage("iofog/proxy"),
+		WithK8sClient(fake.NewClientBuilder().WithScheme(scheme).Build()),
+		WithControllerClient(struct{ ControllerClient }{ioClient}),
+		WithLoadBalancerWaiter(&FakeLoadBalancerWaiter{Address: "1.2.3.4"}),
+		WithEventRecorder(record.NewFakeRecorder(100)),
+		func(opt *Options) {
+			opt.ProtocolFilter = "TCP"
+			opt.ProtocolRegistration = true
+			opt.OutOfCluster = true
+		},
+	)
+	if !errors.Is(err, errProtocolRegistration) {
+		t.Errorf("Expected the protocol registration to be rejected, got %v", err)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	ioClient := NewFakeControllerClient()
+	mgr, _ := newFakeManager(t, ioClient)
+	// Stale port without a Proxy Deployment, kept by reconciles while the Controller is unavailable
+	ioClient.SetError(errors.New("unavailable"))
+	mgr.cacheGenerated = true
+	mgr.cache[5000] = ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}
+
+	mgr.Rebuild()
+	if len(mgr.events) != 1 {
+		t.Fatal("Expected rebuild to trigger a reconcile")
+	}
+	if _, err := mgr.Reconcile(context.Background(), reconcile.Request{}); err == nil {
+		t.Fatal("Expected Controller error")
+	}
+	if len(mgr.cache) != 0 {
+		t.Errorf("Expected cache to be regenerated, got %v", mgr.cache)
+	}
+}
+
+func TestRestorePortMap(t *testing.T) {
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.PortMapConfigMap = "iofog-public-ports"
+	cm := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "iofog-public-ports", Namespace: "iofog"},
+		Data: map[string]string{
+			"http-proxy.json": `[{"port":5000,"protocol":"http","queue":"abc-5000"},{"port":0,"protocol":"http","queue":"invalid"}]`,
+		},
+	}
+	if err := k8sClient.Create(ctx, &cm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a Proxy Deployment the cache is restored from the port map, skipping invalid entries
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expected := ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}
+	if len(mgr.cache) != 1 || mgr.cache[5000] != expected {
+		t.Errorf("Expected cache to be restored from port map, got %v", mgr.cache)
+	}
+}
+
+func TestGenerateCacheJSON(t *testing.T) {
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	opt := *mgr.opt
+	opt.ProxyConfigEncoding = ProxyConfigEncodingJSON
+	port := ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "a,b"}
+	dep, err := newProxyDeployment(&opt, 1, createProxyConfig(portMap{5000: port}, &opt), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Create(ctx, dep); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config is decoded and its queues validated in the encoding of the Deployment, not the current one
+	for _, encoding := range []string{ProxyConfigEncodingPlain, ProxyConfigEncodingJSON} {
+		mgr.opt.ProxyConfigEncoding = encoding
+		if err := mgr.generateCache(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(mgr.cache) != 1 || mgr.cache[5000] != port {
+			t.Errorf("Expected cache to be generated from the JSON config with the %s encoding, got %v", encoding, mgr.cache)
+		}
+	}
+
+	// The queue is rejected once the Controller reports it for the plain encoding
+	mgr.opt.ProxyConfigEncoding = ProxyConfigEncodingPlain
+	mgr.ioClient.(*FakeControllerClient).SetPorts(ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: port})
+	_ = mgr.run(ctx)
+	if _, exists := mgr.cache[5000]; exists {
+		t.Errorf("Expected queue with separators to be removed with the plain encoding, got %v", mgr.cache)
+	}
+}
+
+func TestGenerateCacheMigration(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	// Deployment of port-manager v2, without a format version and with the protocol of the Controller
+	dep, err := newProxyDeployment(mgr.opt, 1, "HTTP:5000=>amqp:abc-5000", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep.Annotations = nil
+	if err := k8sClient.Create(ctx, dep); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expected := ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}
+	if len(mgr.cache) != 1 || mgr.cache[5000] != expected {
+		t.Errorf("Expected cache to be generated from the migrated config, got %v", mgr.cache)
+	}
+	if !mgr.outOfSync {
+		t.Fatal("Expected migrated config to mark the Proxy out of sync")
+	}
+
+	// The Deployment is converted in place
+	_ = mgr.run(ctx)
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	if err := k8sClient.Get(ctx, key, dep); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := getProxyConfigVersion(dep); err != nil || version != proxyConfigCurrentFormat {
+		t.Errorf("Expected Deployment to be migrated to format %d, got %d %v", proxyConfigCurrentFormat, version, err)
+	}
+	if config, _, _ := getProxyConfig(dep); config != "http:5000=>amqp:abc-5000" {
+		t.Errorf("Unexpected migrated Deployment config %s", config)
+	}
+}
+
+func TestExternalDeploymentChanges(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+
+	// Scaled up by an HPA and injected into by a service mesh
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	replicas := int32(3)
+	dep.Spec.Replicas = &replicas
+	podSpec := &dep.Spec.Template.Spec
+	podSpec.Containers = append(podSpec.Containers, corev1.Container{Name: "istio-proxy", Image: "istio/proxyv2"})
+	podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{Name: "istio-init", Image: "istio/proxyv2"})
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{Name: "istio-envoy"})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: "istio-envoy", MountPath: "/etc/istio/proxy"})
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, corev1.EnvVar{Name: "ISTIO_META_MESH_ID", Value: "mesh"})
+	podSpec.Affinity = &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{}}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+
+	ioClient.SetPorts(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}},
+	)
+	_ = mgr.run(ctx)
+	dep = appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if config, _, _ := getProxyConfig(&dep); config != "http:5000=>amqp:abc-5000,tcp:6000=>amqp:abc-6000" {
+		t.Errorf("Expected the config to be updated, got %s", config)
+	}
+	podSpec = &dep.Spec.Template.Spec
+	if *dep.Spec.Replicas != 3 {
+		t.Errorf("Expected replicas to be kept, got %d", *dep.Spec.Replicas)
+	}
+	if len(podSpec.Containers) != 2 || podSpec.Containers[1].Name != "istio-proxy" || len(podSpec.InitContainers) != 1 {
+		t.Errorf("Expected injected containers to be kept, got %v %v", podSpec.Containers, podSpec.InitContainers)
+	}
+	if len(podSpec.Volumes) != 2 || len(podSpec.Containers[0].VolumeMounts) != 2 || len(podSpec.Containers[0].Env) != 2 {
+		t.Errorf("Expected injected volumes and env to be kept, got %v %v %v", podSpec.Volumes, podSpec.Containers[0].VolumeMounts, podSpec.Containers[0].Env)
+	}
+	if podSpec.Affinity == nil || podSpec.Affinity.PodAntiAffinity == nil {
+		t.Errorf("Expected pod anti-affinity to be kept, got %v", podSpec.Affinity)
+	}
+}
+
+func TestStaleService(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient()
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	// Service left behind by a partial failure, without its Deployment
+	svc := newProxyService("iofog", "http-proxy", portMap{5000: {Port: 5000, Protocol: "http", Queue: "abc-5000"}}, "LoadBalancer")
+	if err := k8sClient.Create(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !mgr.outOfSync {
+		t.Fatal("Expected stale Service to mark the Proxy out of sync")
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	if err := k8sClient.Get(ctx, key, &corev1.Service{}); err == nil {
+		t.Error("Expected stale Proxy Service to be deleted")
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.Deployment{}); err == nil {
+		t.Error("Expected no Proxy Deployment to be created without ports")
+	}
+}
+
+func TestSpillProxyConfig(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{PublicPort: ioclient.PublicPort{Port: 5001, Protocol: "tcp", Queue: "abc-5001"}},
+	)
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyConfigSpillSize = 16
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err == nil {
+		t.Fatal("Expected error waiting for Proxy Deployment")
+	}
+
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if _, spilled, err := getProxyConfig(&dep); err != nil || !spilled {
+		t.Fatalf("Expected Proxy config to be spilled, got %v", dep.Spec.Template.Spec.Containers[0].Args)
+	}
+	config, err := mgr.getSpilledProxyConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != createProxyConfig(mgr.cache, mgr.opt) {
+		t.Errorf("Unexpected spilled Proxy config %s", config)
+	}
+
+	// The cache is regenerated from the spilled config
+	expected := mgr.cache
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(mgr.cache) != len(expected) || mgr.cache[5001] != expected[5001] {
+		t.Errorf("Expected cache %v, got %v", expected, mgr.cache)
+	}
+}
+
+func TestProxyArgs(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+	)
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyCommand = []string{"/usr/bin/proxy"}
+	mgr.opt.ProxyArgs = []string{"--config-file", "{{.ConfigFile}}", "--config={{.Config}}"}
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err == nil {
+		t.Fatal("Expected error waiting for Proxy Deployment")
+	}
+
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}, &dep); err != nil {
+		t.Fatal(err)
+	}
+	container := dep.Spec.Template.Spec.Containers[0]
+	expected := []string{"--config-file", "/etc/messaging/proxy.conf", "--config=http:5000=>amqp:abc-5000"}
+	if !reflect.DeepEqual(container.Command, mgr.opt.ProxyCommand) || !reflect.DeepEqual(container.Args, expected) {
+		t.Errorf("Unexpected Proxy command %v and args %v", container.Command, container.Args)
+	}
+
+	// Custom arguments always spill the config, which the cache is regenerated from
+	if _, spilled, err := getProxyConfig(&dep); err != nil || !spilled {
+		t.Fatalf("Expected Proxy config to be spilled: %v", err)
+	}
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := mgr.cache[5000]; !exists || len(mgr.cache) != 1 {
+		t.Errorf("Expected the cache to be regenerated, got %v", mgr.cache)
+	}
+
+	if _, err := ParseProxyArgs([]string{"{{.Queue}}"}); err == nil {
+		t.Error("Expected an unknown template field to be rejected")
+	}
+}
+
+func TestServiceExport(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ServiceExportAPI = "multicluster.x-k8s.io/v1alpha1"
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err == nil {
+		t.Fatal("Expected error waiting for Proxy Deployment")
+	}
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, mgr.newServiceExport()); err != nil {
+		t.Fatalf("Expected ServiceExport of the Proxy Service: %s", err.Error())
+	}
+
+	// The ServiceExport is deleted with the Proxy Service
+	ioClient.SetPorts()
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, mgr.newServiceExport()); err == nil {
+		t.Error("Expected ServiceExport to be deleted")
+	}
+
+	if _, err := ParseServiceExportAPI("v1alpha1"); err == nil {
+		t.Error("Expected error for an API without a group")
+	}
+}
+
+func TestRecreateProxyService(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	svc := newProxyService("iofog", "http-proxy", portMap{5000: {Port: 5000, Protocol: "http", Queue: "abc-5000"}}, "LoadBalancer")
+	if err := k8sClient.Create(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); ignorePending(err) != nil {
+		t.Fatal(err)
+	}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+
+	// The type cannot be updated in place, e.g. after the settings changed
+	mgr.opt.ProxyServiceType = "ClusterIP"
+	mgr.opt.ProxyIPFamilies = []string{"IPv4"}
+	if err := mgr.resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeClusterIP || len(svc.Spec.IPFamilies) != 1 {
+		t.Errorf("Expected Proxy Service to be recreated as an IPv4 ClusterIP Service, got %v", svc.Spec)
+	}
+	if events := mgr.recorder.(*record.FakeRecorder).Events; len(events) != 1 {
+		t.Errorf("Expected an Event for the recreation, got %d", len(events))
+	}
+
+	if _, err := ParseIPFamilies([]string{"IPv6", "IPv6"}); err == nil {
+		t.Error("Expected error for duplicate IP families")
+	}
+}
+
+func TestOwnerModeLabels(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	scheme, err := newScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No owner Deployment exists
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	newManager := func(opt func(*Options)) (*Manager, error) {
+		return New(
+			WithNamespace("iofog"),
+			WithProxyImage("iofog/proxy"),
+			WithRouterAddresses(RouterAddress{Host: "router", Port: 5671}),
+			WithK8sClient(k8sClient),
+			WithControllerClient(ioClient),
+			WithLoadBalancerWaiter(&FakeLoadBalancerWaiter{Address: "1.2.3.4"}),
+			WithEventRecorder(record.NewFakeRecorder(100)),
+			func(o *Options) {
+				o.ProxyReadyTimeout = time.Millisecond
+				o.PortDrainPeriod = 0
+				o.OwnerName = "edge-port-manager"
+			},
+			opt,
+		)
+	}
+	if _, err := newManager(func(*Options) {}); err == nil {
+		t.Fatal("Expected missing owner Deployment to be an error")
+	}
+	mgr, err := newManager(func(o *Options) { o.OwnerMode = OwnerModeLabels })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if len(dep.OwnerReferences) != 0 || dep.Labels[managedByLabel] != "edge-port-manager" {
+		t.Errorf("Expected Proxy Deployment to be labelled instead of owned, got %v %v", dep.OwnerReferences, dep.Labels)
+	}
+	if len(dep.Finalizers) != 0 {
+		t.Errorf("Expected no finalizer blocking the deletion of the Proxy Deployment, got %v", dep.Finalizers)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	svc := corev1.Service{}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Labels[managedByLabel] != "edge-port-manager" {
+		t.Errorf("Expected Proxy Service to be labelled, got %v", svc.Labels)
+	}
+
+	// The labelled resources are deleted once the Manager stops for good, others are kept
+	other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "iofog"}}
+	if err := k8sClient.Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	labelled := corev1.ConfigMapList{}
+	if err := k8sClient.List(ctx, &labelled, k8sclient.MatchingLabels{managedByLabel: "edge-port-manager"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(labelled.Items) == 0 {
+		t.Error("Expected labelled ConfigMaps of the Proxy")
+	}
+	if err := mgr.Cleanup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &corev1.Service{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected Proxy Service to be deleted, got %v", err)
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.Deployment{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected Proxy Deployment to be deleted, got %v", err)
+	}
+	if err := k8sClient.List(ctx, &labelled, k8sclient.MatchingLabels{managedByLabel: "edge-port-manager"}); err != nil || len(labelled.Items) != 0 {
+		t.Errorf("Expected labelled ConfigMaps to be deleted, got %v %v", labelled.Items, err)
+	}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKeyFromObject(other), &corev1.ConfigMap{}); err != nil {
+		t.Errorf("Expected unlabelled ConfigMap to be kept, got %v", err)
+	}
+
+	// The next Manager recreates the Proxy
+	if mgr, err = newManager(func(o *Options) { o.OwnerMode = OwnerModeLabels }); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Manager deletes the resources of a Proxy without ports itself
+	ioClient.SetPorts()
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &corev1.Service{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected Proxy Service to be deleted, got %v", err)
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.Deployment{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected Proxy Deployment to be deleted, got %v", err)
+	}
+}
+
+func TestStatusUpdate(t *testing.T) {
+	dep := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "http-proxy", Namespace: "iofog", Generation: 1}}
+	ready := dep.DeepCopy()
+	ready.Status.AvailableReplicas = 1
+	if !isStatusUpdate(event.UpdateEvent{ObjectOld: dep, ObjectNew: ready}) {
+		t.Error("Expected a status-only update of the Proxy Deployment to be filtered")
+	}
+	changed := dep.DeepCopy()
+	changed.Generation = 2
+	if isStatusUpdate(event.UpdateEvent{ObjectOld: dep, ObjectNew: changed}) {
+		t.Error("Expected a spec update of the Proxy Deployment to trigger a reconcile")
+	}
+	labeled := dep.DeepCopy()
+	labeled.Labels = map[string]string{"app": "http-proxy"}
+	if isStatusUpdate(event.UpdateEvent{ObjectOld: dep, ObjectNew: labeled}) {
+		t.Error("Expected a label update of the Proxy Deployment to trigger a reconcile")
+	}
+
+	// Services have no generation, their spec is compared instead
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "http-proxy", Namespace: "iofog"}}
+	ingress := svc.DeepCopy()
+	ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "1.2.3.4"}}
+	if !isStatusUpdate(event.UpdateEvent{ObjectOld: svc, ObjectNew: ingress}) {
+		t.Error("Expected a status-only update of the Proxy Service to be filtered")
+	}
+	ported := svc.DeepCopy()
+	ported.Spec.Ports = []corev1.ServicePort{{Port: 5000}}
+	if isStatusUpdate(event.UpdateEvent{ObjectOld: svc, ObjectNew: ported}) {
+		t.Error("Expected a spec update of the Proxy Service to trigger a reconcile")
+	}
+}
+
+func setProxyDeploymentReady(t *testing.T, k8sClient k8sclient.Client, ready bool) {
+	ctx := context.Background()
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}, &dep); err != nil {
+		t.Fatal(err)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1}
+	if ready {
+		dep.Status.AvailableReplicas = 1
+	}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddPortsAfterReady(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyReadyTimeout = time.Minute
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); !isPending(err) {
+		t.Fatalf("Expected pending update waiting for Proxy Deployment, got %v", err)
+	}
+	setProxyDeploymentReady(t, k8sClient, true)
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new port is not added to the Service while the updated pods are not available
+	ioClient.SetPorts(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "http", Queue: "abc-6000"}},
+	)
+	setProxyDeploymentReady(t, k8sClient, false)
+	err := mgr.run(ctx)
+	if !isPending(err) {
+		t.Fatalf("Expected pending update waiting for Proxy Deployment, got %v", err)
+	}
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	svc := corev1.Service{}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 5000 {
+		t.Errorf("Expected Service to expose only port 5000 before the Proxy is ready, got %v", svc.Spec.Ports)
+	}
+
+	// The requeued reconcile adds it once they are
+	setProxyDeploymentReady(t, k8sClient, true)
+	if err := mgr.resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 2 {
+		t.Errorf("Expected Service to expose ports 5000 and 6000, got %v", svc.Spec.Ports)
+	}
+}
+
+func TestDrainRemovedPorts(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "http", Queue: "abc-6000"}},
+	)
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyReadyTimeout = time.Minute
+	mgr.opt.PortDrainPeriod = time.Minute
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	setProxyDeploymentReady(t, k8sClient, true)
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The removed port leaves the Service first and the reconcile is requeued at the end of the drain period
+	ioClient.SetPorts(ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}})
+	err := mgr.run(ctx)
+	if !isPending(err) {
+		t.Fatalf("Expected pending update while draining, got %v", err)
+	}
+	if result, _ := getErrorResult(err); result.RequeueAfter != time.Minute {
+		t.Errorf("Expected requeue after the drain period, got %v", result)
+	}
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	svc := corev1.Service{}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 5000 {
+		t.Errorf("Expected Service to expose only port 5000 while draining, got %v", svc.Spec.Ports)
+	}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if config, _, _ := getProxyConfig(&dep); !strings.Contains(config, "6000") {
+		t.Errorf("Expected Proxy to serve port 6000 while draining, got %s", config)
+	}
+
+	// A reconcile during the drain period does not update the Proxy
+	err = mgr.run(ctx)
+	if result, _ := getErrorResult(err); !isPending(err) || result.RequeueAfter <= 0 || result.RequeueAfter > time.Minute {
+		t.Errorf("Expected requeue for the rest of the drain period, got %v %v", result, err)
+	}
+
+	// The Proxy config is updated once the drain period is over
+	mgr.drainUntil = time.Now()
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if config, _, _ := getProxyConfig(&dep); strings.Contains(config, "6000") {
+		t.Errorf("Expected port 6000 to be removed from the Proxy config, got %s", config)
+	}
+}
+
+func TestRemoteClusterFailure(t *testing.T) {
+	mgr, _ := newFakeManager(t, NewFakeControllerClient())
+	// Nothing listens on the port of the remote cluster
+	cfg := &rest.Config{Host: "https://127.0.0.1:1"}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runRemoteControllerManager(ctx, cfg, []*Manager{mgr})
+		close(done)
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for mgr.ClusterError() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := mgr.ClusterError(); err == nil || !strings.Contains(err.Error(), cfg.Host) {
+		t.Errorf("Expected error of the remote cluster, got %v", err)
+	}
+	if _, exists := mgr.status.get()[statusErrorCluster]; !exists {
+		t.Error("Expected remote cluster error in status")
+	}
+	// The failing cluster is retried until the Managers stop
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Error("Expected remote cluster to stop with the context")
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	statusErrorMetadata = "metadata"
+
+	// JSON of the microservice of each port of the Proxy Service, by port
+	microservicesAnnotation = "iofog.org/microservices"
+)
+
+// Microservice exposing a port, set on the resources of the port, see MicroserviceMetadata
+type portMetadata struct {
+	UUID         string `json:"uuid"`
+	Microservice string `json:"microservice,omitempty"`
+	Application  string `json:"application,omitempty"`
+}
+
+// Labels of the microservice of a port, values are reduced to valid label values
+func (meta portMetadata) labels() map[string]string {
+	labels := make(map[string]string)
+	if meta.UUID != "" && len(validation.IsValidLabelValue(meta.UUID)) == 0 {
+		labels[microserviceLabel] = meta.UUID
+	}
+	if name := toDNSLabel(meta.Microservice); name != "" {
+		labels[microserviceNameLabel] = name
+	}
+	if application := toDNSLabel(meta.Application); application != "" {
+		labels[applicationLabel] = application
+	}
+	return labels
+}
+
+// Set the microservice labels of a resource, keeping its other labels, returning whether they changed
+func setMetadataLabels(obj metav1.Object, metadataLabels map[string]string) bool {
+	labels := obj.GetLabels()
+	changed := false
+	for _, key := range []string{microserviceLabel, microserviceNameLabel, applicationLabel} {
+		value, exists := metadataLabels[key]
+		if current, found := labels[key]; found == exists && current == value {
+			continue
+		}
+		changed = true
+		if !exists {
+			delete(labels, key)
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = value
+	}
+	obj.SetLabels(labels)
+	return changed
+}
+
+// Look up the microservice of each cached port, returning whether the metadata of any port changed
+// Ports whose microservice cannot be looked up keep their previous metadata
+func (mgr *Manager) updatePortMetadata(ctx context.Context) bool {
+	if !mgr.opt.MicroserviceMetadata {
+		return false
+	}
+	metadata := make(map[int]portMetadata)
+	var errs []string
+	for port := range mgr.cache {
+		uuid := mgr.portOwners[port]
+		if uuid == "" {
+			continue
+		}
+		msvc, err := mgr.getMicroservice(ctx, uuid)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("port %d: %s", port, err.Error()))
+			if meta, exists := mgr.portMetadata[port]; exists {
+				metadata[port] = meta
+			}
+			continue
+		}
+		metadata[port] = portMetadata{UUID: uuid, Microservice: msvc.Name, Application: msvc.Application}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		mgr.status.setError(statusErrorMetadata, fmt.Errorf("%s", strings.Join(errs, ", ")))
+	} else {
+		mgr.status.setError(statusErrorMetadata, nil)
+	}
+
+	changed := len(metadata) != len(mgr.portMetadata)
+	for port, meta := range metadata {
+		if mgr.portMetadata[port] != meta {
+			changed = true
+		}
+	}
+	mgr.portMetadata = metadata
+	return changed
+}
+
+// Annotate the Proxy Service with the microservice of each port
+// The microservice and application labels are only set while all ports share them
+func (mgr *Manager) setServiceMetadata(svc *corev1.Service) {
+	if !mgr.opt.MicroserviceMetadata {
+		return
+	}
+	var shared map[string]string
+	for _, meta := range mgr.portMetadata {
+		labels := meta.labels()
+		if shared == nil {
+			shared = labels
+			continue
+		}
+		for key, value := range shared {
+			if labels[key] != value {
+				delete(shared, key)
+			}
+		}
+	}
+	setMetadataLabels(svc, shared)
+	if len(mgr.portMetadata) == 0 {
+		delete(svc.Annotations, microservicesAnnotation)
+		return
+	}
+	// Map keys are sorted, so unchanged metadata renders the same annotation
+	data, err := json.Marshal(mgr.portMetadata)
+	if err != nil {
+		return
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	svc.Annotations[microservicesAnnotation] = string(data)
+}
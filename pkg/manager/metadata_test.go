@@ -0,0 +1,110 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMicroserviceMetadata(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient()
+	ioClient.SetMicroservices(
+		ioclient.MicroserviceInfo{UUID: "abc", Name: "Web UI", Application: "shop"},
+		ioclient.MicroserviceInfo{UUID: "def", Name: "db", Application: "shop"},
+	)
+	mgr, _ := newFakeManager(t, ioClient)
+	mgr.opt.MicroserviceMetadata = true
+	mgr.cache[5000] = ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}
+	mgr.cache[6000] = ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "def-6000"}
+	mgr.portOwners[5000], mgr.portOwners[6000] = "abc", "def"
+
+	if !mgr.updatePortMetadata(ctx) {
+		t.Fatal("Expected metadata to change")
+	}
+	if mgr.updatePortMetadata(ctx) {
+		t.Error("Expected unchanged metadata")
+	}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"name": "http-proxy", microserviceLabel: "stale"}}}
+	mgr.setServiceMetadata(svc)
+	if svc.Labels[applicationLabel] != "shop" || svc.Labels["name"] != "http-proxy" {
+		t.Errorf("Expected shared application label, got %v", svc.Labels)
+	}
+	if _, exists := svc.Labels[microserviceLabel]; exists {
+		t.Errorf("Expected microservice label of ports of several microservices to be removed, got %v", svc.Labels)
+	}
+	expected := `{"5000":{"uuid":"abc","microservice":"Web UI","application":"shop"},"6000":{"uuid":"def","microservice":"db","application":"shop"}}`
+	if annotation := svc.Annotations[microservicesAnnotation]; annotation != expected {
+		t.Errorf("Unexpected microservices annotation %s", annotation)
+	}
+	labels := mgr.portMetadata[5000].labels()
+	if labels[microserviceLabel] != "abc" || labels[microserviceNameLabel] != "web-ui" {
+		t.Errorf("Unexpected port labels %v", labels)
+	}
+
+	mgr.saveSnapshot()
+	ports := newExportedPorts(mgr.loadSnapshot())
+	if len(ports) != 2 || ports[0].MicroserviceUUID != "abc" || ports[0].Microservice != "Web UI" || ports[1].Application != "shop" {
+		t.Errorf("Expected port map to include the microservice metadata, got %v", ports)
+	}
+}
+
+func TestCustomMetadata(t *testing.T) {
+	labels, err := ParseLabels("team=edge, cost-center=1234,")
+	if err != nil || len(labels) != 2 || labels["team"] != "edge" || labels["cost-center"] != "1234" {
+		t.Errorf("Unexpected labels %v: %v", labels, err)
+	}
+	for _, value := range []string{"team", "name=proxy", "iofog.org/proxy-group=a", "team=not valid"} {
+		if _, err := ParseLabels(value); err == nil {
+			t.Errorf("Expected labels %s to be rejected", value)
+		}
+	}
+	if err := checkCustomMetadata(&Options{ProxyAnnotations: map[string]string{sidecarsAnnotation: "a"}}); err == nil {
+		t.Error("Expected annotation managed by the Manager to be rejected")
+	}
+
+	opt := &Options{
+		ProxyLabels:      map[string]string{"team": "edge"},
+		ProxyAnnotations: map[string]string{"prometheus.io/scrape": "true"},
+	}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"name": "http-proxy", "other": "kept"}}}
+	setCustomMetadata(svc, opt)
+	if svc.Labels["team"] != "edge" || svc.Labels["name"] != "http-proxy" || svc.Labels["other"] != "kept" {
+		t.Errorf("Unexpected labels %v", svc.Labels)
+	}
+	if svc.Annotations["prometheus.io/scrape"] != "true" {
+		t.Errorf("Unexpected annotations %v", svc.Annotations)
+	}
+
+	opt.ProxyLabels = map[string]string{"cost-center": "1234"}
+	opt.ProxyAnnotations = nil
+	setCustomMetadata(svc, opt)
+	if _, exists := svc.Labels["team"]; exists || svc.Labels["cost-center"] != "1234" || svc.Labels["other"] != "kept" {
+		t.Errorf("Expected removed custom label to be deleted, got %v", svc.Labels)
+	}
+	if _, exists := svc.Annotations["prometheus.io/scrape"]; exists {
+		t.Errorf("Expected removed custom annotation to be deleted, got %v", svc.Annotations)
+	}
+
+	opt.ProxyLabels = nil
+	setCustomMetadata(svc, opt)
+	if len(svc.Labels) != 2 || len(svc.Annotations) != 0 {
+		t.Errorf("Expected only the other metadata to remain, got %v %v", svc.Labels, svc.Annotations)
+	}
+}
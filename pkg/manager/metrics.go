@@ -0,0 +1,191 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "port_manager"
+
+var (
+	registrationAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "address_registration_attempts_total",
+		Help:      "Number of attempts to register the Proxy address with the Controller, by result",
+	}, []string{"proxy", "result"})
+
+	proxyRollbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "proxy_rollbacks_total",
+		Help:      "Number of automatic rollbacks of failed Proxy Deployment rollouts",
+	}, []string{"proxy"})
+
+	proxyImageInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "proxy_image_info",
+		Help:      "Image digest run by the Proxy pods",
+	}, []string{"proxy", "digest"})
+
+	proxyPortConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "proxy_port_connections",
+		Help:      "Open connections of each public port, summed over the Proxy pods",
+	}, []string{"proxy", "port", "queue"})
+
+	proxyPortReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "proxy_port_received_bytes_total",
+		Help:      "Bytes received from clients on each public port",
+	}, []string{"proxy", "port", "queue"})
+
+	proxyPortSentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "proxy_port_sent_bytes_total",
+		Help:      "Bytes sent to clients on each public port",
+	}, []string{"proxy", "port", "queue"})
+
+	proxyPortReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "proxy_port_reachable",
+		Help:      "Whether each public port was reachable through the Proxy Service when last probed",
+	}, []string{"proxy", "port", "queue"})
+
+	proxyExternalPathUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "proxy_external_path_up",
+		Help:      "Whether a sampled public port was reachable through the registered external address when last checked",
+	}, []string{"proxy"})
+
+	panicsRecovered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "panics_recovered_total",
+		Help:      "Number of panics recovered in the routines of a Manager, which are retried or restarted",
+	}, []string{"proxy", "routine"})
+
+	lastReconcileTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_reconcile_timestamp_seconds",
+		Help:      "Unix time the last reconcile of the Proxy finished at, whether it failed or not",
+	}, []string{"proxy"})
+
+	controllerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "controller_request_duration_seconds",
+		Help:      "Latency of the requests to the Controller API, by operation and result",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"operation", "result"})
+
+	controllerRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "controller_request_errors_total",
+		Help:      "Number of failed requests to the Controller API, by operation and class of error",
+	}, []string{"operation", "class"})
+
+	proxyClientIPPreserved = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "proxy_client_ip_preserved",
+		Help:      "Whether the Proxy Service settings preserve the client IPs of its connections when last checked",
+	}, []string{"proxy"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		registrationAttempts,
+		proxyRollbacks,
+		proxyImageInfo,
+		proxyPortConnections,
+		proxyPortReceivedBytes,
+		proxyPortSentBytes,
+		proxyPortReachable,
+		proxyExternalPathUp,
+		proxyClientIPPreserved,
+		controllerRequestDuration,
+		controllerRequestErrors,
+		lastReconcileTime,
+		panicsRecovered,
+	)
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// Delete the per-port series of a port that left the cache, so it is not reported until the next scrape or probe
+func deletePortSeries(proxy string, port int, queue string) {
+	labels := []string{proxy, strconv.Itoa(port), queue}
+	proxyPortConnections.DeleteLabelValues(labels...)
+	proxyPortReceivedBytes.DeleteLabelValues(labels...)
+	proxyPortSentBytes.DeleteLabelValues(labels...)
+	proxyPortReachable.DeleteLabelValues(labels...)
+}
+
+func observeRegistration(proxy string, err error) {
+	registrationAttempts.WithLabelValues(proxy, resultLabel(err)).Inc()
+}
+
+// Record a request to the Controller API, so its slowness can be told apart from the Kubernetes API
+func observeControllerRequest(operation string, start time.Time, err error) {
+	controllerRequestDuration.WithLabelValues(operation, resultLabel(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		controllerRequestErrors.WithLabelValues(operation, getControllerErrorClass(err)).Inc()
+	}
+}
+
+// Class of a failed Controller request, distinguishing unreachable, slow, rejecting and failing Controllers
+func getControllerErrorClass(err error) string {
+	code := 0
+	var statusErr *controllerStatusError
+	var httpErr *ioclient.HTTPError
+	var notFoundErr *ioclient.NotFoundError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr):
+		code = statusErr.code
+	case errors.As(err, &httpErr):
+		code = httpErr.Code
+	case errors.As(err, &notFoundErr):
+		code = http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "connection"
+	default:
+		return "other"
+	}
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return "unauthorized"
+	case code == http.StatusNotFound:
+		return "not_found"
+	case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+		return "timeout"
+	case code >= 500:
+		return "server_error"
+	default:
+		return "client_error"
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+)
+
+var errMicroserviceGetter = errors.New("the Controller client cannot look up microservices")
+
+// Controller clients able to look up a microservice, e.g. the ioFog SDK client
+type microserviceGetter interface {
+	GetMicroserviceByID(uuid string) (*ioclient.MicroserviceInfo, error)
+}
+
+// Look up a microservice, microservices are looked up again once their lookup is older than the refresh interval so
+// changes of their name or environment are picked up, see forgetMicroservices
+// A failed lookup keeps the previous one cached, to be retried on the next call
+func (mgr *Manager) getMicroservice(ctx context.Context, uuid string) (*ioclient.MicroserviceInfo, error) {
+	if msvc, exists := mgr.microservices[uuid]; exists && time.Since(mgr.microserviceLookups[uuid]) < pkg.microserviceRefresh {
+		return msvc, nil
+	}
+	getter, ok := bindControllerClient(ctx, mgr.ioClient).(microserviceGetter)
+	if !ok {
+		return nil, errMicroserviceGetter
+	}
+	msvc, err := getter.GetMicroserviceByID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	mgr.microservices[uuid] = msvc
+	mgr.microserviceLookups[uuid] = time.Now()
+	return msvc, nil
+}
+
+// Forget the microservices no longer exposing any of the ports reported by the Controller, so renames are picked up
+// when they expose a port again
+func (mgr *Manager) forgetMicroservices(ports []ioclient.MicroservicePublicPort) {
+	owners := make(map[string]bool)
+	for _, port := range ports {
+		if matchesProtocolFilter(port.PublicPort.Protocol, mgr.opt.ProtocolFilter) {
+			owners[port.MicroserviceUUID] = true
+		}
+	}
+	for uuid := range mgr.microservices {
+		if !owners[uuid] {
+			delete(mgr.microservices, uuid)
+			delete(mgr.microserviceLookups, uuid)
+		}
+	}
+}
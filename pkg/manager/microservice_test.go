@@ -0,0 +1,63 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestMicroserviceCache(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient()
+	ioClient.SetMicroservices(ioclient.MicroserviceInfo{UUID: "abc", Name: "web", Application: "shop"})
+	mgr, _ := newFakeManager(t, ioClient)
+	tmpl, err := ParseHostnameTemplate("{{.Microservice}}.{{.Application}}.edge.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr.hostnameTemplate = tmpl
+	mgr.opt.MicroserviceMetadata = true
+	port := ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}}
+	mgr.cache[5000] = port.PublicPort
+	mgr.portOwners[5000] = "abc"
+	if !mgr.updateHostnames(ctx) || !mgr.updatePortMetadata(ctx) {
+		t.Fatal("Expected hostnames and metadata to change")
+	}
+
+	// The hostnames and the metadata share the lookup of the microservice
+	ioClient.SetError(errors.New("unavailable"))
+	mgr.forgetMicroservices([]ioclient.MicroservicePublicPort{port})
+	mgr.updateHostnames(ctx)
+	mgr.updatePortMetadata(ctx)
+	for _, operation := range []string{statusErrorHostname, statusErrorMetadata} {
+		if _, failing := mgr.status.get()[operation]; failing {
+			t.Errorf("Expected %s of a cached microservice not to be looked up again", operation)
+		}
+	}
+
+	// A microservice no longer exposing a port is looked up again, e.g. after a rename
+	ioClient.SetError(nil)
+	ioClient.SetMicroservices(ioclient.MicroserviceInfo{UUID: "abc", Name: "api", Application: "shop"})
+	mgr.forgetMicroservices(nil)
+	if !mgr.updateHostnames(ctx) || mgr.hostnames[5000] != "api.shop.edge.example.com" {
+		t.Errorf("Expected hostname of the renamed microservice, got %q", mgr.hostnames[5000])
+	}
+	if !mgr.updatePortMetadata(ctx) || mgr.portMetadata[5000].Microservice != "api" {
+		t.Errorf("Expected metadata of the renamed microservice, got %v", mgr.portMetadata[5000])
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// Maximum size of a notification body, notifications only carry a hint of the change
+const notificationMaxBytes = 4096
+
+// Change notification of the public ports posted by the Controller or iofogctl
+// The body is optional, a protocol only triggers the Proxies serving ports of that protocol
+type portsNotification struct {
+	Protocol string `json:"protocol"`
+}
+
+// NewNotificationHandler serves POST /notify, upon which the Managers poll the Controller immediately rather than at their next poll
+// Notifications received during a reconcile are coalesced into a single reconcile, it is authenticated by a bearer token
+func NewNotificationHandler(mgrs []*Manager, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/notify", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		notification := portsNotification{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, notificationMaxBytes)).Decode(&notification); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid notification: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, mgr := range mgrs {
+			mgr.onPortsNotification(notification)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return withBearerToken(mux, token)
+}
+
+// Poll the Controller immediately if the Proxy serves the protocol of the notification
+func (mgr *Manager) onPortsNotification(notification portsNotification) {
+	if notification.Protocol == "" || matchesProtocolFilter(notification.Protocol, mgr.opt.ProtocolFilter) {
+		mgr.log.V(1).Info("Received public ports notification", "protocol", notification.Protocol)
+		mgr.triggerReconcile()
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestNotificationHandler(t *testing.T) {
+	newManager := func(name, filter string) *Manager {
+		return &Manager{opt: &Options{ProxyName: name, ProtocolFilter: filter}, log: logf.Log, events: make(chan event.GenericEvent, 1)}
+	}
+	httpMgr, tcpMgr := newManager("http-proxy", "HTTP"), newManager("tcp-proxy", "TCP")
+	handler := NewNotificationHandler([]*Manager{httpMgr, tcpMgr}, "secret")
+
+	// Only the Proxies of the protocol reconcile
+	req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(`{"protocol":"ws"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusAccepted || len(httpMgr.events) != 1 || len(tcpMgr.events) != 0 {
+		t.Errorf("Expected only the HTTP Proxy to reconcile, got %d", resp.Code)
+	}
+
+	// An empty body reconciles all Proxies, repeated notifications are coalesced
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest(http.MethodPost, "/notify", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if len(httpMgr.events) != 1 || len(tcpMgr.events) != 1 {
+		t.Error("Expected a single pending reconcile of each Proxy")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(`{"protocol":`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid notification to be rejected, got %d", resp.Code)
+	}
+}
+
+func TestNotificationMessage(t *testing.T) {
+	mgr := &Manager{opt: &Options{ProxyName: "tcp-proxy", ProtocolFilter: "TCP"}, log: logf.Log, events: make(chan event.GenericEvent, 1)}
+	mgr.onNotificationMessage([]byte(`{"protocol":"http"}`))
+	if len(mgr.events) != 0 {
+		t.Error("Expected no reconcile for a notification of another protocol")
+	}
+	// A change happened even if it cannot be narrowed down
+	mgr.onNotificationMessage([]byte("ports changed"))
+	if len(mgr.events) != 1 {
+		t.Error("Expected invalid notification to trigger a reconcile")
+	}
+}
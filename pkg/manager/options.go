@@ -0,0 +1,150 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Option configures a Manager, see New
+type Option func(*Options)
+
+// DefaultOptions returns the Options a Manager starts from before any Option is applied
+// A single LoadBalancer Proxy exposing ports of all protocols
+func DefaultOptions() Options {
+	return Options{
+		ProxyName:             "http-proxy",
+		ProxyServiceType:      "LoadBalancer",
+		RouterScheme:          routerSchemeAMQP,
+		RouterService:         defaultRouterService,
+		OwnerName:             pkg.managerName,
+		ProxyReadyTimeout:     pkg.proxyReadyTimeout,
+		PortDrainPeriod:       time.Second * 30,
+		ProxyAutoRollback:     true,
+		ProxyConfigSpillSize:  32 * 1024,
+		ProxyStatsPath:        "/stats",
+		ProxyStatsInterval:    time.Second * 30,
+		ProxyMinReplicas:      1,
+		ProxyMaxReplicas:      1,
+		ProxyScaleDownDelay:   time.Minute * 5,
+		PortProbeTimeout:      time.Second * 5,
+		ExternalCheckSample:   defaultExternalCheckSample,
+		AuditMaxEntries:       500,
+		AlertFailurePeriod:    time.Minute * 5,
+		AlertRegisterFailures: 5,
+		PortConflictScope:     PortConflictScopeNamespace,
+		AllowPrivilegedPorts:  true,
+		ClientIPCheck:         true,
+		ControllerTimeout:     controllerRequestTimeout,
+		ControllerCallTimeout: controllerCallTimeout,
+	}
+}
+
+// WithOptions replaces all Options, including the defaults
+func WithOptions(opt Options) Option {
+	return func(o *Options) {
+		*o = opt
+	}
+}
+
+// WithNamespace sets the Namespace of the Controller and Proxy resources
+func WithNamespace(namespace string) Option {
+	return func(o *Options) {
+		o.Namespace = namespace
+	}
+}
+
+// WithCredentials sets the Controller user, the password may be base64 encoded
+func WithCredentials(email, password string) Option {
+	return func(o *Options) {
+		o.UserEmail = email
+		o.UserPass = password
+	}
+}
+
+// WithProxyImage sets the image of the Proxy Deployment
+func WithProxyImage(image string) Option {
+	return func(o *Options) {
+		o.ProxyImage = image
+	}
+}
+
+// WithProxy sets the name of the Proxy resources and the protocol of the ports it exposes, all if empty
+func WithProxy(name, protocol string) Option {
+	return func(o *Options) {
+		o.ProxyName = name
+		o.ProtocolFilter = protocol
+	}
+}
+
+// WithExternalAddress exposes the Proxy through a ClusterIP Service and registers address instead of a LoadBalancer address
+func WithExternalAddress(address string) Option {
+	return func(o *Options) {
+		o.ProxyServiceType = "ClusterIP"
+		o.ProxyExternalAddress = address
+	}
+}
+
+// WithRouterAddresses sets the routers the Proxy connects to
+func WithRouterAddresses(addrs ...RouterAddress) Option {
+	return func(o *Options) {
+		o.RouterAddresses = addrs
+	}
+}
+
+// WithPortRegistry shares the registry with the other Managers exposing ports on the same addresses
+func WithPortRegistry(registry *PortRegistry) Option {
+	return func(o *Options) {
+		o.PortRegistry = registry
+	}
+}
+
+// WithConfig sets the config the Kubernetes clients are created from
+func WithConfig(cfg *rest.Config) Option {
+	return func(o *Options) {
+		o.Config = cfg
+	}
+}
+
+// WithK8sClient replaces the Kubernetes client created from the config
+func WithK8sClient(client k8sclient.Client) Option {
+	return func(o *Options) {
+		o.K8sClient = client
+	}
+}
+
+// WithControllerClient replaces the Controller API client, e.g. with a client already logged in
+func WithControllerClient(client ControllerClient) Option {
+	return func(o *Options) {
+		o.ControllerClient = client
+	}
+}
+
+// WithLoadBalancerWaiter replaces the client waiting for the LoadBalancer address
+func WithLoadBalancerWaiter(waiter LoadBalancerWaiter) Option {
+	return func(o *Options) {
+		o.LoadBalancerWaiter = waiter
+	}
+}
+
+// WithEventRecorder replaces the recorder of the Events of the Proxy resources
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(o *Options) {
+		o.EventRecorder = recorder
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+)
+
+const (
+	// Environment variable of a microservice listing the public ports it keeps off the Proxy, e.g. 5000,6000-6010 for
+	// ports only exposed on the LAN of its Agent
+	portOptOutEnv = "IOFOG_PORT_MANAGER_EXCLUDE"
+
+	statusErrorOptOut = "opt-out"
+)
+
+// Drop the ports their microservice opted out of, see PortOptOut
+// Microservices are looked up again after the refresh interval so opting out takes effect without a restart, see
+// getMicroservice
+// Ports of a microservice that cannot be looked up follow its previous lookup, and are skipped without one
+func (mgr *Manager) filterOptedOutPorts(ctx context.Context, ports []ioclient.MicroservicePublicPort) []ioclient.MicroservicePublicPort {
+	if !mgr.opt.PortOptOut {
+		return ports
+	}
+	exclusions := make(map[string]PortRanges)
+	failed := make(map[string]bool)
+	optedOut := make(map[int]bool)
+	var errs []string
+	kept := make([]ioclient.MicroservicePublicPort, 0, len(ports))
+	for _, port := range ports {
+		uuid := port.MicroserviceUUID
+		if _, exists := exclusions[uuid]; !exists && uuid != "" && !failed[uuid] {
+			msvc, err := mgr.getMicroservice(ctx, uuid)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("microservice %s: %s", uuid, err.Error()))
+				msvc = mgr.microservices[uuid]
+			}
+			if msvc == nil {
+				failed[uuid] = true
+			} else if ranges, err := getPortOptOut(msvc); err != nil {
+				errs = append(errs, fmt.Sprintf("microservice %s: %s", uuid, err.Error()))
+				failed[uuid] = true
+			} else {
+				exclusions[uuid] = ranges
+			}
+		}
+		if failed[uuid] || exclusions[uuid].Contains(port.PublicPort.Port) {
+			if !mgr.optedOutPorts[port.PublicPort.Port] {
+				msg := "Not exposing port, the microservice opted out"
+				if failed[uuid] {
+					msg = "Not exposing port, the microservice could not be looked up"
+				}
+				mgr.log.Info(msg, "port", port.PublicPort.Port, "microservice", uuid)
+			}
+			optedOut[port.PublicPort.Port] = true
+			continue
+		}
+		kept = append(kept, port)
+	}
+	mgr.optedOutPorts = optedOut
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		mgr.status.setError(statusErrorOptOut, fmt.Errorf("%s", strings.Join(errs, ", ")))
+	} else {
+		mgr.status.setError(statusErrorOptOut, nil)
+	}
+	return kept
+}
+
+// Get the public ports a microservice opted out of
+func getPortOptOut(msvc *ioclient.MicroserviceInfo) (PortRanges, error) {
+	for _, env := range msvc.Env {
+		if env.Key != portOptOutEnv {
+			continue
+		}
+		ranges, err := ParsePortRanges(env.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", portOptOutEnv, err.Error())
+		}
+		return ranges, nil
+	}
+	return nil, nil
+}
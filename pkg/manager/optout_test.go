@@ -0,0 +1,75 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestPortOptOut(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "def", PublicPort: ioclient.PublicPort{Port: 7000, Protocol: "tcp", Queue: "def-7000"}},
+	)
+	ioClient.SetMicroservices(
+		ioclient.MicroserviceInfo{UUID: "abc", Env: []ioclient.MicroserviceEnvironmentInfo{{Key: portOptOutEnv, Value: "6000-6010"}}},
+		ioclient.MicroserviceInfo{UUID: "def"},
+	)
+	mgr, _ := newFakeManager(t, ioClient)
+	mgr.opt.PortOptOut = true
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	if _, exists := mgr.cache[6000]; exists || len(mgr.cache) != 2 {
+		t.Errorf("Expected the opted out port to be skipped, got %v", mgr.cache)
+	}
+
+	// Opting in again is only looked up after the refresh interval
+	ioClient.SetMicroservices(ioclient.MicroserviceInfo{UUID: "abc"}, ioclient.MicroserviceInfo{UUID: "def"})
+	_ = mgr.run(ctx)
+	if _, exists := mgr.cache[6000]; exists {
+		t.Errorf("Expected the cached lookup to keep the port skipped, got %v", mgr.cache)
+	}
+	refresh := pkg.microserviceRefresh
+	pkg.microserviceRefresh = 0
+	defer func() { pkg.microserviceRefresh = refresh }()
+
+	// Failed lookups fall back to the previous lookup, ports of microservices never looked up are skipped
+	ioClient.SetMicroservices()
+	ports := append(ioClient.ports, ioclient.MicroservicePublicPort{MicroserviceUUID: "ghi", PublicPort: ioclient.PublicPort{Port: 8000, Protocol: "tcp", Queue: "ghi-8000"}})
+	ioClient.SetPorts(ports...)
+	_ = mgr.run(ctx)
+	if _, exists := mgr.cache[6000]; exists || len(mgr.cache) != 2 {
+		t.Errorf("Expected the opted out port and the port of the unknown microservice to be skipped, got %v", mgr.cache)
+	}
+	if _, exists := mgr.status.errors[statusErrorOptOut]; !exists {
+		t.Error("Expected failed lookups in the status")
+	}
+
+	// Opting in again exposes the port once looked up
+	ioClient.SetMicroservices(ioclient.MicroserviceInfo{UUID: "abc"}, ioclient.MicroserviceInfo{UUID: "def"}, ioclient.MicroserviceInfo{UUID: "ghi"})
+	_ = mgr.run(ctx)
+	if len(mgr.cache) != 4 {
+		t.Errorf("Expected all ports to be exposed, got %v", mgr.cache)
+	}
+	if _, exists := mgr.status.errors[statusErrorOptOut]; exists {
+		t.Error("Expected the failed lookups to be cleared from the status")
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	portsv1alpha1 "github.com/eclipse-iofog/port-manager/v3/api/portmanager/v1alpha1"
+)
+
+const (
+	// OwnerModeReference sets the Manager Deployment as owner of the resources, so they are garbage collected with it
+	OwnerModeReference = "reference"
+	// OwnerModeLabels labels the resources with the owner name instead, for Managers not run by a Deployment
+	// Nothing blocks their deletion, the resources are deleted by their label when the program stops, see Cleanup
+	OwnerModeLabels = "labels"
+
+	// Set on the resources of the labels owner mode to the owner name
+	managedByLabel = "iofog.org/managed-by"
+)
+
+// ParseOwnerMode validates how the resources are tied to the Manager, empty sets owner references
+func ParseOwnerMode(value string) (string, error) {
+	switch mode := strings.ToLower(value); mode {
+	case "", OwnerModeReference, OwnerModeLabels:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown owner mode %s, expected %s or %s", value, OwnerModeReference, OwnerModeLabels)
+	}
+}
+
+// The owner name is a Deployment name and the value of the managed-by label
+func validateOwnerName(name string) error {
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(name); len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func setManagedByLabel(obj metav1.Object, opt *Options) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[managedByLabel] = opt.OwnerName
+	obj.SetLabels(labels)
+}
+
+// Cleanup deletes the resources labelled with the owner name in the labels owner mode, once the program stops for good
+// In the reference mode they are garbage collected with the owner Deployment instead, so nothing is deleted
+// The deletions are only requested, not waited for, and the other Managers of the owner delete the kinds they create
+func (mgr *Manager) Cleanup(ctx context.Context) error {
+	if mgr.opt.OwnerMode != OwnerModeLabels {
+		return nil
+	}
+	lists := []k8sclient.ObjectList{&appsv1.DeploymentList{}, &appsv1.StatefulSetList{}, &corev1.ServiceList{}, &corev1.ConfigMapList{}}
+	if mgr.opt.PublicPortResources {
+		lists = append(lists, &portsv1alpha1.PublicPortList{})
+	}
+	var kinds []schema.GroupVersionKind
+	if mgr.opt.ServiceExportAPI != "" {
+		kinds = append(kinds, schema.FromAPIVersionAndKind(mgr.opt.ServiceExportAPI, serviceExportKind))
+	}
+	if renderer := routeRenderers[mgr.opt.IngressMode]; renderer != nil {
+		kinds = append(kinds, renderer.kinds()...)
+	}
+	for _, gvk := range kinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		lists = append(lists, list)
+	}
+
+	deleted := 0
+	for _, list := range lists {
+		if err := mgr.k8sClient.List(ctx, list, k8sclient.InNamespace(mgr.opt.Namespace), k8sclient.MatchingLabels{managedByLabel: mgr.opt.OwnerName}); err != nil {
+			// Optional APIs may not be installed
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			obj, ok := item.(k8sclient.Object)
+			if !ok {
+				continue
+			}
+			if err := mgr.k8sClient.Delete(ctx, obj, k8sclient.PropagationPolicy(metav1.DeletePropagationBackground)); k8sclient.IgnoreNotFound(err) != nil {
+				return err
+			}
+			deleted++
+		}
+	}
+	mgr.log.Info("Deleted labelled resources", "owner", mgr.opt.OwnerName, "count", deleted)
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const reasonPanicRecovered = "PanicRecovered"
+
+// Panic of a routine of the Manager, recovered into an error
+type panicError struct {
+	routine string
+	value   interface{}
+}
+
+func (err *panicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v [recovered]", err.routine, err.value)
+}
+
+// Recover a panic into an error, logging its stack, counting it and reporting it with an Event
+// Deferred directly so recover stops the panic
+func (mgr *Manager) recoverPanic(routine string, err *error) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	panicErr := &panicError{routine: routine, value: value}
+	mgr.log.Error(panicErr, "Recovered from panic", "routine", routine, "stack", string(debug.Stack()))
+	panicsRecovered.WithLabelValues(mgr.opt.ProxyName, routine).Inc()
+	mgr.recorder.Eventf(mgr.getOwnerObjectReference(), corev1.EventTypeWarning, reasonPanicRecovered, "Proxy %s recovered from a panic in %s: %v", mgr.opt.ProxyName, routine, value)
+	*err = panicErr
+}
+
+func (mgr *Manager) callRecovering(routine string, call func() error) (err error) {
+	defer mgr.recoverPanic(routine, &err)
+	return call()
+}
+
+// Run a routine until ctx is done, restarting it with exponential backoff after each panic
+func (mgr *Manager) runRoutine(ctx context.Context, routine string, run func(context.Context)) {
+	delay := pkg.retryBaseDelay
+	for {
+		err := mgr.callRecovering(routine, func() error {
+			run(ctx)
+			return nil
+		})
+		if err == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > pkg.retryMaxDelay {
+			delay = pkg.retryMaxDelay
+		}
+		mgr.getLog(ctx).Info("Restarting routine after panic", "routine", routine)
+	}
+}
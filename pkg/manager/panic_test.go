@@ -0,0 +1,72 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Controller client panicking on each query, e.g. on an unexpected response
+type panickingControllerClient struct {
+	*FakeControllerClient
+}
+
+func (clt *panickingControllerClient) GetAllMicroservicePublicPorts() ([]ioclient.MicroservicePublicPort, error) {
+	panic("unexpected response")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	ctx := context.Background()
+	mgr, _ := newFakeManager(t, &panickingControllerClient{NewFakeControllerClient()})
+	mgr.cacheGenerated = true
+
+	// A panicking reconcile fails and is retried with a rebuilt cache
+	_, err := mgr.Reconcile(ctx, reconcile.Request{})
+	var panicErr *panicError
+	if !errors.As(err, &panicErr) || panicErr.routine != "reconcile" {
+		t.Fatalf("Expected recovered panic, got %v", err)
+	}
+	if atomic.LoadInt32(&mgr.rebuildRequested) != 1 {
+		t.Error("Expected cache to be rebuilt after a panic")
+	}
+	if _, failing := mgr.status.get()[statusErrorReconcile]; !failing {
+		t.Error("Expected panic to be reported in the status")
+	}
+	if events := mgr.recorder.(*record.FakeRecorder).Events; len(events) != 1 {
+		t.Errorf("Expected a single Event, got %d", len(events))
+	}
+	metric := dto.Metric{}
+	if err := panicsRecovered.WithLabelValues("http-proxy", "reconcile").Write(&metric); err != nil || metric.GetCounter().GetValue() < 1 {
+		t.Error("Expected panic to be counted")
+	}
+
+	// A routine is restarted after a panic until it returns
+	runs := 0
+	mgr.runRoutine(ctx, "test", func(context.Context) {
+		if runs++; runs == 1 {
+			panic("first run")
+		}
+	})
+	if runs != 2 {
+		t.Errorf("Expected routine to be restarted once, ran %d times", runs)
+	}
+}
@@ -26,6 +26,18 @@ var pkg struct {
 	controllerPort        int
 	managerName           string
 	pollInterval          time.Duration
+	loadBalancerTimeout   int64
+	registerBackoffBase   time.Duration
+	registerBackoffCap    time.Duration
+	registerBackoffSteps  int
+	retryBaseDelay        time.Duration
+	retryMaxDelay         time.Duration
+	proxyReadyTimeout     time.Duration
+	readyPollInterval     time.Duration
+	microserviceRefresh   time.Duration
+	registryTimeout       time.Duration
+	webhookTimeout        time.Duration
+	statusConfigMapName   string
 }
 
 func init() {
@@ -33,4 +45,16 @@ func init() {
 	pkg.controllerPort = 51121
 	pkg.managerName = "port-manager"
 	pkg.pollInterval = time.Second * 10
+	pkg.loadBalancerTimeout = 60
+	pkg.registerBackoffBase = time.Second * 5
+	pkg.registerBackoffCap = time.Minute * 5
+	pkg.registerBackoffSteps = 10
+	pkg.retryBaseDelay = time.Second
+	pkg.retryMaxDelay = time.Minute
+	pkg.proxyReadyTimeout = time.Minute * 2
+	pkg.readyPollInterval = time.Second * 2
+	pkg.microserviceRefresh = time.Minute
+	pkg.registryTimeout = time.Second * 30
+	pkg.webhookTimeout = time.Second * 10
+	pkg.statusConfigMapName = "port-manager-status"
 }
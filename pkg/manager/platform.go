@@ -0,0 +1,228 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type imagePlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+}
+
+type imageManifest struct {
+	Manifests []struct {
+		Platform imagePlatform `json:"platform"`
+	} `json:"manifests"`
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+// Get the operating systems and architectures an image is published for
+// Multi-platform images list them in their index, single platform images in their config blob
+func getImagePlatforms(ctx context.Context, image string) (oses, arches []string, err error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return
+	}
+	manifest := imageManifest{}
+	if err = getRegistryJSON(ctx, ref.manifestURL(ref.reference()), &manifest); err != nil {
+		return
+	}
+
+	platforms := make([]imagePlatform, 0)
+	for _, item := range manifest.Manifests {
+		platforms = append(platforms, item.Platform)
+	}
+	if len(platforms) == 0 && manifest.Config.Digest != "" {
+		platform := imagePlatform{}
+		if err = getRegistryJSON(ctx, ref.blobURL(manifest.Config.Digest), &platform); err != nil {
+			return
+		}
+		platforms = append(platforms, platform)
+	}
+
+	osSet := make(map[string]bool)
+	archSet := make(map[string]bool)
+	for _, platform := range platforms {
+		// Skip attestation manifests and other non-runnable entries
+		if platform.OS == "" || platform.OS == "unknown" {
+			continue
+		}
+		osSet[platform.OS] = true
+		archSet[platform.Architecture] = true
+	}
+	return sortedKeys(osSet), sortedKeys(archSet), nil
+}
+
+func getRegistryJSON(ctx context.Context, requestURL string, result interface{}) error {
+	resp, err := registryRequest(ctx, http.MethodGet, requestURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry returned %s for %s", resp.Status, requestURL)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Detect the platforms supported by the Proxy image, unless they are configured explicitly
+func (mgr *Manager) detectProxyPlatforms() {
+	if len(mgr.opt.ProxyNodeOS) > 0 || len(mgr.opt.ProxyNodeArch) > 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pkg.registryTimeout)
+	defer cancel()
+	oses, arches, err := getImagePlatforms(ctx, mgr.opt.ProxyImage)
+	if err != nil {
+		// Scheduling constraints are best effort, the Proxy can still be deployed without them
+		mgr.getLog(ctx).Error(err, "Failed to detect Proxy image platforms")
+		return
+	}
+	mgr.opt.ProxyNodeOS = oses
+	mgr.opt.ProxyNodeArch = arches
+	mgr.getLog(ctx).Info("Detected Proxy image platforms", "os", oses, "arch", arches)
+}
+
+// Restrict the Proxy pods to the selected nodes of a compatible operating system and architecture
+// Single values are expressed as a node selector, multiple values as required node affinity
+func setNodePlacement(dep *appsv1.Deployment, opt *Options) {
+	podSpec := &dep.Spec.Template.Spec
+	setNodeSelector(dep, opt.ProxyNodeSelector)
+	requirements := make([]corev1.NodeSelectorRequirement, 0)
+	for _, constraint := range []struct {
+		label  string
+		values []string
+	}{
+		{label: corev1.LabelOSStable, values: opt.ProxyNodeOS},
+		{label: corev1.LabelArchStable, values: opt.ProxyNodeArch},
+	} {
+		delete(podSpec.NodeSelector, constraint.label)
+		switch len(constraint.values) {
+		case 0:
+		case 1:
+			if podSpec.NodeSelector == nil {
+				podSpec.NodeSelector = make(map[string]string)
+			}
+			podSpec.NodeSelector[constraint.label] = constraint.values[0]
+		default:
+			requirements = append(requirements, corev1.NodeSelectorRequirement{
+				Key:      constraint.label,
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   constraint.values,
+			})
+		}
+	}
+	if len(podSpec.NodeSelector) == 0 {
+		podSpec.NodeSelector = nil
+	}
+	setNodeAffinity(podSpec, requirements)
+}
+
+// Replace the node selector keys set before with the configured ones, recording them in an annotation of the Deployment
+// Keys set by others are kept
+func setNodeSelector(dep *appsv1.Deployment, selector map[string]string) {
+	podSpec := &dep.Spec.Template.Spec
+	if previous := dep.Annotations[nodeSelectorAnnotation]; previous != "" {
+		for _, key := range strings.Split(previous, ",") {
+			delete(podSpec.NodeSelector, key)
+		}
+	}
+	keys := make(map[string]bool, len(selector))
+	for key, value := range selector {
+		if podSpec.NodeSelector == nil {
+			podSpec.NodeSelector = make(map[string]string)
+		}
+		podSpec.NodeSelector[key] = value
+		keys[key] = true
+	}
+	if len(keys) == 0 {
+		delete(dep.Annotations, nodeSelectorAnnotation)
+		return
+	}
+	if dep.Annotations == nil {
+		dep.Annotations = make(map[string]string)
+	}
+	dep.Annotations[nodeSelectorAnnotation] = strings.Join(sortedKeys(keys), ",")
+}
+
+// Replace the operating system and architecture requirements of the required node affinity
+// Other constraints, e.g. pod anti-affinity or node pools set by others, are kept
+func setNodeAffinity(podSpec *corev1.PodSpec, requirements []corev1.NodeSelectorRequirement) {
+	var terms []corev1.NodeSelectorTerm
+	if podSpec.Affinity != nil && podSpec.Affinity.NodeAffinity != nil && podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		terms = podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	}
+	kept := make([]corev1.NodeSelectorTerm, 0, len(terms))
+	for _, term := range terms {
+		expressions := make([]corev1.NodeSelectorRequirement, 0, len(term.MatchExpressions)+len(requirements))
+		for _, expression := range term.MatchExpressions {
+			if expression.Key != corev1.LabelOSStable && expression.Key != corev1.LabelArchStable {
+				expressions = append(expressions, expression)
+			}
+		}
+		if len(expressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+		// Terms are alternatives, so each of them must hold the requirements
+		term.MatchExpressions = append(expressions, requirements...)
+		kept = append(kept, term)
+	}
+	if len(kept) == 0 && len(requirements) > 0 {
+		kept = append(kept, corev1.NodeSelectorTerm{MatchExpressions: requirements})
+	}
+
+	if len(kept) > 0 {
+		if podSpec.Affinity == nil {
+			podSpec.Affinity = &corev1.Affinity{}
+		}
+		if podSpec.Affinity.NodeAffinity == nil {
+			podSpec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+		}
+		podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{NodeSelectorTerms: kept}
+		return
+	}
+	if podSpec.Affinity == nil || podSpec.Affinity.NodeAffinity == nil {
+		return
+	}
+	podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = nil
+	if len(podSpec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) == 0 {
+		podSpec.Affinity.NodeAffinity = nil
+	}
+	if podSpec.Affinity.NodeAffinity == nil && podSpec.Affinity.PodAffinity == nil && podSpec.Affinity.PodAntiAffinity == nil {
+		podSpec.Affinity = nil
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The Proxy pod template is shared with other controllers, e.g. service mesh injectors adding containers and volumes,
+// so the port-manager only replaces the entries it owns and keeps the others when it updates the Proxy Deployment
+
+// Whether a volume of the Proxy pods is mounted by the port-manager
+func isManagedVolume(name string) bool {
+	return name == routerConfigVolume || name == proxyTLSVolume || strings.HasPrefix(name, basicAuthVolumePrefix)
+}
+
+// Remove the volumes the port-manager mounts into the Proxy container, keeping the volumes of others
+func removeManagedVolumes(podSpec *corev1.PodSpec, container *corev1.Container) {
+	volumes := make([]corev1.Volume, 0, len(podSpec.Volumes))
+	for _, volume := range podSpec.Volumes {
+		if !isManagedVolume(volume.Name) {
+			volumes = append(volumes, volume)
+		}
+	}
+	podSpec.Volumes = volumes
+	mounts := make([]corev1.VolumeMount, 0, len(container.VolumeMounts))
+	for _, mount := range container.VolumeMounts {
+		if !isManagedVolume(mount.Name) {
+			mounts = append(mounts, mount)
+		}
+	}
+	container.VolumeMounts = mounts
+}
+
+// Set an environment variable of a container, keeping the others
+func setEnvVar(container *corev1.Container, name, value string) {
+	for idx := range container.Env {
+		if container.Env[idx].Name == name {
+			container.Env[idx] = corev1.EnvVar{Name: name, Value: value}
+			return
+		}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+}
+
+// Remove the named containers, keeping the order of the others
+func removeContainers(containers []corev1.Container, names map[string]bool) []corev1.Container {
+	kept := make([]corev1.Container, 0, len(containers))
+	for _, container := range containers {
+		if !names[container.Name] {
+			kept = append(kept, container)
+		}
+	}
+	return kept
+}
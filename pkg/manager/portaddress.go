@@ -0,0 +1,52 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"reflect"
+)
+
+const statusErrorPortAddress = "port-address"
+
+// External address of each cached port overriding the Proxy address, e.g. ports fronted by an existing LB or CDN,
+// otherwise the address of the Service exposing a port overriding the Proxy Service type, see getServiceTypeAddresses
+func (mgr *Manager) getPortAddresses(ctx context.Context) (map[int]string, error) {
+	addresses, err := mgr.getServiceTypeAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for port := range mgr.cache {
+		if address := mgr.opt.PortSettings.Get(port).ExternalAddress; address != "" {
+			addresses[port] = address
+		}
+	}
+	return addresses, nil
+}
+
+// Record the addresses of the ports overriding the Proxy address, published in the status and port map ConfigMaps
+// The Controller only stores the addresses of the Proxies, so they are not registered with it
+func (mgr *Manager) updatePortAddresses(ctx context.Context) {
+	addresses, err := mgr.getPortAddresses(ctx)
+	mgr.status.setError(statusErrorPortAddress, err)
+	if err != nil {
+		mgr.getLog(ctx).Error(err, "Failed to get port addresses")
+		return
+	}
+	if reflect.DeepEqual(addresses, mgr.portAddresses) {
+		return
+	}
+	mgr.getLog(ctx).Info("Port addresses changed", "addresses", addresses)
+	mgr.portAddresses = addresses
+}
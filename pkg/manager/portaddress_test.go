@@ -0,0 +1,74 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestPortAddress(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}},
+	)
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.PortMapConfigMap = "iofog-public-ports"
+	rules, err := ParsePortSettings("5000:external-address=CDN.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr.opt.PortSettings = rules
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	mgr.observeReconcile(ctx, nil)
+	if addresses := mgr.getStatus().PortAddresses; len(addresses) != 1 || addresses[5000] != "cdn.example.com" {
+		t.Errorf("Expected the address of port 5000 in the status, got %v", addresses)
+	}
+
+	// The port map lists the address of each port, the Proxy address unless the port overrides it
+	cm := corev1.ConfigMap{}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: "iofog-public-ports", Namespace: "iofog"}, &cm); err != nil {
+		t.Fatal(err)
+	}
+	exported := []exportedPort{}
+	if err := json.Unmarshal([]byte(cm.Data["http-proxy.json"]), &exported); err != nil {
+		t.Fatal(err)
+	}
+	for _, port := range exported {
+		if expected := map[int]string{5000: "cdn.example.com"}[port.Port]; port.Address != expected {
+			t.Errorf("Expected port %d to have address %q, got %q", port.Port, expected, port.Address)
+		}
+	}
+
+	// Removed ports fall back to the Proxy address
+	ioClient.SetPorts(ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}})
+	mgr.opt.PortSettings = append(mgr.opt.PortSettings, PortSettingsRule{Ports: PortRange{From: 6000, To: 6000}, Settings: PortSettings{ExternalAddress: "10.0.0.9"}})
+	_ = mgr.run(ctx)
+	if addresses := mgr.getStatus().PortAddresses; len(addresses) != 1 || addresses[6000] != "10.0.0.9" {
+		t.Errorf("Expected only the address of port 6000 in the status, got %v", addresses)
+	}
+
+	if _, err := ParsePortSettings("5000:external-address=cdn_example"); err == nil {
+		t.Error("Expected an invalid address to be rejected")
+	}
+}
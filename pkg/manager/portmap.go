@@ -0,0 +1,125 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const portMapKeySuffix = ".json"
+
+// Entry of the port map ConfigMap
+type exportedPort struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Queue    string `json:"queue"`
+	// External address of the Proxy, empty until its address is registered, unless the port overrides it
+	Address string `json:"address,omitempty"`
+	// Microservice exposing the port, see MicroserviceMetadata
+	MicroserviceUUID string `json:"microserviceUuid,omitempty"`
+	Microservice     string `json:"microservice,omitempty"`
+	Application      string `json:"application,omitempty"`
+}
+
+func newExportedPorts(snapshot *cacheSnapshot) []exportedPort {
+	ports := make([]exportedPort, 0, len(snapshot.ports))
+	for _, port := range snapshot.ports {
+		meta := snapshot.metadata[port.Port]
+		address, exists := snapshot.addresses[port.Port]
+		if !exists {
+			address = snapshot.address
+		}
+		ports = append(ports, exportedPort{
+			Port:             port.Port,
+			Protocol:         port.Protocol,
+			Queue:            port.Queue,
+			Address:          address,
+			MicroserviceUUID: meta.UUID,
+			Microservice:     meta.Microservice,
+			Application:      meta.Application,
+		})
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Port < ports[j].Port })
+	return ports
+}
+
+// Write the reconciled port map of the Proxy to its key of the port map ConfigMap
+func (mgr *Manager) exportPortMap(ctx context.Context) error {
+	if mgr.opt.PortMapConfigMap == "" {
+		return nil
+	}
+	snapshot := mgr.loadSnapshot()
+	if snapshot == mgr.exportedSnapshot {
+		return nil
+	}
+	data, err := json.Marshal(newExportedPorts(snapshot))
+	if err != nil {
+		return err
+	}
+	if err := mgr.applyConfigMapKey(ctx, mgr.opt.PortMapConfigMap, mgr.opt.ProxyName+portMapKeySuffix, string(data)); err != nil {
+		return err
+	}
+	mgr.exportedSnapshot = snapshot
+	return nil
+}
+
+// Read the port map last exported by the Proxy from the port map ConfigMap
+// Used to warm start the cache when the Proxy Deployment is missing so restarts do not close and reopen ports
+func (mgr *Manager) restorePortMap(ctx context.Context) (portMap, error) {
+	ports := make(portMap)
+	if mgr.opt.PortMapConfigMap == "" {
+		return ports, nil
+	}
+	cm := corev1.ConfigMap{}
+	key := k8sclient.ObjectKey{Name: mgr.opt.PortMapConfigMap, Namespace: mgr.opt.Namespace}
+	if err := mgr.k8sClient.Get(ctx, key, &cm); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ports, nil
+		}
+		return nil, err
+	}
+	data, exists := cm.Data[mgr.opt.ProxyName+portMapKeySuffix]
+	if !exists {
+		return ports, nil
+	}
+	exported := []exportedPort{}
+	if err := json.Unmarshal([]byte(data), &exported); err != nil {
+		return nil, err
+	}
+	for _, entry := range exported {
+		port := ioclient.PublicPort{
+			Port:     entry.Port,
+			Protocol: entry.Protocol,
+			Queue:    entry.Queue,
+		}
+		err := validatePublicPort(port, mgr.opt.AllowPrivilegedPorts)
+		if err == nil {
+			err = validateQueue(port, mgr.opt.ProxyConfigEncoding)
+		}
+		if err != nil {
+			// Skip the entry, the port is re-added if the Controller still exposes it
+			mgr.getLog(ctx).Error(err, "Skipping invalid port map entry", "port", entry.Port)
+			continue
+		}
+		ports[port.Port] = port
+	}
+	return ports, nil
+}
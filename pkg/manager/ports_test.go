@@ -0,0 +1,674 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestProxyString(t *testing.T) {
+	port := ioclient.PublicPort{
+		Queue:    "W6R2RFNBgTYnLtLkQ6yCDDv979QLhFXb",
+		Port:     5000,
+		Protocol: "tcp",
+	}
+
+	config := createProxyString(port, &Options{})
+	if config != "tcp:5000=>amqp:W6R2RFNBgTYnLtLkQ6yCDDv979QLhFXb" {
+		t.Errorf("Failed to create Proxy string")
+	}
+}
+
+func TestValidatePublicPort(t *testing.T) {
+	valid := ioclient.PublicPort{Port: 80, Protocol: "http", Queue: "queue"}
+	if err := validatePublicPort(valid, true); err != nil {
+		t.Errorf("Expected valid port, got %s", err.Error())
+	}
+	if err := validatePublicPort(valid, false); err == nil {
+		t.Errorf("Expected privileged port to be rejected")
+	}
+	for _, invalid := range []ioclient.PublicPort{
+		{Port: 0, Protocol: "tcp", Queue: "queue"},
+		{Port: 70000, Protocol: "tcp", Queue: "queue"},
+		{Port: 5000, Protocol: "udp", Queue: "queue"},
+		{Port: 5000, Protocol: "tcp", Queue: ""},
+	} {
+		if err := validatePublicPort(invalid, true); err == nil {
+			t.Errorf("Expected error for port %v", invalid)
+		}
+	}
+}
+
+func TestServicePortName(t *testing.T) {
+	cases := map[string]string{
+		"":                 "http-5000",
+		"Web_UI":           "web-ui-5000",
+		"My Dashboard v2":  "my-dashboa-5000",
+		"paint -- studio ": "paint-stud-5000",
+		"123":              "port-5000",
+	}
+	for microservice, expected := range cases {
+		if name := getServicePortName(5000, "http", microservice); name != expected {
+			t.Errorf("Expected port name %s for microservice %q, got %s", expected, microservice, name)
+		}
+	}
+	if name := getServicePortName(65535, "", ""); name != "port-65535" {
+		t.Errorf("Expected port name port-65535 without a protocol, got %s", name)
+	}
+
+	// Ports are named after their microservice once it is known
+	mgr, _ := newFakeManager(t, NewFakeControllerClient())
+	mgr.cache[5000] = ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}
+	mgr.cache[6000] = ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "def-6000"}
+	mgr.portOwners[5000] = "abc"
+	mgr.microservices["abc"] = &ioclient.MicroserviceInfo{UUID: "abc", Name: "web"}
+	svc := newProxyService("iofog", "http-proxy", mgr.cache, "ClusterIP")
+	mgr.setServicePortNames(svc)
+	if svc.Spec.Ports[0].Name != "web-5000" || svc.Spec.Ports[1].Name != "tcp-6000" {
+		t.Errorf("Unexpected port names %v", svc.Spec.Ports)
+	}
+}
+
+func TestPortSettings(t *testing.T) {
+	rules, err := ParsePortSettings("5000-5010:max-connections=100;requests-per-second=10, 5005:max-connections=5")
+	if err != nil {
+		t.Fatalf("Failed to parse port settings: %s", err.Error())
+	}
+	if settings := rules.Get(5000); settings != (PortSettings{MaxConnections: 100, RequestsPerSecond: 10}) {
+		t.Errorf("Unexpected settings of port 5000: %v", settings)
+	}
+	if settings := rules.Get(5005); settings != (PortSettings{MaxConnections: 5, RequestsPerSecond: 10}) {
+		t.Errorf("Expected later rule to override max connections of port 5005, got %v", settings)
+	}
+	if settings := rules.Get(6000); settings != (PortSettings{}) {
+		t.Errorf("Expected no settings for port 6000, got %v", settings)
+	}
+	for _, invalid := range []string{"5000", "5000:max-connections", "5000:max-connections=-1", "5000:timeout=1", "5000:idle-timeout=10", "5000:forwarded-headers=yes", "a:max-connections=1"} {
+		if _, err := ParsePortSettings(invalid); err == nil {
+			t.Errorf("Expected error for port settings %s", invalid)
+		}
+	}
+
+	timeouts, err := ParsePortSettings("6000:idle-timeout=1h;connect-timeout=500ms")
+	if err != nil {
+		t.Fatalf("Failed to parse port timeouts: %s", err.Error())
+	}
+	if settings := timeouts.Get(6000).String(); settings != ";idle-timeout=3600000;connect-timeout=500" {
+		t.Errorf("Unexpected rendered timeouts %s", settings)
+	}
+
+	ports := portMap{
+		5000: {Port: 5000, Protocol: "http", Queue: "queue"},
+		6000: {Port: 6000, Protocol: "tcp", Queue: "other"},
+	}
+	forwarded, err := ParsePortSettings("1-65535:forwarded-headers=true")
+	if err != nil {
+		t.Fatalf("Failed to parse forwarded headers: %s", err.Error())
+	}
+	rules = append(rules, forwarded...)
+	config := createProxyConfig(ports, &Options{PortSettings: rules})
+	if config != "http:5000=>amqp:queue;max-connections=100;requests-per-second=10;forwarded-headers=true,tcp:6000=>amqp:other" {
+		t.Errorf("Unexpected Proxy config %s", config)
+	}
+	// An explicit false of a later rule overrides an earlier true
+	disabled, err := ParsePortSettings("5000:forwarded-headers=false")
+	if err != nil {
+		t.Fatalf("Failed to parse forwarded headers: %s", err.Error())
+	}
+	overridden := PortSettingsRules{forwarded[0], disabled[0]}
+	if settings := overridden.Get(5000); settings.String() != "" {
+		t.Errorf("Expected forwarded headers of port 5000 to be disabled, got %s", settings.String())
+	}
+	if settings := overridden.Get(5001); settings.String() != ";forwarded-headers=true" {
+		t.Errorf("Expected forwarded headers of port 5001 to be kept, got %s", settings.String())
+	}
+	port, err := decodeMicroservice("http:5000=>amqp:queue;max-connections=100;requests-per-second=10", ProxyConfigEncodingPlain)
+	if err != nil {
+		t.Fatalf("Failed to decode config item: %s", err.Error())
+	}
+	if *port != ports[5000] {
+		t.Errorf("Unexpected decoded port %v", *port)
+	}
+}
+
+func TestProxyCapabilities(t *testing.T) {
+	if _, err := ParseProxyCapabilities([]string{"port-settings", "teleport"}); err == nil {
+		t.Errorf("Expected unknown capability to be rejected")
+	}
+	capabilities, err := ParseProxyCapabilities([]string{" Port-Settings", ""})
+	if err != nil || len(capabilities) != 1 || capabilities[0] != ProxyCapabilityPortSettings {
+		t.Fatalf("Unexpected capabilities %v: %v", capabilities, err)
+	}
+	rules, err := ParsePortSettings("5000-5010:max-connections=100")
+	if err != nil {
+		t.Fatalf("Failed to parse port settings: %s", err.Error())
+	}
+	if err := checkProxyCapabilities(&Options{PortSettings: rules}); err == nil {
+		t.Errorf("Expected limits to be rejected without the %s capability", ProxyCapabilityPortSettings)
+	}
+	if err := checkProxyCapabilities(&Options{PortSettings: rules, ProxyCapabilities: capabilities}); err != nil {
+		t.Errorf("Expected limits to be accepted with the %s capability: %s", ProxyCapabilityPortSettings, err.Error())
+	}
+	// Settings that are not passed to the Proxy need no capability
+	rules, err = ParsePortSettings("5000:service-type=NodePort")
+	if err != nil {
+		t.Fatalf("Failed to parse port settings: %s", err.Error())
+	}
+	if err := checkProxyCapabilities(&Options{PortSettings: rules}); err != nil {
+		t.Errorf("Expected the Service type to be accepted: %s", err.Error())
+	}
+	if err := checkProxyCapabilities(&Options{ProxyConfigEncoding: ProxyConfigEncodingEscaped}); err == nil {
+		t.Errorf("Expected the escaped encoding to be rejected without the %s capability", ProxyCapabilityEscapedQueues)
+	}
+	if err := checkProxyCapabilities(&Options{ProxyConfigEncoding: ProxyConfigEncodingEscaped, ProxyCapabilities: []string{ProxyCapabilityEscapedQueues}}); err != nil {
+		t.Errorf("Expected the escaped encoding to be accepted with the %s capability: %s", ProxyCapabilityEscapedQueues, err.Error())
+	}
+	if err := checkProxyCapabilities(&Options{ProxyConfigEncoding: ProxyConfigEncodingJSON, ProxyCapabilities: []string{ProxyCapabilityEscapedQueues}}); err == nil {
+		t.Errorf("Expected the json encoding to be rejected without the %s capability", ProxyCapabilityJSONConfig)
+	}
+	if err := checkProxyCapabilities(&Options{ProxyConfigEncoding: ProxyConfigEncodingJSON, ProxyCapabilities: []string{ProxyCapabilityJSONConfig}}); err != nil {
+		t.Errorf("Expected the json encoding to be accepted with the %s capability: %s", ProxyCapabilityJSONConfig, err.Error())
+	}
+}
+
+func TestBasicAuthSettings(t *testing.T) {
+	rules, err := ParsePortSettings("5000-6000:basic-auth=dashboard-users,5001:basic-auth=admin-users")
+	if err != nil {
+		t.Fatalf("Failed to parse port settings: %s", err.Error())
+	}
+	if _, err := ParsePortSettings("5000:basic-auth=Not_A_Secret"); err == nil {
+		t.Errorf("Expected invalid Secret name to be rejected")
+	}
+	// Limits do not stand in for basic auth
+	if err := checkProxyCapabilities(&Options{PortSettings: rules, ProxyCapabilities: []string{ProxyCapabilityPortSettings}}); err == nil {
+		t.Errorf("Expected basic auth to be refused without the %s capability", ProxyCapabilityBasicAuth)
+	}
+	if err := checkProxyCapabilities(&Options{PortSettings: rules, ProxyCapabilities: []string{ProxyCapabilityBasicAuth}}); err != nil {
+		t.Errorf("Expected basic auth to be accepted with the %s capability: %s", ProxyCapabilityBasicAuth, err.Error())
+	}
+	ports := portMap{
+		5000: {Port: 5000, Protocol: "http", Queue: "queue"},
+		5001: {Port: 5001, Protocol: "http", Queue: "admin"},
+		6000: {Port: 6000, Protocol: "tcp", Queue: "other"},
+	}
+	config := createProxyConfig(ports, &Options{PortSettings: rules})
+	expected := "http:5000=>amqp:queue;basic-auth=/etc/basic-auth/dashboard-users," +
+		"http:5001=>amqp:admin;basic-auth=/etc/basic-auth/admin-users,tcp:6000=>amqp:other"
+	if config != expected {
+		t.Errorf("Unexpected Proxy config %s", config)
+	}
+
+	opt := DefaultOptions()
+	opt.RouterAddresses = []RouterAddress{{Host: "router", Port: 5671}}
+	opt.PortSettings = rules
+	dep, err := newProxyDeployment(&opt, 1, config, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create Proxy Deployment: %s", err.Error())
+	}
+	mounts := dep.Spec.Template.Spec.Containers[0].VolumeMounts
+	volumes := dep.Spec.Template.Spec.Volumes
+	if len(mounts) != 3 || len(volumes) != 3 {
+		t.Fatalf("Expected router config and two basic auth volumes, got %d mounts and %d volumes", len(mounts), len(volumes))
+	}
+	if mounts[1].MountPath != "/etc/basic-auth/admin-users" || volumes[1].Secret.SecretName != "admin-users" {
+		t.Errorf("Unexpected basic auth volume %v mounted at %s", volumes[1], mounts[1].MountPath)
+	}
+}
+
+func TestJWTSettings(t *testing.T) {
+	rules, err := ParsePortSettings("5000:jwks-url=https://issuer.example.com/keys;jwt-issuer=https://issuer.example.com;jwt-audience=api")
+	if err != nil {
+		t.Fatalf("Failed to parse port settings: %s", err.Error())
+	}
+	settings := rules.Get(5000)
+	if settings.forProtocol("http", &Options{}).String() != ";jwks-url=https://issuer.example.com/keys;jwt-issuer=https://issuer.example.com;jwt-audience=api" {
+		t.Errorf("Unexpected rendered JWT settings %s", settings.String())
+	}
+	if settings.forProtocol("tcp", &Options{}).String() != "" {
+		t.Errorf("Expected JWT settings to be dropped from tcp ports")
+	}
+	if err := checkProxyCapabilities(&Options{PortSettings: rules}); err == nil {
+		t.Errorf("Expected JWT validation to be refused without the %s capability", ProxyCapabilityJWT)
+	}
+	if err := checkProxyCapabilities(&Options{PortSettings: rules, ProxyCapabilities: []string{ProxyCapabilityJWT}}); err != nil {
+		t.Errorf("Expected JWT validation to be accepted with the %s capability: %s", ProxyCapabilityJWT, err.Error())
+	}
+	for _, invalid := range []string{
+		"5000:jwt-issuer=https://issuer.example.com",
+		"5000:jwks-url=issuer.example.com/keys",
+		"5000:jwks-url=http://issuer.example.com/keys",
+		"5000:jwks-url=https://issuer.example.com/keys;jwt-audience=a=>b",
+	} {
+		if _, err := ParsePortSettings(invalid); err == nil {
+			t.Errorf("Expected error for port settings %s", invalid)
+		}
+	}
+}
+
+func TestWebSocketPorts(t *testing.T) {
+	wsPort := ioclient.PublicPort{Port: 5000, Protocol: "ws", Queue: "queue"}
+	wsOpt := &Options{ProxyCapabilities: []string{ProxyCapabilityWebSocket}}
+	if config := createProxyString(wsPort, wsOpt); config != "ws:5000=>amqp:queue" {
+		t.Errorf("Unexpected ws config item %s", config)
+	}
+	// The stock Proxy does not know the ws protocol
+	config := createProxyConfig(portMap{5000: wsPort}, &Options{})
+	if config != "http:5000=>amqp:queue;ws=true" {
+		t.Errorf("Expected ws port to be passed as a flagged http port, got %s", config)
+	}
+	for _, config := range []string{config, createProxyString(wsPort, wsOpt)} {
+		port, err := decodeMicroservice(config, ProxyConfigEncodingPlain)
+		if err != nil || *port != wsPort {
+			t.Fatalf("Failed to decode ws config item %s: %v %v", config, port, err)
+		}
+	}
+	if err := verifyProxyConfig(createProxyConfig(portMap{5000: wsPort}, &Options{ProxyConfigEncoding: ProxyConfigEncodingJSON}), portMap{5000: wsPort}, ProxyConfigEncodingJSON); err != nil {
+		t.Errorf("Expected flagged ws port to decode from the JSON config: %s", err.Error())
+	}
+	if !matchesProtocolFilter("ws", "HTTP") || matchesProtocolFilter("ws", "TCP") || matchesProtocolFilter("http", "WS") {
+		t.Errorf("Expected ws ports to only be served by Proxies filtering http or ws")
+	}
+	opt := &Options{ProxyCapabilities: []string{ProxyCapabilityPortSettings}}
+	if settings := (PortSettings{RequestTimeout: time.Second}).forProtocol("ws", opt); settings != (PortSettings{IdleTimeout: streamIdleTimeout}) {
+		t.Errorf("Expected ws port to default the idle timeout and drop the request timeout, got %v", settings)
+	}
+	if settings := (PortSettings{}).forProtocol("ws", &Options{}); settings != (PortSettings{}) {
+		t.Errorf("Expected no idle timeout without the %s capability, got %v", ProxyCapabilityPortSettings, settings)
+	}
+	svcPort := generateServicePort(5000, "ws")
+	if svcPort.AppProtocol == nil || *svcPort.AppProtocol != "kubernetes.io/ws" {
+		t.Errorf("Expected ws app protocol, got %v", svcPort.AppProtocol)
+	}
+	if svcPort := generateServicePort(5000, "http"); svcPort.AppProtocol != nil {
+		t.Errorf("Expected no app protocol for http port, got %s", *svcPort.AppProtocol)
+	}
+}
+
+func TestGRPCPorts(t *testing.T) {
+	grpcPort := ioclient.PublicPort{Port: 5000, Protocol: "grpc", Queue: "queue"}
+	config := createProxyConfig(portMap{5000: grpcPort}, &Options{ProxyCapabilities: []string{ProxyCapabilityPortSettings}})
+	if config != "http2:5000=>amqp:queue;grpc=true;idle-timeout=3600000" {
+		t.Errorf("Unexpected Proxy config %s", config)
+	}
+	port, err := decodeMicroservice(config, ProxyConfigEncodingPlain)
+	if err != nil {
+		t.Fatalf("Failed to decode grpc config item: %s", err.Error())
+	}
+	if *port != grpcPort {
+		t.Errorf("Expected decoded grpc port, got %v", *port)
+	}
+	if !matchesProtocolFilter("grpc", "HTTP2") || matchesProtocolFilter("http2", "GRPC") {
+		t.Errorf("Expected grpc ports to be served by Proxies filtering http2")
+	}
+	if svcPort := generateServicePort(5000, "grpc"); svcPort.AppProtocol == nil || *svcPort.AppProtocol != "grpc" {
+		t.Errorf("Expected grpc app protocol, got %v", svcPort.AppProtocol)
+	}
+}
+
+// With an HTTP and a TCP Proxy, ws and grpc ports are served by the HTTP Proxy and neither Proxy rejects them
+func TestSplitProxyStreamPorts(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "ws", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5001, Protocol: "GRPC", Queue: "abc-5001"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}},
+	)
+	registry := NewPortRegistry()
+	httpMgr, _ := newFakeManager(t, ioClient)
+	tcpMgr, _ := newFakeManager(t, ioClient)
+	for _, mgr := range []*Manager{httpMgr, tcpMgr} {
+		mgr.opt.PortRegistry = registry
+	}
+	httpMgr.opt.ProtocolFilter = "HTTP"
+	tcpMgr.opt.ProxyName = "tcp-proxy"
+	tcpMgr.opt.ProtocolFilter = "TCP"
+	for _, mgr := range []*Manager{httpMgr, tcpMgr} {
+		if err := mgr.generateCache(ctx); err != nil {
+			t.Fatal(err)
+		}
+		_ = mgr.run(ctx)
+		mgr.observeReconcile(ctx, nil)
+		if rejected := mgr.getStatus().Rejected; len(rejected) != 0 {
+			t.Errorf("Expected %s to reject no ports, got %v", mgr.opt.ProxyName, rejected)
+		}
+	}
+	if config := createProxyConfig(httpMgr.cache, httpMgr.opt); config != "http:5000=>amqp:abc-5000;ws=true,http2:5001=>amqp:abc-5001;grpc=true" {
+		t.Errorf("Expected the HTTP Proxy to serve the ws and grpc ports, got %s", config)
+	}
+	if config := createProxyConfig(tcpMgr.cache, tcpMgr.opt); config != "tcp:6000=>amqp:abc-6000" {
+		t.Errorf("Expected the TCP Proxy to serve only the tcp port, got %s", config)
+	}
+}
+
+func TestProxyTLS(t *testing.T) {
+	opt := DefaultOptions()
+	opt.RouterAddresses = []RouterAddress{{Host: "router", Port: 5671}}
+	opt.ProxyTLSSecret = "wildcard-tls"
+	ports := portMap{
+		5000: {Port: 5000, Protocol: "http", Queue: "queue"},
+		6000: {Port: 6000, Protocol: "tcp", Queue: "other"},
+	}
+	config := createProxyConfig(ports, &opt)
+	if config != "http:5000=>amqp:queue;tls-cert=/etc/proxy-tls/tls.crt;tls-key=/etc/proxy-tls/tls.key,tcp:6000=>amqp:other" {
+		t.Errorf("Unexpected Proxy config %s", config)
+	}
+	dep, err := newProxyDeployment(&opt, 1, config, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create Proxy Deployment: %s", err.Error())
+	}
+	volumes := dep.Spec.Template.Spec.Volumes
+	if len(volumes) != 2 || volumes[1].Secret == nil || volumes[1].Secret.SecretName != "wildcard-tls" {
+		t.Errorf("Expected wildcard TLS Secret volume, got %v", volumes)
+	}
+}
+
+func TestTLSRedirect(t *testing.T) {
+	opt := &Options{ProxyTLSRedirectPort: 443}
+	if err := checkTLSRedirect(opt); err == nil {
+		t.Error("Expected the redirect to require a TLS Secret")
+	}
+	opt.ProxyTLSSecret = "wildcard-tls"
+	if err := checkTLSRedirect(opt); err == nil {
+		t.Errorf("Expected the redirect to require the %s capability", ProxyCapabilityTLSRedirect)
+	}
+	opt.ProxyCapabilities = []string{ProxyCapabilityTLSRedirect}
+	if err := checkTLSRedirect(opt); err != nil {
+		t.Errorf("Expected the redirect to be accepted: %s", err.Error())
+	}
+	if err := checkTLSRedirect(&Options{ProxyTLSSecret: "wildcard-tls", ProxyTLSRedirectPort: 80, ProxyCapabilities: opt.ProxyCapabilities}); err == nil {
+		t.Error("Expected port 80 to be rejected as the HTTPS port")
+	}
+
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 80, Protocol: "http", Queue: "abc-80"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 443, Protocol: "http", Queue: "abc-443"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "tcp", Queue: "abc-5000"}},
+	)
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyTLSSecret = opt.ProxyTLSSecret
+	mgr.opt.ProxyTLSRedirectPort = opt.ProxyTLSRedirectPort
+	mgr.opt.ProxyCapabilities = opt.ProxyCapabilities
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); !isPending(err) {
+		t.Fatalf("Expected pending update waiting for Proxy Deployment, got %v", err)
+	}
+	setProxyDeploymentReady(t, k8sClient, true)
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := mgr.cache[80]; exists {
+		t.Error("Expected port 80 serving the redirect not to be exposed")
+	}
+	expected := "http:443=>amqp:abc-443;tls-cert=/etc/proxy-tls/tls.crt;tls-key=/etc/proxy-tls/tls.key,tcp:5000=>amqp:abc-5000,redirect:80=>https:443"
+	if config := createProxyConfig(mgr.cache, mgr.opt); config != expected {
+		t.Errorf("Unexpected Proxy config %s", config)
+	}
+	if err := verifyProxyConfig(expected, mgr.cache, ProxyConfigEncodingPlain); err != nil {
+		t.Errorf("Expected the redirect item to be skipped when decoding: %s", err.Error())
+	}
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	svc := corev1.Service{}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if ports := svc.Spec.Ports; len(ports) != 3 || ports[2].Port != 80 || ports[2].Name != tlsRedirectPortName {
+		t.Errorf("Expected the Proxy Service to expose the redirect on port 80, got %v", ports)
+	}
+	if hasStaleServicePorts(&svc, mgr.getProxyServicePorts(), getTLSRedirectServicePorts(mgr.cache, mgr.opt)...) {
+		t.Error("Expected the redirect port not to mark the Proxy Service stale")
+	}
+	mgr.opt.ProxyConfigEncoding = ProxyConfigEncodingJSON
+	if config := createProxyConfig(mgr.cache, mgr.opt); !strings.HasSuffix(config, `"redirect":{"port":80,"httpsPort":443}}`) {
+		t.Errorf("Expected the redirect in the JSON Proxy config, got %s", config)
+	}
+	mgr.opt.ProxyConfigEncoding = ProxyConfigEncodingPlain
+
+	// The redirect is removed with its HTTPS port
+	ioClient.SetPorts(ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "tcp", Queue: "abc-5000"}})
+	if err := mgr.run(ctx); err != nil && !isPending(err) {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 5000 {
+		t.Errorf("Expected the redirect to be removed with port 443, got %v", svc.Spec.Ports)
+	}
+	if config := createProxyConfig(mgr.cache, mgr.opt); config != "tcp:5000=>amqp:abc-5000" {
+		t.Errorf("Unexpected Proxy config %s", config)
+	}
+}
+
+func TestLoadBalancerPreset(t *testing.T) {
+	opt := &Options{LoadBalancerPreset: LoadBalancerPresetDigitalOcean}
+	ports := portMap{5000: {Port: 5000, Protocol: "tcp", Queue: "abc-5000"}}
+	svc := newProxyService("iofog", "http-proxy", ports, "LoadBalancer")
+	svc.Annotations = map[string]string{"owner": "other"}
+	setLoadBalancerPreset(svc, opt)
+	if svc.Annotations["service.beta.kubernetes.io/do-loadbalancer-healthcheck-protocol"] != "tcp" || svc.Annotations["owner"] != "other" {
+		t.Errorf("Expected preset annotations to be added, got %v", svc.Annotations)
+	}
+	if svc.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeCluster {
+		t.Errorf("Expected Cluster traffic policy, got %s", svc.Spec.ExternalTrafficPolicy)
+	}
+
+	// Only LoadBalancer Services are changed
+	svc = newProxyService("iofog", "http-proxy", ports, "ClusterIP")
+	setLoadBalancerPreset(svc, opt)
+	if len(svc.Annotations) != 0 {
+		t.Errorf("Expected ClusterIP Service to be unchanged, got %v", svc.Annotations)
+	}
+
+	if _, err := ParseLoadBalancerPreset("aws-alb"); err == nil {
+		t.Error("Expected error for unknown preset")
+	}
+}
+
+func TestLoadBalancerIP(t *testing.T) {
+	ports := portMap{5000: {Port: 5000, Protocol: "tcp", Queue: "abc-5000"}}
+	svc := newProxyService("iofog", "http-proxy", ports, "LoadBalancer")
+	setLoadBalancerIP(svc, &Options{LoadBalancerIP: "203.0.113.10"})
+	if svc.Spec.LoadBalancerIP != "203.0.113.10" {
+		t.Errorf("Expected loadBalancerIP to be set, got %s", svc.Spec.LoadBalancerIP)
+	}
+
+	svc = newProxyService("iofog", "http-proxy", ports, "LoadBalancer")
+	setLoadBalancerIP(svc, &Options{LoadBalancerIP: "eipalloc-0a1b,eipalloc-2c3d", LoadBalancerPreset: LoadBalancerPresetAWSNLB})
+	if svc.Spec.LoadBalancerIP != "" || svc.Annotations[awsEIPAllocationsAnnotation] != "eipalloc-0a1b,eipalloc-2c3d" {
+		t.Errorf("Expected Elastic IP allocations annotation, got %v", svc.Annotations)
+	}
+
+	for _, value := range []string{"203.0.113", "eipalloc-", "eipalloc-0a1b,203.0.113.10"} {
+		if _, err := ParseLoadBalancerIP(value); err == nil {
+			t.Errorf("Expected error for %s", value)
+		}
+	}
+}
+
+func TestPreserveNodePorts(t *testing.T) {
+	ports := portMap{5000: {Port: 5000, Protocol: "tcp", Queue: "abc-5000"}}
+	svc := newProxyService("iofog", "http-proxy", ports, "LoadBalancer")
+	svc.Spec.Ports[0].NodePort = 31000
+
+	ports[6000] = ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}
+	modifyServiceSpec(svc, ports)
+	if len(svc.Spec.Ports) != 2 || svc.Spec.Ports[0].NodePort != 31000 || svc.Spec.Ports[1].NodePort != 0 {
+		t.Errorf("Expected the NodePort of port 5000 to be kept, got %v", svc.Spec.Ports)
+	}
+
+	// ClusterIP Services have no NodePorts
+	svc.Spec.Type = corev1.ServiceTypeClusterIP
+	modifyServiceSpec(svc, ports)
+	if svc.Spec.Ports[0].NodePort != 0 {
+		t.Errorf("Expected no NodePort on a ClusterIP Service, got %v", svc.Spec.Ports)
+	}
+}
+
+func TestProxyConfigEncoding(t *testing.T) {
+	port := ioclient.PublicPort{Port: 5000, Protocol: "tcp", Queue: "a,b;c=>d%"}
+	if err := validateQueue(port, ProxyConfigEncodingPlain); err == nil {
+		t.Error("Expected queue with separators to be rejected by the plain encoding")
+	}
+	if err := validateQueue(port, ProxyConfigEncodingEscaped); err != nil {
+		t.Error(err)
+	}
+	opt := &Options{ProxyConfigEncoding: ProxyConfigEncodingEscaped, RouterAddresses: []RouterAddress{{Host: "router", Port: 5671}}}
+	config := createProxyConfig(portMap{5000: port, 6000: {Port: 6000, Protocol: "http", Queue: "plain"}}, opt)
+	if config != "tcp:5000=>amqp:a%2Cb%3Bc%3D%3Ed%25,http:6000=>amqp:plain" {
+		t.Errorf("Unexpected escaped config %s", config)
+	}
+	decoded, err := decodeMicroservice(strings.Split(config, ",")[0], ProxyConfigEncodingEscaped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decoded != port {
+		t.Errorf("Expected %v to be decoded, got %v", port, *decoded)
+	}
+
+	// Deployments without the annotation were created with the plain encoding
+	dep, err := newProxyDeployment(opt, 1, config, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding := getProxyConfigEncoding(dep); encoding != ProxyConfigEncodingEscaped {
+		t.Errorf("Expected escaped encoding to be recorded, got %s", encoding)
+	}
+	opt.ProxyConfigEncoding = ProxyConfigEncodingPlain
+	if err := updateProxyConfig(dep, opt, "http:6000=>amqp:plain"); err != nil {
+		t.Fatal(err)
+	}
+	if encoding := getProxyConfigEncoding(dep); encoding != ProxyConfigEncodingPlain {
+		t.Errorf("Expected plain encoding, got %s", encoding)
+	}
+}
+
+func TestProxyConfigJSON(t *testing.T) {
+	rules, err := ParsePortSettings("5000:max-connections=100;idle-timeout=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := &Options{ProxyConfigEncoding: ProxyConfigEncodingJSON, PortSettings: rules, ProxyCapabilities: []string{ProxyCapabilityPortSettings}}
+	ports := portMap{
+		5000: {Port: 5000, Protocol: "tcp", Queue: "a,b;c=>d"},
+		6000: {Port: 6000, Protocol: "grpc", Queue: "grpc"},
+	}
+	config := createProxyConfig(ports, opt)
+	expected := `{"version":1,"ports":[{"protocol":"tcp","port":5000,"queue":"a,b;c=>d","maxConnections":100,"idleTimeoutMs":60000},` +
+		`{"protocol":"http2","port":6000,"queue":"grpc","grpc":true,"idleTimeoutMs":3600000}]}`
+	if config != expected {
+		t.Errorf("Unexpected JSON config %s", config)
+	}
+	if err := validateQueue(ports[5000], ProxyConfigEncodingJSON); err != nil {
+		t.Error(err)
+	}
+	decoded, errs := decodeProxyConfig(config, ProxyConfigEncodingJSON)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(decoded) != 2 || decoded[0] != ports[5000] || decoded[1] != ports[6000] {
+		t.Errorf("Expected %v to be decoded, got %v", ports, decoded)
+	}
+
+	// Unknown versions and protocols are rejected
+	if _, errs := decodeProxyConfig(`{"version":2,"ports":[]}`, ProxyConfigEncodingJSON); len(errs) != 1 {
+		t.Errorf("Expected unsupported version to be rejected, got %v", errs)
+	}
+	decoded, errs = decodeProxyConfig(`{"version":1,"ports":[{"protocol":"udp","port":5000,"queue":"a"},{"protocol":"tcp","port":6000,"queue":"b"}]}`, ProxyConfigEncodingJSON)
+	if len(errs) != 1 || len(decoded) != 1 || decoded[0].Port != 6000 {
+		t.Errorf("Expected only the udp port to be skipped, got %v %v", decoded, errs)
+	}
+}
+
+func TestMigrateProxyConfig(t *testing.T) {
+	config, migrated, err := migrateProxyConfig("HTTP:5000=>amqp:abc, tcp:6000=>amqp:def,", proxyConfigLegacyFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !migrated || config != "http:5000=>amqp:abc,tcp:6000=>amqp:def" {
+		t.Errorf("Unexpected migrated config %s", config)
+	}
+	if _, migrated, err := migrateProxyConfig("http:5000=>amqp:abc", proxyConfigCurrentFormat); err != nil || migrated {
+		t.Errorf("Expected current config not to be migrated, got %v %v", migrated, err)
+	}
+	if _, _, err := migrateProxyConfig("http:5000=>amqp:abc", proxyConfigCurrentFormat+1); err == nil {
+		t.Error("Expected config of a newer format to be rejected")
+	}
+}
+
+func TestVerifyProxyConfig(t *testing.T) {
+	ports := portMap{
+		5000: {Port: 5000, Protocol: "http", Queue: "abc"},
+		6000: {Port: 6000, Protocol: "grpc", Queue: "def"},
+	}
+	opt := &Options{}
+	for _, encoding := range []string{ProxyConfigEncodingPlain, ProxyConfigEncodingEscaped, ProxyConfigEncodingJSON} {
+		opt.ProxyConfigEncoding = encoding
+		if err := verifyProxyConfig(createProxyConfig(ports, opt), ports, encoding); err != nil {
+			t.Errorf("Expected %s config to round trip: %v", encoding, err)
+		}
+	}
+
+	// Ports lost or corrupted by the encoder are caught
+	if err := verifyProxyConfig("http:5000=>amqp:abc", ports, ProxyConfigEncodingPlain); err == nil {
+		t.Error("Expected lost port to be detected")
+	}
+	if err := verifyProxyConfig("http:5000=>amqp:abc,http2:6000=>amqp:def", ports, ProxyConfigEncodingPlain); err == nil {
+		t.Error("Expected corrupted port to be detected")
+	}
+	if err := verifyProxyConfig("HTTP:5000=>amqp:abc,http2:6000=>amqp:def;grpc=true", ports, ProxyConfigEncodingPlain); err == nil {
+		t.Error("Expected undecodable item to be detected")
+	}
+}
+
+func TestSetNodePlacement(t *testing.T) {
+	pool := corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"edge"}}
+	dep := &appsv1.Deployment{}
+	dep.Spec.Template.Spec.Affinity = &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{pool}}},
+			},
+		},
+	}
+
+	// The requirements are added to the constraints of others
+	setNodePlacement(dep, &Options{ProxyNodeArch: []string{"amd64", "arm64"}})
+	terms := dep.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 || len(terms[0].MatchExpressions) != 2 || terms[0].MatchExpressions[0].Key != "pool" || terms[0].MatchExpressions[1].Key != corev1.LabelArchStable {
+		t.Errorf("Unexpected node affinity %v", terms)
+	}
+	setNodePlacement(dep, &Options{})
+	terms = dep.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 || len(terms[0].MatchExpressions) != 1 || terms[0].MatchExpressions[0].Key != pool.Key {
+		t.Errorf("Expected only the node pool to be kept, got %v", terms)
+	}
+
+	dep = &appsv1.Deployment{}
+	setNodePlacement(dep, &Options{ProxyNodeArch: []string{"amd64", "arm64"}})
+	setNodePlacement(dep, &Options{})
+	if dep.Spec.Template.Spec.Affinity != nil {
+		t.Errorf("Expected node affinity to be removed, got %v", dep.Spec.Template.Spec.Affinity)
+	}
+}
@@ -0,0 +1,325 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Keys of the per-port settings, used in PORT_SETTINGS and the Proxy config items
+const (
+	portSettingMaxConnections    = "max-connections"
+	portSettingRequestsPerSecond = "requests-per-second"
+	portSettingIdleTimeout       = "idle-timeout"
+	portSettingConnectTimeout    = "connect-timeout"
+	portSettingRequestTimeout    = "request-timeout"
+	portSettingForwardedHeaders  = "forwarded-headers"
+	portSettingBasicAuth         = "basic-auth"
+	portSettingJWTIssuer         = "jwt-issuer"
+	portSettingJWKSURL           = "jwks-url"
+	portSettingJWTAudience       = "jwt-audience"
+	portSettingServiceType       = "service-type"
+	portSettingExternalAddress   = "external-address"
+)
+
+// PortSettings are enforced by the Proxy on the traffic of a public port, zero values are not enforced
+type PortSettings struct {
+	// Concurrent connections accepted on the port
+	MaxConnections int
+	// Requests accepted per second on an http or http2 port, new connections per second on a tcp port
+	RequestsPerSecond int
+	// Time a connection may be idle before the Proxy closes it, e.g. long for MQTT sessions
+	IdleTimeout time.Duration
+	// Time the Proxy waits for the router to accept a new connection
+	ConnectTimeout time.Duration
+	// Time an http or http2 request may take before the Proxy answers with a gateway timeout
+	RequestTimeout time.Duration
+	// Add X-Forwarded-For, X-Forwarded-Proto and Forwarded headers to http and http2 requests
+	// Unset unless a rule sets it, so a later rule can turn it off for some of the ports of an earlier one
+	ForwardedHeaders *bool
+	// Secret of username and password pairs the Proxy checks the basic auth of http and http2 requests against
+	BasicAuthSecret string
+	// Bearer tokens of http and http2 requests must be signed by a key of JWKSURL and, if set, match the issuer and audience
+	JWKSURL     string
+	JWTIssuer   string
+	JWTAudience string
+	// Type of the Service exposing the port instead of the Proxy Service type, not passed to the Proxy
+	ServiceType string
+	// Address of the port listed instead of the Proxy address, e.g. of an existing LB or CDN
+	// fronting the port, not passed to the Proxy, see ParseExternalAddress
+	ExternalAddress string
+}
+
+// PortSettingsRule applies Settings to the ports in Ports
+type PortSettingsRule struct {
+	Ports    PortRange
+	Settings PortSettings
+}
+
+type PortSettingsRules []PortSettingsRule
+
+// Get the settings of a port, later rules override the settings earlier rules set
+func (rules PortSettingsRules) Get(port int) (settings PortSettings) {
+	for _, rule := range rules {
+		if port >= rule.Ports.From && port <= rule.Ports.To {
+			settings.override(rule.Settings)
+		}
+	}
+	return settings
+}
+
+func (settings *PortSettings) override(other PortSettings) {
+	if other.MaxConnections != 0 {
+		settings.MaxConnections = other.MaxConnections
+	}
+	if other.RequestsPerSecond != 0 {
+		settings.RequestsPerSecond = other.RequestsPerSecond
+	}
+	if other.IdleTimeout != 0 {
+		settings.IdleTimeout = other.IdleTimeout
+	}
+	if other.ConnectTimeout != 0 {
+		settings.ConnectTimeout = other.ConnectTimeout
+	}
+	if other.RequestTimeout != 0 {
+		settings.RequestTimeout = other.RequestTimeout
+	}
+	if other.ForwardedHeaders != nil {
+		settings.ForwardedHeaders = other.ForwardedHeaders
+	}
+	if other.BasicAuthSecret != "" {
+		settings.BasicAuthSecret = other.BasicAuthSecret
+	}
+	// Token validation is overridden as a whole so an issuer is never checked against the keys of another
+	if other.JWKSURL != "" {
+		settings.JWKSURL = other.JWKSURL
+		settings.JWTIssuer = other.JWTIssuer
+		settings.JWTAudience = other.JWTAudience
+	}
+	if other.ServiceType != "" {
+		settings.ServiceType = other.ServiceType
+	}
+	if other.ExternalAddress != "" {
+		settings.ExternalAddress = other.ExternalAddress
+	}
+}
+
+// Reject the settings the Proxy image lacks the capability for
+func (settings PortSettings) checkCapabilities(opt *Options) error {
+	if settings.MaxConnections != 0 || settings.RequestsPerSecond != 0 || settings.IdleTimeout != 0 ||
+		settings.ConnectTimeout != 0 || settings.RequestTimeout != 0 || settings.forwardsHeaders() {
+		if err := requireProxyCapability(opt, ProxyCapabilityPortSettings, "limits, timeouts and forwarded headers"); err != nil {
+			return err
+		}
+	}
+	if settings.BasicAuthSecret != "" {
+		if err := requireProxyCapability(opt, ProxyCapabilityBasicAuth, portSettingBasicAuth); err != nil {
+			return err
+		}
+	}
+	if settings.JWKSURL != "" {
+		return requireProxyCapability(opt, ProxyCapabilityJWT, "JWT validation")
+	}
+	return nil
+}
+
+func (settings PortSettings) forwardsHeaders() bool {
+	return settings.ForwardedHeaders != nil && *settings.ForwardedHeaders
+}
+
+// Idle timeout of ws and grpc ports without one, WebSocket sessions and gRPC streams are long-lived
+const streamIdleTimeout = time.Hour
+
+// Drop the settings that only apply to http and http2 ports from tcp ports
+// ws and grpc ports default to a long idle timeout, if the Proxy takes timeouts, and drop the request timeout, which would cut upgraded connections and streams
+func (settings PortSettings) forProtocol(protocol string, opt *Options) PortSettings {
+	if protocol == "ws" || protocol == "grpc" {
+		settings.RequestTimeout = 0
+		if settings.IdleTimeout == 0 && hasProxyCapability(opt, ProxyCapabilityPortSettings) {
+			settings.IdleTimeout = streamIdleTimeout
+		}
+	}
+	if !isHTTPProtocol(protocol) {
+		settings.RequestTimeout = 0
+		settings.ForwardedHeaders = nil
+		settings.BasicAuthSecret = ""
+		settings.JWKSURL = ""
+		settings.JWTIssuer = ""
+		settings.JWTAudience = ""
+	}
+	return settings
+}
+
+// Render the non-zero settings in a fixed order, e.g. ;max-connections=100;idle-timeout=30000
+// Timeouts are rendered in milliseconds
+func (settings PortSettings) String() string {
+	var b strings.Builder
+	if settings.MaxConnections != 0 {
+		fmt.Fprintf(&b, ";%s=%d", portSettingMaxConnections, settings.MaxConnections)
+	}
+	if settings.RequestsPerSecond != 0 {
+		fmt.Fprintf(&b, ";%s=%d", portSettingRequestsPerSecond, settings.RequestsPerSecond)
+	}
+	for _, timeout := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{portSettingIdleTimeout, settings.IdleTimeout},
+		{portSettingConnectTimeout, settings.ConnectTimeout},
+		{portSettingRequestTimeout, settings.RequestTimeout},
+	} {
+		if timeout.value != 0 {
+			fmt.Fprintf(&b, ";%s=%d", timeout.key, timeout.value.Milliseconds())
+		}
+	}
+	if settings.forwardsHeaders() {
+		fmt.Fprintf(&b, ";%s=true", portSettingForwardedHeaders)
+	}
+	// The Proxy is pointed at the mounted Secret
+	if settings.BasicAuthSecret != "" {
+		fmt.Fprintf(&b, ";%s=%s", portSettingBasicAuth, getBasicAuthPath(settings.BasicAuthSecret))
+	}
+	for _, setting := range []struct {
+		key   string
+		value string
+	}{
+		{portSettingJWKSURL, settings.JWKSURL},
+		{portSettingJWTIssuer, settings.JWTIssuer},
+		{portSettingJWTAudience, settings.JWTAudience},
+	} {
+		if setting.value != "" {
+			fmt.Fprintf(&b, ";%s=%s", setting.key, setting.value)
+		}
+	}
+	return b.String()
+}
+
+// ParsePortSettings parses a comma-separated list of ports or port ranges and their semicolon-separated settings
+// e.g. 5000-5010:max-connections=100;requests-per-second=10,1883:idle-timeout=1h
+func ParsePortSettings(value string) (rules PortSettingsRules, err error) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid port settings %s: expected ports:settings", item)
+		}
+		ranges, err := ParsePortRanges(parts[0])
+		if err != nil || len(ranges) != 1 {
+			return nil, fmt.Errorf("invalid port settings %s: expected a port or port range", item)
+		}
+		rule := PortSettingsRule{Ports: ranges[0]}
+		for _, setting := range strings.Split(parts[1], ";") {
+			if setting = strings.TrimSpace(setting); setting == "" {
+				continue
+			}
+			keyValue := strings.SplitN(setting, "=", 2)
+			if len(keyValue) != 2 {
+				return nil, fmt.Errorf("invalid port settings %s: expected key=value, got %s", item, setting)
+			}
+			if err := rule.Settings.set(strings.TrimSpace(keyValue[0]), strings.TrimSpace(keyValue[1])); err != nil {
+				return nil, fmt.Errorf("invalid port settings %s: %s", item, err.Error())
+			}
+		}
+		if rule.Settings.JWKSURL == "" && (rule.Settings.JWTIssuer != "" || rule.Settings.JWTAudience != "") {
+			return nil, fmt.Errorf("invalid port settings %s: %s and %s require %s", item, portSettingJWTIssuer, portSettingJWTAudience, portSettingJWKSURL)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (settings *PortSettings) set(key, value string) error {
+	switch key {
+	case portSettingMaxConnections:
+		return parseLimit(key, value, &settings.MaxConnections)
+	case portSettingRequestsPerSecond:
+		return parseLimit(key, value, &settings.RequestsPerSecond)
+	case portSettingIdleTimeout:
+		return parseTimeout(key, value, &settings.IdleTimeout)
+	case portSettingConnectTimeout:
+		return parseTimeout(key, value, &settings.ConnectTimeout)
+	case portSettingRequestTimeout:
+		return parseTimeout(key, value, &settings.RequestTimeout)
+	case portSettingForwardedHeaders:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", key)
+		}
+		settings.ForwardedHeaders = &enabled
+		return nil
+	case portSettingBasicAuth:
+		if errs := validation.IsDNS1123Subdomain(value); len(errs) > 0 {
+			return fmt.Errorf("%s must be a Secret name: %s", key, strings.Join(errs, ", "))
+		}
+		settings.BasicAuthSecret = value
+		return nil
+	case portSettingJWKSURL:
+		// Keys fetched over plain HTTP could be replaced in transit to forge tokens
+		jwksURL, err := url.Parse(value)
+		if err != nil || jwksURL.Scheme != "https" || jwksURL.Host == "" {
+			return fmt.Errorf("%s must be an https URL", key)
+		}
+		return parseConfigValue(key, value, &settings.JWKSURL)
+	case portSettingJWTIssuer:
+		return parseConfigValue(key, value, &settings.JWTIssuer)
+	case portSettingJWTAudience:
+		return parseConfigValue(key, value, &settings.JWTAudience)
+	case portSettingServiceType:
+		serviceType, err := ParseServiceType(value)
+		if err != nil {
+			return fmt.Errorf("%s %s", key, err.Error())
+		}
+		settings.ServiceType = serviceType
+		return nil
+	case portSettingExternalAddress:
+		address, err := ParseExternalAddress(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", key, err.Error())
+		}
+		settings.ExternalAddress = address
+		return nil
+	}
+	return fmt.Errorf("unknown setting %s", key)
+}
+
+func parseLimit(key, value string, limit *int) (err error) {
+	if *limit, err = strconv.Atoi(value); err != nil || *limit < 0 {
+		return fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	return nil
+}
+
+// Values are rendered verbatim into the Proxy config and must not contain its separators
+func parseConfigValue(key, value string, setting *string) error {
+	if value == "" || strings.ContainsAny(value, ",;") || strings.Contains(value, "=>") {
+		return fmt.Errorf("%s must be set without , ; or =>", key)
+	}
+	*setting = value
+	return nil
+}
+
+func parseTimeout(key, value string, timeout *time.Duration) (err error) {
+	if *timeout, err = time.ParseDuration(value); err != nil || *timeout < 0 {
+		return fmt.Errorf("%s must be a non-negative duration, e.g. 30s", key)
+	}
+	return nil
+}
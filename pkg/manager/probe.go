@@ -0,0 +1,249 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	reasonPortUnreachable = "PortUnreachable"
+	reasonPortReachable   = "PortReachable"
+
+	statusErrorProbe = "probe"
+
+	probeConcurrency = 10
+)
+
+// Periodically connects to each exposed port through the Proxy Service
+// so a broken Proxy or router bridge is detected before end users notice
+type portProber struct {
+	mgr        *Manager
+	httpClient *probeHTTPClient
+	// Error of each port found unreachable by the last probe
+	unreachable map[int]string
+	// Queue of each port with an exported series
+	exported map[int]string
+}
+
+func (mgr *Manager) newPortProber() *portProber {
+	return &portProber{
+		mgr:         mgr,
+		httpClient:  &probeHTTPClient{mgr: mgr},
+		unreachable: make(map[int]string),
+		exported:    make(map[int]string),
+	}
+}
+
+// HTTP client of the probes, rebuilt when the CA of the wildcard TLS Secret changes
+type probeHTTPClient struct {
+	mgr    *Manager
+	ca     []byte
+	client *http.Client
+}
+
+func (clt *probeHTTPClient) get(ctx context.Context) (*http.Client, error) {
+	ca, err := clt.mgr.getProxyTLSCA(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if clt.client != nil && bytes.Equal(ca, clt.ca) {
+		return clt.client, nil
+	}
+	client, err := newProbeHTTPClient(clt.mgr.opt.PortProbeTimeout, ca)
+	if err != nil {
+		return nil, err
+	}
+	clt.ca, clt.client = ca, client
+	return client, nil
+}
+
+// Create the HTTP client of the probes, verifying the Proxy certificate against a CA if one is given
+func newProbeHTTPClient(timeout time.Duration, ca []byte) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// The certificate covers the hostnames of the ports, not the probed address, so only its chain is verified
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS12} // nolint:gosec
+	if len(ca) > 0 {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid " + proxyTLSCAKey + " in Proxy TLS Secret")
+		}
+		transport.TLSClientConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyCertificateChain(state.PeerCertificates, roots)
+		}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// A redirect is a response, the port is reachable
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}, nil
+}
+
+func verifyCertificateChain(certs []*x509.Certificate, roots *x509.CertPool) error {
+	if len(certs) == 0 {
+		return errors.New("no certificate presented")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err
+}
+
+func (prober *portProber) run(ctx context.Context) {
+	ticker := time.NewTicker(prober.mgr.opt.PortProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			prober.deleteSeries(nil)
+			return
+		case <-ticker.C:
+		}
+		if err := prober.probe(ctx); err != nil {
+			prober.mgr.getLog(ctx).Error(err, "Failed to probe ports")
+		}
+	}
+}
+
+// Probe the cached ports, the result is published to the status ConfigMap by the next reconcile
+func (prober *portProber) probe(ctx context.Context) error {
+	mgr := prober.mgr
+	snapshot := mgr.loadSnapshot()
+	if snapshot == nil {
+		return nil
+	}
+	// Ports that are not exposed yet are not probed
+	hosts, err := mgr.getPortClusterIPs(ctx, snapshot.ports)
+	if err != nil {
+		return err
+	}
+	ports := make(portMap, len(hosts))
+	for port := range hosts {
+		ports[port] = snapshot.ports[port]
+	}
+	httpClient, err := prober.httpClient.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	results := make(map[int]error, len(ports))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, probeConcurrency)
+	for _, port := range ports {
+		wg.Add(1)
+		go func(port int, protocol string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			addr := net.JoinHostPort(hosts[port], strconv.Itoa(port))
+			err := probePort(ctx, httpClient, mgr.opt.PortProbeTimeout, addr, protocol, isTLSTerminated(mgr.opt, protocol))
+			mu.Lock()
+			results[port] = err
+			mu.Unlock()
+		}(port.Port, port.Protocol)
+	}
+	wg.Wait()
+
+	prober.deleteSeries(ports)
+	var msgs []string
+	unreachable := make(map[int]string)
+	for _, port := range ports {
+		labels := []string{mgr.opt.ProxyName, strconv.Itoa(port.Port), port.Queue}
+		prober.exported[port.Port] = port.Queue
+		err := results[port.Port]
+		if err == nil {
+			proxyPortReachable.WithLabelValues(labels...).Set(1)
+			if _, exists := prober.unreachable[port.Port]; exists {
+				mgr.getLog(ctx).Info("Port is reachable again", "port", port.Port)
+				mgr.recorder.Eventf(mgr.getOwnerObjectReference(), corev1.EventTypeNormal, reasonPortReachable, "Port %d is reachable through Proxy %s", port.Port, mgr.opt.ProxyName)
+			}
+			continue
+		}
+		proxyPortReachable.WithLabelValues(labels...).Set(0)
+		unreachable[port.Port] = err.Error()
+		msgs = append(msgs, fmt.Sprintf("port %d: %s", port.Port, err.Error()))
+		if _, exists := prober.unreachable[port.Port]; !exists {
+			mgr.getLog(ctx).Info("Port is unreachable", "port", port.Port, "error", err.Error())
+			mgr.recorder.Eventf(mgr.getOwnerObjectReference(), corev1.EventTypeWarning, reasonPortUnreachable, "Port %d is unreachable through Proxy %s: %s", port.Port, mgr.opt.ProxyName, err.Error())
+		}
+	}
+	prober.unreachable = unreachable
+	if len(msgs) == 0 {
+		mgr.status.setError(statusErrorProbe, nil)
+		return nil
+	}
+	sort.Strings(msgs)
+	mgr.status.setError(statusErrorProbe, fmt.Errorf("%s", strings.Join(msgs, ", ")))
+	return nil
+}
+
+// HTTP ports must answer a request without a gateway error, other ports must accept a connection
+// Ports the Proxy terminates TLS on are requested over HTTPS
+func probePort(ctx context.Context, httpClient *http.Client, timeout time.Duration, addr, protocol string, terminatesTLS bool) error {
+	if protocol != "http" && protocol != "ws" {
+		dialer := net.Dialer{Timeout: timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	scheme := "http"
+	if terminatesTLS {
+		scheme = "https"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+addr+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Delete the series of ports that are no longer exposed or whose queue changed
+func (prober *portProber) deleteSeries(ports portMap) {
+	for port, queue := range prober.exported {
+		if current, exists := ports[port]; exists && current.Queue == queue {
+			continue
+		}
+		proxyPortReachable.DeleteLabelValues(prober.mgr.opt.ProxyName, strconv.Itoa(port), queue)
+		delete(prober.exported, port)
+	}
+}
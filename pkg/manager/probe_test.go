@@ -0,0 +1,146 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/client-go/tools/record"
+)
+
+func getServerPort(t *testing.T, addr net.Addr) int {
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestProbePorts(t *testing.T) {
+	ctx := context.Background()
+	// HTTP port whose router bridge is broken and a healthy TCP port
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	httpPort, tcpPort := getServerPort(t, server.Listener.Addr()), getServerPort(t, listener.Addr())
+
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	recorder := record.NewFakeRecorder(10)
+	mgr.recorder = recorder
+	mgr.opt.PortProbeTimeout = time.Second
+	mgr.cache[httpPort] = ioclient.PublicPort{Port: httpPort, Protocol: "http", Queue: "abc-http"}
+	mgr.cache[tcpPort] = ioclient.PublicPort{Port: tcpPort, Protocol: "tcp", Queue: "abc-tcp"}
+	mgr.saveSnapshot()
+	svc := newProxyService("iofog", "http-proxy", mgr.cache, "ClusterIP")
+	svc.Spec.ClusterIP = "127.0.0.1"
+	if err := k8sClient.Create(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+
+	prober := mgr.newPortProber()
+	for idx := 0; idx < 2; idx++ {
+		if err := prober.probe(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	metric := dto.Metric{}
+	if err := proxyPortReachable.WithLabelValues("http-proxy", strconv.Itoa(httpPort), "abc-http").Write(&metric); err != nil || metric.GetGauge().GetValue() != 0 {
+		t.Error("Expected HTTP port to be unreachable")
+	}
+	if err := proxyPortReachable.WithLabelValues("http-proxy", strconv.Itoa(tcpPort), "abc-tcp").Write(&metric); err != nil || metric.GetGauge().GetValue() != 1 {
+		t.Error("Expected TCP port to be reachable")
+	}
+	if _, exists := mgr.status.get()[statusErrorProbe]; !exists {
+		t.Error("Expected unreachable port in status")
+	}
+	// An Event is only recorded when a port becomes unreachable
+	if len(recorder.Events) != 1 {
+		t.Errorf("Expected a single Event, got %d", len(recorder.Events))
+	}
+	prober.deleteSeries(nil)
+}
+
+func TestProbeTLSPort(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	for _, ca := range [][]byte{nil, serverCA} {
+		httpClient, err := newProbeHTTPClient(time.Second, ca)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := probePort(context.Background(), httpClient, time.Second, addr, "http", true); err != nil {
+			t.Errorf("Expected TLS port to be reachable, got %v", err)
+		}
+	}
+
+	// A certificate not signed by the CA of the TLS Secret fails the probe
+	httpClient, err := newProbeHTTPClient(time.Second, newTestCA(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := probePort(context.Background(), httpClient, time.Second, addr, "http", true); err == nil {
+		t.Error("Expected TLS port signed by another CA to fail the probe")
+	}
+	if _, err := newProbeHTTPClient(time.Second, []byte("invalid")); err == nil {
+		t.Error("Expected error for invalid CA")
+	}
+}
+
+func newTestCA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,612 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	proxyContainerName = "proxy"
+	proxyEntrypoint    = "/opt/app-root/bin/simple.js"
+	proxyConfigKey     = "proxy.conf"
+)
+
+func getProxyContainerArgs(config string) []string {
+	return []string{
+		"node",
+		proxyEntrypoint,
+		config,
+	}
+}
+
+// Node script run instead of the Proxy entrypoint when the config is spilled to the router ConfigMap
+// The config is read from the mounted file and handed over in memory so it is not subject to exec argument limits
+const spilledProxyConfigScript = `
+const fs = require('fs');
+process.argv = [process.argv[0], '%[2]s', fs.readFileSync('%[1]s', 'utf8')];
+require('%[2]s');
+`
+
+func getSpilledProxyContainerArgs() []string {
+	return []string{
+		"node",
+		"-e",
+		fmt.Sprintf(spilledProxyConfigScript, path.Join(routerConfigMountPath, proxyConfigKey), proxyEntrypoint),
+	}
+}
+
+// Values available to the argument template of the Proxy container
+type proxyArgsTemplateValues struct {
+	// Proxy config, e.g. http:5000=>amqp:abc-5000
+	Config string
+	// File the Proxy config is mounted at from the router ConfigMap
+	ConfigFile string
+}
+
+// ParseProxyArgs parses the argument template of Proxy images with another entrypoint,
+// e.g. /usr/bin/proxy,--config-file,{{.ConfigFile}}
+func ParseProxyArgs(args []string) ([]*template.Template, error) {
+	templates := make([]*template.Template, 0, len(args))
+	for idx, arg := range args {
+		tmpl, err := template.New(fmt.Sprintf("arg%d", idx)).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		// Catch unknown fields before the Proxy is deployed
+		if err := tmpl.Execute(&bytes.Buffer{}, proxyArgsTemplateValues{}); err != nil {
+			return nil, err
+		}
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
+
+// Whether the Proxy config is too large to be passed as a container argument
+// Custom argument templates always have the config mounted from the router ConfigMap, so they can refer to the file
+func isProxyConfigSpilled(opt *Options, config string) bool {
+	if len(opt.ProxyArgs) > 0 {
+		return true
+	}
+	return opt.ProxyConfigSpillSize > 0 && len(config) > opt.ProxyConfigSpillSize
+}
+
+func getProxyArgs(opt *Options, config string) ([]string, error) {
+	if len(opt.ProxyArgs) > 0 {
+		return renderProxyArgs(opt.ProxyArgs, config)
+	}
+	if isProxyConfigSpilled(opt, config) {
+		return getSpilledProxyContainerArgs(), nil
+	}
+	return getProxyContainerArgs(config), nil
+}
+
+func renderProxyArgs(args []string, config string) ([]string, error) {
+	templates, err := ParseProxyArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	values := proxyArgsTemplateValues{Config: config, ConfigFile: path.Join(routerConfigMountPath, proxyConfigKey)}
+	rendered := make([]string, 0, len(templates))
+	for _, tmpl := range templates {
+		buf := bytes.Buffer{}
+		if err := tmpl.Execute(&buf, values); err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, buf.String())
+	}
+	return rendered, nil
+}
+
+// Set the command and arguments of the Proxy container, an empty command runs the entrypoint of the image
+func setProxyArgs(dep *appsv1.Deployment, opt *Options, config string) error {
+	args, err := getProxyArgs(opt, config)
+	if err != nil {
+		return err
+	}
+	container := &dep.Spec.Template.Spec.Containers[0]
+	container.Command = opt.ProxyCommand
+	container.Args = args
+	return nil
+}
+func newProxyDeployment(opt *Options, replicas int32, config, configHash string, sidecars []corev1.Container) (*appsv1.Deployment, error) {
+	labels := map[string]string{
+		"name": opt.ProxyName,
+	}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opt.ProxyName,
+			Namespace: opt.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:            proxyContainerName,
+							Image:           opt.ProxyImage,
+							ImagePullPolicy: corev1.PullAlways,
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := setProxyArgs(dep, opt, config); err != nil {
+		return nil, err
+	}
+	setRouterConfig(dep, opt, configHash)
+	setBasicAuthSecrets(dep, opt)
+	setProxyTLSSecret(dep, opt)
+	setUpdateStrategy(dep, opt)
+	setNodePlacement(dep, opt)
+	setSidecars(dep, sidecars)
+	setProxyGroup(dep, opt)
+	setDeploymentCustomMetadata(dep, opt)
+	setProxyConfigFormat(dep, opt)
+	if err := setInitContainers(dep, opt); err != nil {
+		return nil, err
+	}
+	return dep, nil
+}
+
+// ParseDeploymentStrategy builds the Proxy Deployment strategy from its type and RollingUpdate parameters
+// Surge and unavailable values are either absolute numbers or percentages, e.g. 1 or 25%
+func ParseDeploymentStrategy(strategyType, maxSurge, maxUnavailable string) (*appsv1.DeploymentStrategy, error) {
+	switch appsv1.DeploymentStrategyType(strategyType) {
+	case "":
+		if maxSurge == "" && maxUnavailable == "" {
+			return nil, nil
+		}
+		strategyType = string(appsv1.RollingUpdateDeploymentStrategyType)
+	case appsv1.RecreateDeploymentStrategyType:
+		if maxSurge != "" || maxUnavailable != "" {
+			return nil, errors.New("max surge and max unavailable are only supported by the RollingUpdate strategy")
+		}
+		return &appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}, nil
+	case appsv1.RollingUpdateDeploymentStrategyType:
+	default:
+		return nil, fmt.Errorf("unsupported Deployment strategy %s", strategyType)
+	}
+
+	rollingUpdate := &appsv1.RollingUpdateDeployment{}
+	if maxSurge != "" {
+		value := intstr.Parse(maxSurge)
+		rollingUpdate.MaxSurge = &value
+	}
+	if maxUnavailable != "" {
+		value := intstr.Parse(maxUnavailable)
+		rollingUpdate.MaxUnavailable = &value
+	}
+	return &appsv1.DeploymentStrategy{
+		Type:          appsv1.DeploymentStrategyType(strategyType),
+		RollingUpdate: rollingUpdate,
+	}, nil
+}
+
+// Add or remove the init container waiting for the router, keeping the init containers of others
+func setInitContainers(dep *appsv1.Deployment, opt *Options) error {
+	podSpec := &dep.Spec.Template.Spec
+	podSpec.InitContainers = removeContainers(podSpec.InitContainers, map[string]bool{waitForRouterContainerName: true})
+	if !opt.ProxyWaitForRouter {
+		if len(podSpec.InitContainers) == 0 {
+			podSpec.InitContainers = nil
+		}
+		return nil
+	}
+	container, err := newWaitForRouterContainer(opt)
+	if err != nil {
+		return err
+	}
+	podSpec.InitContainers = append(podSpec.InitContainers, container)
+	return nil
+}
+
+func setProxyImage(dep *appsv1.Deployment, opt *Options) {
+	dep.Spec.Template.Spec.Containers[0].Image = opt.ProxyImage
+}
+
+// Apply the configured strategy and progress deadline, the API server defaults them when not configured
+func setUpdateStrategy(dep *appsv1.Deployment, opt *Options) {
+	if opt.ProxyStrategy != nil {
+		dep.Spec.Strategy = *opt.ProxyStrategy.DeepCopy()
+	}
+	if opt.ProxyProgressTimeout > 0 {
+		deadline := int32(opt.ProxyProgressTimeout.Seconds())
+		dep.Spec.ProgressDeadlineSeconds = &deadline
+	}
+}
+
+// Point the Proxy at the router(s) and mount the rendered router config
+// The config hash annotation rolls the Proxy pods whenever the router config changes
+// All volumes of the port-manager are removed, so the other volumes must be mounted again afterwards
+func setRouterConfig(dep *appsv1.Deployment, opt *Options, configHash string) {
+	podSpec := &dep.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	setEnvVar(container, "ICPROXY_BRIDGE_HOST", opt.RouterAddresses[0].Host)
+	removeManagedVolumes(podSpec, container)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      routerConfigVolume,
+		MountPath: routerConfigMountPath,
+		ReadOnly:  true,
+	})
+	// Router config and TLS credentials share the same directory
+	sources := []corev1.VolumeProjection{
+		{
+			ConfigMap: &corev1.ConfigMapProjection{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: getRouterConfigMapName(opt.ProxyName),
+				},
+			},
+		},
+	}
+	if opt.RouterTLSSecret != "" {
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: opt.RouterTLSSecret,
+				},
+				Items: []corev1.KeyToPath{
+					{Key: routerCAKey, Path: routerCAKey},
+					{Key: routerCertKey, Path: routerCertKey},
+					{Key: routerKeyKey, Path: routerKeyKey},
+				},
+			},
+		})
+	}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: routerConfigVolume,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: sources,
+			},
+		},
+	})
+	if dep.Spec.Template.Annotations == nil {
+		dep.Spec.Template.Annotations = make(map[string]string)
+	}
+	dep.Spec.Template.Annotations[configHashAnnotation] = configHash
+}
+
+func newProxyService(namespace, name string, ports portMap, svcType string, extraPorts ...corev1.ServicePort) *corev1.Service {
+	labels := map[string]string{
+		"name": name,
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:                  corev1.ServiceType(svcType),
+			ExternalTrafficPolicy: getTrafficPolicy(svcType),
+			Selector:              labels,
+		},
+	}
+	modifyServiceSpec(svc, ports, extraPorts...)
+
+	return svc
+}
+
+// Render the ports in order so an unchanged cache renders the same config, e.g. for the config hash
+func createProxyConfig(ports portMap, opt *Options) string {
+	keys := make([]int, 0, len(ports))
+	for port := range ports {
+		keys = append(keys, port)
+	}
+	sort.Ints(keys)
+	if opt.ProxyConfigEncoding == ProxyConfigEncodingJSON {
+		return createProxyConfigDocument(ports, keys, opt)
+	}
+	items := make([]string, 0, len(keys))
+	for _, port := range keys {
+		protocol := ports[port].Protocol
+		encoded := ports[port]
+		encoded.Queue = encodeQueue(encoded.Queue, opt.ProxyConfigEncoding)
+		items = append(items, createProxyString(encoded, opt)+opt.PortSettings.Get(port).forProtocol(protocol, opt).String()+getProxyTLSString(opt, protocol))
+	}
+	if target := getTLSRedirectTarget(ports, opt); target != 0 {
+		items = append(items, getTLSRedirectString(target))
+	}
+	return strings.Join(items, ",")
+}
+
+func updateProxyConfig(dep *appsv1.Deployment, opt *Options, config string) error {
+	if err := checkProxyDeployment(dep); err != nil {
+		return err
+	}
+	if err := setProxyArgs(dep, opt, config); err != nil {
+		return err
+	}
+	setProxyConfigFormat(dep, opt)
+	return nil
+}
+
+// gRPC ports are forwarded as http2 and flagged to have the Proxy stream them without buffering
+const grpcProxyFlag = ";grpc=true"
+
+// ws ports are forwarded as http and flagged, unless the Proxy image knows the ws protocol
+const wsProxyFlag = ";ws=true"
+
+func createProxyString(port ioclient.PublicPort, opt *Options) string {
+	if port.Protocol == "grpc" {
+		return fmt.Sprintf("http2:%d=>amqp:%s%s", port.Port, port.Queue, grpcProxyFlag)
+	}
+	if port.Protocol == "ws" && !hasProxyCapability(opt, ProxyCapabilityWebSocket) {
+		return fmt.Sprintf("http:%d=>amqp:%s%s", port.Port, port.Queue, wsProxyFlag)
+	}
+	return fmt.Sprintf("%s:%d=>amqp:%s", port.Protocol, port.Port, port.Queue)
+}
+
+// Get the config passed to the Proxy, spilled is true when the config is held by the router ConfigMap instead
+// Only the default arguments pass the config inline, custom argument templates always spill it
+func getProxyConfig(dep *appsv1.Deployment) (config string, spilled bool, err error) {
+	if err := checkProxyDeployment(dep); err != nil {
+		return "", false, err
+	}
+	container := dep.Spec.Template.Spec.Containers[0]
+	args := container.Args
+	if len(container.Command) == 0 && len(args) == len(getProxyContainerArgs("")) && args[1] == proxyEntrypoint {
+		return args[len(args)-1], false, nil
+	}
+	return "", true, nil
+}
+
+func checkProxyDeployment(dep *appsv1.Deployment) error {
+	containers := dep.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return errors.New("proxy Deployment has no containers")
+	}
+	if len(containers[0].Command) == 0 && len(containers[0].Args) == 0 {
+		return errors.New("proxy Deployment has no arguments")
+	}
+	return nil
+}
+
+// Find all ports in config string
+func decodeConfig(config, startDelim, endDelim string) (ports map[int]bool, err error) { // nolint:unused,deadcode
+	portStrings := between(config, startDelim, endDelim)
+	ports = make(map[int]bool)
+	for idx := range portStrings {
+		port := 0
+		port, err = strconv.Atoi(portStrings[idx])
+		if err != nil {
+			return
+		}
+		ports[port] = true
+	}
+	return
+}
+
+func decodeMicroservice(configItem, encoding string) (*ioclient.PublicPort, error) {
+	// {protocol}:{msvcPort}=>amqp:{queueName}[;{setting}={value}...]
+	// Settings are derived from the Options again, so they are dropped
+	settings := strings.TrimPrefix(configItem, before(configItem, ";"))
+	configItem = before(configItem, ";")
+	// Protocol
+	protocol := before(configItem, ":")
+	if protocol != "http" && protocol != "http2" && protocol != "ws" && protocol != "tcp" {
+		return nil, errors.New("Unsupported protocol: " + protocol)
+	}
+	// Port
+	ports := between(configItem, protocol+":", "=>")
+	if len(ports) != 1 {
+		return nil, errors.New("Could not get port from config item " + configItem)
+	}
+	port, err := strconv.Atoi(ports[0])
+	if err != nil {
+		return nil, errors.New("Failed to convert port string to int: " + ports[0])
+	}
+	// Queue name
+	ids := strings.SplitAfter(configItem, "=>amqp:")
+	if len(ids) != 2 {
+		return nil, errors.New("Could not split after =>amqp: in config item " + configItem)
+	}
+	queue, err := decodeQueue(ids[1], encoding)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == "http2" && strings.HasPrefix(settings, grpcProxyFlag) {
+		protocol = "grpc"
+	}
+	if protocol == "http" && strings.HasPrefix(settings, wsProxyFlag) {
+		protocol = "ws"
+	}
+	return &ioclient.PublicPort{
+		Protocol: protocol,
+		Queue:    queue,
+		Port:     port,
+	}, nil
+}
+
+func generateServicePort(port int, protocol string) corev1.ServicePort {
+	return corev1.ServicePort{
+		Name:        getServicePortName(port, protocol, ""),
+		Port:        int32(port),
+		TargetPort:  intstr.FromInt(port),
+		Protocol:    corev1.Protocol("TCP"),
+		AppProtocol: getAppProtocol(protocol),
+	}
+}
+
+// Hint the application protocol to Ingress controllers and meshes, unset for protocols they detect themselves
+func getAppProtocol(protocol string) *string {
+	var appProtocol string
+	switch protocol {
+	case "ws":
+		appProtocol = "kubernetes.io/ws"
+	case "grpc":
+		appProtocol = "grpc"
+	default:
+		return nil
+	}
+	return &appProtocol
+}
+
+func getTrafficPolicy(serviceType string) corev1.ServiceExternalTrafficPolicyType {
+	if serviceType == string(corev1.ServiceTypeLoadBalancer) {
+		return corev1.ServiceExternalTrafficPolicyTypeLocal
+	}
+	return ""
+}
+
+// ParseIPFamilies checks the IP families of the Proxy Service, two families request a dual-stack Service with the first as primary
+func ParseIPFamilies(families []string) ([]string, error) {
+	if len(families) > 2 || (len(families) == 2 && families[0] == families[1]) {
+		return nil, fmt.Errorf("expected one or two distinct IP families, got %v", families)
+	}
+	for _, family := range families {
+		if family != string(corev1.IPv4Protocol) && family != string(corev1.IPv6Protocol) {
+			return nil, fmt.Errorf("unsupported IP family %s, expected %s or %s", family, corev1.IPv4Protocol, corev1.IPv6Protocol)
+		}
+	}
+	return families, nil
+}
+
+func setIPFamilies(svc *corev1.Service, opt *Options) {
+	if len(opt.ProxyIPFamilies) == 0 {
+		return
+	}
+	policy := corev1.IPFamilyPolicySingleStack
+	if len(opt.ProxyIPFamilies) > 1 {
+		policy = corev1.IPFamilyPolicyRequireDualStack
+	}
+	svc.Spec.IPFamilyPolicy = &policy
+	svc.Spec.IPFamilies = make([]corev1.IPFamily, 0, len(opt.ProxyIPFamilies))
+	for _, family := range opt.ProxyIPFamilies {
+		svc.Spec.IPFamilies = append(svc.Spec.IPFamilies, corev1.IPFamily(family))
+	}
+}
+
+// Describe the change of a Service value that cannot be updated in place, empty if there is none
+// Updating the type in place would leave the NodePorts and LoadBalancer of the previous type behind
+func getImmutableServiceChange(svc *corev1.Service, opt *Options) string {
+	if svc.Spec.Type != corev1.ServiceType(opt.ProxyServiceType) {
+		return fmt.Sprintf("type from %s to %s", svc.Spec.Type, opt.ProxyServiceType)
+	}
+	if len(opt.ProxyIPFamilies) == 0 {
+		return ""
+	}
+	families := make([]string, 0, len(svc.Spec.IPFamilies))
+	for _, family := range svc.Spec.IPFamilies {
+		families = append(families, string(family))
+	}
+	if strings.Join(families, ",") != strings.Join(opt.ProxyIPFamilies, ",") {
+		return fmt.Sprintf("IP families from %v to %v", families, opt.ProxyIPFamilies)
+	}
+	return ""
+}
+
+// Whether the Service exposes a port that is not in the cache or misses a cached port
+func hasStaleServicePorts(svc *corev1.Service, ports portMap, extraPorts ...corev1.ServicePort) bool {
+	if len(svc.Spec.Ports) != len(ports)+len(extraPorts) {
+		return true
+	}
+	extra := make(map[int32]bool, len(extraPorts))
+	for _, svcPort := range extraPorts {
+		extra[svcPort.Port] = true
+	}
+	for _, svcPort := range svc.Spec.Ports {
+		if _, exists := ports[int(svcPort.Port)]; !exists && !extra[svcPort.Port] {
+			return true
+		}
+	}
+	return false
+}
+
+// Replace the Service ports with the cached ports in order
+// Ports the Service already exposes keep their allocated NodePort, so firewall rules keyed on NodePorts survive updates
+// Extra ports, e.g. of the HTTPS redirect, are exposed after the cached ports
+func modifyServiceSpec(svc *corev1.Service, ports portMap, extraPorts ...corev1.ServicePort) {
+	allocated := make(map[int32]int32)
+	if svc.Spec.Type == corev1.ServiceTypeNodePort || svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		for _, svcPort := range svc.Spec.Ports {
+			allocated[svcPort.Port] = svcPort.NodePort
+		}
+	}
+	svc.Spec.Ports = make([]corev1.ServicePort, 0)
+	names := make(map[string]bool)
+	for _, key := range getSortedPorts(ports) {
+		port := ports[key]
+		svcPort := generateServicePort(port.Port, port.Protocol)
+		svcPort.NodePort = allocated[svcPort.Port]
+		// Port names must be unique within the Service
+		if names[svcPort.Name] {
+			svcPort.Name = fmt.Sprintf("port-%d", port.Port)
+		}
+		names[svcPort.Name] = true
+		svc.Spec.Ports = append(svc.Spec.Ports, svcPort)
+	}
+	for _, svcPort := range extraPorts {
+		svcPort.NodePort = allocated[svcPort.Port]
+		svc.Spec.Ports = append(svc.Spec.Ports, svcPort)
+	}
+}
+
+// Ports already exposed by a Service, the others are held back until the Proxy serves them
+func getExposedPorts(ports portMap, svc *corev1.Service) portMap {
+	exposed := make(portMap)
+	for _, svcPort := range svc.Spec.Ports {
+		if port, exists := ports[int(svcPort.Port)]; exists {
+			exposed[port.Port] = port
+		}
+	}
+	return exposed
+}
+
+// Name the Proxy Service ports after the microservice exposing them once it is known, see getServicePortName
+// Falls back to the port number if a name is already taken
+func (mgr *Manager) setServicePortNames(svc *corev1.Service) {
+	names := make(map[string]bool)
+	for idx := range svc.Spec.Ports {
+		svcPort := &svc.Spec.Ports[idx]
+		port := int(svcPort.Port)
+		if isTLSRedirectPort(mgr.opt, port) {
+			names[svcPort.Name] = true
+			continue
+		}
+		name := mgr.portMetadata[port].Microservice
+		if msvc, exists := mgr.microservices[mgr.portOwners[port]]; exists && name == "" {
+			name = msvc.Name
+		}
+		svcPort.Name = getServicePortName(port, mgr.cache[port].Protocol, name)
+		if names[svcPort.Name] {
+			svcPort.Name = fmt.Sprintf("port-%d", port)
+		}
+		names[svcPort.Name] = true
+	}
+}
@@ -0,0 +1,336 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ProxyConfigEncodingPlain passes queue names to the Proxy as is, rejecting queues containing the separators of the config
+	ProxyConfigEncodingPlain = "plain"
+	// ProxyConfigEncodingEscaped percent-encodes the separators in queue names, requires a Proxy image decoding them
+	ProxyConfigEncodingEscaped = "escaped"
+	// ProxyConfigEncodingJSON passes a versioned JSON document of the ports to the Proxy, requires a Proxy image parsing it
+	ProxyConfigEncodingJSON = "json"
+
+	// Encoding of the config of a Proxy Deployment, plain if unset, so a change of encoding decodes the existing config first
+	proxyConfigEncodingAnnotation = "iofog.org/proxy-config-encoding"
+	// Format version of the config of a Proxy Deployment, unset on Deployments of earlier releases
+	proxyConfigVersionAnnotation = "iofog.org/proxy-config-version"
+
+	alertReasonProxyConfig = "ProxyConfigInvalid"
+)
+
+// Format versions of the Proxy config
+// 2: config items of port-manager v2 and v3.0, Deployments without a format version
+// 3: lower-case protocols, per-port settings and the encodings of ParseProxyConfigEncoding
+const (
+	proxyConfigLegacyFormat  = 2
+	proxyConfigCurrentFormat = 3
+)
+
+// Migrations of the config items of a format version to the next
+var proxyConfigMigrations = map[int]func(config string) string{
+	proxyConfigLegacyFormat: migrateLegacyProxyConfig,
+}
+
+// Characters of queue names escaped in the Proxy config, the escape character first
+var queueEscaper = strings.NewReplacer("%", "%25", ",", "%2C", ";", "%3B", "=", "%3D", ">", "%3E")
+
+// ParseProxyConfigEncoding validates how queue names are encoded in the Proxy config, empty is plain
+func ParseProxyConfigEncoding(value string) (string, error) {
+	switch encoding := strings.ToLower(value); encoding {
+	case "":
+		return ProxyConfigEncodingPlain, nil
+	case ProxyConfigEncodingPlain, ProxyConfigEncodingEscaped, ProxyConfigEncodingJSON:
+		return encoding, nil
+	default:
+		return "", fmt.Errorf("unknown proxy config encoding %s, expected %s, %s or %s", value, ProxyConfigEncodingPlain, ProxyConfigEncodingEscaped, ProxyConfigEncodingJSON)
+	}
+}
+
+func encodeQueue(queue, encoding string) string {
+	if encoding == ProxyConfigEncodingEscaped {
+		return queueEscaper.Replace(queue)
+	}
+	return queue
+}
+
+func decodeQueue(queue, encoding string) (string, error) {
+	if encoding != ProxyConfigEncodingEscaped {
+		return queue, nil
+	}
+	decoded, err := url.PathUnescape(queue)
+	if err != nil {
+		return "", fmt.Errorf("invalid escaped queue %s: %s", queue, err.Error())
+	}
+	return decoded, nil
+}
+
+// Check that the queue of a port can be passed to the Proxy in the encoding
+func validateQueue(port ioclient.PublicPort, encoding string) error {
+	if encoding == ProxyConfigEncodingEscaped || encoding == ProxyConfigEncodingJSON {
+		return nil
+	}
+	// Separators of the Proxy config
+	if strings.ContainsAny(port.Queue, ",;") || strings.Contains(port.Queue, "=>") {
+		return fmt.Errorf("port %d has invalid queue %s, queues containing separators require the %s or %s proxy config encoding", port.Port, port.Queue, ProxyConfigEncodingEscaped, ProxyConfigEncodingJSON)
+	}
+	return nil
+}
+
+// Record the format version and encoding of the config of a Proxy Deployment
+func setProxyConfigFormat(dep *appsv1.Deployment, opt *Options) {
+	if dep.Annotations == nil {
+		dep.Annotations = make(map[string]string)
+	}
+	dep.Annotations[proxyConfigVersionAnnotation] = strconv.Itoa(proxyConfigCurrentFormat)
+	encoding := opt.ProxyConfigEncoding
+	if encoding == "" || encoding == ProxyConfigEncodingPlain {
+		delete(dep.Annotations, proxyConfigEncodingAnnotation)
+		return
+	}
+	dep.Annotations[proxyConfigEncodingAnnotation] = encoding
+}
+
+// Encoding the config of a Proxy Deployment was created with
+func getProxyConfigEncoding(dep *appsv1.Deployment) string {
+	if encoding, exists := dep.Annotations[proxyConfigEncodingAnnotation]; exists {
+		return encoding
+	}
+	return ProxyConfigEncodingPlain
+}
+
+// Decode the ports of a Proxy config in its encoding, invalid items are skipped with an error each
+// The HTTPS redirect is derived from the Options again, so it is dropped
+func decodeProxyConfig(config, encoding string) (ports []ioclient.PublicPort, errs []error) {
+	if encoding == ProxyConfigEncodingJSON {
+		return decodeProxyConfigDocument(config)
+	}
+	for _, configItem := range strings.Split(config, ",") {
+		if isTLSRedirectItem(configItem) {
+			continue
+		}
+		port, err := decodeMicroservice(configItem, encoding)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid Proxy config item %s: %s", configItem, err.Error()))
+			continue
+		}
+		ports = append(ports, *port)
+	}
+	return ports, errs
+}
+
+// Version of the JSON Proxy config, bumped on incompatible changes
+const proxyConfigVersion = 1
+
+// Proxy config of the json encoding
+type proxyConfigDocument struct {
+	Version  int                  `json:"version"`
+	Ports    []proxyConfigPort    `json:"ports"`
+	Redirect *proxyConfigRedirect `json:"redirect,omitempty"`
+}
+
+// HTTPS redirect of the JSON Proxy config, see Options.ProxyTLSRedirectPort
+type proxyConfigRedirect struct {
+	Port      int `json:"port"`
+	HTTPSPort int `json:"httpsPort"`
+}
+
+// Port of the JSON Proxy config, with the PortSettings of the port and timeouts in milliseconds
+type proxyConfigPort struct {
+	Protocol          string `json:"protocol"`
+	Port              int    `json:"port"`
+	Queue             string `json:"queue"`
+	GRPC              bool   `json:"grpc,omitempty"`
+	WS                bool   `json:"ws,omitempty"`
+	MaxConnections    int    `json:"maxConnections,omitempty"`
+	RequestsPerSecond int    `json:"requestsPerSecond,omitempty"`
+	IdleTimeout       int64  `json:"idleTimeoutMs,omitempty"`
+	ConnectTimeout    int64  `json:"connectTimeoutMs,omitempty"`
+	RequestTimeout    int64  `json:"requestTimeoutMs,omitempty"`
+	ForwardedHeaders  bool   `json:"forwardedHeaders,omitempty"`
+	BasicAuth         string `json:"basicAuth,omitempty"`
+	JWKSURL           string `json:"jwksUrl,omitempty"`
+	JWTIssuer         string `json:"jwtIssuer,omitempty"`
+	JWTAudience       string `json:"jwtAudience,omitempty"`
+	TLSCert           string `json:"tlsCert,omitempty"`
+	TLSKey            string `json:"tlsKey,omitempty"`
+}
+
+// gRPC ports are forwarded as http2 and ws ports as http, flagged like in the plain config items
+func newProxyConfigPort(port ioclient.PublicPort, opt *Options) proxyConfigPort {
+	item := proxyConfigPort{
+		Protocol: port.Protocol,
+		Port:     port.Port,
+		Queue:    port.Queue,
+	}
+	if port.Protocol == "grpc" {
+		item.Protocol = "http2"
+		item.GRPC = true
+	}
+	if port.Protocol == "ws" && !hasProxyCapability(opt, ProxyCapabilityWebSocket) {
+		item.Protocol = "http"
+		item.WS = true
+	}
+	settings := opt.PortSettings.Get(port.Port).forProtocol(port.Protocol, opt)
+	item.MaxConnections = settings.MaxConnections
+	item.RequestsPerSecond = settings.RequestsPerSecond
+	item.IdleTimeout = settings.IdleTimeout.Milliseconds()
+	item.ConnectTimeout = settings.ConnectTimeout.Milliseconds()
+	item.RequestTimeout = settings.RequestTimeout.Milliseconds()
+	item.ForwardedHeaders = settings.forwardsHeaders()
+	if settings.BasicAuthSecret != "" {
+		item.BasicAuth = getBasicAuthPath(settings.BasicAuthSecret)
+	}
+	item.JWKSURL = settings.JWKSURL
+	item.JWTIssuer = settings.JWTIssuer
+	item.JWTAudience = settings.JWTAudience
+	if isTLSTerminated(opt, port.Protocol) {
+		item.TLSCert = path.Join(proxyTLSMountPath, corev1.TLSCertKey)
+		item.TLSKey = path.Join(proxyTLSMountPath, corev1.TLSPrivateKeyKey)
+	}
+	return item
+}
+
+// Settings are derived from the Options again, so they are dropped
+func (item proxyConfigPort) publicPort() (ioclient.PublicPort, error) {
+	protocol := item.Protocol
+	if protocol != "http" && protocol != "http2" && protocol != "ws" && protocol != "tcp" {
+		return ioclient.PublicPort{}, fmt.Errorf("unsupported protocol %s of port %d", protocol, item.Port)
+	}
+	if protocol == "http2" && item.GRPC {
+		protocol = "grpc"
+	}
+	if protocol == "http" && item.WS {
+		protocol = "ws"
+	}
+	return ioclient.PublicPort{
+		Protocol: protocol,
+		Queue:    item.Queue,
+		Port:     item.Port,
+	}, nil
+}
+
+// Render the JSON Proxy config of the ports in the order of keys
+func createProxyConfigDocument(ports portMap, keys []int, opt *Options) string {
+	doc := proxyConfigDocument{
+		Version: proxyConfigVersion,
+		Ports:   make([]proxyConfigPort, 0, len(keys)),
+	}
+	for _, port := range keys {
+		doc.Ports = append(doc.Ports, newProxyConfigPort(ports[port], opt))
+	}
+	if target := getTLSRedirectTarget(ports, opt); target != 0 {
+		doc.Redirect = &proxyConfigRedirect{Port: tlsRedirectPort, HTTPSPort: target}
+	}
+	// Queues are kept readable, e.g. with =>, the document is not embedded in HTML
+	// It only holds strings, numbers and booleans, which always encode
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(doc)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func decodeProxyConfigDocument(config string) (ports []ioclient.PublicPort, errs []error) {
+	doc := proxyConfigDocument{}
+	if err := json.Unmarshal([]byte(config), &doc); err != nil {
+		return nil, []error{fmt.Errorf("invalid JSON Proxy config: %s", err.Error())}
+	}
+	if doc.Version != proxyConfigVersion {
+		return nil, []error{fmt.Errorf("unsupported JSON Proxy config version %d, expected %d", doc.Version, proxyConfigVersion)}
+	}
+	for _, item := range doc.Ports {
+		port, err := item.publicPort()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ports = append(ports, port)
+	}
+	return ports, errs
+}
+
+// Format version the config of a Proxy Deployment was created with
+func getProxyConfigVersion(dep *appsv1.Deployment) (int, error) {
+	value, exists := dep.Annotations[proxyConfigVersionAnnotation]
+	if !exists {
+		return proxyConfigLegacyFormat, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid proxy config format version %s", value)
+	}
+	return version, nil
+}
+
+// Migrate the config of a Proxy Deployment to the current format, migrated is true when it was converted
+func migrateProxyConfig(config string, version int) (_ string, migrated bool, err error) {
+	if version > proxyConfigCurrentFormat {
+		return "", false, fmt.Errorf("proxy config format version %d is newer than the supported version %d", version, proxyConfigCurrentFormat)
+	}
+	for ; version < proxyConfigCurrentFormat; version++ {
+		migrate, exists := proxyConfigMigrations[version]
+		if !exists {
+			return "", false, fmt.Errorf("proxy config format version %d cannot be migrated", version)
+		}
+		config = migrate(config)
+		migrated = true
+	}
+	return config, migrated, nil
+}
+
+// port-manager v2 passed the protocols of the Controller as is, e.g. HTTP, and rendered an empty item without ports
+func migrateLegacyProxyConfig(config string) string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(config, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		protocol := before(item, ":")
+		items = append(items, strings.ToLower(protocol)+strings.TrimPrefix(item, protocol))
+	}
+	return strings.Join(items, ",")
+}
+
+// Decode a rendered config and compare it to the ports it was rendered from, so drift between the encoder and decoder
+// is caught before the config is applied rather than when the cache is generated after a restart
+func verifyProxyConfig(config string, ports portMap, encoding string) error {
+	decoded, errs := decodeProxyConfig(config, encoding)
+	if len(errs) != 0 {
+		return fmt.Errorf("rendered proxy config cannot be decoded: %s", errs[0].Error())
+	}
+	found := make(map[int]bool, len(decoded))
+	for _, port := range decoded {
+		if expected, exists := ports[port.Port]; !exists || port != expected || found[port.Port] {
+			return fmt.Errorf("rendered proxy config decodes port %d as %v, expected %v", port.Port, port, expected)
+		}
+		found[port.Port] = true
+	}
+	if len(found) != len(ports) {
+		return fmt.Errorf("rendered proxy config decodes %d of %d ports", len(found), len(ports))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	portsv1alpha1 "github.com/eclipse-iofog/port-manager/v3/api/portmanager/v1alpha1"
+)
+
+const (
+	publicPortProxyLabel = "iofog.org/proxy"
+
+	messageReconcileFailed = "Proxy failed to reconcile: "
+	messageAddressPending  = "Waiting for the Proxy address to be registered"
+)
+
+// Scheme of the K8s client, including the PublicPort API
+func newScheme() (*runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := portsv1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	return scheme, nil
+}
+
+func getPublicPortName(proxy string, port int) string {
+	return fmt.Sprintf("%s-%d", proxy, port)
+}
+
+// Status of the PublicPorts of the Proxy, set from the cache snapshot and the last reconcile
+func getPublicPortStatus(snapshot *cacheSnapshot, reconcileErr error) portsv1alpha1.PublicPortStatus {
+	status := portsv1alpha1.PublicPortStatus{
+		Address: snapshot.address,
+	}
+	switch {
+	case reconcileErr != nil:
+		status.Message = messageReconcileFailed + reconcileErr.Error()
+	case snapshot.address == "":
+		status.Message = messageAddressPending
+	default:
+		status.Ready = true
+	}
+	return status
+}
+
+// Compare statuses by the instant of their transition, a time read back from the API server has lost its monotonic clock reading
+func isPublicPortStatusEqual(a, b *portsv1alpha1.PublicPortStatus) bool {
+	return a.Address == b.Address && a.Ready == b.Ready && a.Message == b.Message && a.LastTransitionTime.Equal(&b.LastTransitionTime)
+}
+
+// Create, update and delete PublicPort resources to mirror the cached ports of the Proxy
+func (mgr *Manager) syncPublicPorts(ctx context.Context, reconcileErr error) error {
+	if !mgr.opt.PublicPortResources {
+		return nil
+	}
+	snapshot := mgr.loadSnapshot()
+	status := getPublicPortStatus(snapshot, reconcileErr)
+	if snapshot == mgr.syncedSnapshot && isPublicPortStatusEqual(&status, &mgr.syncedStatus) {
+		return nil
+	}
+
+	list := portsv1alpha1.PublicPortList{}
+	if err := mgr.k8sClient.List(ctx, &list, k8sclient.InNamespace(mgr.opt.Namespace), k8sclient.MatchingLabels{publicPortProxyLabel: mgr.opt.ProxyName}); err != nil {
+		return err
+	}
+	existing := make(map[string]*portsv1alpha1.PublicPort)
+	for idx := range list.Items {
+		existing[list.Items[idx].Name] = &list.Items[idx]
+	}
+
+	now := metav1.Now()
+	for _, port := range snapshot.ports {
+		spec := portsv1alpha1.PublicPortSpec{
+			Proxy:            mgr.opt.ProxyName,
+			Port:             int32(port.Port),
+			Protocol:         port.Protocol,
+			Queue:            port.Queue,
+			MicroserviceUUID: mgr.portOwners[port.Port],
+		}
+		name := getPublicPortName(mgr.opt.ProxyName, port.Port)
+		publicPort, exists := existing[name]
+		delete(existing, name)
+		if !exists {
+			publicPort = &portsv1alpha1.PublicPort{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: mgr.opt.Namespace,
+					Labels:    map[string]string{publicPortProxyLabel: mgr.opt.ProxyName},
+				},
+				Spec: spec,
+			}
+			setMetadataLabels(publicPort, snapshot.metadata[port.Port].labels())
+			mgr.setOwnerReference(publicPort)
+			if err := mgr.k8sClient.Create(ctx, publicPort); err != nil {
+				return err
+			}
+		} else if setMetadataLabels(publicPort, snapshot.metadata[port.Port].labels()) || publicPort.Spec != spec {
+			publicPort.Spec = spec
+			if err := mgr.k8sClient.Update(ctx, publicPort); err != nil {
+				return err
+			}
+		}
+
+		newStatus := status
+		newStatus.LastTransitionTime = publicPort.Status.LastTransitionTime
+		if !exists || publicPort.Status.Ready != status.Ready {
+			newStatus.LastTransitionTime = now
+		}
+		if isPublicPortStatusEqual(&publicPort.Status, &newStatus) {
+			continue
+		}
+		publicPort.Status = newStatus
+		if err := mgr.k8sClient.Status().Update(ctx, publicPort); err != nil {
+			return err
+		}
+	}
+
+	// Ports no longer exposed
+	for _, publicPort := range existing {
+		if err := mgr.k8sClient.Delete(ctx, publicPort); k8sclient.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	mgr.syncedSnapshot = snapshot
+	mgr.syncedStatus = status
+	return nil
+}
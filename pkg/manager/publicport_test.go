@@ -0,0 +1,38 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	portsv1alpha1 "github.com/eclipse-iofog/port-manager/v3/api/portmanager/v1alpha1"
+)
+
+func TestPublicPortStatusEqual(t *testing.T) {
+	now := metav1.Now()
+	status := portsv1alpha1.PublicPortStatus{Address: "1.2.3.4", Ready: true, LastTransitionTime: now}
+
+	// The same instant read back from the API server has no monotonic clock reading and is in UTC
+	readBack := status
+	readBack.LastTransitionTime = metav1.NewTime(now.Time.Round(0).UTC())
+	if !isPublicPortStatusEqual(&status, &readBack) {
+		t.Error("Expected statuses with the same transition instant to be equal")
+	}
+	readBack.Ready = false
+	if isPublicPortStatusEqual(&status, &readBack) {
+		t.Error("Expected statuses of a different readiness to differ")
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Update of the Proxy left pending, e.g. until its Deployment is ready, the reconcile is requeued instead of waiting
+type pendingError struct {
+	reason string
+	after  time.Duration
+}
+
+func (err *pendingError) Error() string {
+	return err.reason
+}
+
+func isPending(err error) bool {
+	var pending *pendingError
+	return errors.As(err, &pending)
+}
+
+// A pending update is not a failure of the reconcile
+func ignorePending(err error) error {
+	if isPending(err) {
+		return nil
+	}
+	return err
+}
+
+// Requeue a pending reconcile after its delay, failures are retried with the rate limiter's backoff
+func getErrorResult(err error) (reconcile.Result, error) {
+	var pending *pendingError
+	if errors.As(err, &pending) {
+		return reconcile.Result{RequeueAfter: pending.after}, nil
+	}
+	return reconcile.Result{}, err
+}
+
+// Check whether the latest rollout of a Deployment has completed and all replicas are available
+func isDeploymentReady(dep *appsv1.Deployment) bool {
+	if dep.Status.ObservedGeneration < dep.Generation {
+		return false
+	}
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	return dep.Status.UpdatedReplicas >= replicas &&
+		dep.Status.AvailableReplicas >= replicas &&
+		dep.Status.Replicas == dep.Status.UpdatedReplicas
+}
+
+// Check whether the Proxy workload is ready without waiting for it, a DaemonSet is ready once its pods are updated
+// and available on all scheduled nodes
+// The check is pending until ProxyReadyTimeout after the Proxy was first found not ready, then it fails and an Event is recorded
+func (mgr *Manager) checkProxyReady(obj k8sclient.Object) error {
+	if isDeploymentReady(getWorkloadDeployment(obj)) {
+		mgr.notReadySince = time.Time{}
+		return nil
+	}
+	if mgr.notReadySince.IsZero() {
+		mgr.notReadySince = time.Now()
+		mgr.notReadyReported = false
+	}
+	if time.Since(mgr.notReadySince) < mgr.opt.ProxyReadyTimeout {
+		return &pendingError{reason: "waiting for the Proxy to become ready", after: pkg.readyPollInterval}
+	}
+	err := fmt.Errorf("proxy %s did not become ready within %s", mgr.opt.ProxyWorkload, mgr.opt.ProxyReadyTimeout)
+	if !mgr.notReadyReported {
+		mgr.recorder.Event(obj, corev1.EventTypeWarning, reasonProxyNotReady, err.Error())
+		mgr.notReadyReported = true
+	}
+	return err
+}
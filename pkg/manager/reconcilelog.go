@@ -0,0 +1,55 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+)
+
+// Context key of the reconcile a context belongs to
+type reconcileKey struct{}
+
+// Logger of a reconcile, the ID is shared with the reconciles of the grouped Proxies it runs
+type reconcileLog struct {
+	id  string
+	mgr *Manager
+	log logr.Logger
+}
+
+// Tag a reconcile with a unique ID, logged with the number of ports or groups by every log line of the reconcile
+func (mgr *Manager) withReconcileLog(ctx context.Context) context.Context {
+	id := uuid.New().String()
+	if parent, ok := ctx.Value(reconcileKey{}).(reconcileLog); ok {
+		id = parent.id
+	}
+	log := mgr.log.WithValues("reconcileID", id)
+	return context.WithValue(ctx, reconcileKey{}, reconcileLog{id: id, mgr: mgr, log: log})
+}
+
+// Get the logger of the reconcile of ctx, or of the Manager outside of its reconciles,
+// e.g. in the routines of grouped Proxies started by a reconcile of their parent
+// The number of ports or groups is read for each log line, the cache and groups change while the reconcile runs
+func (mgr *Manager) getLog(ctx context.Context) logr.Logger {
+	reconcile, ok := ctx.Value(reconcileKey{}).(reconcileLog)
+	if !ok || reconcile.mgr != mgr {
+		return mgr.log
+	}
+	if mgr.opt.ProxyGrouping != "" {
+		return reconcile.log.WithValues("groups", len(mgr.groups))
+	}
+	return reconcile.log.WithValues("ports", len(mgr.cache))
+}
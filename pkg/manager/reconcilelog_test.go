@@ -0,0 +1,85 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"github.com/go-logr/logr/funcr"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileLog(t *testing.T) {
+	var lines []string
+	log := funcr.New(func(prefix, args string) {
+		lines = append(lines, args)
+	}, funcr.Options{})
+	mgr, _ := newFakeManager(t, NewFakeControllerClient())
+	mgr.log = log.WithValues("proxy", mgr.opt.ProxyName)
+
+	// Each reconcile is tagged with a new ID
+	if _, err := mgr.Reconcile(context.Background(), reconcile.Request{}); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Rebuild()
+	if _, err := mgr.Reconcile(context.Background(), reconcile.Request{}); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for _, line := range lines {
+		idx := strings.Index(line, `"reconcileID"="`)
+		if idx < 0 {
+			t.Fatalf("Expected reconcile ID in log line %s", line)
+		}
+		ids[strings.SplitN(line[idx+len(`"reconcileID"="`):], `"`, 2)[0]] = true
+		if !strings.Contains(line, `"ports"=`) {
+			t.Errorf("Expected port count in log line %s", line)
+		}
+	}
+	if len(ids) != 2 {
+		t.Errorf("Expected an ID per reconcile, got %v", ids)
+	}
+
+	// The port count is the one of the log line, not of the start of the reconcile
+	countCtx := mgr.withReconcileLog(context.Background())
+	mgr.cache = portMap{5000: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}}
+	lines = nil
+	mgr.getLog(countCtx).Info("cached")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"ports"=1`) {
+		t.Errorf("Expected port count of the cache at the log line, got %v", lines)
+	}
+
+	// Another Manager reconciling within the reconcile keeps its ID but logs with its own logger
+	ctx := mgr.withReconcileLog(context.Background())
+	group, _ := newFakeManager(t, NewFakeControllerClient())
+	group.log = log.WithValues("proxy", "group")
+	groupCtx := group.withReconcileLog(ctx)
+	lines = nil
+	mgr.getLog(ctx).Info("parent")
+	group.getLog(groupCtx).Info("group")
+	group.getLog(ctx).Info("routine")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 log lines, got %v", lines)
+	}
+	id := ctx.Value(reconcileKey{}).(reconcileLog).id
+	if !strings.Contains(lines[0], id) || !strings.Contains(lines[1], id) || !strings.Contains(lines[1], `"proxy"="group"`) {
+		t.Errorf("Expected grouped reconcile to share the ID %s, got %v", id, lines)
+	}
+	if strings.Contains(lines[2], "reconcileID") {
+		t.Errorf("Expected routine outside of the reconciles of its Manager not to log a reconcile ID, got %s", lines[2])
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"sync"
+)
+
+const reasonPortDuplicate = "PortDuplicate"
+
+// PortRegistry detects the same external port being requested by several Managers of one process, e.g. in dual-proxy mode
+// The Proxy with the lowest name wins, so the outcome does not depend on which Manager polls first
+type PortRegistry struct {
+	mu       sync.Mutex
+	requests map[string]map[int]bool // Indexed by Proxy, then port
+}
+
+func NewPortRegistry() *PortRegistry {
+	return &PortRegistry{
+		requests: make(map[string]map[int]bool),
+	}
+}
+
+// Replace the ports requested by a Proxy, returning the ports it loses to another Proxy and the winner of each
+func (registry *PortRegistry) request(proxy string, ports map[int]bool) map[int]string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.requests[proxy] = ports
+	lost := make(map[int]string)
+	for other, otherPorts := range registry.requests {
+		if other >= proxy {
+			continue
+		}
+		for port := range ports {
+			if !otherPorts[port] {
+				continue
+			}
+			if winner, exists := lost[port]; !exists || other < winner {
+				lost[port] = other
+			}
+		}
+	}
+	return lost
+}
+
+func newDuplicatePortRejection(port int, winner string) portRejection {
+	return portRejection{
+		reason:  reasonPortDuplicate,
+		message: fmt.Sprintf("port %d is also requested for Proxy %s, which takes precedence", port, winner),
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPortRegistry(t *testing.T) {
+	registry := NewPortRegistry()
+	// The losing Proxy keeps the port until the winner requests it
+	if lost := registry.request("tcp-proxy", map[int]bool{80: true, 5000: true}); len(lost) != 0 {
+		t.Errorf("Expected no lost ports, got %v", lost)
+	}
+	if lost := registry.request("http-proxy", map[int]bool{80: true}); len(lost) != 0 {
+		t.Errorf("Expected winner to keep its ports, got %v", lost)
+	}
+	lost := registry.request("tcp-proxy", map[int]bool{80: true, 5000: true})
+	if len(lost) != 1 || lost[80] != "http-proxy" {
+		t.Errorf("Expected port 80 to be lost to http-proxy, got %v", lost)
+	}
+}
+
+func TestRejectDuplicatePort(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	mgr, _ := newFakeManager(t, ioClient)
+	mgr.opt.PortRegistry = NewPortRegistry()
+	mgr.opt.PortRegistry.request("a-proxy", map[int]bool{5000: true})
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	mgr.observeReconcile(ctx, nil)
+	if _, exists := mgr.cache[5000]; exists {
+		t.Error("Expected port 5000 to be left to a-proxy")
+	}
+
+	// The losing Proxy reports the rejection as an Event and in its status
+	select {
+	case event := <-mgr.recorder.(*record.FakeRecorder).Events:
+		if !strings.Contains(event, reasonPortDuplicate) || !strings.Contains(event, "a-proxy") {
+			t.Errorf("Expected a %s Event naming a-proxy, got %s", reasonPortDuplicate, event)
+		}
+	default:
+		t.Error("Expected an Event for the duplicate port")
+	}
+	rejected := mgr.getStatus().Rejected
+	if len(rejected) != 1 || rejected[0].Port != 5000 || rejected[0].Reason != reasonPortDuplicate {
+		t.Errorf("Expected the status to list duplicate port 5000, got %v", rejected)
+	}
+}
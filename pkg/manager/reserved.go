@@ -0,0 +1,83 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const reasonPortReserved = "PortReserved"
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	From int
+	To   int
+}
+
+type PortRanges []PortRange
+
+// Contains checks whether a port is in any of the ranges
+func (ranges PortRanges) Contains(port int) bool {
+	for _, portRange := range ranges {
+		if port >= portRange.From && port <= portRange.To {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range", port)
+	}
+	return port, nil
+}
+
+// ParsePortRanges parses a comma-separated list of ports and port ranges, e.g. 22,6443,10250-10259
+func ParsePortRanges(value string) (ranges PortRanges, err error) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		bounds := strings.SplitN(item, "-", 2)
+		portRange := PortRange{}
+		if portRange.From, err = parsePort(bounds[0]); err != nil {
+			return nil, fmt.Errorf("invalid port range %s: %s", item, err.Error())
+		}
+		portRange.To = portRange.From
+		if len(bounds) == 2 {
+			if portRange.To, err = parsePort(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid port range %s: %s", item, err.Error())
+			}
+		}
+		if portRange.To < portRange.From {
+			return nil, fmt.Errorf("invalid port range %s: end is lower than start", item)
+		}
+		ranges = append(ranges, portRange)
+	}
+	return ranges, nil
+}
+
+func newReservedPortRejection(port int) portRejection {
+	return portRejection{
+		reason:  reasonPortReserved,
+		message: fmt.Sprintf("port %d is reserved", port),
+	}
+}
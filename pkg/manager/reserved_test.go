@@ -0,0 +1,79 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParsePortRanges(t *testing.T) {
+	ranges, err := ParsePortRanges("22, 6443,10250-10259")
+	if err != nil {
+		t.Fatalf("Failed to parse port ranges: %s", err.Error())
+	}
+	for _, port := range []int{22, 6443, 10250, 10255, 10259} {
+		if !ranges.Contains(port) {
+			t.Errorf("Expected port %d to be reserved", port)
+		}
+	}
+	for _, port := range []int{21, 80, 10249, 10260} {
+		if ranges.Contains(port) {
+			t.Errorf("Expected port %d not to be reserved", port)
+		}
+	}
+
+	for _, invalid := range []string{"abc", "0", "70000", "100-90", "1-2-3"} {
+		if _, err := ParsePortRanges(invalid); err == nil {
+			t.Errorf("Expected error for port ranges %q", invalid)
+		}
+	}
+}
+
+func TestRejectReservedPort(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 22, Protocol: "http", Queue: "abc-22"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+	)
+	mgr, _ := newFakeManager(t, ioClient)
+	mgr.opt.ReservedPorts, _ = ParsePortRanges("22")
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	mgr.observeReconcile(ctx, nil)
+	if _, exists := mgr.cache[22]; exists {
+		t.Error("Expected reserved port 22 not to be exposed")
+	}
+
+	// The rejection is reported as an Event and in the status
+	events := mgr.recorder.(*record.FakeRecorder).Events
+	select {
+	case event := <-events:
+		if !strings.Contains(event, reasonPortReserved) {
+			t.Errorf("Expected a %s Event, got %s", reasonPortReserved, event)
+		}
+	default:
+		t.Error("Expected an Event for the reserved port")
+	}
+	rejected := mgr.getStatus().Rejected
+	if len(rejected) != 1 || rejected[0].Port != 22 || rejected[0].Reason != reasonPortReserved {
+		t.Errorf("Expected the status to list reserved port 22, got %v", rejected)
+	}
+}
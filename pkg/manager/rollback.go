@@ -0,0 +1,180 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	revisionAnnotation            = "deployment.kubernetes.io/revision"
+	reasonProgressDeadlineExceeds = "ProgressDeadlineExceeded"
+	reasonProxyRolledBack         = "ProxyRolledBack"
+	alertReasonRollback           = "ProxyRolledBack"
+	// Set on the Proxy Deployment to the hash of the rollout that was rolled back, so a restart does not retry it
+	failedRolloutAnnotation = "iofog.org/proxy-failed-rollout"
+)
+
+// Check whether the latest rollout of a Deployment failed to make progress in time
+func hasRolloutFailed(dep *appsv1.Deployment) bool {
+	// Status does not reflect the latest spec yet
+	if dep.Status.ObservedGeneration < dep.Generation {
+		return false
+	}
+	for _, cond := range dep.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing {
+			return cond.Status == corev1.ConditionFalse && cond.Reason == reasonProgressDeadlineExceeds
+		}
+	}
+	return false
+}
+
+func getRevision(obj k8sclient.Object) int64 {
+	revision, err := strconv.ParseInt(obj.GetAnnotations()[revisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
+// Roll the Proxy Deployment back to its previous revision if its latest rollout failed
+// The previous revision is taken from the ReplicaSets kept by Kubernetes, the same way kubectl rollout undo does
+func (mgr *Manager) checkProxyRollout(ctx context.Context) error {
+	// DaemonSets and StatefulSets keep their revisions as ControllerRevisions instead and are not rolled back
+	if !mgr.opt.ProxyAutoRollback || mgr.opt.ProxyWorkload != ProxyWorkloadDeployment {
+		return nil
+	}
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	dep := appsv1.Deployment{}
+	if err := mgr.k8sClient.Get(ctx, proxyKey, &dep); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !hasRolloutFailed(&dep) {
+		// Resolved once a config other than the failed one rolled out
+		if mgr.failedRollout == "" && isDeploymentReady(&dep) {
+			mgr.observeAlert(ctx, mgr.rollbackAlert, nil)
+		}
+		return nil
+	}
+
+	previous, err := mgr.getPreviousReplicaSet(ctx, &dep)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		mgr.getLog(ctx).Info("Proxy rollout failed and there is no previous revision to roll back to")
+		return nil
+	}
+
+	// Restore the previous pod template, without the label Kubernetes adds to ReplicaSets
+	failedHash := getRolloutHash(&dep)
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	dep.Spec.Template = *template
+	if dep.Annotations == nil {
+		dep.Annotations = make(map[string]string)
+	}
+	dep.Annotations[failedRolloutAnnotation] = failedHash
+	if err := mgr.k8sClient.Update(ctx, &dep); err != nil {
+		return err
+	}
+
+	// Keep the failed config from being re-applied and its new ports from being exposed, until the ports change
+	mgr.failedRollout = failedHash
+	msg := fmt.Sprintf("Rolled back Proxy to revision %d after failed rollout", getRevision(previous))
+	mgr.getLog(ctx).Info(msg, "failedRollout", failedHash)
+	mgr.recorder.Event(&dep, corev1.EventTypeWarning, reasonProxyRolledBack, msg)
+	proxyRollbacks.WithLabelValues(mgr.opt.ProxyName).Inc()
+	mgr.observeAlert(ctx, mgr.rollbackAlert, errors.New(msg))
+	return nil
+}
+
+// Hash of what a Proxy rollout applies: the image, the Proxy config passed as arguments and the hash of the mounted config
+// Fields defaulted by the API server are left out so a re-applied config hashes the same
+func getRolloutHash(dep *appsv1.Deployment) string {
+	parts := []string{dep.Spec.Template.Annotations[configHashAnnotation]}
+	for _, container := range dep.Spec.Template.Spec.Containers {
+		parts = append(parts, container.Image)
+		parts = append(parts, container.Args...)
+	}
+	return hashConfig(parts...)
+}
+
+// Whether applying the spec of a Proxy Deployment would repeat a rollout that was rolled back
+// A different spec, e.g. after the ports changed, is applied and clears the failed one along with its annotation
+func (mgr *Manager) isFailedRollout(ctx context.Context, dep *appsv1.Deployment) bool {
+	if mgr.failedRollout == "" {
+		return false
+	}
+	hash := getRolloutHash(dep)
+	if hash != mgr.failedRollout {
+		mgr.failedRollout = ""
+		delete(dep.Annotations, failedRolloutAnnotation)
+		return false
+	}
+	mgr.getLog(ctx).Info("Not re-applying the Proxy config that was rolled back", "rollout", hash)
+	return true
+}
+
+// Find the ReplicaSet of the revision preceding the current revision of a Deployment
+func (mgr *Manager) getPreviousReplicaSet(ctx context.Context, dep *appsv1.Deployment) (*appsv1.ReplicaSet, error) {
+	rsList := appsv1.ReplicaSetList{}
+	opts := []k8sclient.ListOption{
+		k8sclient.InNamespace(dep.Namespace),
+		k8sclient.MatchingLabels(dep.Spec.Selector.MatchLabels),
+	}
+	if err := mgr.k8sClient.List(ctx, &rsList, opts...); err != nil {
+		return nil, err
+	}
+
+	current := getRevision(dep)
+	var previous *appsv1.ReplicaSet
+	for idx := range rsList.Items {
+		rs := &rsList.Items[idx]
+		if !isOwnedBy(rs, dep) {
+			continue
+		}
+		revision := getRevision(rs)
+		if revision >= current {
+			continue
+		}
+		if previous == nil || revision > getRevision(previous) {
+			previous = rs
+		}
+	}
+	return previous, nil
+}
+
+func isOwnedBy(obj k8sclient.Object, owner k8sclient.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func getProxyRolloutHash(t *testing.T, k8sClient k8sclient.Client) string {
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(context.Background(), k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}, &dep); err != nil {
+		t.Fatal(err)
+	}
+	return getRolloutHash(&dep)
+}
+
+func TestRollbackFailedConfig(t *testing.T) {
+	ctx := context.Background()
+	port := func(port int) ioclient.MicroservicePublicPort {
+		return ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: port, Protocol: "http", Queue: fmt.Sprintf("abc-%d", port)}}
+	}
+	ioClient := NewFakeControllerClient(port(5000))
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyReadyTimeout = time.Minute
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	setProxyDeploymentReady(t, k8sClient, true)
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	working := dep.Spec.Template.DeepCopy()
+	workingHash := getRolloutHash(&dep)
+
+	// The rollout of the next config fails
+	setProxyDeploymentReady(t, k8sClient, false)
+	ioClient.SetPorts(port(5000), port(6000))
+	_ = mgr.run(ctx)
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	failedHash := getRolloutHash(&dep)
+	if failedHash == workingHash {
+		t.Fatal("Expected the Proxy config to change with the ports")
+	}
+	dep.Annotations = map[string]string{revisionAnnotation: "2"}
+	dep.Status = appsv1.DeploymentStatus{
+		ObservedGeneration: dep.Generation,
+		Conditions: []appsv1.DeploymentCondition{{
+			Type:   appsv1.DeploymentProgressing,
+			Status: corev1.ConditionFalse,
+			Reason: reasonProgressDeadlineExceeds,
+		}},
+	}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+	previous := appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "http-proxy-1",
+			Namespace:       "iofog",
+			Labels:          dep.Spec.Selector.MatchLabels,
+			Annotations:     map[string]string{revisionAnnotation: "1"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: dep.Name, UID: dep.UID}},
+		},
+		Spec: appsv1.ReplicaSetSpec{Selector: dep.Spec.Selector, Template: *working},
+	}
+	if err := k8sClient.Create(ctx, &previous); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.checkProxyRollout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hash := getProxyRolloutHash(t, k8sClient); hash != workingHash {
+		t.Fatalf("Expected Proxy to be rolled back to config %s, got %s", workingHash, hash)
+	}
+	if !mgr.rollbackAlert.firing {
+		t.Error("Expected an alert for the rollback")
+	}
+
+	// The failed config is not re-applied while the ports are unchanged
+	setProxyDeploymentReady(t, k8sClient, true)
+	if err := mgr.resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hash := getProxyRolloutHash(t, k8sClient); hash != workingHash {
+		t.Errorf("Expected the rolled back config to be kept, got %s", hash)
+	}
+	// The port of the failed rollout is not exposed by the rolled back Proxy's Service
+	svc := corev1.Service{}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 5000 {
+		t.Errorf("Expected the Service to keep only port 5000, got %v", svc.Spec.Ports)
+	}
+
+	// The failed rollout is restored from the Deployment after a restart
+	mgr.failedRollout = ""
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.failedRollout != failedHash {
+		t.Errorf("Expected the failed rollout %s to be restored, got %s", failedHash, mgr.failedRollout)
+	}
+	if err := mgr.resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hash := getProxyRolloutHash(t, k8sClient); hash != workingHash {
+		t.Errorf("Expected the rolled back config to be kept after a restart, got %s", hash)
+	}
+
+	// New ports are applied again
+	ioClient.SetPorts(port(5000), port(7000))
+	_ = mgr.run(ctx)
+	if hash := getProxyRolloutHash(t, k8sClient); hash == workingHash || hash == failedHash {
+		t.Errorf("Expected the config of the new ports to be applied, got %s", hash)
+	}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := dep.Annotations[failedRolloutAnnotation]; exists {
+		t.Errorf("Expected the failed rollout annotation to be removed, got %v", dep.Annotations)
+	}
+	setProxyDeploymentReady(t, k8sClient, true)
+	if err := mgr.checkProxyRollout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.rollbackAlert.firing {
+		t.Error("Expected the rollback alert to resolve once a new config rolled out")
+	}
+}
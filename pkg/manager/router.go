@@ -0,0 +1,331 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	routerSchemeAMQP      = "amqp"
+	routerSchemeAMQPS     = "amqps"
+	routerConfigKey       = "connect.json"
+	routerConfigMountPath = "/etc/messaging"
+	routerConfigVolume    = "router-config"
+	routerCAKey           = "ca.crt"
+	routerCertKey         = "tls.crt"
+	routerKeyKey          = "tls.key"
+	configHashAnnotation  = "iofog.org/proxy-config-hash"
+)
+
+// GetDefaultRouterPort returns the port used for router addresses that do not specify one
+func GetDefaultRouterPort(scheme string) int {
+	if scheme == routerSchemeAMQPS {
+		return 5671
+	}
+	return 5672
+}
+
+// GetRouterScheme resolves the scheme of the Proxy to router connection
+// TLS is implied when a Secret is provided and no scheme is specified
+func GetRouterScheme(scheme, tlsSecret string) (string, error) {
+	scheme = strings.ToLower(scheme)
+	switch scheme {
+	case "":
+		if tlsSecret != "" {
+			return routerSchemeAMQPS, nil
+		}
+		return routerSchemeAMQP, nil
+	case routerSchemeAMQP:
+		if tlsSecret != "" {
+			return "", errors.New("router TLS Secret cannot be used with scheme " + routerSchemeAMQP)
+		}
+		return scheme, nil
+	case routerSchemeAMQPS:
+		return scheme, nil
+	}
+	return "", errors.New("unsupported router scheme " + scheme)
+}
+
+// RouterAddress is an AMQP endpoint of an interior router the Proxy can connect to
+type RouterAddress struct {
+	Host string
+	Port int
+}
+
+func (addr RouterAddress) String() string {
+	return net.JoinHostPort(addr.Host, strconv.Itoa(addr.Port))
+}
+
+// ParseRouterAddresses parses a comma-separated list of host[:port] router addresses
+// The first address is the primary router, the rest are used for failover in order
+func ParseRouterAddresses(value string, defaultPort int) (addrs []RouterAddress, err error) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		addr := RouterAddress{Host: item, Port: defaultPort}
+		if host, port, splitErr := net.SplitHostPort(item); splitErr == nil {
+			if addr.Port, err = strconv.Atoi(port); err != nil || addr.Port < 1 || addr.Port > 65535 {
+				return nil, fmt.Errorf("invalid port in router address %s", item)
+			}
+			addr.Host = host
+		}
+		if addr.Host == "" {
+			return nil, fmt.Errorf("invalid router address %s", item)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no router address found in %s", value)
+	}
+	return addrs, nil
+}
+
+type routerConnection struct {
+	Host string `json:"host"`
+	Port string `json:"port"`
+}
+
+type routerTLSConfig struct {
+	CA     string `json:"ca"`
+	Cert   string `json:"cert"`
+	Key    string `json:"key"`
+	Verify bool   `json:"verify"`
+}
+
+type routerConfig struct {
+	Scheme string `json:"scheme"`
+	routerConnection
+	TLS      *routerTLSConfig   `json:"tls,omitempty"`
+	Failover []routerConnection `json:"failover,omitempty"`
+}
+
+// Render the connect.json consumed by the Proxy to reach the router(s)
+func getRouterConfig(opt *Options, routers []RouterAddress) (string, error) {
+	if len(routers) == 0 {
+		return "", fmt.Errorf("no router address provided")
+	}
+	config := routerConfig{
+		Scheme:           opt.RouterScheme,
+		routerConnection: newRouterConnection(routers[0]),
+	}
+	if config.Scheme == "" {
+		config.Scheme = routerSchemeAMQP
+	}
+	if opt.RouterTLSSecret != "" {
+		config.TLS = &routerTLSConfig{
+			CA:     path.Join(routerConfigMountPath, routerCAKey),
+			Cert:   path.Join(routerConfigMountPath, routerCertKey),
+			Key:    path.Join(routerConfigMountPath, routerKeyKey),
+			Verify: !opt.RouterTLSInsecure,
+		}
+	}
+	for _, router := range routers[1:] {
+		config.Failover = append(config.Failover, newRouterConnection(router))
+	}
+	bytes, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+func newRouterConnection(addr RouterAddress) routerConnection {
+	return routerConnection{
+		Host: addr.Host,
+		Port: strconv.Itoa(addr.Port),
+	}
+}
+
+// Node script run by the init container, exits once any router accepts a TCP connection
+const waitForRouterScript = `
+const net = require('net');
+const routers = JSON.parse(process.argv[1]);
+function attempt(idx) {
+  const router = routers[idx % routers.length];
+  const socket = net.connect(router.port, router.host);
+  let done = false;
+  const retry = () => {
+    if (done) return;
+    done = true;
+    socket.destroy();
+    console.log('Waiting for router at ' + router.host + ':' + router.port);
+    setTimeout(() => attempt(idx + 1), 2000);
+  };
+  socket.setTimeout(2000);
+  socket.on('connect', () => {
+    console.log('Router reachable at ' + router.host + ':' + router.port);
+    process.exit(0);
+  });
+  socket.on('error', retry);
+  socket.on('timeout', retry);
+}
+attempt(0);
+`
+
+const waitForRouterContainerName = "wait-for-router"
+
+// Init container that blocks the Proxy from starting until a router is reachable
+func newWaitForRouterContainer(opt *Options) (corev1.Container, error) {
+	routers := make([]routerConnection, 0, len(opt.RouterAddresses))
+	for _, addr := range opt.RouterAddresses {
+		routers = append(routers, newRouterConnection(addr))
+	}
+	bytes, err := json.Marshal(routers)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	return corev1.Container{
+		Name:            waitForRouterContainerName,
+		Image:           opt.ProxyImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"node", "-e", waitForRouterScript, string(bytes)},
+	}, nil
+}
+
+func getRouterConfigMapName(proxyName string) string {
+	return proxyName + "-router"
+}
+
+// The spilled Proxy config, if any, is stored next to the router config so it is mounted into the Proxy pods
+func newRouterConfigMap(namespace, proxyName, config, proxyConfig string) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getRouterConfigMapName(proxyName),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"name": proxyName,
+			},
+		},
+		Data: map[string]string{
+			routerConfigKey: config,
+		},
+	}
+	if proxyConfig != "" {
+		cm.Data[proxyConfigKey] = proxyConfig
+	}
+	return cm
+}
+
+func hashConfig(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Hash Secret data in key order so equal content always hashes the same
+func hashSecretData(secret *corev1.Secret) string {
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		parts = append(parts, key, string(secret.Data[key]))
+	}
+	return hashConfig(parts...)
+}
+
+// Hash of everything mounted into the Proxy pods
+// Annotating the pod template with it rolls the Proxy whenever the router config, spilled Proxy config or TLS credentials change
+func (mgr *Manager) getConfigHash(ctx context.Context, routerConfig, proxyConfig string) (string, error) {
+	parts := []string{routerConfig}
+	if proxyConfig != "" {
+		parts = append(parts, proxyConfig)
+	}
+	// Restart the Proxy when the pods of a headless router Service change
+	if mgr.routerEndpoints != "" {
+		parts = append(parts, mgr.routerEndpoints)
+	}
+	if mgr.opt.RouterTLSSecret == "" {
+		return hashConfig(parts...), nil
+	}
+	secret := corev1.Secret{}
+	key := k8sclient.ObjectKey{
+		Name:      mgr.opt.RouterTLSSecret,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(ctx, key, &secret); err != nil {
+		return "", err
+	}
+	return hashConfig(append(parts, hashSecretData(&secret))...), nil
+}
+
+// Create or update the ConfigMap holding the router config mounted by the Proxy
+func (mgr *Manager) applyRouterConfigMap(ctx context.Context, config, proxyConfig string) error {
+	cm := newRouterConfigMap(mgr.opt.Namespace, mgr.opt.ProxyName, config, proxyConfig)
+	found := corev1.ConfigMap{}
+	if err := mgr.k8sClient.Get(ctx, k8sclient.ObjectKeyFromObject(cm), &found); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+		mgr.setOwnerReference(cm)
+		return mgr.k8sClient.Create(ctx, cm)
+	}
+	if reflect.DeepEqual(found.Data, cm.Data) {
+		return nil
+	}
+	found.Data = cm.Data
+	return mgr.k8sClient.Update(ctx, &found)
+}
+
+// Read the Proxy config spilled to the router ConfigMap
+func (mgr *Manager) getSpilledProxyConfig(ctx context.Context) (string, error) {
+	cm := corev1.ConfigMap{}
+	key := k8sclient.ObjectKey{
+		Name:      getRouterConfigMapName(mgr.opt.ProxyName),
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(ctx, key, &cm); err != nil {
+		return "", err
+	}
+	config, exists := cm.Data[proxyConfigKey]
+	if !exists {
+		return "", fmt.Errorf("router ConfigMap %s has no %s key", key.Name, proxyConfigKey)
+	}
+	return config, nil
+}
+
+func (mgr *Manager) deleteRouterConfigMap(ctx context.Context) error {
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      getRouterConfigMapName(mgr.opt.ProxyName),
+		Namespace: mgr.opt.Namespace,
+	}}
+	if err := mgr.k8sClient.Delete(ctx, cm); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"testing"
+)
+
+func TestParseRouterAddresses(t *testing.T) {
+	addrs, err := ParseRouterAddresses("router, backup:5673,[fd00::1]:5674", GetDefaultRouterPort("amqp"))
+	if err != nil {
+		t.Fatalf("Failed to parse router addresses: %s", err.Error())
+	}
+	expected := []RouterAddress{
+		{Host: "router", Port: 5672},
+		{Host: "backup", Port: 5673},
+		{Host: "fd00::1", Port: 5674},
+	}
+	if len(addrs) != len(expected) {
+		t.Fatalf("Expected %d router addresses, got %d", len(expected), len(addrs))
+	}
+	for idx := range expected {
+		if addrs[idx] != expected[idx] {
+			t.Errorf("Expected %v, got %v", expected[idx], addrs[idx])
+		}
+	}
+
+	for _, invalid := range []string{"", "router:abc", "router:0", ":5672"} {
+		if _, err := ParseRouterAddresses(invalid, 5672); err == nil {
+			t.Errorf("Expected error for router address %q", invalid)
+		}
+	}
+}
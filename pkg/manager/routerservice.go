@@ -0,0 +1,200 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	defaultRouterService = "router"
+
+	statusErrorRouterDiscovery = "router-discovery"
+)
+
+// Whether the router Service is a label selector rather than a name, e.g. application=interior-router
+func isRouterServiceSelector(service string) bool {
+	return strings.ContainsAny(service, "=!")
+}
+
+// Check the router Service is a Service name or a valid label selector
+func validateRouterService(service string) error {
+	if !isRouterServiceSelector(service) {
+		if errs := validation.IsDNS1035Label(service); len(errs) > 0 {
+			return fmt.Errorf("invalid router Service %s: %s", service, strings.Join(errs, ", "))
+		}
+		return nil
+	}
+	if _, err := labels.Parse(service); err != nil {
+		return fmt.Errorf("invalid router Service selector %s: %s", service, err.Error())
+	}
+	return nil
+}
+
+// Get the router Service by name, or the first Service by name matching the label selector
+func (mgr *Manager) getRouterService(ctx context.Context) (*corev1.Service, error) {
+	if !isRouterServiceSelector(mgr.opt.RouterService) {
+		svc := &corev1.Service{}
+		key := k8sclient.ObjectKey{Name: mgr.opt.RouterService, Namespace: mgr.opt.Namespace}
+		if err := mgr.k8sClient.Get(ctx, key, svc); err != nil {
+			return nil, err
+		}
+		return svc, nil
+	}
+	selector, err := labels.Parse(mgr.opt.RouterService)
+	if err != nil {
+		return nil, err
+	}
+	svcs := corev1.ServiceList{}
+	if err := mgr.k8sClient.List(ctx, &svcs, k8sclient.InNamespace(mgr.opt.Namespace), k8sclient.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	if len(svcs.Items) == 0 {
+		return nil, fmt.Errorf("no Service matches the router selector %s", mgr.opt.RouterService)
+	}
+	sort.Slice(svcs.Items, func(i, j int) bool { return svcs.Items[i].Name < svcs.Items[j].Name })
+	return &svcs.Items[0], nil
+}
+
+// Address of a router Service by its cluster DNS name, on the port named after the scheme or else the default port of the scheme
+func getRouterServiceAddress(svc *corev1.Service, scheme string) (RouterAddress, error) {
+	host := svc.Name + "." + svc.Namespace + ".svc"
+	for _, port := range svc.Spec.Ports {
+		if port.Name == scheme {
+			return RouterAddress{Host: host, Port: int(port.Port)}, nil
+		}
+	}
+	defaultPort := GetDefaultRouterPort(scheme)
+	for _, port := range svc.Spec.Ports {
+		if int(port.Port) == defaultPort {
+			return RouterAddress{Host: host, Port: defaultPort}, nil
+		}
+	}
+	return RouterAddress{}, fmt.Errorf("router Service %s has no %s port nor port %d", svc.Name, scheme, defaultPort)
+}
+
+// Ready endpoints of a headless router Service, which the Proxy resolves the router address to when it starts
+// Empty for Services with a ClusterIP, which stays the same when the router pods move
+func (mgr *Manager) getRouterEndpoints(ctx context.Context, svc *corev1.Service) (string, error) {
+	if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		return "", nil
+	}
+	slices := discoveryv1.EndpointSliceList{}
+	if err := mgr.k8sClient.List(ctx, &slices, k8sclient.InNamespace(svc.Namespace), k8sclient.MatchingLabels{discoveryv1.LabelServiceName: svc.Name}); err != nil {
+		return "", err
+	}
+	var endpoints []string
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			endpoints = append(endpoints, endpoint.Addresses...)
+		}
+	}
+	sort.Strings(endpoints)
+	return strings.Join(endpoints, ","), nil
+}
+
+// Discover the router address from the router Service, returning whether it or the endpoints of a headless Service
+// changed so the Proxy is re-rendered and, for endpoint changes, restarted to resolve the router again
+// The previous address is kept while the Service cannot be found
+func (mgr *Manager) discoverRouter(ctx context.Context) (changed bool, err error) {
+	defer func() { mgr.status.setError(statusErrorRouterDiscovery, err) }()
+
+	svc, err := mgr.getRouterService(ctx)
+	if err != nil {
+		return false, err
+	}
+	addr, err := getRouterServiceAddress(svc, mgr.opt.RouterScheme)
+	if err != nil {
+		return false, err
+	}
+	endpoints, err := mgr.getRouterEndpoints(ctx, svc)
+	if err != nil {
+		return false, err
+	}
+	mgr.routerServiceName.Store(svc.Name)
+	if endpoints != mgr.routerEndpoints {
+		mgr.getLog(ctx).Info("Router endpoints changed", "service", svc.Name, "endpoints", endpoints)
+		mgr.routerEndpoints = endpoints
+		changed = true
+	}
+	if len(mgr.opt.RouterAddresses) == 1 && mgr.opt.RouterAddresses[0] == addr {
+		return changed, nil
+	}
+	mgr.getLog(ctx).Info("Discovered router address", "service", svc.Name, "address", addr.String())
+	mgr.opt.RouterAddresses = []RouterAddress{addr}
+	mgr.routerAddresses.Store([]RouterAddress{addr})
+	return true, nil
+}
+
+// Router addresses as last discovered, safe to call outside of Reconcile
+func (mgr *Manager) getRouterAddresses() []RouterAddress {
+	addrs, _ := mgr.routerAddresses.Load().([]RouterAddress)
+	return addrs
+}
+
+// Name of the router Service, known once discovered if RouterService is a selector
+func (mgr *Manager) getRouterServiceName() string {
+	if !isRouterServiceSelector(mgr.opt.RouterService) {
+		return mgr.opt.RouterService
+	}
+	name, _ := mgr.routerServiceName.Load().(string)
+	return name
+}
+
+// Select the EndpointSlices of the router Services by their Service name label, all of them if a router Service is a selector
+func newRouterEndpointsSelector(mgrs []*Manager) (labels.Selector, error) {
+	var names []string
+	for _, mgr := range mgrs {
+		if !mgr.routerDiscovery {
+			continue
+		}
+		if isRouterServiceSelector(mgr.opt.RouterService) {
+			return labels.Everything(), nil
+		}
+		names = append(names, mgr.opt.RouterService)
+	}
+	if len(names) == 0 {
+		return labels.Nothing(), nil
+	}
+	requirement, err := labels.NewRequirement(discoveryv1.LabelServiceName, selection.In, names)
+	if err != nil {
+		return nil, err
+	}
+	return labels.NewSelector().Add(*requirement), nil
+}
+
+// Pass the EndpointSlices of the router Service, or all of them until a router Service selector is resolved
+func (mgr *Manager) newRouterEndpointsPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj k8sclient.Object) bool {
+		if obj.GetNamespace() != mgr.opt.Namespace {
+			return false
+		}
+		name := mgr.getRouterServiceName()
+		return name == "" || obj.GetLabels()[discoveryv1.LabelServiceName] == name
+	})
+}
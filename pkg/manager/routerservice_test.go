@@ -0,0 +1,182 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestDiscoverRouter(t *testing.T) {
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.RouterAddresses = nil
+	mgr.opt.RouterScheme = routerSchemeAMQPS
+	mgr.routerDiscovery = true
+
+	if _, err := mgr.discoverRouter(ctx); err == nil {
+		t.Fatal("Expected discovery to fail without a router Service")
+	}
+	if _, exists := mgr.status.errors[statusErrorRouterDiscovery]; !exists {
+		t.Error("Expected the failed discovery in the status")
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "iofog", Labels: map[string]string{"application": "interior-router"}},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "interior", Port: 55671},
+			{Name: "amqps", Port: 5673},
+		}},
+	}
+	if err := k8sClient.Create(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := mgr.discoverRouter(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := RouterAddress{Host: "router.iofog.svc", Port: 5673}
+	if !changed || len(mgr.opt.RouterAddresses) != 1 || mgr.opt.RouterAddresses[0] != expected {
+		t.Errorf("Expected router address %s, got %v", expected, mgr.opt.RouterAddresses)
+	}
+	if changed, _ := mgr.discoverRouter(ctx); changed {
+		t.Error("Expected an unchanged router address")
+	}
+
+	// Without a port named after the scheme the default port of the scheme is used
+	svc.Spec.Ports = []corev1.ServicePort{{Name: "messaging", Port: 5671}}
+	if err := k8sClient.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	mgr.opt.RouterService = "application=interior-router"
+	if changed, err := mgr.discoverRouter(ctx); err != nil || !changed || mgr.opt.RouterAddresses[0].Port != 5671 {
+		t.Errorf("Expected the default port by selector, got %v: %v", mgr.opt.RouterAddresses, err)
+	}
+
+	// The previous address is kept while the Service is missing
+	if err := k8sClient.Delete(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.discoverRouter(ctx); err == nil || len(mgr.opt.RouterAddresses) != 1 {
+		t.Errorf("Expected the previous router address to be kept, got %v", mgr.opt.RouterAddresses)
+	}
+
+	if err := validateRouterService("name in (router,"); err == nil {
+		t.Error("Expected an invalid selector to be rejected")
+	}
+}
+
+// Run with -race: the notification routine reads the addresses discovered by Reconcile
+func TestDiscoverRouterHandoff(t *testing.T) {
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.RouterAddresses = nil
+	mgr.routerDiscovery = true
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "iofog"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "amqp", Port: 5672}}},
+	}
+	if err := k8sClient.Create(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	read := make(chan []RouterAddress)
+	go func() {
+		for {
+			select {
+			case <-done:
+				read <- mgr.getRouterAddresses()
+				return
+			default:
+				_ = mgr.getRouterAddresses()
+			}
+		}
+	}()
+	for port := int32(5672); port < 5682; port++ {
+		svc.Spec.Ports[0].Port = port
+		if err := k8sClient.Update(ctx, svc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mgr.discoverRouter(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	addrs := <-read
+	expected := RouterAddress{Host: "router.iofog.svc", Port: 5681}
+	if len(addrs) != 1 || addrs[0] != expected {
+		t.Errorf("Expected the routine to read router address %s, got %v", expected, addrs)
+	}
+}
+
+func TestRouterEndpoints(t *testing.T) {
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.RouterAddresses = nil
+	mgr.routerDiscovery = true
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "iofog"},
+		Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone, Ports: []corev1.ServicePort{{Name: "amqp", Port: 5672}}},
+	}
+	notReady := false
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta:  metav1.ObjectMeta{Name: "router-abc", Namespace: "iofog", Labels: map[string]string{discoveryv1.LabelServiceName: "router"}},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"10.1.0.5"}},
+			{Addresses: []string{"10.1.0.6"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+		},
+	}
+	for _, obj := range []k8sclient.Object{svc, slice} {
+		if err := k8sClient.Create(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mgr.discoverRouter(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.routerEndpoints != "10.1.0.5" {
+		t.Errorf("Expected the ready endpoints of the headless Service, got %s", mgr.routerEndpoints)
+	}
+	hash, err := mgr.getConfigHash(ctx, "config", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Moved router pods roll the Proxy so it resolves the router again
+	slice.Endpoints[0].Addresses = []string{"10.1.0.7"}
+	if err := k8sClient.Update(ctx, slice); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := mgr.discoverRouter(ctx); err != nil || !changed {
+		t.Errorf("Expected the endpoint change to be reported: %v", err)
+	}
+	if moved, _ := mgr.getConfigHash(ctx, "config", ""); moved == hash {
+		t.Error("Expected the config hash to change with the router endpoints")
+	}
+
+	predicate := mgr.newRouterEndpointsPredicate()
+	other := &discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "iofog", Labels: map[string]string{discoveryv1.LabelServiceName: "db"}}}
+	if !predicate.Generic(event.GenericEvent{Object: slice}) || predicate.Generic(event.GenericEvent{Object: other}) {
+		t.Error("Expected only the EndpointSlices of the router Service to pass")
+	}
+}
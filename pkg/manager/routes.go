@@ -0,0 +1,228 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	statusErrorRoutes = "routes"
+
+	// Keys of the annotations last set on a route, so annotations dropped from the route or the Options are removed
+	routeAnnotationsAnnotation = "iofog.org/proxy-route-annotations"
+)
+
+// Ingress controllers the Proxy Service can be exposed through instead of a LoadBalancer, see IngressMode
+const (
+	IngressModeIstio   = "istio"
+	IngressModeTraefik = "traefik"
+	IngressModeContour = "contour"
+	IngressModeKong    = "kong"
+	IngressModeGateway = "gateway"
+)
+
+// Renders the resources of an ingress controller routing the ports to the Proxy Service
+// The resources are unstructured so the APIs of the ingress controllers are not required by the Manager
+type routeRenderer interface {
+	// Kinds of all rendered resources, existing resources of these kinds labelled for the Proxy are pruned
+	kinds() []schema.GroupVersionKind
+	// The error lists the ports that cannot be routed, the resources of the others are still applied
+	render(mgr *Manager, ports portMap) ([]*unstructured.Unstructured, error)
+}
+
+var routeRenderers = map[string]routeRenderer{
+	IngressModeIstio:   istioRenderer{},
+	IngressModeTraefik: traefikRenderer{},
+	IngressModeContour: contourRenderer{},
+	IngressModeKong:    kongRenderer{},
+	IngressModeGateway: gatewayRenderer{},
+}
+
+// ParseIngressMode checks the ingress controller the ports are routed through, empty disables routing
+func ParseIngressMode(mode string) (string, error) {
+	if _, exists := routeRenderers[mode]; mode != "" && !exists {
+		modes := make([]string, 0, len(routeRenderers))
+		for mode := range routeRenderers {
+			modes = append(modes, mode)
+		}
+		sort.Strings(modes)
+		return "", fmt.Errorf("unsupported ingress mode %s, expected one of %v", mode, modes)
+	}
+	return mode, nil
+}
+
+// ParseAnnotations parses semicolon-separated annotations added to the ingress controller or Proxy resources
+// Values may contain commas, e.g. konghq.com/plugins=rate-limiting,key-auth;konghq.com/strip-path=true
+func ParseAnnotations(value string) (map[string]string, error) {
+	annotations := make(map[string]string)
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		keyValue := strings.SplitN(item, "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid annotation %s: expected key=value", item)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid annotation key %s: %s", key, strings.Join(errs, ", "))
+		}
+		annotations[key] = strings.TrimSpace(keyValue[1])
+	}
+	return annotations, nil
+}
+
+// Whether all annotations are set on an object
+func hasAnnotations(obj *unstructured.Unstructured, annotations map[string]string) bool {
+	current := obj.GetAnnotations()
+	for key, value := range annotations {
+		if existing, exists := current[key]; !exists || existing != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Sorted ports, so the rendered resources only change with the ports
+func getSortedPorts(ports portMap) []int {
+	keys := make([]int, 0, len(ports))
+	for port := range ports {
+		keys = append(keys, port)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func newRouteObject(gvk schema.GroupVersionKind, name, namespace string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(name)
+	obj.SetNamespace(namespace)
+	return obj
+}
+
+// Create, update and delete the ingress controller resources routing the cached ports to the Proxy Service
+func (mgr *Manager) syncRoutes(ctx context.Context) error {
+	renderer := routeRenderers[mgr.opt.IngressMode]
+	if renderer == nil {
+		return nil
+	}
+	objs, renderErr := renderer.render(mgr, mgr.loadSnapshot().ports)
+	rendered, err := json.Marshal(objs)
+	if err != nil {
+		return err
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(rendered))
+	if hash == mgr.syncedRoutes {
+		return renderErr
+	}
+
+	existing := make(map[schema.GroupVersionKind]map[string]*unstructured.Unstructured)
+	for _, gvk := range renderer.kinds() {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		existing[gvk] = make(map[string]*unstructured.Unstructured)
+		if err := mgr.k8sClient.List(ctx, list, k8sclient.InNamespace(mgr.opt.Namespace), k8sclient.MatchingLabels{publicPortProxyLabel: mgr.opt.ProxyName}); err != nil {
+			// Optional APIs, e.g. experimental routes, fail when a resource of their kind is created
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return fmt.Errorf("could not list %s: %s", gvk.Kind, err.Error())
+		}
+		for idx := range list.Items {
+			existing[gvk][list.Items[idx].GetName()] = &list.Items[idx]
+		}
+	}
+
+	for _, obj := range objs {
+		obj.SetLabels(map[string]string{publicPortProxyLabel: mgr.opt.ProxyName})
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		for key, value := range mgr.opt.IngressAnnotations {
+			annotations[key] = value
+		}
+		setRouteAnnotations(obj, annotations)
+		mgr.setOwnerReference(obj)
+		gvk := obj.GroupVersionKind()
+		found, exists := existing[gvk][obj.GetName()]
+		delete(existing[gvk], obj.GetName())
+		if !exists {
+			if err := mgr.k8sClient.Create(ctx, obj); err != nil {
+				return err
+			}
+			continue
+		}
+		// Annotations of other tools are kept, the recorded keys only match while no annotation was dropped
+		recorded := found.GetAnnotations()[routeAnnotationsAnnotation] == obj.GetAnnotations()[routeAnnotationsAnnotation]
+		if equality.Semantic.DeepEqual(found.Object["spec"], obj.Object["spec"]) && hasAnnotations(found, obj.GetAnnotations()) && recorded {
+			continue
+		}
+		found.Object["spec"] = obj.Object["spec"]
+		setRouteAnnotations(found, annotations)
+		if err := mgr.k8sClient.Update(ctx, found); err != nil {
+			return err
+		}
+	}
+
+	// Routes of ports no longer exposed
+	for _, objs := range existing {
+		for _, obj := range objs {
+			if err := mgr.k8sClient.Delete(ctx, obj); k8sclient.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+
+	mgr.syncedRoutes = hash
+	return renderErr
+}
+
+// Merge the annotations into a route, removing those set before that are no longer rendered or configured
+// Other annotations are kept
+func setRouteAnnotations(obj *unstructured.Unstructured, annotations map[string]string) {
+	var previous []string
+	if recorded, exists := obj.GetAnnotations()[routeAnnotationsAnnotation]; exists {
+		_ = json.Unmarshal([]byte(recorded), &previous)
+	}
+	merged := mergeCustomMetadata(obj.GetAnnotations(), previous, annotations)
+	if len(annotations) == 0 {
+		delete(merged, routeAnnotationsAnnotation)
+	} else if data, err := json.Marshal(sortedKeys(toSet(annotations))); err == nil {
+		merged[routeAnnotationsAnnotation] = string(data)
+	}
+	obj.SetAnnotations(merged)
+}
+
+// Error listing the ports an ingress controller cannot route, nil if there are none
+func newUnroutedPortsError(ports []int, reason string) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	return fmt.Errorf("ports %v are not routed: %s", ports, reason)
+}
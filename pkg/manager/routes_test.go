@@ -0,0 +1,239 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestIstioRoutes(t *testing.T) {
+	if _, err := ParseIngressMode("nginx"); err == nil {
+		t.Error("Expected unsupported ingress mode to be rejected")
+	}
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.IngressMode = IngressModeIstio
+	mgr.cache[5000] = ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}
+	mgr.cache[6000] = ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}
+	mgr.saveSnapshot()
+	if err := mgr.syncRoutes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	virtualService := &unstructured.Unstructured{}
+	virtualService.SetGroupVersionKind(istioVirtualServiceKind)
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: mgr.opt.ProxyName, Namespace: "iofog"}, virtualService); err != nil {
+		t.Fatalf("Expected VirtualService: %s", err.Error())
+	}
+	httpRoutes, _, _ := unstructured.NestedSlice(virtualService.Object, "spec", "http")
+	tcpRoutes, _, _ := unstructured.NestedSlice(virtualService.Object, "spec", "tcp")
+	if len(httpRoutes) != 1 || len(tcpRoutes) != 1 {
+		t.Errorf("Expected an http and a tcp route, got %v and %v", httpRoutes, tcpRoutes)
+	}
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(istioGatewayKind)
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: mgr.opt.ProxyName, Namespace: "iofog"}, gateway); err != nil {
+		t.Fatalf("Expected Gateway: %s", err.Error())
+	}
+	if selector, _, _ := unstructured.NestedString(gateway.Object, "spec", "selector", "istio"); selector != defaultIstioGateway {
+		t.Errorf("Unexpected Gateway selector %s", selector)
+	}
+
+	// Removed ports are pruned with the resources once no port is left
+	mgr.cache = make(portMap)
+	mgr.saveSnapshot()
+	if err := mgr.syncRoutes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: mgr.opt.ProxyName, Namespace: "iofog"}, gateway); err == nil {
+		t.Error("Expected Gateway to be deleted")
+	}
+}
+
+func TestTraefikRoutes(t *testing.T) {
+	mgr, _ := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.IngressMode = IngressModeTraefik
+	ports := portMap{
+		5000: {Port: 5000, Protocol: "http", Queue: "abc-5000"},
+		5001: {Port: 5001, Protocol: "grpc", Queue: "abc-5001"},
+		6000: {Port: 6000, Protocol: "tcp", Queue: "abc-6000"},
+	}
+	mgr.hostnames[5000] = "dashboard.edge.example.com"
+	objs, err := traefikRenderer{}.render(mgr, ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 3 {
+		t.Fatalf("Expected a route per port, got %d", len(objs))
+	}
+	expected := []struct {
+		kind, entryPoint, match string
+	}{
+		{"IngressRoute", "web", "Host(`dashboard.edge.example.com`)"},
+		{"IngressRoute", "port-5001", "PathPrefix(`/`)"},
+		{"IngressRouteTCP", "port-6000", "HostSNI(`*`)"},
+	}
+	for idx, obj := range objs {
+		entryPoints, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "entryPoints")
+		routes, _, _ := unstructured.NestedSlice(obj.Object, "spec", "routes")
+		match, _, _ := unstructured.NestedString(routes[0].(map[string]interface{}), "match")
+		if obj.GetKind() != expected[idx].kind || entryPoints[0] != expected[idx].entryPoint || match != expected[idx].match {
+			t.Errorf("Unexpected %s on %v matching %s", obj.GetKind(), entryPoints, match)
+		}
+	}
+
+	// HTTP ports the Proxy terminates TLS on are passed through by SNI
+	mgr.opt.ProxyTLSSecret = "wildcard-tls"
+	objs, _ = traefikRenderer{}.render(mgr, ports)
+	if passthrough, _, _ := unstructured.NestedBool(objs[0].Object, "spec", "tls", "passthrough"); objs[0].GetKind() != "IngressRouteTCP" || !passthrough {
+		t.Errorf("Expected TLS passthrough route, got %v", objs[0].Object)
+	}
+}
+
+func TestContourRoutes(t *testing.T) {
+	mgr, _ := newFakeManager(t, NewFakeControllerClient())
+	ports := portMap{
+		5000: {Port: 5000, Protocol: "ws", Queue: "abc-5000"},
+		5001: {Port: 5001, Protocol: "http", Queue: "abc-5001"},
+		6000: {Port: 6000, Protocol: "tcp", Queue: "abc-6000"},
+	}
+	mgr.hostnames[5000] = "chat.edge.example.com"
+	objs, err := contourRenderer{}.render(mgr, ports)
+	if err == nil {
+		t.Error("Expected ports without a hostname to be reported")
+	}
+	if len(objs) != 1 || objs[0].GetName() != mgr.opt.ProxyName+"-5000" {
+		t.Fatalf("Expected an HTTPProxy for the port with a hostname, got %v", objs)
+	}
+	if fqdn, _, _ := unstructured.NestedString(objs[0].Object, "spec", "virtualhost", "fqdn"); fqdn != "chat.edge.example.com" {
+		t.Errorf("Unexpected HTTPProxy fqdn %s", fqdn)
+	}
+	routes, _, _ := unstructured.NestedSlice(objs[0].Object, "spec", "routes")
+	if len(routes) != 1 || routes[0].(map[string]interface{})["enableWebsockets"] != true {
+		t.Errorf("Expected WebSocket route, got %v", routes)
+	}
+}
+
+func TestKongRoutes(t *testing.T) {
+	annotations, err := ParseAnnotations("konghq.com/plugins=rate-limiting,key-auth; konghq.com/strip-path=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annotations["konghq.com/plugins"] != "rate-limiting,key-auth" || len(annotations) != 2 {
+		t.Errorf("Unexpected annotations %v", annotations)
+	}
+	if _, err := ParseAnnotations("konghq.com/plugins"); err == nil {
+		t.Error("Expected annotation without value to be rejected")
+	}
+
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.IngressMode = IngressModeKong
+	mgr.opt.IngressAnnotations = annotations
+	mgr.cache[5000] = ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}
+	mgr.cache[6000] = ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}
+	mgr.hostnames[5000] = "api.edge.example.com"
+	mgr.saveSnapshot()
+	if err := mgr.syncRoutes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ingress := &unstructured.Unstructured{}
+	ingress.SetGroupVersionKind(kongIngressKind)
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: mgr.opt.ProxyName + "-5000", Namespace: "iofog"}, ingress); err != nil {
+		t.Fatalf("Expected Ingress: %s", err.Error())
+	}
+	if ingress.GetAnnotations()["konghq.com/plugins"] != "rate-limiting,key-auth" {
+		t.Errorf("Expected plugins annotation, got %v", ingress.GetAnnotations())
+	}
+	tcpIngress := &unstructured.Unstructured{}
+	tcpIngress.SetGroupVersionKind(kongTCPIngressKind)
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: mgr.opt.ProxyName + "-6000", Namespace: "iofog"}, tcpIngress); err != nil {
+		t.Fatalf("Expected TCPIngress: %s", err.Error())
+	}
+	if class := tcpIngress.GetAnnotations()["kubernetes.io/ingress.class"]; class != defaultKongIngressClass {
+		t.Errorf("Unexpected TCPIngress class %s", class)
+	}
+
+	// Annotations dropped from the settings are removed, those of other tools are kept
+	annotations = ingress.GetAnnotations()
+	annotations["other"] = "kept"
+	ingress.SetAnnotations(annotations)
+	if err := k8sClient.Update(ctx, ingress); err != nil {
+		t.Fatal(err)
+	}
+	mgr.opt.IngressAnnotations = nil
+	mgr.syncedRoutes = ""
+	if err := mgr.syncRoutes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: mgr.opt.ProxyName + "-5000", Namespace: "iofog"}, ingress); err != nil {
+		t.Fatal(err)
+	}
+	if annotations := ingress.GetAnnotations(); len(annotations) != 1 || annotations["other"] != "kept" {
+		t.Errorf("Expected only the annotation of another tool, got %v", annotations)
+	}
+}
+
+func TestGatewayRoutes(t *testing.T) {
+	ctx := context.Background()
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.IngressMode = IngressModeGateway
+	mgr.opt.IngressGatewayClass = "edge"
+	mgr.cache[5000] = ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}
+	mgr.cache[6000] = ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}
+	mgr.hostnames[5000] = "app.edge.example.com"
+	mgr.saveSnapshot()
+	if err := mgr.syncRoutes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(gatewayKind)
+	key := k8sclient.ObjectKey{Name: mgr.opt.ProxyName, Namespace: "iofog"}
+	if err := k8sClient.Get(ctx, key, gateway); err != nil {
+		t.Fatalf("Expected owned Gateway: %s", err.Error())
+	}
+	listeners, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	if len(listeners) != 2 || listeners[0].(map[string]interface{})["hostname"] != "app.edge.example.com" {
+		t.Errorf("Expected a listener per port, got %v", listeners)
+	}
+
+	// Listeners of removed ports are pruned
+	delete(mgr.cache, 6000)
+	mgr.saveSnapshot()
+	if err := mgr.syncRoutes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, gateway); err != nil {
+		t.Fatal(err)
+	}
+	if listeners, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners"); len(listeners) != 1 {
+		t.Errorf("Expected the listener of the removed port to be pruned, got %v", listeners)
+	}
+	tcpRoute := &unstructured.Unstructured{}
+	tcpRoute.SetGroupVersionKind(tcpRouteKind)
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: mgr.opt.ProxyName + "-6000", Namespace: "iofog"}, tcpRoute); err == nil {
+		t.Error("Expected the TCPRoute of the removed port to be deleted")
+	}
+
+	mgr.opt.IngressGatewayClass = ""
+	if _, err := (gatewayRenderer{}).render(mgr, mgr.cache); err == nil {
+		t.Error("Expected error without a Gateway to attach to")
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const serviceExportKind = "ServiceExport"
+
+// ParseServiceExportAPI checks the group/version of the ServiceExport kind of an MCS API implementation,
+// e.g. multicluster.x-k8s.io/v1alpha1 for Submariner or net.gke.io/v1 for GKE, empty disables exporting
+func ParseServiceExportAPI(api string) (string, error) {
+	if api == "" {
+		return "", nil
+	}
+	gv, err := schema.ParseGroupVersion(api)
+	if err != nil {
+		return "", err
+	}
+	if gv.Group == "" || gv.Version == "" {
+		return "", fmt.Errorf("expected a group/version, got %s", api)
+	}
+	return api, nil
+}
+
+func (mgr *Manager) newServiceExport() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(schema.FromAPIVersionAndKind(mgr.opt.ServiceExportAPI, serviceExportKind))
+	obj.SetName(mgr.opt.ProxyName)
+	obj.SetNamespace(mgr.opt.Namespace)
+	return obj
+}
+
+// Export the Proxy Service to the peer clusters of the ClusterSet, the ServiceExport has no spec so an existing one is kept
+func (mgr *Manager) exportProxyService(ctx context.Context) error {
+	if mgr.opt.ServiceExportAPI == "" {
+		return nil
+	}
+	obj := mgr.newServiceExport()
+	key := k8sclient.ObjectKey{Name: obj.GetName(), Namespace: obj.GetNamespace()}
+	if err := mgr.k8sClient.Get(ctx, key, obj.DeepCopy()); err == nil || !k8serrors.IsNotFound(err) {
+		return wrapServiceExportError(err)
+	}
+	obj.SetLabels(map[string]string{publicPortProxyLabel: mgr.opt.ProxyName})
+	mgr.setOwnerReference(obj)
+	return wrapServiceExportError(mgr.k8sClient.Create(ctx, obj))
+}
+
+// Withdraw the Proxy Service from the peer clusters before it is deleted
+func (mgr *Manager) unexportProxyService(ctx context.Context) error {
+	if mgr.opt.ServiceExportAPI == "" {
+		return nil
+	}
+	return wrapServiceExportError(k8sclient.IgnoreNotFound(mgr.k8sClient.Delete(ctx, mgr.newServiceExport())))
+}
+
+func wrapServiceExportError(err error) error {
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("the MCS API is not installed: %s", err.Error())
+	}
+	return err
+}
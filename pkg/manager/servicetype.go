@@ -0,0 +1,211 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Types ports can be exposed with instead of the Proxy Service type, each by its own Service
+var serviceTypes = []corev1.ServiceType{
+	corev1.ServiceTypeClusterIP,
+	corev1.ServiceTypeNodePort,
+	corev1.ServiceTypeLoadBalancer,
+}
+
+// ParseServiceType checks the type of the Proxy Service or of the Service of a port, case-insensitive
+func ParseServiceType(value string) (string, error) {
+	for _, serviceType := range serviceTypes {
+		if strings.EqualFold(value, string(serviceType)) {
+			return string(serviceType), nil
+		}
+	}
+	return "", fmt.Errorf("must be %s, %s or %s", corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer)
+}
+
+// Type of the Service exposing a port, the Proxy Service type unless the port settings override it
+func getPortServiceType(opt *Options, port int) string {
+	if serviceType := opt.PortSettings.Get(port).ServiceType; serviceType != "" {
+		return serviceType
+	}
+	return opt.ProxyServiceType
+}
+
+// Whether a port is exposed by the Proxy Service rather than the Service of another type
+func isProxyServicePort(opt *Options, port int) bool {
+	return strings.EqualFold(getPortServiceType(opt, port), opt.ProxyServiceType)
+}
+
+// Ports exposed by the Service of a type, the Proxy Service exposes the ports of its own type
+func getServiceTypePorts(ports portMap, opt *Options, serviceType string) portMap {
+	filtered := make(portMap)
+	for port, publicPort := range ports {
+		if strings.EqualFold(getPortServiceType(opt, port), serviceType) {
+			filtered[port] = publicPort
+		}
+	}
+	return filtered
+}
+
+// Ports exposed by the Proxy Service
+func (mgr *Manager) getProxyServicePorts() portMap {
+	return getServiceTypePorts(mgr.cache, mgr.opt, mgr.opt.ProxyServiceType)
+}
+
+// Name of the Service of the ports overriding the Proxy Service type, e.g. http-proxy-clusterip
+func getServiceTypeName(proxy, serviceType string) string {
+	name := proxy + "-" + strings.ToLower(serviceType)
+	if len(name) > validation.DNS1035LabelMaxLength {
+		name = name[:validation.DNS1035LabelMaxLength]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// Name of the Service exposing a port
+func getPortServiceName(opt *Options, port int) string {
+	if isProxyServicePort(opt, port) {
+		return opt.ProxyName
+	}
+	return getServiceTypeName(opt.ProxyName, getPortServiceType(opt, port))
+}
+
+// Create, update and delete the Services of the ports overriding the Proxy Service type
+// Like the Proxy Service, ports not exposed yet are only added once the Proxy is ready
+func (mgr *Manager) syncServiceTypes(ctx context.Context, ready bool) error {
+	for _, serviceType := range serviceTypes {
+		if strings.EqualFold(string(serviceType), mgr.opt.ProxyServiceType) {
+			continue
+		}
+		if err := mgr.syncServiceType(ctx, string(serviceType), ready); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mgr *Manager) syncServiceType(ctx context.Context, serviceType string, ready bool) error {
+	ports := getServiceTypePorts(mgr.cache, mgr.opt, serviceType)
+	key := k8sclient.ObjectKey{
+		Name:      getServiceTypeName(mgr.opt.ProxyName, serviceType),
+		Namespace: mgr.opt.Namespace,
+	}
+	found := corev1.Service{}
+	if err := mgr.k8sClient.Get(ctx, key, &found); err == nil {
+		if len(ports) == 0 {
+			mgr.getLog(ctx).Info("Deleting Service of ports without an overridden type", "service", key.Name)
+			return k8sclient.IgnoreNotFound(mgr.k8sClient.Delete(ctx, &found))
+		}
+		if !ready {
+			if ports = getExposedPorts(ports, &found); len(ports) == 0 {
+				return nil
+			}
+		}
+		modifyServiceSpec(&found, ports, getTLSRedirectServicePorts(ports, mgr.opt)...)
+		mgr.setServicePortNames(&found)
+		mgr.setServiceMetadata(&found)
+		setLoadBalancerPreset(&found, mgr.opt)
+		setLoadBalancerIP(&found, mgr.opt)
+		setWorkloadTrafficPolicy(&found, mgr.opt)
+		setCustomMetadata(&found, mgr.opt)
+		return mgr.k8sClient.Update(ctx, &found)
+	} else if !k8serrors.IsNotFound(err) {
+		return err
+	}
+	// Do not expose the Proxy until it can serve traffic
+	if len(ports) == 0 || !ready {
+		return nil
+	}
+	svc := newProxyService(mgr.opt.Namespace, key.Name, ports, serviceType, getTLSRedirectServicePorts(ports, mgr.opt)...)
+	svc.Spec.Selector = map[string]string{"name": mgr.opt.ProxyName}
+	setIPFamilies(svc, mgr.opt)
+	mgr.setServicePortNames(svc)
+	mgr.setServiceMetadata(svc)
+	setLoadBalancerPreset(svc, mgr.opt)
+	setLoadBalancerIP(svc, mgr.opt)
+	setWorkloadTrafficPolicy(svc, mgr.opt)
+	setProxyGroup(svc, mgr.opt)
+	setCustomMetadata(svc, mgr.opt)
+	mgr.setOwnerReference(svc)
+	mgr.getLog(ctx).Info("Creating Service of ports overriding the Proxy Service type", "service", key.Name, "type", serviceType)
+	return mgr.k8sClient.Create(ctx, svc)
+}
+
+// Address of the ports of the LoadBalancer and NodePort Services overriding the Proxy Service type, the
+// ingress of the LoadBalancer Service once assigned and the external address of the Proxy for the NodePort Service,
+// the ports of a ClusterIP Service are not reachable from outside the cluster
+func (mgr *Manager) getServiceTypeAddresses(ctx context.Context) (map[int]string, error) {
+	addresses := make(map[int]string)
+	for _, serviceType := range []corev1.ServiceType{corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer} {
+		if strings.EqualFold(string(serviceType), mgr.opt.ProxyServiceType) {
+			continue
+		}
+		ports := getServiceTypePorts(mgr.cache, mgr.opt, string(serviceType))
+		if len(ports) == 0 {
+			continue
+		}
+		address := mgr.opt.ProxyExternalAddress
+		if serviceType == corev1.ServiceTypeLoadBalancer {
+			svc := corev1.Service{}
+			key := k8sclient.ObjectKey{Name: getServiceTypeName(mgr.opt.ProxyName, string(serviceType)), Namespace: mgr.opt.Namespace}
+			if err := mgr.k8sClient.Get(ctx, key, &svc); err != nil && !k8serrors.IsNotFound(err) {
+				return nil, err
+			}
+			address = getLoadBalancerAddress(&svc)
+		}
+		if address == "" {
+			continue
+		}
+		for port := range ports {
+			addresses[port] = address
+		}
+	}
+	return addresses, nil
+}
+
+// Update the port addresses with the LoadBalancer Service overriding the Proxy Service type once its ingress is assigned
+func (mgr *Manager) onServiceTypeEvent(obj k8sclient.Object, deleted bool) {
+	mgr.triggerReconcile()
+}
+
+// ClusterIPs of the Services exposing the ports, ports without a Service or ClusterIP are left out
+func (mgr *Manager) getPortClusterIPs(ctx context.Context, ports portMap) (map[int]string, error) {
+	clusterIPs := make(map[string]string)
+	hosts := make(map[int]string)
+	for port := range ports {
+		name := getPortServiceName(mgr.opt, port)
+		host, exists := clusterIPs[name]
+		if !exists {
+			svc := corev1.Service{}
+			key := k8sclient.ObjectKey{Name: name, Namespace: mgr.opt.Namespace}
+			if err := mgr.k8sClient.Get(ctx, key, &svc); err != nil && !k8serrors.IsNotFound(err) {
+				return nil, err
+			}
+			if host = svc.Spec.ClusterIP; host == corev1.ClusterIPNone {
+				host = ""
+			}
+			clusterIPs[name] = host
+		}
+		if host != "" {
+			hosts[port] = host
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,187 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPortServiceType(t *testing.T) {
+	if _, err := ParsePortSettings("6000:service-type=ExternalName"); err == nil {
+		t.Error("Expected unsupported Service type to be rejected")
+	}
+	rules, err := ParsePortSettings("6000-6010:service-type=clusterip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serviceType := rules.Get(6000).ServiceType; serviceType != "ClusterIP" {
+		t.Errorf("Expected ClusterIP Service type, got %s", serviceType)
+	}
+	if settings := rules.Get(6000).String(); settings != "" {
+		t.Errorf("Expected Service type not to be passed to the Proxy, got %s", settings)
+	}
+
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}},
+	)
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.PortSettings = rules
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Proxy Service exposes the other ports, the ClusterIP Service the overriding port
+	svc := corev1.Service{}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 5000 {
+		t.Errorf("Expected Proxy Service to expose port 5000, got %v", svc.Spec.Ports)
+	}
+	typeKey := k8sclient.ObjectKey{Name: "http-proxy-clusterip", Namespace: "iofog"}
+	if err := k8sClient.Get(ctx, typeKey, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeClusterIP || len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 6000 || svc.Spec.Selector["name"] != "http-proxy" {
+		t.Errorf("Unexpected ClusterIP Service %v", svc.Spec)
+	}
+
+	// The Service is deleted with its last port
+	ioClient.SetPorts(ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}})
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, typeKey, &corev1.Service{}); err == nil {
+		t.Error("Expected ClusterIP Service to be deleted")
+	}
+}
+
+func TestProxyServiceType(t *testing.T) {
+	newManager := func(serviceType string) (*Manager, error) {
+		return New(
+			WithNamespace("iofog"),
+			WithProxyImage("iofog/proxy"),
+			WithRouterAddresses(RouterAddress{Host: "router", Port: 5671}),
+			WithK8sClient(fake.NewClientBuilder().Build()),
+			WithControllerClient(NewFakeControllerClient()),
+			WithLoadBalancerWaiter(&FakeLoadBalancerWaiter{Address: "1.2.3.4"}),
+			WithEventRecorder(record.NewFakeRecorder(10)),
+			func(opt *Options) {
+				opt.OutOfCluster = true
+				opt.ProxyServiceType = serviceType
+			},
+		)
+	}
+	// The type is compared with the type of the existing Service, a different case would recreate it
+	mgr, err := newManager("nodeport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mgr.opt.ProxyServiceType != "NodePort" {
+		t.Errorf("Expected NodePort Proxy Service type, got %s", mgr.opt.ProxyServiceType)
+	}
+	if _, err := newManager("ExternalName"); err == nil {
+		t.Error("Expected unsupported Proxy Service type to be rejected")
+	}
+}
+
+func TestPortServiceTypeAddress(t *testing.T) {
+	rules, err := ParsePortSettings("6000:service-type=LoadBalancer,7000:service-type=NodePort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "abc-6000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 7000, Protocol: "tcp", Queue: "abc-7000"}},
+	)
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.PortSettings = rules
+	mgr.opt.ProxyServiceType = string(corev1.ServiceTypeClusterIP)
+	mgr.opt.ProxyExternalAddress = "proxy.example.com"
+	mgr.opt.LoadBalancerPreset = LoadBalancerPresetOCI
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The LoadBalancer Service gets the same load balancer settings as a LoadBalancer Proxy Service
+	svc := corev1.Service{}
+	lbKey := k8sclient.ObjectKey{Name: "http-proxy-loadbalancer", Namespace: "iofog"}
+	if err := k8sClient.Get(ctx, lbKey, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Annotations["oci.oraclecloud.com/load-balancer-type"] != "nlb" || svc.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal {
+		t.Errorf("Expected the load balancer preset on the LoadBalancer Service, got %v", svc)
+	}
+
+	// The NodePort port gets the external address, the LoadBalancer port its ingress once assigned
+	addresses := mgr.getStatus().PortAddresses
+	if address := addresses[7000]; address != "proxy.example.com" {
+		t.Errorf("Expected the NodePort port to get the external address, got %q", address)
+	}
+	if address, exists := addresses[6000]; exists {
+		t.Errorf("Expected the LoadBalancer port to get no address before its ingress, got %q", address)
+	}
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.7"}}
+	if err := k8sClient.Status().Update(ctx, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	addresses = mgr.getStatus().PortAddresses
+	if address := addresses[6000]; address != "10.0.0.7" {
+		t.Errorf("Expected the LoadBalancer port to get its ingress, got %q", address)
+	}
+	if address, exists := addresses[5000]; exists {
+		t.Errorf("Expected the Proxy Service port to keep the Proxy address, got %q", address)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ProxyGroupingShard runs a Proxy per PortShard, set when PortShards are configured
+const ProxyGroupingShard = "shard"
+
+// PortShard is a Proxy named <ProxyName>-<Name> exposing the public ports in Ports
+type PortShard struct {
+	Name  string
+	Ports PortRanges
+}
+
+// ParsePortShards parses semicolon-separated shards of port ranges, e.g. a=1-10000;b=10001-65535
+// A port is exposed by the first shard containing it
+func ParsePortShards(value string) ([]PortShard, error) {
+	var shards []PortShard
+	names := make(map[string]bool)
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid port shard %s, expected <name>=<port ranges>", item)
+		}
+		name := strings.TrimSpace(parts[0])
+		if errs := validation.IsDNS1123Label(name); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid port shard name %s: %s", name, strings.Join(errs, ", "))
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate port shard %s", name)
+		}
+		names[name] = true
+		ports, err := ParsePortRanges(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("port shard %s: %s", name, err.Error())
+		}
+		if len(ports) == 0 {
+			return nil, fmt.Errorf("port shard %s has no ports", name)
+		}
+		shards = append(shards, PortShard{Name: name, Ports: ports})
+	}
+	return shards, nil
+}
+
+// Split the ports passing the protocol filter by the Proxy of their shard
+func (mgr *Manager) shardPorts(allPorts []ioclient.MicroservicePublicPort) (map[string]*groupedPorts, error) {
+	groupPorts := make(map[string]*groupedPorts)
+	var unsharded []string
+	for _, port := range allPorts {
+		if !matchesProtocolFilter(port.PublicPort.Protocol, mgr.opt.ProtocolFilter) {
+			continue
+		}
+		shard := getPortShard(mgr.opt.PortShards, port.PublicPort.Port)
+		if shard == nil {
+			unsharded = append(unsharded, fmt.Sprint(port.PublicPort.Port))
+			continue
+		}
+		name := getGroupProxyName(mgr.opt.ProxyName, shard.Name)
+		if _, exists := groupPorts[name]; !exists {
+			groupPorts[name] = &groupedPorts{labels: map[string]string{portShardLabel: shard.Name}}
+		}
+		groupPorts[name].ports = append(groupPorts[name].ports, port)
+	}
+	if len(unsharded) > 0 {
+		sort.Strings(unsharded)
+		return groupPorts, errors.New("ports " + strings.Join(unsharded, ", ") + " are not in any port shard")
+	}
+	return groupPorts, nil
+}
+
+func getPortShard(shards []PortShard, port int) *PortShard {
+	for idx := range shards {
+		if shards[idx].Ports.Contains(port) {
+			return &shards[idx]
+		}
+	}
+	return nil
+}
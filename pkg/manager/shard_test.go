@@ -0,0 +1,84 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestPortShards(t *testing.T) {
+	for _, value := range []string{"a", "a=", "A=1-10", "a=1-10;a=11-20", "a=10-1"} {
+		if _, err := ParsePortShards(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+	shards, err := ParsePortShards("a=1-10000; b=10001-30000,40000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 40000, Protocol: "tcp", Queue: "abc-40000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 50000, Protocol: "tcp", Queue: "abc-50000"}},
+	)
+	mgr, _ := newFakeManager(t, ioClient)
+	mgr.opt.ProxyGrouping = ProxyGroupingShard
+	mgr.opt.PortShards = shards
+
+	if err := mgr.runGroups(ctx, false, false); err == nil {
+		t.Fatal("Expected error waiting for shard Proxy Deployments")
+	}
+	for name, port := range map[string]int{"http-proxy-a": 5000, "http-proxy-b": 40000} {
+		group, exists := mgr.groups[name]
+		if !exists {
+			t.Fatalf("Expected shard Proxy %s", name)
+		}
+		if _, exists := group.mgr.cache[port]; !exists || len(group.mgr.cache) != 1 {
+			t.Errorf("Expected %s to only expose port %d, got %v", name, port, group.mgr.cache)
+		}
+		if group.mgr.opt.ProxyGroupLabels[portShardLabel] != name[len("http-proxy-"):] {
+			t.Errorf("Unexpected labels of %s: %v", name, group.mgr.opt.ProxyGroupLabels)
+		}
+	}
+	if _, failing := mgr.status.get()[statusErrorGroups]; !failing {
+		t.Error("Expected port outside all shards to be reported")
+	}
+
+	// The address of a shard is recorded in its status rather than registered as the default address of the Controller
+	for _, group := range mgr.groups {
+		group.cancel()
+	}
+	shard := mgr.groups["http-proxy-a"].mgr
+	if err := shard.registerAddress(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if address := shard.getStatus().Address; address != "1.2.3.4" {
+		t.Errorf("Expected shard address to be recorded, got %q", address)
+	}
+	if err := mgr.groups["http-proxy-b"].mgr.registerAddress(ctx, "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.runGroups(ctx, false, false)
+	if address := ioClient.GetDefaultProxy(); address != "" {
+		t.Errorf("Expected shard address not to be registered as the default address, got %q", address)
+	}
+	if address := mgr.groups["http-proxy-b"].mgr.getStatus().Address; address != "10.0.0.2" {
+		t.Errorf("Expected shard address to be recorded, got %q", address)
+	}
+}
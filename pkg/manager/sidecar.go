@@ -0,0 +1,110 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	sidecarsKey = "sidecars.yaml"
+	// Names of the sidecars added to the Proxy pods, so containers added by others are kept
+	sidecarsAnnotation = "iofog.org/proxy-sidecars"
+)
+
+// Values available to sidecar templates
+type sidecarTemplateValues struct {
+	Namespace string
+	ProxyName string
+}
+
+// Render a YAML list of containers, templated with the Proxy details
+func renderSidecars(tmpl string, values sidecarTemplateValues) ([]corev1.Container, error) {
+	parsed, err := template.New(sidecarsKey).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	if err := parsed.Execute(&buf, values); err != nil {
+		return nil, err
+	}
+	containers := make([]corev1.Container, 0)
+	if err := yaml.UnmarshalStrict(buf.Bytes(), &containers); err != nil {
+		return nil, err
+	}
+	for idx := range containers {
+		if containers[idx].Name == "" || containers[idx].Name == proxyContainerName {
+			return nil, fmt.Errorf("sidecar container %d must have a name other than %q", idx, proxyContainerName)
+		}
+	}
+	return containers, nil
+}
+
+// Get the sidecar containers to inject into the Proxy pods from the configured ConfigMap
+func (mgr *Manager) getSidecars(ctx context.Context) ([]corev1.Container, error) {
+	if mgr.opt.ProxySidecarConfigMap == "" {
+		return nil, nil
+	}
+	cm := corev1.ConfigMap{}
+	key := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxySidecarConfigMap,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(ctx, key, &cm); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return renderSidecars(cm.Data[sidecarsKey], sidecarTemplateValues{
+		Namespace: mgr.opt.Namespace,
+		ProxyName: mgr.opt.ProxyName,
+	})
+}
+
+// Replace the sidecars added before, keeping the Proxy first and the containers of others
+// Deployments of earlier releases without the annotation only hold sidecars of the port-manager
+func setSidecars(dep *appsv1.Deployment, sidecars []corev1.Container) {
+	podSpec := &dep.Spec.Template.Spec
+	added, recorded := dep.Annotations[sidecarsAnnotation]
+	replaced := make(map[string]bool)
+	for _, name := range strings.Split(added, ",") {
+		replaced[name] = true
+	}
+	names := make([]string, 0, len(sidecars))
+	for _, sidecar := range sidecars {
+		replaced[sidecar.Name] = true
+		names = append(names, sidecar.Name)
+	}
+	containers := []corev1.Container{podSpec.Containers[0]}
+	if recorded {
+		containers = append(containers, removeContainers(podSpec.Containers[1:], replaced)...)
+	}
+	podSpec.Containers = append(containers, sidecars...)
+	// Recorded even without sidecars, so the containers of others are kept from now on
+	if dep.Annotations == nil {
+		dep.Annotations = make(map[string]string)
+	}
+	dep.Annotations[sidecarsAnnotation] = strings.Join(names, ",")
+}
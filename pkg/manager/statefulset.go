@@ -0,0 +1,121 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func isStatefulSet(opt *Options) bool {
+	return opt.ProxyWorkload == ProxyWorkloadStatefulSet
+}
+
+// Name of the headless Service governing a StatefulSet Proxy, its pods resolve as <proxy>-<ordinal>.<proxy>-pods.<namespace>.svc
+func getHeadlessServiceName(proxy string) string {
+	name := proxy + "-pods"
+	if len(name) > validation.DNS1035LabelMaxLength {
+		name = name[:validation.DNS1035LabelMaxLength]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// View of a StatefulSet as a Deployment, its update strategy is left to the StatefulSet
+func newStatefulSetDeployment(ss *appsv1.StatefulSet) *appsv1.Deployment {
+	dep := &appsv1.Deployment{
+		ObjectMeta: *ss.ObjectMeta.DeepCopy(),
+		Spec: appsv1.DeploymentSpec{
+			Selector: ss.Spec.Selector.DeepCopy(),
+			Template: *ss.Spec.Template.DeepCopy(),
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: ss.Status.ObservedGeneration,
+			Replicas:           ss.Status.Replicas,
+			UpdatedReplicas:    ss.Status.UpdatedReplicas,
+			AvailableReplicas:  ss.Status.AvailableReplicas,
+		},
+	}
+	if ss.Spec.Replicas != nil {
+		replicas := *ss.Spec.Replicas
+		dep.Spec.Replicas = &replicas
+	}
+	return dep
+}
+
+// Write the metadata and pod template of a Deployment view back to its StatefulSet
+func setStatefulSetSpec(ss *appsv1.StatefulSet, dep *appsv1.Deployment) {
+	ss.ObjectMeta = dep.ObjectMeta
+	ss.Spec.Selector = dep.Spec.Selector
+	ss.Spec.Template = dep.Spec.Template
+}
+
+// StatefulSet of a new Proxy, its pods are started and replaced in parallel as they do not depend on each other
+func newProxyStatefulSet(dep *appsv1.Deployment, opt *Options) *appsv1.StatefulSet {
+	ss := &appsv1.StatefulSet{}
+	setStatefulSetSpec(ss, dep)
+	ss.Spec.Replicas = dep.Spec.Replicas
+	ss.Spec.ServiceName = getHeadlessServiceName(opt.ProxyName)
+	ss.Spec.PodManagementPolicy = appsv1.ParallelPodManagement
+	return ss
+}
+
+// Maintain the headless Service giving the pods of a StatefulSet Proxy their stable hostnames
+// Not ready pods are published too, so their hostnames resolve while they start
+func (mgr *Manager) syncHeadlessService(ctx context.Context) error {
+	if !isStatefulSet(mgr.opt) {
+		return nil
+	}
+	key := k8sclient.ObjectKey{
+		Name:      getHeadlessServiceName(mgr.opt.ProxyName),
+		Namespace: mgr.opt.Namespace,
+	}
+	found := corev1.Service{}
+	if err := mgr.k8sClient.Get(ctx, key, &found); err == nil {
+		if len(mgr.cache) == 0 {
+			return k8sclient.IgnoreNotFound(mgr.k8sClient.Delete(ctx, &found))
+		}
+		modifyServiceSpec(&found, mgr.cache)
+		setCustomMetadata(&found, mgr.opt)
+		return mgr.k8sClient.Update(ctx, &found)
+	} else if !k8serrors.IsNotFound(err) {
+		return err
+	}
+	if len(mgr.cache) == 0 {
+		return nil
+	}
+	svc := newProxyService(mgr.opt.Namespace, key.Name, mgr.cache, string(corev1.ServiceTypeClusterIP))
+	svc.Spec.Selector = map[string]string{"name": mgr.opt.ProxyName}
+	svc.Spec.ClusterIP = corev1.ClusterIPNone
+	svc.Spec.PublishNotReadyAddresses = true
+	setIPFamilies(svc, mgr.opt)
+	setCustomMetadata(svc, mgr.opt)
+	mgr.setOwnerReference(svc)
+	mgr.getLog(ctx).Info("Creating headless Service of Proxy StatefulSet", "service", key.Name)
+	return mgr.k8sClient.Create(ctx, svc)
+}
+
+func (mgr *Manager) deleteHeadlessService(ctx context.Context) error {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name:      getHeadlessServiceName(mgr.opt.ProxyName),
+		Namespace: mgr.opt.Namespace,
+	}}
+	return k8sclient.IgnoreNotFound(mgr.k8sClient.Delete(ctx, svc))
+}
@@ -0,0 +1,195 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const proxyStatsTimeout = time.Second * 5
+
+// Entry of the stats served by each Proxy pod, e.g. by a sidecar exporter
+// Byte counts are cumulative since the pod started
+type proxyPortStats struct {
+	Port        int    `json:"port"`
+	Connections int64  `json:"connections"`
+	BytesIn     uint64 `json:"bytesIn"`
+	BytesOut    uint64 `json:"bytesOut"`
+}
+
+// Scrapes the stats of the Proxy pods into the per-port metrics
+type statsScraper struct {
+	mgr    *Manager
+	client *http.Client
+	// Stats last scraped by pod and port, to turn the cumulative byte counts into counter increments
+	last map[types.UID]map[int]proxyPortStats
+	// Queue of each port with exported series
+	exported map[int]string
+	// Scales the Proxy with the scraped connections, nil unless autoscaling is enabled
+	scaler *proxyScaler
+}
+
+func (mgr *Manager) newStatsScraper() *statsScraper {
+	scraper := &statsScraper{
+		mgr:      mgr,
+		client:   &http.Client{Timeout: proxyStatsTimeout},
+		last:     make(map[types.UID]map[int]proxyPortStats),
+		exported: make(map[int]string),
+	}
+	if isAutoscalingEnabled(mgr.opt) {
+		scraper.scaler = &proxyScaler{mgr: mgr}
+	}
+	return scraper
+}
+
+func (scraper *statsScraper) run(ctx context.Context) {
+	interval := scraper.mgr.opt.ProxyStatsInterval
+	if interval <= 0 {
+		interval = DefaultOptions().ProxyStatsInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := scraper.scrape(ctx); err != nil {
+			scraper.mgr.getLog(ctx).Error(err, "Failed to scrape Proxy stats")
+		}
+		select {
+		case <-ctx.Done():
+			scraper.deleteSeries(nil)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (scraper *statsScraper) scrape(ctx context.Context) error {
+	mgr := scraper.mgr
+	snapshot := mgr.loadSnapshot()
+	if snapshot == nil {
+		return nil
+	}
+	pods := corev1.PodList{}
+	opts := []k8sclient.ListOption{
+		k8sclient.InNamespace(mgr.opt.Namespace),
+		k8sclient.MatchingLabels{"name": mgr.opt.ProxyName},
+	}
+	if err := mgr.k8sClient.List(ctx, &pods, opts...); err != nil {
+		return err
+	}
+
+	var scrapeErr error
+	connections := make(map[int]int64)
+	listed := make(map[types.UID]bool)
+	for idx := range pods.Items {
+		pod := &pods.Items[idx]
+		listed[pod.UID] = true
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		stats, err := scraper.getPodStats(ctx, pod.Status.PodIP)
+		if err != nil {
+			scrapeErr = fmt.Errorf("pod %s: %s", pod.Name, err.Error())
+			continue
+		}
+		previous := scraper.last[pod.UID]
+		current := make(map[int]proxyPortStats)
+		for _, stat := range stats {
+			port, exists := snapshot.ports[stat.Port]
+			if !exists {
+				continue
+			}
+			current[stat.Port] = stat
+			connections[stat.Port] += stat.Connections
+			labels := []string{mgr.opt.ProxyName, strconv.Itoa(port.Port), port.Queue}
+			proxyPortReceivedBytes.WithLabelValues(labels...).Add(float64(counterIncrement(previous[stat.Port].BytesIn, stat.BytesIn)))
+			proxyPortSentBytes.WithLabelValues(labels...).Add(float64(counterIncrement(previous[stat.Port].BytesOut, stat.BytesOut)))
+		}
+		scraper.last[pod.UID] = current
+	}
+	for uid := range scraper.last {
+		if !listed[uid] {
+			delete(scraper.last, uid)
+		}
+	}
+
+	scraper.deleteSeries(snapshot.ports)
+	total := int64(0)
+	for _, port := range snapshot.ports {
+		proxyPortConnections.WithLabelValues(mgr.opt.ProxyName, strconv.Itoa(port.Port), port.Queue).Set(float64(connections[port.Port]))
+		scraper.exported[port.Port] = port.Queue
+		total += connections[port.Port]
+	}
+	if scrapeErr != nil {
+		return scrapeErr
+	}
+	// Only scale on the connections of all pods
+	if scraper.scaler != nil {
+		return scraper.scaler.observe(ctx, total, time.Now())
+	}
+	return nil
+}
+
+// Delete the series of ports that are no longer exposed or whose queue changed
+func (scraper *statsScraper) deleteSeries(ports portMap) {
+	for port, queue := range scraper.exported {
+		if current, exists := ports[port]; exists && current.Queue == queue {
+			continue
+		}
+		labels := []string{scraper.mgr.opt.ProxyName, strconv.Itoa(port), queue}
+		proxyPortConnections.DeleteLabelValues(labels...)
+		proxyPortReceivedBytes.DeleteLabelValues(labels...)
+		proxyPortSentBytes.DeleteLabelValues(labels...)
+		delete(scraper.exported, port)
+	}
+}
+
+func (scraper *statsScraper) getPodStats(ctx context.Context, podIP string) ([]proxyPortStats, error) {
+	opt := scraper.mgr.opt
+	requestURL := fmt.Sprintf("http://%s%s", net.JoinHostPort(podIP, strconv.Itoa(opt.ProxyStatsPort)), opt.ProxyStatsPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := scraper.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stats endpoint returned %s", resp.Status)
+	}
+	stats := []proxyPortStats{}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Increment of a cumulative count, which restarts from zero when the Proxy pod restarts
+func counterIncrement(previous, current uint64) uint64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
@@ -0,0 +1,117 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScrapeStats(t *testing.T) {
+	ctx := context.Background()
+	stats := []proxyPortStats{
+		{Port: 5000, Connections: 2, BytesIn: 100, BytesOut: 200},
+		{Port: 6000, Connections: 1, BytesIn: 1, BytesOut: 1},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(stats)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mgr, k8sClient := newFakeManager(t, NewFakeControllerClient())
+	mgr.opt.ProxyStatsPort, _ = strconv.Atoi(port)
+	mgr.cache[5000] = ioclient.PublicPort{Port: 5000, Protocol: "tcp", Queue: "abc-5000"}
+	mgr.saveSnapshot()
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "http-proxy-1", Namespace: "iofog", UID: "pod", Labels: map[string]string{"name": "http-proxy"}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: host},
+	}
+	if err := k8sClient.Create(ctx, &pod); err != nil {
+		t.Fatal(err)
+	}
+
+	scraper := mgr.newStatsScraper()
+	if err := scraper.scrape(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Byte counts restart from zero with the pod
+	stats[0] = proxyPortStats{Port: 5000, Connections: 3, BytesIn: 150, BytesOut: 50}
+	if err := scraper.scrape(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := []string{"http-proxy", "5000", "abc-5000"}
+	metric := dto.Metric{}
+	if err := proxyPortConnections.WithLabelValues(labels...).Write(&metric); err != nil || metric.GetGauge().GetValue() != 3 {
+		t.Errorf("Expected 3 connections, got %v", metric.GetGauge().GetValue())
+	}
+	if err := proxyPortReceivedBytes.WithLabelValues(labels...).Write(&metric); err != nil || metric.GetCounter().GetValue() != 150 {
+		t.Errorf("Expected 150 received bytes, got %v", metric.GetCounter().GetValue())
+	}
+	if err := proxyPortSentBytes.WithLabelValues(labels...).Write(&metric); err != nil || metric.GetCounter().GetValue() != 250 {
+		t.Errorf("Expected 250 sent bytes, got %v", metric.GetCounter().GetValue())
+	}
+
+	// Ports that are not exposed have no series
+	if proxyPortConnections.DeleteLabelValues("http-proxy", "6000", "") {
+		t.Error("Expected no series for a port that is not exposed")
+	}
+	scraper.deleteSeries(nil)
+	if proxyPortConnections.DeleteLabelValues(labels...) {
+		t.Error("Expected series to be deleted")
+	}
+}
+
+func TestDeletePortSeries(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "http", Queue: "abc-6000"}},
+	)
+	mgr, _ := newFakeManager(t, ioClient)
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = mgr.run(ctx)
+	proxyPortConnections.WithLabelValues("http-proxy", "5000", "abc-5000").Set(1)
+	proxyPortReachable.WithLabelValues("http-proxy", "6000", "abc-6000").Set(1)
+
+	// A removed port and the previous queue of a port have no series
+	ioClient.SetPorts(ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "http", Queue: "abc-6001"}})
+	_ = mgr.run(ctx)
+	if proxyPortConnections.DeleteLabelValues("http-proxy", "5000", "abc-5000") {
+		t.Error("Expected the series of a removed port to be deleted")
+	}
+	if proxyPortReachable.DeleteLabelValues("http-proxy", "6000", "abc-6000") {
+		t.Error("Expected the series of the previous queue to be deleted")
+	}
+}
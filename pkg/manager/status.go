@@ -0,0 +1,179 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	statusKeySuffix = ".json"
+
+	statusErrorReconcile    = "reconcile"
+	statusErrorRegistration = "registration"
+)
+
+// Status of a Manager, published to the status ConfigMap with one key per Proxy
+type Status struct {
+	Proxy   string       `json:"proxy"`
+	Ports   []PortStatus `json:"ports"`
+	Address string       `json:"address,omitempty"`
+	// Addresses of single ports overriding the Proxy address, e.g. the external-address of the port settings
+	PortAddresses map[int]string `json:"portAddresses,omitempty"`
+	// Ports the Controller requested that are not exposed
+	Rejected []RejectedPort `json:"rejected,omitempty"`
+	// Last error of each operation that is currently failing
+	Errors map[string]ErrorStatus `json:"errors,omitempty"`
+	// Current conditions degrading the Proxy without failing an operation, e.g. lost client IPs
+	Warnings map[string]ErrorStatus `json:"warnings,omitempty"`
+	Updated  time.Time              `json:"updated"`
+}
+
+type PortStatus struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Queue    string `json:"queue"`
+}
+
+// RejectedPort is a public port that is not exposed, e.g. because it is reserved
+type RejectedPort struct {
+	Port    int    `json:"port"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type ErrorStatus struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Errors are set from both the main loop and the address registration routine
+type statusErrors struct {
+	mu     sync.Mutex
+	errors map[string]ErrorStatus
+}
+
+func newStatusErrors() *statusErrors {
+	return &statusErrors{errors: make(map[string]ErrorStatus)}
+}
+
+// Record the result of an operation, clearing its error on success
+func (errs *statusErrors) setError(operation string, err error) {
+	errs.mu.Lock()
+	defer errs.mu.Unlock()
+	if err == nil {
+		delete(errs.errors, operation)
+		return
+	}
+	errs.errors[operation] = ErrorStatus{Message: err.Error(), Time: time.Now().UTC()}
+}
+
+func (errs *statusErrors) get() map[string]ErrorStatus {
+	errs.mu.Lock()
+	defer errs.mu.Unlock()
+	if len(errs.errors) == 0 {
+		return nil
+	}
+	copied := make(map[string]ErrorStatus, len(errs.errors))
+	for operation, err := range errs.errors {
+		copied[operation] = err
+	}
+	return copied
+}
+
+func getPortStatuses(ports portMap) []PortStatus {
+	statuses := make([]PortStatus, 0, len(ports))
+	for _, port := range ports {
+		statuses = append(statuses, PortStatus{Port: port.Port, Protocol: port.Protocol, Queue: port.Queue})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Port < statuses[j].Port })
+	return statuses
+}
+
+func (mgr *Manager) getStatus() Status {
+	status := Status{
+		Proxy:    mgr.opt.ProxyName,
+		Ports:    getPortStatuses(mgr.cache),
+		Address:  mgr.addressQueue.getRegistered(),
+		Errors:   mgr.status.get(),
+		Warnings: mgr.warnings.get(),
+	}
+	if len(mgr.portAddresses) > 0 {
+		status.PortAddresses = make(map[int]string, len(mgr.portAddresses))
+		for port, address := range mgr.portAddresses {
+			status.PortAddresses[port] = address
+		}
+	}
+	for port, rejection := range mgr.rejectedPorts {
+		status.Rejected = append(status.Rejected, RejectedPort{Port: port, Reason: rejection.reason, Message: rejection.message})
+	}
+	sort.Slice(status.Rejected, func(i, j int) bool { return status.Rejected[i].Port < status.Rejected[j].Port })
+	return status
+}
+
+// Write the status to the status ConfigMap if it changed since the last write
+func (mgr *Manager) publishStatus(ctx context.Context) error {
+	status := mgr.getStatus()
+	content, err := json.Marshal(&status)
+	if err != nil {
+		return err
+	}
+	if string(content) == mgr.publishedStatus {
+		return nil
+	}
+	status.Updated = time.Now().UTC()
+	data, err := json.Marshal(&status)
+	if err != nil {
+		return err
+	}
+	if err := mgr.applyConfigMapKey(ctx, pkg.statusConfigMapName, mgr.opt.ProxyName+statusKeySuffix, string(data)); err != nil {
+		return err
+	}
+	mgr.publishedStatus = string(content)
+	return nil
+}
+
+// Read the status of all Managers in a Namespace
+func GetStatus(ctx context.Context, client k8sclient.Client, namespace string) ([]Status, error) {
+	key := k8sclient.ObjectKey{
+		Name:      pkg.statusConfigMapName,
+		Namespace: namespace,
+	}
+	cm := corev1.ConfigMap{}
+	if err := client.Get(ctx, key, &cm); err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for statusKey, data := range cm.Data {
+		if !strings.HasSuffix(statusKey, statusKeySuffix) {
+			continue
+		}
+		status := Status{}
+		if err := json.Unmarshal([]byte(data), &status); err != nil {
+			return nil, fmt.Errorf("could not decode status %s: %s", statusKey, err.Error())
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Proxy < statuses[j].Proxy })
+	return statuses, nil
+}
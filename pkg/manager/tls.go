@@ -0,0 +1,151 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	proxyTLSVolume    = "proxy-tls"
+	proxyTLSMountPath = "/etc/proxy-tls"
+	// Optional key of the TLS Secret with the CA the probes verify the Proxy certificate against
+	proxyTLSCAKey = "ca.crt"
+
+	// Port the Proxy redirects HTTP requests from to the HTTPS port, and the name of its Service port
+	tlsRedirectPort     = 80
+	tlsRedirectPortName = "http-redirect"
+	// Prefix of the redirect item of the Proxy config, e.g. redirect:80=>https:443
+	tlsRedirectProtocol = "redirect"
+)
+
+// Whether the Proxy terminates TLS on a port, with the wildcard certificate of ProxyTLSSecret on all HTTP ports
+func isTLSTerminated(opt *Options, protocol string) bool {
+	return opt.ProxyTLSSecret != "" && isHTTPProtocol(protocol)
+}
+
+// Render the certificate and key the Proxy terminates TLS with, e.g. ;tls-cert=/etc/proxy-tls/tls.crt;tls-key=/etc/proxy-tls/tls.key
+func getProxyTLSString(opt *Options, protocol string) string {
+	if !isTLSTerminated(opt, protocol) {
+		return ""
+	}
+	return ";tls-cert=" + path.Join(proxyTLSMountPath, corev1.TLSCertKey) + ";tls-key=" + path.Join(proxyTLSMountPath, corev1.TLSPrivateKeyKey)
+}
+
+// Mount the wildcard TLS Secret into the Proxy container
+func setProxyTLSSecret(dep *appsv1.Deployment, opt *Options) {
+	if opt.ProxyTLSSecret == "" {
+		return
+	}
+	podSpec := &dep.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: proxyTLSVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: opt.ProxyTLSSecret,
+				Items: []corev1.KeyToPath{
+					{Key: corev1.TLSCertKey, Path: corev1.TLSCertKey},
+					{Key: corev1.TLSPrivateKeyKey, Path: corev1.TLSPrivateKeyKey},
+				},
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      proxyTLSVolume,
+		MountPath: proxyTLSMountPath,
+		ReadOnly:  true,
+	})
+}
+
+// Load the CA of the wildcard TLS Secret, nil unless it has one
+func (mgr *Manager) getProxyTLSCA(ctx context.Context) ([]byte, error) {
+	if mgr.opt.ProxyTLSSecret == "" {
+		return nil, nil
+	}
+	secret := corev1.Secret{}
+	key := k8sclient.ObjectKey{Name: mgr.opt.ProxyTLSSecret, Namespace: mgr.opt.Namespace}
+	if err := mgr.k8sClient.Get(ctx, key, &secret); err != nil {
+		return nil, err
+	}
+	return secret.Data[proxyTLSCAKey], nil
+}
+
+// Check the HTTPS port HTTP requests are redirected to, it must be terminated with the TLS Secret
+func checkTLSRedirect(opt *Options) error {
+	if opt.ProxyTLSRedirectPort == 0 {
+		return nil
+	}
+	if opt.ProxyTLSSecret == "" {
+		return errors.New("the HTTPS redirect requires a Proxy TLS Secret")
+	}
+	if opt.ProxyTLSRedirectPort < 1 || opt.ProxyTLSRedirectPort > 65535 || opt.ProxyTLSRedirectPort == tlsRedirectPort {
+		return fmt.Errorf("invalid HTTPS redirect port %d", opt.ProxyTLSRedirectPort)
+	}
+	return requireProxyCapability(opt, ProxyCapabilityTLSRedirect, "the HTTPS redirect")
+}
+
+// Whether a port serves the HTTPS redirect, so it cannot be exposed as a public port
+func isTLSRedirectPort(opt *Options, port int) bool {
+	return opt.ProxyTLSRedirectPort != 0 && port == tlsRedirectPort
+}
+
+func newTLSRedirectPortRejection(port int) portRejection {
+	return portRejection{
+		reason:  reasonPortReserved,
+		message: fmt.Sprintf("port %d serves the HTTPS redirect", port),
+	}
+}
+
+// The HTTPS port requests to port 80 are redirected to, 0 unless the ports include it with TLS terminated
+func getTLSRedirectTarget(ports portMap, opt *Options) int {
+	if opt.ProxyTLSRedirectPort == 0 {
+		return 0
+	}
+	if port, exists := ports[opt.ProxyTLSRedirectPort]; !exists || !isTLSTerminated(opt, port.Protocol) {
+		return 0
+	}
+	return opt.ProxyTLSRedirectPort
+}
+
+// Render the redirect item of the Proxy config, e.g. redirect:80=>https:443
+func getTLSRedirectString(target int) string {
+	return fmt.Sprintf("%s:%d=>https:%d", tlsRedirectProtocol, tlsRedirectPort, target)
+}
+
+func isTLSRedirectItem(configItem string) bool {
+	return strings.HasPrefix(configItem, tlsRedirectProtocol+":")
+}
+
+// Service port of the redirect, added to the Service exposing the HTTPS port
+func getTLSRedirectServicePorts(ports portMap, opt *Options) []corev1.ServicePort {
+	if getTLSRedirectTarget(ports, opt) == 0 {
+		return nil
+	}
+	return []corev1.ServicePort{{
+		Name:       tlsRedirectPortName,
+		Port:       tlsRedirectPort,
+		TargetPort: intstr.FromInt(tlsRedirectPort),
+		Protocol:   corev1.ProtocolTCP,
+	}}
+}
@@ -0,0 +1,54 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+// Spans are no-ops unless a tracer provider is registered by the caller
+var tracer = otel.Tracer("github.com/eclipse-iofog/port-manager/v3/pkg/manager")
+
+// Start a span attributed to the Proxy managed by this Manager
+func (mgr *Manager) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("proxy", mgr.opt.ProxyName))
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End a span, recording the error it resulted in if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Copy a K8s client config so that every API request is traced as a child of the calling span
+func withTracing(cfg *rest.Config) *rest.Config {
+	traced := rest.CopyConfig(cfg)
+	traced.WrapTransport = transport.Wrappers(traced.WrapTransport, func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt)
+	})
+	return traced
+}
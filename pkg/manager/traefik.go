@@ -0,0 +1,100 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	defaultTraefikEntryPoint    = "web"
+	defaultTraefikTLSEntryPoint = "websecure"
+)
+
+var (
+	traefikIngressRouteKind    = schema.GroupVersionKind{Group: "traefik.containo.us", Version: "v1alpha1", Kind: "IngressRoute"}
+	traefikIngressRouteTCPKind = schema.GroupVersionKind{Group: "traefik.containo.us", Version: "v1alpha1", Kind: "IngressRouteTCP"}
+)
+
+// Routes each port to the Proxy Service with an IngressRoute or IngressRouteTCP named after the Proxy and the port
+// HTTP ports with a hostname share the entry point of the gateway by Host, or by SNI when the Proxy terminates TLS
+// Other ports are served on the entry point port-<port>, which must be defined in the static config of Traefik
+type traefikRenderer struct{}
+
+func (traefikRenderer) kinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{traefikIngressRouteKind, traefikIngressRouteTCPKind}
+}
+
+func (traefikRenderer) render(mgr *Manager, ports portMap) ([]*unstructured.Unstructured, error) {
+	gateway := mgr.opt.IngressGateway
+	if gateway == "" {
+		gateway = defaultTraefikEntryPoint
+		if mgr.opt.ProxyTLSSecret != "" {
+			gateway = defaultTraefikTLSEntryPoint
+		}
+	}
+	objs := make([]*unstructured.Unstructured, 0, len(ports))
+	for _, port := range getSortedPorts(ports) {
+		protocol := ports[port].Protocol
+		name := fmt.Sprintf("%s-%d", mgr.opt.ProxyName, port)
+		service := map[string]interface{}{
+			"name": mgr.opt.ProxyName,
+			"port": int64(port),
+		}
+		entryPoint := fmt.Sprintf("port-%d", port)
+		hostname, hasHostname := mgr.hostnames[port]
+		if hasHostname {
+			entryPoint = gateway
+		}
+
+		if !isHTTPProtocol(protocol) || isTLSTerminated(mgr.opt, protocol) {
+			route := map[string]interface{}{
+				"match":    "HostSNI(`*`)",
+				"services": []interface{}{service},
+			}
+			spec := map[string]interface{}{
+				"entryPoints": []interface{}{entryPoint},
+				"routes":      []interface{}{route},
+			}
+			if hasHostname {
+				route["match"] = fmt.Sprintf("HostSNI(`%s`)", hostname)
+				spec["tls"] = map[string]interface{}{"passthrough": true}
+			}
+			objs = append(objs, newRouteObject(traefikIngressRouteTCPKind, name, mgr.opt.Namespace, spec))
+			continue
+		}
+
+		match := "PathPrefix(`/`)"
+		if hasHostname {
+			match = fmt.Sprintf("Host(`%s`)", hostname)
+		}
+		if protocol == "http2" || protocol == "grpc" {
+			service["scheme"] = "h2c"
+		}
+		objs = append(objs, newRouteObject(traefikIngressRouteKind, name, mgr.opt.Namespace, map[string]interface{}{
+			"entryPoints": []interface{}{entryPoint},
+			"routes": []interface{}{
+				map[string]interface{}{
+					"kind":     "Rule",
+					"match":    match,
+					"services": []interface{}{service},
+				},
+			},
+		}))
+	}
+	return objs, nil
+}
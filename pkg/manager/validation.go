@@ -0,0 +1,119 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	reasonPortInvalid = "PortInvalid"
+
+	maxPrivilegedPort = 1023
+	// Maximum length of an IANA service name
+	servicePortNameMaxLength = 15
+)
+
+// Whether a protocol is served over HTTP by the Proxy
+// ws ports are HTTP ports tuned for WebSocket upgrades and grpc ports are http2 ports tuned for streaming
+func isHTTPProtocol(protocol string) bool {
+	switch strings.ToLower(protocol) {
+	case "http", "http2", "ws", "grpc":
+		return true
+	}
+	return false
+}
+
+// Whether a port is served by a Proxy with the protocol filter
+// Proxies filtering http also serve ws and grpc ports, Proxies filtering http2 also serve grpc ports
+func matchesProtocolFilter(protocol, filter string) bool {
+	if filter == "" || strings.EqualFold(protocol, filter) {
+		return true
+	}
+	switch strings.ToLower(protocol) {
+	case "ws":
+		return strings.EqualFold(filter, "http")
+	case "grpc":
+		return strings.EqualFold(filter, "http") || strings.EqualFold(filter, "http2")
+	}
+	return false
+}
+
+// Check that a public port can be exposed by the Proxy
+func validatePublicPort(port ioclient.PublicPort, allowPrivileged bool) error {
+	if port.Port < 1 || port.Port > 65535 {
+		return fmt.Errorf("port %d out of range 1-65535", port.Port)
+	}
+	if !allowPrivileged && port.Port <= maxPrivilegedPort {
+		return fmt.Errorf("privileged port %d is not allowed", port.Port)
+	}
+	switch strings.ToLower(port.Protocol) {
+	case "http", "http2", "ws", "grpc", "tcp":
+	default:
+		return fmt.Errorf("port %d has unsupported protocol %s", port.Port, port.Protocol)
+	}
+	if port.Queue == "" {
+		return fmt.Errorf("port %d has no queue", port.Port)
+	}
+	return nil
+}
+
+// Derive a readable Service port name from the microservice name or else the protocol of a port, e.g. web-ui-5000
+// Names are valid IANA service names of at most 15 characters, so they are also accepted as container port names
+func getServicePortName(port int, protocol, microservice string) string {
+	suffix := fmt.Sprintf("-%d", port)
+	prefix := toDNSLabel(microservice)
+	if prefix == "" {
+		prefix = toDNSLabel(protocol)
+	}
+	for strings.Contains(prefix, "--") {
+		prefix = strings.ReplaceAll(prefix, "--", "-")
+	}
+	if maxLength := servicePortNameMaxLength - len(suffix); len(prefix) > maxLength {
+		prefix = strings.TrimRight(prefix[:maxLength], "-")
+	}
+	if name := prefix + suffix; len(validation.IsValidPortName(name)) == 0 {
+		return name
+	}
+	return "port" + suffix
+}
+
+// Lower-case a value and replace the characters not allowed in a DNS-1123 label, empty if no valid label remains
+func toDNSLabel(value string) string {
+	label := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(value))
+	if len(label) > validation.DNS1123LabelMaxLength {
+		label = label[:validation.DNS1123LabelMaxLength]
+	}
+	label = strings.Trim(label, "-")
+	if len(validation.IsDNS1123Label(label)) != 0 {
+		return ""
+	}
+	return label
+}
+
+func newInvalidPortRejection(err error) portRejection {
+	return portRejection{
+		reason:  reasonPortInvalid,
+		message: err.Error(),
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Resync the Proxy if its Deployment is deleted
+func (mgr *Manager) onProxyEvent(obj k8sclient.Object, deleted bool) {
+	if deleted {
+		mgr.triggerResync()
+	}
+}
+
+// Resync the Proxy if its Service is deleted and re-register its address whenever the LoadBalancer ingress changes
+func (mgr *Manager) onProxyServiceEvent(obj k8sclient.Object, deleted bool) {
+	if deleted {
+		mgr.triggerResync()
+		return
+	}
+	// Only relevant when no external address is configured
+	svc, ok := obj.(*corev1.Service)
+	if !ok || mgr.opt.ProxyExternalAddress != "" {
+		return
+	}
+	addr := getLoadBalancerAddress(svc)
+	if addr == "" || mgr.addressQueue.isKnown(addr) {
+		return
+	}
+	mgr.log.Info("Proxy Service address changed", "address", addr)
+	mgr.addressQueue.add(addr)
+}
+
+// Re-apply the Proxy when a resource it is rendered from changes, unchanged content does not roll the Proxy
+func (mgr *Manager) onDependencyEvent(obj k8sclient.Object, deleted bool) {
+	mgr.log.Info("Proxy dependency changed", "kind", obj.GetObjectKind().GroupVersionKind().Kind, "name", obj.GetName())
+	mgr.triggerResync()
+}
+
+// Hostname takes precedence over IP, matching the address reported when waiting for the LoadBalancer
+func getLoadBalancerAddress(svc *corev1.Service) string {
+	if len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return ""
+	}
+	ingress := svc.Status.LoadBalancer.Ingress[0]
+	if ingress.Hostname != "" {
+		return ingress.Hostname
+	}
+	return ingress.IP
+}
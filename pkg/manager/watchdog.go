@@ -0,0 +1,39 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"time"
+)
+
+// Record that a reconcile finished, whether it failed or not
+func (mgr *Manager) markReconciled() {
+	now := time.Now()
+	mgr.lastReconciled.Store(now)
+	lastReconcileTime.WithLabelValues(mgr.opt.ProxyName).Set(float64(now.Unix()))
+}
+
+// ReconcileStallError returns an error once no reconcile finished for ReconcileStallTimeout, e.g. when a request hangs
+// It is nil while reconciles finish, including failed ones, and when the watchdog is disabled
+func (mgr *Manager) ReconcileStallError() error {
+	if mgr.opt.ReconcileStallTimeout <= 0 {
+		return nil
+	}
+	last, _ := mgr.lastReconciled.Load().(time.Time)
+	if since := time.Since(last); since > mgr.opt.ReconcileStallTimeout {
+		return fmt.Errorf("no reconcile of %s finished for %s", mgr.opt.ProxyName, since.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReconcileStallError(t *testing.T) {
+	mgr, _ := newFakeManager(t, NewFakeControllerClient())
+	if err := mgr.ReconcileStallError(); err != nil {
+		t.Fatalf("Expected a new Manager not to be stalled, got %v", err)
+	}
+
+	// A reconcile that does not finish within the timeout stalls the Manager, a failed one does not
+	mgr.opt.ReconcileStallTimeout = time.Minute
+	mgr.lastReconciled.Store(time.Now().Add(-time.Hour))
+	if err := mgr.ReconcileStallError(); err == nil {
+		t.Fatal("Expected stalled reconcile loop to be reported")
+	}
+	mgr.observeReconcile(context.Background(), context.DeadlineExceeded)
+	if err := mgr.ReconcileStallError(); err != nil {
+		t.Errorf("Expected finished reconcile to reset the watchdog, got %v", err)
+	}
+
+	mgr.opt.ReconcileStallTimeout = 0
+	mgr.lastReconciled.Store(time.Now().Add(-time.Hour))
+	if err := mgr.ReconcileStallError(); err != nil {
+		t.Errorf("Expected disabled watchdog not to report, got %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ProxyWorkloadDeployment runs the Proxy replicas as a Deployment
+	ProxyWorkloadDeployment = "Deployment"
+	// ProxyWorkloadDaemonSet runs a Proxy pod on every node selected by the Proxy node selector
+	ProxyWorkloadDaemonSet = "DaemonSet"
+	// ProxyWorkloadStatefulSet runs the Proxy replicas as a StatefulSet, so each replica has a stable hostname
+	ProxyWorkloadStatefulSet = "StatefulSet"
+
+	// Keys of the node selector of the Proxy pods set from the Options
+	nodeSelectorAnnotation = "iofog.org/proxy-node-selector"
+)
+
+// ParseProxyWorkload validates the kind of the Proxy workload, empty runs a Deployment
+func ParseProxyWorkload(value string) (string, error) {
+	switch {
+	case value == "" || strings.EqualFold(value, ProxyWorkloadDeployment):
+		return ProxyWorkloadDeployment, nil
+	case strings.EqualFold(value, ProxyWorkloadDaemonSet):
+		return ProxyWorkloadDaemonSet, nil
+	case strings.EqualFold(value, ProxyWorkloadStatefulSet):
+		return ProxyWorkloadStatefulSet, nil
+	default:
+		return "", fmt.Errorf("unknown Proxy workload %s, expected %s, %s or %s", value, ProxyWorkloadDeployment, ProxyWorkloadDaemonSet, ProxyWorkloadStatefulSet)
+	}
+}
+
+func isDaemonSet(opt *Options) bool {
+	return opt.ProxyWorkload == ProxyWorkloadDaemonSet
+}
+
+// Empty object of the kind of the Proxy workload
+func newProxyWorkload(opt *Options) k8sclient.Object {
+	switch opt.ProxyWorkload {
+	case ProxyWorkloadDaemonSet:
+		return &appsv1.DaemonSet{}
+	case ProxyWorkloadStatefulSet:
+		return &appsv1.StatefulSet{}
+	}
+	return &appsv1.Deployment{}
+}
+
+// Get the Proxy workload as a Deployment, so its pod template is read and set the same way for all kinds
+// The workload itself is returned as well, e.g. to record Events on it
+func (mgr *Manager) getProxyDeployment(ctx context.Context) (*appsv1.Deployment, k8sclient.Object, error) {
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	obj := newProxyWorkload(mgr.opt)
+	if err := mgr.getReader().Get(ctx, proxyKey, obj); err != nil {
+		return nil, nil, err
+	}
+	return getWorkloadDeployment(obj), obj, nil
+}
+
+func getWorkloadDeployment(obj k8sclient.Object) *appsv1.Deployment {
+	switch workload := obj.(type) {
+	case *appsv1.DaemonSet:
+		return newDaemonSetDeployment(workload)
+	case *appsv1.StatefulSet:
+		return newStatefulSetDeployment(workload)
+	}
+	return obj.(*appsv1.Deployment)
+}
+
+// View of a DaemonSet as a Deployment with a replica per scheduled node
+// A DaemonSet not scheduled on any node is viewed with a missing replica, so it is not ready
+func newDaemonSetDeployment(ds *appsv1.DaemonSet) *appsv1.Deployment {
+	replicas := ds.Status.DesiredNumberScheduled
+	if replicas == 0 {
+		replicas = 1
+	}
+	dep := &appsv1.Deployment{
+		ObjectMeta: *ds.ObjectMeta.DeepCopy(),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: ds.Spec.Selector.DeepCopy(),
+			Template: *ds.Spec.Template.DeepCopy(),
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: ds.Status.ObservedGeneration,
+			Replicas:           ds.Status.CurrentNumberScheduled,
+			UpdatedReplicas:    ds.Status.UpdatedNumberScheduled,
+			AvailableReplicas:  ds.Status.NumberAvailable,
+		},
+	}
+	if update := ds.Spec.UpdateStrategy.RollingUpdate; update != nil {
+		dep.Spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{MaxUnavailable: update.MaxUnavailable, MaxSurge: update.MaxSurge}
+	}
+	return dep
+}
+
+// Write the metadata and pod template of a Deployment view back to its DaemonSet
+// DaemonSets have no Recreate strategy, only the parameters of a rolling update are kept
+func setDaemonSetSpec(ds *appsv1.DaemonSet, dep *appsv1.Deployment) {
+	ds.ObjectMeta = dep.ObjectMeta
+	ds.Spec.Selector = dep.Spec.Selector
+	ds.Spec.Template = dep.Spec.Template
+	if update := dep.Spec.Strategy.RollingUpdate; update != nil {
+		ds.Spec.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{
+			Type:          appsv1.RollingUpdateDaemonSetStrategyType,
+			RollingUpdate: &appsv1.RollingUpdateDaemonSet{MaxUnavailable: update.MaxUnavailable, MaxSurge: update.MaxSurge},
+		}
+	}
+}
+
+// Proxy workload of the kind of the Options rendered from a new Proxy Deployment
+func newProxyWorkloadFrom(dep *appsv1.Deployment, opt *Options) k8sclient.Object {
+	switch opt.ProxyWorkload {
+	case ProxyWorkloadDaemonSet:
+		ds := &appsv1.DaemonSet{}
+		setDaemonSetSpec(ds, dep)
+		return ds
+	case ProxyWorkloadStatefulSet:
+		return newProxyStatefulSet(dep, opt)
+	}
+	return dep
+}
+
+// Update an existing Proxy workload of any kind
+func (mgr *Manager) updateProxyWorkload(ctx context.Context, obj k8sclient.Object, configHash string, sidecars []corev1.Container) error {
+	if dep, ok := obj.(*appsv1.Deployment); ok {
+		return mgr.updateProxyDeployment(ctx, dep, configHash, sidecars)
+	}
+	config := createProxyConfig(mgr.cache, mgr.opt)
+	if config == "" {
+		return mgr.deleteProxyDeployment(ctx)
+	}
+	original := obj.DeepCopyObject().(k8sclient.Object)
+	dep := getWorkloadDeployment(obj)
+	if err := mgr.setProxySpec(dep, config, configHash, sidecars); err != nil {
+		return err
+	}
+	switch workload := obj.(type) {
+	case *appsv1.DaemonSet:
+		setDaemonSetSpec(workload, dep)
+	case *appsv1.StatefulSet:
+		// StatefulSet replicas are not scaled by load, so their hostnames stay in use
+		setStatefulSetSpec(workload, dep)
+		replicas := int32(getMinReplicas(mgr.opt))
+		workload.Spec.Replicas = &replicas
+	}
+	if err := mgr.k8sClient.Patch(ctx, obj, k8sclient.StrategicMergeFrom(original)); err != nil {
+		return err
+	}
+	// The workload of the Proxy before switching to another kind serves until the new workload is ready
+	if isDeploymentReady(getWorkloadDeployment(original)) {
+		return mgr.deleteReplacedProxyWorkloads(ctx)
+	}
+	return nil
+}
+
+// Delete the Proxy workloads of the kinds other than the one of the Options, their pods are selected by the Proxy Service too
+func (mgr *Manager) deleteReplacedProxyWorkloads(ctx context.Context) error {
+	for _, kind := range []string{ProxyWorkloadDeployment, ProxyWorkloadDaemonSet, ProxyWorkloadStatefulSet} {
+		if kind == mgr.opt.ProxyWorkload {
+			continue
+		}
+		obj := newProxyWorkload(&Options{ProxyWorkload: kind})
+		key := k8sclient.ObjectKey{
+			Name:      mgr.opt.ProxyName,
+			Namespace: mgr.opt.Namespace,
+		}
+		if err := mgr.k8sClient.Get(ctx, key, obj); err != nil {
+			if k8serrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		mgr.getLog(ctx).Info("Deleting Proxy workload replaced by another kind", "replaced", kind, "workload", mgr.opt.ProxyWorkload)
+		if err := mgr.k8sClient.Delete(ctx, obj); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		if kind == ProxyWorkloadStatefulSet {
+			if err := mgr.deleteHeadlessService(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Bind the ports of the Proxy container on the nodes running its pods, or remove the bindings
+// Ports bound on the host are only served by a single Proxy pod per node
+func setHostPorts(dep *appsv1.Deployment, ports portMap, opt *Options) {
+	container := &dep.Spec.Template.Spec.Containers[0]
+	if !opt.ProxyHostPorts {
+		container.Ports = nil
+		return
+	}
+	sorted := getSortedPorts(ports)
+	container.Ports = make([]corev1.ContainerPort, 0, len(sorted))
+	for _, port := range sorted {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			ContainerPort: int32(port),
+			HostPort:      int32(port),
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+	if getTLSRedirectTarget(ports, opt) != 0 {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			ContainerPort: tlsRedirectPort,
+			HostPort:      tlsRedirectPort,
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+}
+
+// Keep the traffic of NodePort and LoadBalancer Services on the node receiving it when a Proxy pod runs on every node,
+// which also preserves client IPs, unless the load balancer preset sets the policy
+func setWorkloadTrafficPolicy(svc *corev1.Service, opt *Options) {
+	if !isDaemonSet(opt) || opt.LoadBalancerPreset != "" {
+		return
+	}
+	if svc.Spec.Type == corev1.ServiceTypeNodePort || svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		svc.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+	}
+}
+
+// Reader of the Proxy resources, the informer cache when set up with a controller manager
+func (mgr *Manager) getReader() k8sclient.Reader {
+	if mgr.reader != nil {
+		return mgr.reader
+	}
+	return mgr.k8sClient
+}
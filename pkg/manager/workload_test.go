@@ -0,0 +1,178 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestDaemonSetWorkload(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyServiceType = string(corev1.ServiceTypeNodePort)
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err == nil {
+		t.Fatal("Expected error waiting for Proxy Deployment")
+	}
+
+	// Switching to a DaemonSet keeps the Deployment until the DaemonSet is ready
+	mgr.opt.ProxyWorkload = ProxyWorkloadDaemonSet
+	mgr.opt.ProxyHostPorts = true
+	mgr.opt.ProxyNodeSelector = map[string]string{"node-role.kubernetes.io/ingress": ""}
+	if err := mgr.run(ctx); err == nil {
+		t.Fatal("Expected error waiting for Proxy DaemonSet")
+	}
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	ds := appsv1.DaemonSet{}
+	if err := k8sClient.Get(ctx, key, &ds); err != nil {
+		t.Fatal(err)
+	}
+	ports := ds.Spec.Template.Spec.Containers[0].Ports
+	if len(ports) != 1 || ports[0].HostPort != 5000 || ports[0].ContainerPort != 5000 {
+		t.Errorf("Expected port 5000 to be bound on the nodes, got %v", ports)
+	}
+	if _, exists := ds.Spec.Template.Spec.NodeSelector["node-role.kubernetes.io/ingress"]; !exists {
+		t.Errorf("Expected node selector, got %v", ds.Spec.Template.Spec.NodeSelector)
+	}
+	if len(ds.OwnerReferences) != 1 || ds.OwnerReferences[0].UID != "owner" {
+		t.Errorf("Expected Proxy DaemonSet to be owned by the manager, got %v", ds.OwnerReferences)
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.Deployment{}); err != nil {
+		t.Errorf("Expected Proxy Deployment to be kept, got %v", err)
+	}
+	if err := mgr.generateCache(ctx); err != nil || len(mgr.cache) != 1 {
+		t.Errorf("Expected cache to be generated from the DaemonSet, got %v: %v", mgr.cache, err)
+	}
+
+	ds.Status = appsv1.DaemonSetStatus{ObservedGeneration: ds.Generation, DesiredNumberScheduled: 2, CurrentNumberScheduled: 2, UpdatedNumberScheduled: 2, NumberAvailable: 2}
+	if err := k8sClient.Update(ctx, &ds); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.Deployment{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected replaced Proxy Deployment to be deleted, got %v", err)
+	}
+	svc := corev1.Service{}
+	if err := k8sClient.Get(ctx, key, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal {
+		t.Errorf("Expected Local traffic policy, got %s", svc.Spec.ExternalTrafficPolicy)
+	}
+
+	// Switching back to a Deployment keeps the DaemonSet until the Deployment is ready
+	// The Options are changed on a restart, which updates the Proxy on its first run
+	mgr.opt.ProxyWorkload = ProxyWorkloadDeployment
+	mgr.outOfSync = true
+	if err := mgr.run(ctx); err == nil {
+		t.Fatal("Expected error waiting for Proxy Deployment")
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.DaemonSet{}); err != nil {
+		t.Errorf("Expected Proxy DaemonSet to be kept, got %v", err)
+	}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, key, &dep); err != nil {
+		t.Fatal(err)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.DaemonSet{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected replaced Proxy DaemonSet to be deleted, got %v", err)
+	}
+	mgr.opt.ProxyWorkload = ProxyWorkloadDaemonSet
+
+	// Removing the last port deletes the DaemonSet
+	ioClient.SetPorts()
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.DaemonSet{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected Proxy DaemonSet to be deleted, got %v", err)
+	}
+}
+
+func TestStatefulSetWorkload(t *testing.T) {
+	ctx := context.Background()
+	ioClient := NewFakeControllerClient(ioclient.MicroservicePublicPort{
+		MicroserviceUUID: "abc",
+		PublicPort:       ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"},
+	})
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyWorkload = ProxyWorkloadStatefulSet
+	mgr.opt.ProxyMinReplicas, mgr.opt.ProxyMaxReplicas = 2, 2
+	if err := mgr.generateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err == nil {
+		t.Fatal("Expected error waiting for Proxy StatefulSet")
+	}
+	key := k8sclient.ObjectKey{Name: "http-proxy", Namespace: "iofog"}
+	ss := appsv1.StatefulSet{}
+	if err := k8sClient.Get(ctx, key, &ss); err != nil {
+		t.Fatal(err)
+	}
+	if ss.Spec.ServiceName != "http-proxy-pods" || ss.Spec.Replicas == nil || *ss.Spec.Replicas != 2 {
+		t.Errorf("Unexpected StatefulSet spec %v", ss.Spec)
+	}
+	headless := corev1.Service{}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: "http-proxy-pods", Namespace: "iofog"}, &headless); err != nil {
+		t.Fatal(err)
+	}
+	if headless.Spec.ClusterIP != corev1.ClusterIPNone || headless.Spec.Selector["name"] != "http-proxy" || len(headless.Spec.Ports) != 1 {
+		t.Errorf("Unexpected headless Service spec %v", headless.Spec)
+	}
+
+	ss.Status = appsv1.StatefulSetStatus{ObservedGeneration: ss.Generation, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}
+	if err := k8sClient.Update(ctx, &ss); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &corev1.Service{}); err != nil {
+		t.Errorf("Expected Proxy Service, got %v", err)
+	}
+
+	// Removing the last port deletes the StatefulSet and its headless Service
+	ioClient.SetPorts()
+	if err := mgr.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, key, &appsv1.StatefulSet{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected Proxy StatefulSet to be deleted, got %v", err)
+	}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKeyFromObject(&headless), &corev1.Service{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Expected headless Service to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ProxyGroupingZone runs a Proxy exposing all ports per availability zone, on the nodes of its zone
+	ProxyGroupingZone = "zone"
+
+	// Set on the resources of the Proxy of a zone to the zone
+	proxyZoneLabel = "iofog.org/proxy-zone"
+)
+
+// ParseProxyZones validates the availability zones a Proxy is run in, without zones they are discovered from the nodes
+func ParseProxyZones(zones []string) ([]string, error) {
+	parsed := make([]string, 0, len(zones))
+	seen := make(map[string]bool)
+	for _, zone := range zones {
+		if errs := validation.IsValidLabelValue(zone); len(errs) > 0 || zone == "" {
+			return nil, fmt.Errorf("invalid zone %s: %s", zone, strings.Join(errs, ", "))
+		}
+		if toDNSLabel(zone) == "" {
+			return nil, fmt.Errorf("invalid zone %s: cannot name a Proxy after it", zone)
+		}
+		if seen[zone] {
+			return nil, fmt.Errorf("duplicate zone %s", zone)
+		}
+		seen[zone] = true
+		parsed = append(parsed, zone)
+	}
+	return parsed, nil
+}
+
+// Zones a Proxy is run in, the configured ones or those of the topology labels of the nodes
+func (mgr *Manager) getZones(ctx context.Context) ([]string, error) {
+	if len(mgr.opt.ProxyZones) > 0 {
+		return mgr.opt.ProxyZones, nil
+	}
+	nodes := corev1.NodeList{}
+	if err := mgr.k8sClient.List(ctx, &nodes); err != nil {
+		return nil, err
+	}
+	zones := make(map[string]bool)
+	for _, node := range nodes.Items {
+		if zone := node.Labels[corev1.LabelTopologyZone]; zone != "" && toDNSLabel(zone) != "" {
+			zones[zone] = true
+		}
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("no node has the %s label", corev1.LabelTopologyZone)
+	}
+	return sortedKeys(zones), nil
+}
+
+// Give the Proxy of every zone all ports passing the protocol filter
+// The Proxies of zones no longer found are deleted like those of removed groups
+func (mgr *Manager) zonePorts(ctx context.Context, allPorts []ioclient.MicroservicePublicPort) (map[string]*groupedPorts, error) {
+	zones, err := mgr.getZones(ctx)
+	if err != nil {
+		// Keep the Proxies of the known zones until the zones can be found again
+		if len(mgr.groups) == 0 {
+			return nil, err
+		}
+		zones = nil
+		for _, group := range mgr.groups {
+			zones = append(zones, group.mgr.opt.ProxyGroupLabels[proxyZoneLabel])
+		}
+	}
+	var ports []ioclient.MicroservicePublicPort
+	for _, port := range allPorts {
+		if matchesProtocolFilter(port.PublicPort.Protocol, mgr.opt.ProtocolFilter) {
+			ports = append(ports, port)
+		}
+	}
+	groupPorts := make(map[string]*groupedPorts)
+	for _, zone := range zones {
+		name := getGroupProxyName(mgr.opt.ProxyName, toDNSLabel(zone))
+		groupPorts[name] = &groupedPorts{labels: map[string]string{proxyZoneLabel: zone}, ports: ports}
+	}
+	return groupPorts, err
+}
+
+// Restrict the Proxy of a zone to the nodes of its zone
+// The Proxies of all zones expose the same ports, so they neither share the PortRegistry nor claim ports from each other
+func setZoneOptions(opt *Options) {
+	zone, exists := opt.ProxyGroupLabels[proxyZoneLabel]
+	if !exists {
+		return
+	}
+	selector := make(map[string]string, len(opt.ProxyNodeSelector)+1)
+	for key, value := range opt.ProxyNodeSelector {
+		selector[key] = value
+	}
+	selector[corev1.LabelTopologyZone] = zone
+	opt.ProxyNodeSelector = selector
+	opt.PortRegistry = nil
+}
+
+// Whether a Service belongs to the Proxy of another zone of the same grouping Manager
+func isZoneSibling(svc *corev1.Service, opt *Options) bool {
+	if _, exists := opt.ProxyGroupLabels[proxyZoneLabel]; !exists {
+		return false
+	}
+	_, zonal := svc.Labels[proxyZoneLabel]
+	return zonal && svc.Namespace == opt.Namespace && svc.Labels[proxyGroupLabel] == opt.ProxyGroup
+}
@@ -0,0 +1,112 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2019 Edgeworx, Inc.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	ioclient "github.com/eclipse-iofog/iofog-go-sdk/v3/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestZoneGrouping(t *testing.T) {
+	for _, zones := range [][]string{{""}, {"a", "a"}, {"-"}, {"us east"}} {
+		if _, err := ParseProxyZones(zones); err == nil {
+			t.Errorf("Expected zones %q to be rejected", zones)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ioClient := NewFakeControllerClient(
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "abc", PublicPort: ioclient.PublicPort{Port: 5000, Protocol: "http", Queue: "abc-5000"}},
+		ioclient.MicroservicePublicPort{MicroserviceUUID: "def", PublicPort: ioclient.PublicPort{Port: 6000, Protocol: "tcp", Queue: "def-6000"}},
+	)
+	mgr, k8sClient := newFakeManager(t, ioClient)
+	mgr.opt.ProxyGrouping = ProxyGroupingZone
+	mgr.opt.PortConflictScope = PortConflictScopeNamespace
+	for name, zone := range map[string]string{"node-a": "us-east-1a", "node-b": "us-east-1b", "node-c": "us-east-1b"} {
+		node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{corev1.LabelTopologyZone: zone}}}
+		if err := k8sClient.Create(ctx, &node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The Proxy of every zone exposes all ports on the nodes of its zone
+	if err := mgr.runGroups(ctx, false, false); err == nil {
+		t.Fatal("Expected error waiting for zone Proxy Deployments")
+	}
+	for name, zone := range map[string]string{"http-proxy-us-east-1a": "us-east-1a", "http-proxy-us-east-1b": "us-east-1b"} {
+		group, exists := mgr.groups[name]
+		if !exists {
+			t.Fatalf("Expected zone Proxy %s", name)
+		}
+		if len(group.mgr.cache) != 2 {
+			t.Errorf("Expected %s to expose all ports, got %v", name, group.mgr.cache)
+		}
+		dep := appsv1.Deployment{}
+		if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: name, Namespace: "iofog"}, &dep); err != nil {
+			t.Fatal(err)
+		}
+		if dep.Labels[proxyZoneLabel] != zone || dep.Spec.Template.Spec.NodeSelector[corev1.LabelTopologyZone] != zone {
+			t.Errorf("Expected %s to run in zone %s, got labels %v and node selector %v", name, zone, dep.Labels, dep.Spec.Template.Spec.NodeSelector)
+		}
+		dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+		if err := k8sClient.Update(ctx, &dep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mgr.runGroups(ctx, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Services of the other zones do not claim the ports of a zone added later
+	node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-d", Labels: map[string]string{corev1.LabelTopologyZone: "us-east-1c"}}}
+	if err := k8sClient.Create(ctx, &node); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.runGroups(ctx, false, false); err == nil {
+		t.Fatal("Expected error waiting for added zone Proxy Deployment")
+	}
+	if group, exists := mgr.groups["http-proxy-us-east-1c"]; !exists || len(group.mgr.cache) != 2 {
+		t.Fatalf("Expected added zone Proxy to expose all ports, got %v", mgr.groups)
+	}
+	dep := appsv1.Deployment{}
+	if err := k8sClient.Get(ctx, k8sclient.ObjectKey{Name: "http-proxy-us-east-1c", Namespace: "iofog"}, &dep); err != nil {
+		t.Fatal(err)
+	}
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(ctx, &dep); err != nil {
+		t.Fatal(err)
+	}
+
+	// The address of each zone is recorded in the status of its Proxy
+	if err := mgr.runGroups(ctx, false, false); err != nil {
+		t.Fatal(err)
+	}
+	for name, group := range mgr.groups {
+		if err := group.mgr.registerAddress(ctx, ""); err != nil {
+			t.Fatal(err)
+		}
+		if address := group.mgr.getStatus().Address; address != "1.2.3.4" {
+			t.Errorf("Expected %s to record its address, got %q", name, address)
+		}
+	}
+	if address := ioClient.GetDefaultProxy(); address != "" {
+		t.Errorf("Expected zone addresses not to be registered as the default address, got %q", address)
+	}
+}